@@ -2,38 +2,82 @@ package main
 
 import (
 	"log"
+	"os"
+	"strconv"
 
-	"github.com/e-berman/baseball_api/internal/routes"
 	"github.com/e-berman/baseball_api/internal/db"
+	"github.com/e-berman/baseball_api/internal/routes"
 )
 
 func main() {
-	dbpool := setup()
-	defer dbpool.Poolconn.Close()
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		migrate(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "purge" {
+		purge(os.Args[2:])
+		return
+	}
+
+	store := setup()
+	defer store.Close()
 
-	server := routes.NewServer(":4242", dbpool)
+	// lines in the trash are seen and restored with the admin token, and by no one without it
+	server := routes.NewServer(":4242", store, os.Getenv("ADMIN_TOKEN"), teamGames())
 	server.StartServer()
 }
 
-func setup() *db.DBPool {
-	dbpool, err := db.NewDBPool()
-	if err != nil {
-		log.Fatal(err)
+// teamGames returns the games every team plays in a season set by TEAM_GAMES, or 0 to count them from the lines
+func teamGames() int {
+	raw := os.Getenv("TEAM_GAMES")
+	if raw == "" {
+		return 0
+	}
+
+	games, err := strconv.Atoi(raw)
+	if err != nil || games <= 0 {
+		log.Fatalf("TEAM_GAMES expects a positive number of games, got %q", raw)
 	}
 
-	if err := dbpool.InitializePositionPlayerTable(); err != nil {
+	return games
+}
+
+func setup() db.Store {
+	store, err := db.Open()
+	if err != nil {
 		log.Fatal(err)
 	}
-	if err := dbpool.InitializePitcherTable(); err != nil {
+
+	if err := store.Initialize(); err != nil {
 		log.Fatal(err)
 	}
-	if err := dbpool.ImportPitcherDataFromCSV(); err != nil {
+
+	// a bad csv is logged rather than stopping the server from starting
+	mode, err := db.ParseImportMode(os.Getenv("CSV_IMPORT_MODE"))
+	if err != nil {
 		log.Fatal(err)
 	}
-	if err := dbpool.ImportPositionPlayerDataFromCSV(); err != nil {
+	write, err := db.ParseWriteMode(os.Getenv("CSV_WRITE_MODE"))
+	if err != nil {
 		log.Fatal(err)
 	}
+	opts := &db.ImportOptions{Mode: mode, Write: write}
+	logImport(store.ImportPitcherDataFromCSV(opts))
+	logImport(store.ImportPositionPlayerDataFromCSV(opts))
 
-	return dbpool
+	return store
 }
 
+// logImport logs the report of each imported csv along with its invalid rows
+func logImport(reports []*db.ImportReport, err error) {
+	for _, report := range reports {
+		log.Println("Imported", report)
+		for _, rowErr := range report.Errors {
+			log.Println(report.Source+":", rowErr)
+		}
+	}
+
+	if err != nil {
+		log.Println("Import failed:", err)
+	}
+}
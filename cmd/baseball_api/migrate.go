@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/e-berman/baseball_api/internal/db"
+)
+
+const migrateUsage = `usage: main migrate <command>
+
+	up [version]	apply migrations up to version, or every migration
+	down [steps]	roll back the given number of migrations, or the newest one
+	status		print the applied and latest schema versions`
+
+// migrate runs the migrate command, applying or rolling back schema migrations explicitly
+//
+// the server applies every migration on startup, so this is mostly for rolling back
+func migrate(args []string) {
+	if len(args) == 0 || len(args) > 2 {
+		log.Fatal(migrateUsage)
+	}
+
+	store, err := db.Open()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer store.Close()
+
+	current, err := store.SchemaVersion()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	arg := func(fallback int) int {
+		if len(args) < 2 {
+			return fallback
+		}
+		n, err := strconv.Atoi(args[1])
+		if err != nil {
+			log.Fatal(migrateUsage)
+		}
+		return n
+	}
+
+	target := current
+	switch args[0] {
+	case "up":
+		target = arg(db.LatestVersion)
+		if target < current {
+			log.Fatalf("schema is at version %d, use down to roll back", current)
+		}
+	case "down":
+		target = current - arg(1)
+		if target < 0 {
+			target = 0
+		}
+	case "status":
+	default:
+		log.Fatal(migrateUsage)
+	}
+
+	if err := store.MigrateTo(target); err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("schema version %d, latest %d\n", target, db.LatestVersion)
+}
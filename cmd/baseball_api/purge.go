@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/e-berman/baseball_api/internal/db"
+)
+
+const purgeUsage = `usage: main purge [retention]
+
+	retention	how long deleted lines stay in the trash, e.g. 720h, defaulting to TRASH_RETENTION or 30 days`
+
+// how long deleted lines stay in the trash when neither the purge command nor TRASH_RETENTION gives a retention
+const defaultRetention = 30 * 24 * time.Hour
+
+// purge runs the purge command, permanently deleting the lines kept in the trash longer than the retention period
+func purge(args []string) {
+	if len(args) > 1 {
+		log.Fatal(purgeUsage)
+	}
+
+	raw := os.Getenv("TRASH_RETENTION")
+	if len(args) == 1 {
+		raw = args[0]
+	}
+
+	retention := defaultRetention
+	if raw != "" {
+		var err error
+		retention, err = time.ParseDuration(raw)
+		if err != nil || retention < 0 {
+			log.Fatal(purgeUsage)
+		}
+	}
+
+	store, err := db.Open()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer store.Close()
+
+	report, err := store.PurgeDeleted(time.Now().Add(-retention))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("purged %s deleted over %s ago\n", report, retention)
+}
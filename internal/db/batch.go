@@ -0,0 +1,207 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/e-berman/baseball_api/internal/models"
+	"github.com/jackc/pgx/v5"
+)
+
+// BatchAction is the write made by an operation of a batch
+type BatchAction string
+
+const (
+	// BatchCreate adds a line, as AddPositionPlayer does
+	BatchCreate BatchAction = "create"
+	// BatchUpdate sets the name, team, season and stats of a line, as UpdatePositionPlayer does
+	BatchUpdate BatchAction = "update"
+	// BatchDelete moves a line to the trash, as DeletePositionPlayer does
+	BatchDelete BatchAction = "delete"
+)
+
+// BatchMode decides what happens to a batch when some of its operations fail
+type BatchMode string
+
+const (
+	// BatchAtomic writes nothing from a batch with any failed operations
+	BatchAtomic BatchMode = "atomic"
+	// BatchPartial writes every operation that succeeds and reports the ones that fail
+	BatchPartial BatchMode = "partial"
+)
+
+// ErrRolledBack is reported for the operations of an atomic batch that would have succeeded,
+// had another operation of the batch not failed
+var ErrRolledBack = errors.New("not written, another operation of the batch failed")
+
+// errBatchFailed rolls back the transaction of an atomic batch with failed operations
+var errBatchFailed = errors.New("batch has failed operations")
+
+// ParseBatchMode returns the batch mode with the given name, defaulting to atomic when empty
+func ParseBatchMode(name string) (BatchMode, error) {
+	switch BatchMode(name) {
+	case "", BatchAtomic:
+		return BatchAtomic, nil
+	case BatchPartial:
+		return BatchPartial, nil
+	}
+
+	return "", fmt.Errorf("unknown batch mode: %s", name)
+}
+
+// BatchOp is an operation of a batch
+//
+// Line is the line created, or the values an update sets. an update or delete names its line by ID
+// and is only written while the line is at Version, or whatever its version when Version is 0
+type BatchOp[T any] struct {
+	Action  BatchAction
+	ID      int
+	Version int
+	Line    *T
+}
+
+// BatchResult is the outcome of an operation of a batch
+//
+// Line is the line as written, nil for a delete. Err is the Error the operation failed with,
+// or ErrRolledBack for an operation undone because another one failed
+type BatchResult[T any] struct {
+	Line *T
+	Err  error
+}
+
+// runBatch applies every operation of a batch in order, returning the result of each
+//
+// apply is expected to leave nothing written by an operation that fails, so the operations
+// after it see the tables as they were before it
+func runBatch[T any](ops []*BatchOp[T], apply func(*BatchOp[T]) (*T, error)) []*BatchResult[T] {
+	results := make([]*BatchResult[T], len(ops))
+	for i, op := range ops {
+		line, err := apply(op)
+		if err != nil {
+			line = nil
+		}
+		results[i] = &BatchResult[T]{Line: line, Err: err}
+	}
+
+	return results
+}
+
+// settleBatch returns errBatchFailed when an atomic batch has failed operations, reporting
+// every other operation as rolled back
+func settleBatch[T any](results []*BatchResult[T], mode BatchMode) error {
+	if mode != BatchAtomic {
+		return nil
+	}
+
+	failed := false
+	for _, result := range results {
+		failed = failed || result.Err != nil
+	}
+	if !failed {
+		return nil
+	}
+
+	for _, result := range results {
+		if result.Err == nil {
+			result.Line, result.Err = nil, ErrRolledBack
+		}
+	}
+
+	return errBatchFailed
+}
+
+// batchOp applies an operation of a batch to a table, returning the written line
+//
+// the write is recorded in the history of the line as made by the given author
+func batchOp[T any](q querier, table *lineTable[T], by author, op *BatchOp[T]) (*T, error) {
+	switch op.Action {
+	case BatchCreate:
+		return recordCreate(q, table, by, func() (*T, error) {
+			return insertLine(q, table, op.Line)
+		})
+	case BatchUpdate:
+		return recordUpdate(q, table, by, op.ID, func() (*T, error) {
+			return updateLine(q, table, op.ID, op.Version, op.Line)
+		})
+	case BatchDelete:
+		_, err := trashLine(q, table, by, op.ID, op.Version)
+		return nil, err
+	}
+
+	return nil, newError(ErrConstraint, "", fmt.Sprintf("unknown batch action: %s", op.Action))
+}
+
+// insertLine adds a line to a table, returning the inserted row
+//
+// a line already existing for the same name, team and season is reported as an ErrConflict Error holding its id
+func insertLine[T any](q querier, table *lineTable[T], line *T) (*T, error) {
+	if err := resolveRefs(q, table, map[models.Player]int{}, []*T{line}); err != nil {
+		return nil, err
+	}
+
+	fields := table.copyFields()
+	columns := make([]string, len(fields))
+	values := make([]any, len(fields))
+	for i, field := range fields {
+		columns[i] = field.Column
+		values[i] = field.ValueOf(line)
+	}
+
+	query := `INSERT INTO ` + table.name + ` (` + strings.Join(columns, ", ") + `)
+	VALUES (` + placeholders(len(fields)) + `)
+	ON CONFLICT (name, team, season) DO NOTHING
+	RETURNING ` + table.columns
+
+	inserted, err := table.scan(q.QueryRow(context.Background(), query, values...))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, existingLineError(q, table.name, lineKeyOf(table.fields, line))
+	}
+
+	return inserted, err
+}
+
+// updateLine sets the name, team, season and stats of the line with the given id to those of line,
+// keeping its player, while the line is at version
+func updateLine[T any](q querier, table *lineTable[T], id, version int, line *T) (*T, error) {
+	names := []string{}
+	for _, field := range table.copyFields() {
+		if field.Name != "playerId" {
+			names = append(names, field.Name)
+		}
+	}
+
+	updated := *line
+	*table.version(&updated) = version
+
+	return patchLine(q, table, id, &updated, names)
+}
+
+// pgBatch applies a batch of operations to a table in a single transaction
+//
+// each operation runs in its own savepoint, so a failed operation doesn't abort the transaction
+// for the operations after it
+func pgBatch[T any](pool *DBPool, table *lineTable[T], ops []*BatchOp[T], mode BatchMode) ([]*BatchResult[T], error) {
+	ctx := context.Background()
+
+	var results []*BatchResult[T]
+	err := pgx.BeginFunc(ctx, pool.Poolconn, func(tx pgx.Tx) error {
+		results = runBatch(ops, func(op *BatchOp[T]) (*T, error) {
+			var line *T
+			err := pgx.BeginFunc(ctx, tx, func(savepoint pgx.Tx) error {
+				var err error
+				line, err = batchOp(savepoint, table, pool.author(), op)
+				return err
+			})
+			return line, pgError(err)
+		})
+
+		return settleBatch(results, mode)
+	})
+	if err != nil && !errors.Is(err, errBatchFailed) {
+		return nil, pgError(err)
+	}
+
+	return results, nil
+}
@@ -0,0 +1,356 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/e-berman/baseball_api/internal/models"
+	"github.com/jackc/pgx/v5"
+)
+
+// lineTable describes a table of season lines for bulk writes
+type lineTable[T any] struct {
+	name   string
+	fields *FieldSet
+	// columns selected for a line, in scan order
+	columns string
+	scan    func(pgx.Row) (*T, error)
+	// identity of the player a line belongs to, or nil when it only has a name
+	identity func(*T) *models.Player
+	// version of a line
+	version func(*T) *int
+	// when a line was moved to the trash, nil while it is live
+	deletedAt func(*T) **time.Time
+	// stats computed from the stored ones, and the columns of the league averages they are adjusted to
+	derived []derivedStat[T]
+	league  []string
+	// league and team averages of the table's lines
+	averages *averagesTable
+}
+
+var positionPlayerTable = &lineTable[models.PositionPlayer]{
+	name:      "position_players",
+	fields:    PositionPlayerFields,
+	columns:   positionPlayerColumns,
+	scan:      scanPositionPlayer,
+	identity:  func(player *models.PositionPlayer) *models.Player { return player.Player },
+	version:   func(player *models.PositionPlayer) *int { return &player.Version },
+	deletedAt: func(player *models.PositionPlayer) **time.Time { return &player.DeletedAt },
+	derived:   positionPlayerDerived,
+	league:    positionPlayerLeague,
+	averages:  battingAverages,
+}
+
+var pitcherTable = &lineTable[models.Pitcher]{
+	name:      "pitchers",
+	fields:    PitcherFields,
+	columns:   pitcherColumns,
+	scan:      scanPitcher,
+	identity:  func(player *models.Pitcher) *models.Player { return player.Player },
+	version:   func(player *models.Pitcher) *int { return &player.Version },
+	deletedAt: func(player *models.Pitcher) **time.Time { return &player.DeletedAt },
+	derived:   pitcherDerived,
+	league:    pitcherLeague,
+	averages:  pitchingAverages,
+}
+
+// copyFields returns the fields written by a bulk write, every field but the line's id
+func (t *lineTable[T]) copyFields() []Field {
+	fields := []Field{}
+	for _, field := range t.fields.Fields() {
+		if field.Name != "id" {
+			fields = append(fields, field)
+		}
+	}
+
+	return fields
+}
+
+// statColumns returns the columns holding the stats of a line
+func (t *lineTable[T]) statColumns() []string {
+	columns := []string{}
+	for _, field := range t.fields.Fields() {
+		if !lineKeyFields[field.Name] {
+			columns = append(columns, field.Column)
+		}
+	}
+
+	return columns
+}
+
+// bulkWriter writes the lines of an import in batches within a transaction
+//
+// each batch is copied into a temporary staging table, then inserted into or used to update
+// the table with a single statement
+type bulkWriter[T any] struct {
+	tx      pgx.Tx
+	table   *lineTable[T]
+	staging string
+	// ids of the players already resolved in the import, by identity
+	refs map[models.Player]int
+	// who the changes are recorded as made by
+	by author
+}
+
+// newBulkWriter returns a bulkWriter for a table, creating its staging table for the transaction
+func newBulkWriter[T any](tx pgx.Tx, table *lineTable[T], by author) (*bulkWriter[T], error) {
+	w := &bulkWriter[T]{
+		tx:      tx,
+		table:   table,
+		staging: "import_" + table.name,
+		refs:    map[models.Player]int{},
+		by:      by,
+	}
+
+	query := `CREATE TEMP TABLE IF NOT EXISTS ` + w.staging + ` ON COMMIT DROP AS
+	SELECT ` + strings.Join(w.copyColumns(), ", ") + ` FROM ` + table.name + ` WITH NO DATA`
+
+	if _, err := tx.Exec(context.Background(), query); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// copyColumns returns the columns copied into the staging table
+func (w *bulkWriter[T]) copyColumns() []string {
+	columns := []string{}
+	for _, field := range w.table.copyFields() {
+		columns = append(columns, field.Column)
+	}
+
+	return columns
+}
+
+// queryLines runs a query returning the columns of the table's lines
+func (w *bulkWriter[T]) queryLines(query string, args ...any) ([]*T, error) {
+	rows, err := w.tx.Query(context.Background(), query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lines := []*T{}
+	for rows.Next() {
+		line, err := w.table.scan(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		lines = append(lines, line)
+	}
+
+	return lines, rows.Err()
+}
+
+// record copies the changes of a batch of lines into the history, pairing each line
+// before a change with the line after it
+func (w *bulkWriter[T]) record(action ChangeAction, before, after []*T) error {
+	changes := [][]any{}
+	for i := range max(len(before), len(after)) {
+		var old, line *T
+		if before != nil {
+			old = before[i]
+		}
+		if after != nil {
+			line = after[i]
+		}
+
+		change, err := newChangeRow(w.table, w.by, action, old, line)
+		if err != nil {
+			return err
+		}
+		changes = append(changes, change.values())
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+
+	_, err := w.tx.CopyFrom(context.Background(), pgx.Identifier{"line_history"}, strings.Split(changeColumns, ", "), pgx.CopyFromRows(changes))
+	return err
+}
+
+// deleteSeason permanently deletes every line of a season, including the lines in the trash,
+// returning the number of lines deleted
+func (w *bulkWriter[T]) deleteSeason(season int) (int, error) {
+	query := `DELETE FROM ` + w.table.name + ` WHERE season = $1 RETURNING ` + w.table.columns
+
+	deleted, err := w.queryLines(query, season)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(deleted), w.record(ChangePurge, deleted, nil)
+}
+
+// resolveRefs links every line without a player id to its player
+//
+// players are resolved once per import, so the lines of a multi-season csv share lookups
+func resolveRefs[T any](q querier, table *lineTable[T], refs map[models.Player]int, lines []*T) error {
+	playerID, _ := table.fields.Lookup("playerId")
+	name, _ := table.fields.Lookup("name")
+
+	for _, line := range lines {
+		if playerID.ValueOf(line).(int) != 0 {
+			continue
+		}
+
+		identity := table.identity(line)
+		if identity == nil {
+			identity = &models.Player{Name: name.ValueOf(line).(string)}
+		}
+
+		id, ok := refs[*identity]
+		if !ok {
+			var err error
+			id, err = resolvePlayer(q, identity)
+			if err != nil {
+				return err
+			}
+			refs[*identity] = id
+		}
+
+		playerID.setValue(line, id)
+	}
+
+	return nil
+}
+
+// writeLines writes a batch of lines
+//
+// lines are inserted unless a line already exists for the same name, team and season,
+// in which case it is skipped, or when upserting has any stats that differ updated.
+// a line in the trash is always skipped
+func (w *bulkWriter[T]) writeLines(lines []*T, mode WriteMode, report *ImportReport) error {
+	ctx := context.Background()
+
+	if err := resolveRefs(w.tx, w.table, w.refs, lines); err != nil {
+		return err
+	}
+
+	fields := w.table.copyFields()
+	_, err := w.tx.CopyFrom(ctx, pgx.Identifier{w.staging}, w.copyColumns(), pgx.CopyFromSlice(len(lines), func(i int) ([]any, error) {
+		values := make([]any, len(fields))
+		for j, field := range fields {
+			values[j] = field.ValueOf(lines[i])
+		}
+		return values, nil
+	}))
+	if err != nil {
+		return err
+	}
+
+	matched := 0
+	if mode == WriteUpsert {
+		if matched, err = w.updateLines(lines, report); err != nil {
+			return err
+		}
+	}
+
+	columns := strings.Join(w.copyColumns(), ", ")
+	query := `INSERT INTO ` + w.table.name + ` (` + columns + `)
+	SELECT ` + columns + ` FROM ` + w.staging + `
+	ON CONFLICT (name, team, season) DO NOTHING
+	RETURNING ` + w.table.columns
+
+	inserted, err := w.queryLines(query)
+	if err != nil {
+		return err
+	}
+	if err := w.record(ChangeCreate, nil, inserted); err != nil {
+		return err
+	}
+
+	report.Inserted += len(inserted)
+	report.Skipped += len(lines) - len(inserted) - matched
+
+	_, err = w.tx.Exec(ctx, `TRUNCATE `+w.staging)
+	return err
+}
+
+// updateLines updates the existing lines of a staged batch whose stats differ, returning the number
+// of lines of the batch that exist
+//
+// the stats that changed are recorded in the report, and lines with the same stats are left untouched.
+// lines in the trash aren't updated
+func (w *bulkWriter[T]) updateLines(lines []*T, report *ImportReport) (int, error) {
+	query := `SELECT ` + w.table.columns + ` FROM ` + w.table.name + `
+	WHERE (name, team, season) IN (SELECT name, team, season FROM ` + w.staging + `) AND ` + liveCondition
+
+	found, err := w.queryLines(query)
+	if err != nil {
+		return 0, err
+	}
+
+	existing := map[lineKey]*T{}
+	for _, line := range found {
+		existing[lineKeyOf(w.table.fields, line)] = line
+	}
+
+	id, _ := w.table.fields.Lookup("id")
+	for _, line := range lines {
+		key := lineKeyOf(w.table.fields, line)
+		old, ok := existing[key]
+		if !ok {
+			// inserted once the existing lines are updated
+			continue
+		}
+
+		changes := diffStats(w.table.fields, old, line)
+		if len(changes) == 0 {
+			report.Unchanged++
+			continue
+		}
+
+		report.Updated++
+		report.Changes = append(report.Changes, &RowChange{
+			ID:     id.ValueOf(old).(int),
+			Name:   key.name,
+			Team:   key.team,
+			Season: key.season,
+			Fields: changes,
+		})
+	}
+
+	stats := w.table.statColumns()
+	set := make([]string, len(stats))
+	current := make([]string, len(stats))
+	staged := make([]string, len(stats))
+	for i, column := range stats {
+		set[i] = fmt.Sprintf("%s = s.%s", column, column)
+		current[i] = "t." + column
+		staged[i] = "s." + column
+	}
+
+	set = append(set, "version = t.version + 1")
+
+	query = `UPDATE ` + w.table.name + ` AS t SET ` + strings.Join(set, ", ") + `
+	FROM ` + w.staging + ` AS s
+	WHERE (t.name, t.team, t.season) = (s.name, s.team, s.season) AND t.` + liveCondition + `
+	AND (` + strings.Join(current, ", ") + `) IS DISTINCT FROM (` + strings.Join(staged, ", ") + `)
+	RETURNING ` + qualifiedColumns(w.table.columns, "t")
+
+	updated, err := w.queryLines(query)
+	if err != nil {
+		return 0, err
+	}
+
+	before := make([]*T, len(updated))
+	for i, line := range updated {
+		before[i] = existing[lineKeyOf(w.table.fields, line)]
+	}
+
+	return len(existing), w.record(ChangeUpdate, before, updated)
+}
+
+// qualifiedColumns returns a list of columns with each qualified by a table alias
+func qualifiedColumns(columns, alias string) string {
+	qualified := strings.Split(columns, ",")
+	for i, column := range qualified {
+		qualified[i] = alias + "." + strings.TrimSpace(column)
+	}
+
+	return strings.Join(qualified, ", ")
+}
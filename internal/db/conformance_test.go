@@ -0,0 +1,1084 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/e-berman/baseball_api/internal/models"
+	"github.com/stretchr/testify/assert"
+)
+
+// the conformance suite runs every test against each backend, which must behave identically
+
+// backends returns a constructor of an empty, initialized Store for each backend under test
+//
+// Postgres is only tested when TEST_DATABASE_URL is set, its tables are dropped before each test
+func backends(t *testing.T) map[string]func(*testing.T) Store {
+	stores := map[string]func(*testing.T) Store{
+		"memory": func(t *testing.T) Store {
+			return NewMemoryDB()
+		},
+		"sqlite": func(t *testing.T) Store {
+			store, err := NewSQLiteDB(":memory:")
+			assert.NoError(t, err)
+			assert.NoError(t, store.Initialize())
+			return store
+		},
+	}
+
+	if url := os.Getenv("TEST_DATABASE_URL"); url != "" {
+		stores["postgres"] = func(t *testing.T) Store {
+			pool, err := NewDBPool(url)
+			assert.NoError(t, err)
+			_, err = pool.Poolconn.Exec(context.Background(), `DROP TABLE IF EXISTS batting_averages, pitching_averages, line_history, pitchers, position_players, players, schema_migrations`)
+			assert.NoError(t, err)
+			assert.NoError(t, pool.Initialize())
+			return pool
+		}
+	}
+
+	return stores
+}
+
+// conform runs a test against every backend
+func conform(t *testing.T, test func(*testing.T, Store)) {
+	for name, newStore := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			store := newStore(t)
+			defer store.Close()
+
+			test(t, store)
+		})
+	}
+}
+
+// importAssets imports the 2022 csvs in assets
+func importAssets(t *testing.T, store Store) {
+	opts := &ImportOptions{Mode: ImportLenient, Write: WriteInsert, Season: 2022}
+
+	batters, err := os.Open("../../assets/batters_2022.csv")
+	assert.NoError(t, err)
+	defer batters.Close()
+	report, err := store.ImportPositionPlayers(batters, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, 130, report.Inserted)
+
+	pitchers, err := os.Open("../../assets/pitchers_2022.csv")
+	assert.NoError(t, err)
+	defer pitchers.Close()
+	_, err = store.ImportPitchers(pitchers, opts)
+	assert.NoError(t, err)
+}
+
+// allPositionPlayers returns every position player in a store
+func allPositionPlayers(t *testing.T, store Store) []*models.PositionPlayer {
+	page, err := store.GetPositionPlayers(&ListOptions{})
+	assert.NoError(t, err)
+
+	return page.Items
+}
+
+func newJudge() *models.PositionPlayer {
+	return models.NewPositionPlayer("Aaron Judge", "NYY", 2022, 157, 696, 62, 133, 131, 16, 207, 15.9, 25.1, .375, .340, .311, .425, .686, .458, .463, 2.1, 11.5)
+}
+
+func newNola() *models.Pitcher {
+	return models.NewPitcher("Aaron Nola", "PHI", 2022, 11, 13, 0, 32, 32, 205, 10.3, 1.3, 0.8, .289, 73.0, 43.6, 9.8, 92.9, 3.25, 2.74, 2.58, 2.77, 6.3)
+}
+
+func TestConformanceAdd(t *testing.T) {
+	conform(t, func(t *testing.T, store Store) {
+		judge := newJudge()
+		added, err := store.AddPositionPlayer(judge)
+		assert.NoError(t, err)
+		assert.NotZero(t, judge.ID)
+		assert.NotZero(t, judge.PlayerID)
+		assert.Equal(t, judge, added)
+
+		// a second line for the same name, team and season is rejected, naming the existing line
+		again := newJudge()
+		again.HR = 10
+		_, err = store.AddPositionPlayer(again)
+		assert.ErrorIs(t, err, ErrConflict)
+		var conflict *Error
+		assert.ErrorAs(t, err, &conflict)
+		assert.Equal(t, judge.ID, conflict.ID)
+		assert.Zero(t, again.ID)
+
+		stored, err := store.GetPositionPlayerByID(judge.ID)
+		assert.NoError(t, err)
+		assert.NotNil(t, stored.Derived)
+		// only reads compute the derived stats
+		stored.Derived = nil
+		assert.Equal(t, added, stored)
+		assert.Equal(t, 62, stored.HR)
+		assert.Equal(t, 15.9, stored.BbRate)
+		assert.Equal(t, judge.PlayerID, stored.PlayerID)
+
+		player, err := store.GetPlayerByID(judge.PlayerID)
+		assert.NoError(t, err)
+		assert.Equal(t, "Aaron Judge", player.Name)
+
+		// a line with the same name belongs to the same player
+		nextSeason := newJudge()
+		nextSeason.Season = 2023
+		_, err = store.AddPositionPlayer(nextSeason)
+		assert.NoError(t, err)
+		assert.Equal(t, judge.PlayerID, nextSeason.PlayerID)
+	})
+}
+
+func TestConformanceConstraints(t *testing.T) {
+	conform(t, func(t *testing.T, store Store) {
+		judge := newJudge()
+		_, err := store.AddPositionPlayer(judge)
+		assert.NoError(t, err)
+
+		negative := newJudge()
+		negative.Name, negative.HR = "Juan Soto", -27
+		_, err = store.AddPositionPlayer(negative)
+		assert.ErrorIs(t, err, ErrConstraint)
+
+		noSeason := newNola()
+		noSeason.Season = 0
+		_, err = store.AddPitcher(noSeason)
+		assert.ErrorIs(t, err, ErrConstraint)
+
+		page, err := store.GetPitchers(&ListOptions{})
+		assert.NoError(t, err)
+		assert.Empty(t, page.Items)
+
+		unknownPlayer := newNola()
+		unknownPlayer.PlayerID = judge.PlayerID + 100
+		_, err = store.AddPitcher(unknownPlayer)
+		assert.ErrorIs(t, err, ErrConstraint)
+
+		// a bsr or war below zero is allowed
+		belowReplacement := newJudge()
+		belowReplacement.Name, belowReplacement.BsR, belowReplacement.WAR = "Joey Gallo", -1.2, -0.4
+		_, err = store.AddPositionPlayer(belowReplacement)
+		assert.NoError(t, err)
+	})
+}
+
+func TestConformanceUpdate(t *testing.T) {
+	conform(t, func(t *testing.T, store Store) {
+		judge := newJudge()
+		_, err := store.AddPositionPlayer(judge)
+		assert.NoError(t, err)
+		nextSeason := newJudge()
+		nextSeason.Season = 2023
+		_, err = store.AddPositionPlayer(nextSeason)
+		assert.NoError(t, err)
+
+		updated := *judge
+		updated.HR = 63
+		assert.NoError(t, store.UpdatePositionPlayer(&updated))
+
+		stored, err := store.GetPositionPlayerByID(judge.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, 63, stored.HR)
+		assert.Equal(t, judge.PlayerID, stored.PlayerID)
+
+		// the line would share a name, team and season with the next season's line
+		updated.Season = 2023
+		assert.ErrorIs(t, store.UpdatePositionPlayer(&updated), ErrConflict)
+
+		updated.Season, updated.HR = 2022, -1
+		assert.ErrorIs(t, store.UpdatePositionPlayer(&updated), ErrConstraint)
+
+		stored, err = store.GetPositionPlayerByID(judge.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, 63, stored.HR)
+
+		missing := *judge
+		missing.ID = judge.ID + 100
+		assert.ErrorIs(t, store.UpdatePositionPlayer(&missing), ErrNotFound)
+	})
+}
+
+func TestConformancePatch(t *testing.T) {
+	conform(t, func(t *testing.T, store Store) {
+		judge := newJudge()
+		_, err := store.AddPositionPlayer(judge)
+		assert.NoError(t, err)
+		nextSeason := newJudge()
+		nextSeason.Season = 2023
+		_, err = store.AddPositionPlayer(nextSeason)
+		assert.NoError(t, err)
+
+		// only the named fields are written, the rest of the line is left as stored
+		patch := &models.PositionPlayer{HR: 63, WAR: 11.5, G: 1}
+		patched, err := store.PatchPositionPlayer(judge.ID, patch, []string{"homeRuns", "war"})
+		assert.NoError(t, err)
+		assert.Equal(t, 63, patched.HR)
+		assert.Equal(t, 11.5, patched.WAR)
+		assert.Equal(t, judge.G, patched.G)
+		assert.Equal(t, judge.ID, patched.ID)
+		assert.Equal(t, judge.PlayerID, patched.PlayerID)
+
+		// the patched line is returned as a read returns it, with its derived stats
+		stored, err := store.GetPositionPlayerByID(judge.ID)
+		assert.NoError(t, err)
+		assert.NotNil(t, patched.Derived)
+		assert.Equal(t, stored, patched)
+
+		unchanged, err := store.PatchPositionPlayer(judge.ID, patch, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, stored, unchanged)
+
+		_, err = store.PatchPositionPlayer(judge.ID, &models.PositionPlayer{Season: 2023}, []string{"season"})
+		assert.ErrorIs(t, err, ErrConflict)
+
+		_, err = store.PatchPositionPlayer(judge.ID, &models.PositionPlayer{HR: -1}, []string{"homeRuns"})
+		assert.ErrorIs(t, err, ErrConstraint)
+
+		_, err = store.PatchPositionPlayer(judge.ID, patch, []string{"playerId"})
+		assert.ErrorIs(t, err, ErrConstraint)
+
+		// derived stats aren't stored, so patching one leaves the line at its version
+		for _, name := range []string{"ops", "onBasePlusSluggingPlus"} {
+			_, err = store.PatchPositionPlayer(judge.ID, patch, []string{name})
+			assert.ErrorIs(t, err, ErrConstraint)
+		}
+		unpatched, err := store.GetPositionPlayerByID(judge.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, patched.Version, unpatched.Version)
+
+		_, err = store.PatchPositionPlayer(judge.ID+100, patch, []string{"homeRuns"})
+		assert.ErrorIs(t, err, ErrNotFound)
+
+		nola := newNola()
+		_, err = store.AddPitcher(nola)
+		assert.NoError(t, err)
+		pitcher, err := store.PatchPitcher(nola.ID, &models.Pitcher{ERA: 3.1}, []string{"earnedRunAvg"})
+		assert.NoError(t, err)
+		assert.Equal(t, 3.1, pitcher.ERA)
+		assert.Equal(t, nola.IP, pitcher.IP)
+		assert.NotNil(t, pitcher.Derived)
+
+		_, err = store.PatchPitcher(nola.ID, &models.Pitcher{}, []string{"fip_minus"})
+		assert.ErrorIs(t, err, ErrConstraint)
+	})
+}
+
+func TestConformanceDelete(t *testing.T) {
+	conform(t, func(t *testing.T, store Store) {
+		nola := newNola()
+		_, err := store.AddPitcher(nola)
+		assert.NoError(t, err)
+
+		assert.NoError(t, store.DeletePitcher(nola.ID, 0))
+		_, err = store.GetPitcherByID(nola.ID)
+		assert.ErrorIs(t, err, ErrNotFound)
+		assert.EqualError(t, err, fmt.Sprintf("pitcher %d not found", nola.ID))
+
+		_, err = store.GetPlayerByID(nola.PlayerID + 100)
+		assert.EqualError(t, err, fmt.Sprintf("player %d not found", nola.PlayerID+100))
+
+		assert.ErrorIs(t, store.DeletePitcher(nola.ID, 0), ErrNotFound)
+	})
+}
+
+func TestConformanceTrash(t *testing.T) {
+	conform(t, func(t *testing.T, store Store) {
+		nola := newNola()
+		_, err := store.AddPitcher(nola)
+		assert.NoError(t, err)
+		assert.NoError(t, store.DeletePitcher(nola.ID, 0))
+
+		// a trashed line is left out of every read, and can't be written
+		_, err = store.GetPitcherCareer(nola.ID)
+		assert.ErrorIs(t, err, ErrNotFound)
+		count, err := store.CountPitchers(&ListOptions{})
+		assert.NoError(t, err)
+		assert.Zero(t, count)
+		_, err = store.PatchPitcher(nola.ID, &models.Pitcher{W: 12}, []string{"wins"})
+		assert.ErrorIs(t, err, ErrNotFound)
+		nola.W = 12
+		assert.ErrorIs(t, store.UpdatePitcher(nola), ErrNotFound)
+
+		page, err := store.GetPitchers(&ListOptions{IncludeDeleted: true})
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(page.Items))
+		assert.Equal(t, 2, page.Items[0].Version)
+		assert.NotNil(t, page.Items[0].DeletedAt)
+		count, err = store.CountPitchers(&ListOptions{IncludeDeleted: true})
+		assert.NoError(t, err)
+		assert.Equal(t, 1, count)
+
+		// it still holds its name, team and season
+		_, err = store.AddPitcher(newNola())
+		var conflict *Error
+		assert.ErrorAs(t, err, &conflict)
+		assert.Equal(t, nola.ID, conflict.ID)
+
+		restored, err := store.UndeletePitcher(nola.ID)
+		assert.NoError(t, err)
+		assert.Nil(t, restored.DeletedAt)
+		assert.Equal(t, 3, restored.Version)
+		assert.Equal(t, 11, restored.W)
+
+		stored, err := store.GetPitcherByID(nola.ID)
+		assert.NoError(t, err)
+		assert.NotNil(t, stored.Derived)
+		// only reads compute the derived stats
+		stored.Derived = nil
+		assert.Equal(t, restored, stored)
+
+		_, err = store.UndeletePitcher(nola.ID)
+		assert.ErrorIs(t, err, ErrNotFound)
+		_, err = store.UndeletePitcher(nola.ID + 100)
+		assert.ErrorIs(t, err, ErrNotFound)
+
+		history, err := store.GetPitcherHistory(nola.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, 3, len(history))
+		assert.Equal(t, ChangeRestore, history[2].Action)
+		assert.NotNil(t, history[2].Before.DeletedAt)
+	})
+}
+
+func TestConformancePurge(t *testing.T) {
+	conform(t, func(t *testing.T, store Store) {
+		judge, nola := newJudge(), newNola()
+		_, err := store.AddPositionPlayer(judge)
+		assert.NoError(t, err)
+		_, err = store.AddPitcher(nola)
+		assert.NoError(t, err)
+		assert.NoError(t, store.DeletePitcher(nola.ID, 0))
+
+		// lines trashed since the retention cutoff are kept
+		report, err := store.PurgeDeleted(time.Now().Add(-time.Hour))
+		assert.NoError(t, err)
+		assert.Equal(t, &PurgeReport{}, report)
+
+		report, err = store.PurgeDeleted(time.Now().Add(time.Hour))
+		assert.NoError(t, err)
+		assert.Equal(t, &PurgeReport{Pitchers: 1}, report)
+
+		page, err := store.GetPitchers(&ListOptions{IncludeDeleted: true})
+		assert.NoError(t, err)
+		assert.Empty(t, page.Items)
+		_, err = store.UndeletePitcher(nola.ID)
+		assert.ErrorIs(t, err, ErrNotFound)
+		_, err = store.GetPositionPlayerByID(judge.ID)
+		assert.NoError(t, err)
+
+		history, err := store.GetPitcherHistory(nola.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, 3, len(history))
+		assert.Equal(t, ChangePurge, history[2].Action)
+		assert.Equal(t, SourceMaintenance, history[2].Source)
+		assert.Nil(t, history[2].After)
+
+		// a purged line can still be recreated from its history
+		restored, err := store.RestorePitcher(nola.ID, 1)
+		assert.NoError(t, err)
+		assert.Equal(t, 3, restored.Version)
+	})
+}
+
+func TestConformanceImportTrash(t *testing.T) {
+	conform(t, func(t *testing.T, store Store) {
+		opts := &ImportOptions{Mode: ImportLenient, Write: WriteUpsert, Season: 2022}
+		_, err := store.ImportPositionPlayers(strings.NewReader(invalidBattersCSV), opts)
+		assert.NoError(t, err)
+
+		lines := allPositionPlayers(t, store)
+		assert.Equal(t, 1, len(lines))
+		assert.NoError(t, store.DeletePositionPlayer(lines[0].ID, 0))
+
+		// a line in the trash isn't brought back or updated by an import
+		changed := strings.Replace(invalidBattersCSV, "157,696,62", "157,696,63", 1)
+		report, err := store.ImportPositionPlayers(strings.NewReader(changed), opts)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, report.Skipped)
+		assert.Zero(t, report.Updated)
+		assert.Empty(t, allPositionPlayers(t, store))
+
+		// a replace removes the trashed line along with the rest of its season
+		opts.Write = WriteReplace
+		report, err = store.ImportPositionPlayers(strings.NewReader(changed), opts)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, report.Deleted)
+		assert.Equal(t, 1, report.Inserted)
+		lines = allPositionPlayers(t, store)
+		assert.Equal(t, 1, len(lines))
+		assert.Equal(t, 63, lines[0].HR)
+	})
+}
+
+func TestConformanceVersion(t *testing.T) {
+	conform(t, func(t *testing.T, store Store) {
+		nola := newNola()
+		_, err := store.AddPitcher(nola)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, nola.Version)
+
+		stale, err := store.GetPitcherByID(nola.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, stale.Version)
+
+		nola.W = 12
+		assert.NoError(t, store.UpdatePitcher(nola))
+		assert.Equal(t, 2, nola.Version)
+
+		// written against version 1, after the line moved to version 2
+		stale.L = 14
+		assert.ErrorIs(t, store.UpdatePitcher(stale), ErrVersionMismatch)
+		_, err = store.PatchPitcher(nola.ID, stale, []string{"losses"})
+		assert.ErrorIs(t, err, ErrVersionMismatch)
+		_, err = store.PatchPitcher(nola.ID, stale, nil)
+		assert.ErrorIs(t, err, ErrVersionMismatch)
+		assert.ErrorIs(t, store.DeletePitcher(nola.ID, stale.Version), ErrVersionMismatch)
+
+		stored, err := store.GetPitcherByID(nola.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, 12, stored.W)
+		assert.Equal(t, 13, stored.L)
+
+		patched, err := store.PatchPitcher(nola.ID, &models.Pitcher{L: 14, Version: 2}, []string{"losses"})
+		assert.NoError(t, err)
+		assert.Equal(t, 3, patched.Version)
+
+		// a version of 0 writes whatever the version
+		stale.Version = 0
+		assert.NoError(t, store.UpdatePitcher(stale))
+		assert.Equal(t, 4, stale.Version)
+
+		missing := newNola()
+		missing.ID, missing.Version = nola.ID+100, 1
+		assert.ErrorIs(t, store.UpdatePitcher(missing), ErrNotFound)
+		assert.ErrorIs(t, store.DeletePitcher(missing.ID, 1), ErrNotFound)
+
+		assert.NoError(t, store.DeletePitcher(nola.ID, 4))
+	})
+}
+
+func TestConformanceBatch(t *testing.T) {
+	conform(t, func(t *testing.T, store Store) {
+		nola := newNola()
+		_, err := store.AddPitcher(nola)
+		assert.NoError(t, err)
+
+		updated := newNola()
+		updated.W = 12
+		wheeler := newNola()
+		wheeler.Name = "Zack Wheeler"
+		ops := []*BatchOp[models.Pitcher]{
+			{Action: BatchCreate, Line: wheeler},
+			{Action: BatchUpdate, ID: nola.ID, Version: 1, Line: updated},
+			// the line was just updated to version 2
+			{Action: BatchDelete, ID: nola.ID, Version: 1},
+		}
+
+		// an atomic batch with a failed operation writes nothing
+		results, err := store.BatchPitchers(ops, BatchAtomic)
+		assert.NoError(t, err)
+		assert.Equal(t, 3, len(results))
+		assert.ErrorIs(t, results[0].Err, ErrRolledBack)
+		assert.ErrorIs(t, results[1].Err, ErrRolledBack)
+		assert.ErrorIs(t, results[2].Err, ErrVersionMismatch)
+
+		stored, err := store.GetPitcherByID(nola.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, 11, stored.W)
+		assert.Equal(t, 1, stored.Version)
+		page, err := store.GetPitchers(&ListOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(page.Items))
+		history, err := store.GetPitcherHistory(nola.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(history))
+
+		// a line trashed by a failed atomic batch is taken back out of the trash
+		results, err = store.BatchPitchers([]*BatchOp[models.Pitcher]{
+			{Action: BatchDelete, ID: nola.ID, Version: 1},
+			{Action: BatchUpdate, ID: nola.ID + 100, Version: 1, Line: newNola()},
+		}, BatchAtomic)
+		assert.NoError(t, err)
+		assert.ErrorIs(t, results[0].Err, ErrRolledBack)
+		assert.ErrorIs(t, results[1].Err, ErrNotFound)
+
+		stored, err = store.GetPitcherByID(nola.ID)
+		assert.NoError(t, err)
+		assert.Nil(t, stored.DeletedAt)
+		assert.Equal(t, 1, stored.Version)
+
+		// a partial batch keeps the operations that succeeded
+		wheeler = newNola()
+		wheeler.Name = "Zack Wheeler"
+		ops[0].Line = wheeler
+		results, err = store.BatchPitchers(ops, BatchPartial)
+		assert.NoError(t, err)
+		assert.NoError(t, results[0].Err)
+		assert.NotZero(t, results[0].Line.ID)
+		assert.Equal(t, 1, results[0].Line.Version)
+		assert.NoError(t, results[1].Err)
+		assert.Equal(t, 12, results[1].Line.W)
+		assert.Equal(t, 2, results[1].Line.Version)
+		assert.Equal(t, nola.PlayerID, results[1].Line.PlayerID)
+		assert.ErrorIs(t, results[2].Err, ErrVersionMismatch)
+		assert.Nil(t, results[2].Line)
+
+		// a failed operation leaves nothing behind for the operations after it
+		results, err = store.BatchPitchers([]*BatchOp[models.Pitcher]{
+			{Action: BatchCreate, Line: newNola()},
+			{Action: BatchDelete, ID: nola.ID, Version: 2},
+			{Action: BatchDelete, ID: nola.ID},
+		}, BatchPartial)
+		assert.NoError(t, err)
+		assert.ErrorIs(t, results[0].Err, ErrConflict)
+		assert.NoError(t, results[1].Err)
+		assert.ErrorIs(t, results[2].Err, ErrNotFound)
+
+		page, err = store.GetPitchers(&ListOptions{})
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(page.Items))
+		assert.Equal(t, "Zack Wheeler", page.Items[0].Name)
+
+		// every operation of a successful atomic batch is written
+		added, err := store.BatchPositionPlayers([]*BatchOp[models.PositionPlayer]{
+			{Action: BatchCreate, Line: newJudge()},
+		}, BatchAtomic)
+		assert.NoError(t, err)
+		assert.NoError(t, added[0].Err)
+		assert.Equal(t, 1, len(allPositionPlayers(t, store)))
+	})
+}
+
+func TestConformanceImportVersion(t *testing.T) {
+	conform(t, func(t *testing.T, store Store) {
+		opts := &ImportOptions{Mode: ImportLenient, Write: WriteUpsert, Season: 2022}
+		_, err := store.ImportPositionPlayers(strings.NewReader(invalidBattersCSV), opts)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, allPositionPlayers(t, store)[0].Version)
+
+		// an unchanged line keeps its version
+		_, err = store.ImportPositionPlayers(strings.NewReader(invalidBattersCSV), opts)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, allPositionPlayers(t, store)[0].Version)
+
+		changed := strings.Replace(invalidBattersCSV, "157,696,62", "157,696,63", 1)
+		_, err = store.ImportPositionPlayers(strings.NewReader(changed), opts)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, allPositionPlayers(t, store)[0].Version)
+	})
+}
+
+func TestConformanceCareer(t *testing.T) {
+	conform(t, func(t *testing.T, store Store) {
+		nextSeason := newNola()
+		nextSeason.Season = 2023
+		_, err := store.AddPitcher(nextSeason)
+		assert.NoError(t, err)
+		nola := newNola()
+		_, err = store.AddPitcher(nola)
+		assert.NoError(t, err)
+
+		career, err := store.GetPitcherCareer(nextSeason.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, len(career))
+		assert.Equal(t, 2022, career[0].Season)
+		assert.Equal(t, 2023, career[1].Season)
+
+		_, err = store.GetPitcherCareer(nola.ID + 100)
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+}
+
+func TestConformanceList(t *testing.T) {
+	conform(t, func(t *testing.T, store Store) {
+		importAssets(t, store)
+
+		hr, _ := PositionPlayerFields.Lookup("homeRuns")
+		team, _ := PositionPlayerFields.Lookup("team")
+		war, _ := PositionPlayerFields.Lookup("winsAboveReplacement")
+		opts := &ListOptions{
+			Filters: []Filter{
+				{Field: team, Op: OpIn, Value: []any{"NYY", "LAD"}},
+				{Field: war, Op: OpGte, Value: 1.0},
+			},
+			Sort:  []SortKey{{Field: hr, Desc: true}},
+			Limit: 2,
+		}
+
+		page, err := store.GetPositionPlayers(opts)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, len(page.Items))
+		assert.Equal(t, "Aaron Judge", page.Items[0].Name)
+		assert.True(t, page.HasNext)
+		assert.False(t, page.HasPrev)
+
+		last := page.Items[1]
+		opts.After = NewCursor(opts.OrderKeys(), last)
+		next, err := store.GetPositionPlayers(opts)
+		assert.NoError(t, err)
+		assert.LessOrEqual(t, next.Items[0].HR, last.HR)
+		assert.NotEqual(t, last.ID, next.Items[0].ID)
+		assert.True(t, next.HasPrev)
+
+		opts.After, opts.Before = nil, NewCursor(opts.OrderKeys(), next.Items[0])
+		prev, err := store.GetPositionPlayers(opts)
+		assert.NoError(t, err)
+		assert.Equal(t, page.Items, prev.Items)
+		assert.False(t, prev.HasPrev)
+		assert.True(t, prev.HasNext)
+
+		total, err := store.CountPositionPlayers(opts)
+		assert.NoError(t, err)
+		count := 0
+		for _, line := range allPositionPlayers(t, store) {
+			if (line.Team == "NYY" || line.Team == "LAD") && line.WAR >= 1 {
+				count++
+			}
+		}
+		assert.Equal(t, count, total)
+	})
+}
+
+func TestConformanceDerived(t *testing.T) {
+	conform(t, func(t *testing.T, store Store) {
+		importAssets(t, store)
+
+		var obp, slg, pa float64
+		for _, line := range allPositionPlayers(t, store) {
+			obp += line.OBP * float64(line.PA)
+			slg += line.SLG * float64(line.PA)
+			pa += float64(line.PA)
+		}
+
+		name, _ := PositionPlayerFields.Lookup("name")
+		page, err := store.GetPositionPlayers(&ListOptions{Filters: []Filter{{Field: name, Op: OpEq, Value: "Aaron Judge"}}})
+		assert.NoError(t, err)
+		judge := page.Items[0]
+		// derived stats are rounded to the places of the stats they are computed from
+		assert.Equal(t, roundFloat(judge.OBP+judge.SLG, 3), judge.Derived.OPS)
+		assert.Equal(t, roundFloat(judge.WOBA-judge.XWOBA, 3), judge.Derived.WOBAMinusXWOBA)
+		assert.InDelta(t, 100*(judge.OBP/(obp/pa)+judge.SLG/(slg/pa)-1), judge.Derived.OPSPlus, 1)
+
+		stored, err := store.GetPositionPlayerByID(judge.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, judge.Derived, stored.Derived)
+
+		ops, _ := PositionPlayerFields.Lookup("ops")
+		opsPlus, _ := PositionPlayerFields.Lookup("onBasePlusSluggingPlus")
+		opts := &ListOptions{
+			Filters: []Filter{{Field: ops, Op: OpGte, Value: .8}},
+			Sort:    []SortKey{{Field: opsPlus, Desc: true}},
+			Limit:   3,
+		}
+		page, err = store.GetPositionPlayers(opts)
+		assert.NoError(t, err)
+		assert.Equal(t, "Aaron Judge", page.Items[0].Name)
+
+		seen := 0
+		for {
+			for i, line := range page.Items {
+				assert.GreaterOrEqual(t, line.Derived.OPS, .8)
+				if i > 0 {
+					assert.LessOrEqual(t, line.Derived.OPSPlus, page.Items[i-1].Derived.OPSPlus)
+				}
+			}
+			seen += len(page.Items)
+			if !page.HasNext {
+				break
+			}
+
+			opts.After = NewCursor(opts.OrderKeys(), page.Items[len(page.Items)-1])
+			page, err = store.GetPositionPlayers(opts)
+			assert.NoError(t, err)
+		}
+		total, err := store.CountPositionPlayers(opts)
+		assert.NoError(t, err)
+		assert.Equal(t, total, seen)
+
+		fipMinus, _ := PitcherFields.Lookup("fip_minus")
+		kbb, _ := PitcherFields.Lookup("strikeoutToWalkRatio")
+		pitchers, err := store.GetPitchers(&ListOptions{
+			Filters: []Filter{{Field: fipMinus, Op: OpLt, Value: 100}},
+			Sort:    []SortKey{{Field: kbb, Desc: true}},
+		})
+		assert.NoError(t, err)
+		assert.NotEmpty(t, pitchers.Items)
+		for i, line := range pitchers.Items {
+			assert.Less(t, line.Derived.FIPMinus, 100)
+			assert.InDelta(t, line.K9/line.BB9, line.Derived.KPerBB, .005)
+			assert.Equal(t, roundFloat(line.K9-line.BB9, 2), line.Derived.KMinusBB9)
+			assert.Equal(t, roundFloat(line.ERA-line.FIP, 2), line.Derived.ERAMinusFIP)
+			if i > 0 {
+				assert.LessOrEqual(t, line.Derived.KPerBB, pitchers.Items[i-1].Derived.KPerBB)
+			}
+		}
+	})
+}
+
+func TestConformanceDerivedRounding(t *testing.T) {
+	conform(t, func(t *testing.T, store Store) {
+		// a ratio halfway between two places is rounded away from zero, by sql as in memory
+		nola := newNola()
+		nola.K9, nola.BB9 = 10.25, 2
+		_, err := store.AddPitcher(nola)
+		assert.NoError(t, err)
+
+		stored, err := store.GetPitcherByID(nola.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, 5.13, stored.Derived.KPerBB)
+		assert.Equal(t, 8.25, stored.Derived.KMinusBB9)
+
+		kbb, _ := PitcherFields.Lookup("k_bb")
+		page, err := store.GetPitchers(&ListOptions{Filters: []Filter{{Field: kbb, Op: OpEq, Value: 5.13}}})
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(page.Items))
+	})
+}
+
+func TestConformanceLeagueAverages(t *testing.T) {
+	conform(t, func(t *testing.T, store Store) {
+		_, err := store.GetLeagueAverages(0)
+		assert.ErrorIs(t, err, ErrNotFound)
+
+		importAssets(t, store)
+
+		var obp, pa, lines float64
+		team, teamPA, teamLines := "", 0, 0
+		for _, line := range allPositionPlayers(t, store) {
+			obp += line.OBP * float64(line.PA)
+			pa += float64(line.PA)
+			lines++
+			if team == "" && line.Team != "" {
+				team = line.Team
+			}
+			if line.Team == team {
+				teamPA += line.PA
+				teamLines++
+			}
+		}
+
+		league, err := store.GetLeagueAverages(2022)
+		assert.NoError(t, err)
+		assert.Len(t, league, 1)
+		assert.Equal(t, 2022, league[0].Season)
+		assert.Empty(t, league[0].Team)
+		assert.Equal(t, int(lines), league[0].Batting.Lines)
+		assert.Equal(t, int(pa), league[0].Batting.PA)
+		assert.InDelta(t, obp/pa, league[0].Batting.OBP, 1e-9)
+		assert.NotZero(t, league[0].Pitching.FIP)
+
+		every, err := store.GetLeagueAverages(0)
+		assert.NoError(t, err)
+		assert.Equal(t, league, every)
+
+		averages, err := store.GetTeamAverages(team, 0)
+		assert.NoError(t, err)
+		assert.Len(t, averages, 1)
+		assert.Equal(t, team, averages[0].Team)
+		assert.Equal(t, teamLines, averages[0].Batting.Lines)
+		assert.Equal(t, teamPA, averages[0].Batting.PA)
+
+		_, err = store.GetLeagueAverages(1999)
+		assert.ErrorIs(t, err, ErrNotFound)
+		_, err = store.GetTeamAverages("NOPE", 0)
+		assert.ErrorIs(t, err, ErrNotFound)
+
+		// a dry run changes no lines, so the averages are left as they are
+		batters, err := os.Open("../../assets/batters_2022.csv")
+		assert.NoError(t, err)
+		defer batters.Close()
+		_, err = store.ImportPositionPlayers(batters, &ImportOptions{Mode: ImportLenient, Season: 2023, DryRun: true})
+		assert.NoError(t, err)
+		_, err = store.GetLeagueAverages(2023)
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+}
+
+func TestConformancePlayers(t *testing.T) {
+	conform(t, func(t *testing.T, store Store) {
+		importAssets(t, store)
+
+		nola, err := store.GetPlayerByExternalID("mlbam", "605400")
+		assert.NoError(t, err)
+		assert.Equal(t, "Aaron Nola", nola.Name)
+		assert.Equal(t, "16149", nola.FangraphsID)
+
+		byID, err := store.GetPlayerByID(nola.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, nola, byID)
+
+		_, err = store.GetPlayerByExternalID("mlbam", "1")
+		assert.ErrorIs(t, err, ErrNotFound)
+
+		_, err = store.GetPlayerByExternalID("espn", "1")
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+}
+
+func TestConformancePlayerIdentity(t *testing.T) {
+	conform(t, func(t *testing.T, store Store) {
+		// a pitcher traded during the season has a line for each team, both of the same player
+		phi := newNola()
+		phi.Player = &models.Player{Name: "Aaron Nola", FangraphsID: "16149", MLBAMID: "605400"}
+		_, err := store.AddPitcher(phi)
+		assert.NoError(t, err)
+		atl := newNola()
+		atl.Team = "ATL"
+		atl.Player = &models.Player{Name: "Aaron Nola", MLBAMID: "605400"}
+		_, err = store.AddPitcher(atl)
+		assert.NoError(t, err)
+		assert.Equal(t, phi.PlayerID, atl.PlayerID)
+
+		// so is a hitter traded without ids, matched by name
+		nyy := newJudge()
+		nyy.Name = "Joey Gallo"
+		_, err = store.AddPositionPlayer(nyy)
+		assert.NoError(t, err)
+		lad := newJudge()
+		lad.Name, lad.Team = "Joey Gallo", "LAD"
+		_, err = store.AddPositionPlayer(lad)
+		assert.NoError(t, err)
+		assert.Equal(t, nyy.PlayerID, lad.PlayerID)
+
+		// ids of a line matched by name to a player without ids are filled in
+		pitching := newNola()
+		pitching.Name, pitching.Team = "Joey Gallo", "NYY"
+		pitching.Player = &models.Player{Name: "Joey Gallo", MLBAMID: "608336"}
+		_, err = store.AddPitcher(pitching)
+		assert.NoError(t, err)
+		assert.Equal(t, nyy.PlayerID, pitching.PlayerID)
+		gallo, err := store.GetPlayerByExternalID("mlbam", "608336")
+		assert.NoError(t, err)
+		assert.Equal(t, nyy.PlayerID, gallo.ID)
+
+		// players sharing a name are told apart by their ids, even ids from different sources
+		dodger := newNola()
+		dodger.Name, dodger.Team = "Will Smith", "LAD"
+		dodger.Player = &models.Player{Name: "Will Smith", MLBAMID: "669257"}
+		_, err = store.AddPitcher(dodger)
+		assert.NoError(t, err)
+		brave := newNola()
+		brave.Name, brave.Team = "Will Smith", "ATL"
+		brave.Player = &models.Player{Name: "Will Smith", BBRefID: "smithwi04"}
+		_, err = store.AddPitcher(brave)
+		assert.NoError(t, err)
+		assert.NotEqual(t, dodger.PlayerID, brave.PlayerID)
+
+		// a line without ids sharing its name with several players is given a player of its own
+		unknown := newJudge()
+		unknown.Name, unknown.Team = "Will Smith", "LAD"
+		_, err = store.AddPositionPlayer(unknown)
+		assert.NoError(t, err)
+		assert.NotEqual(t, dodger.PlayerID, unknown.PlayerID)
+		assert.NotEqual(t, brave.PlayerID, unknown.PlayerID)
+		player, err := store.GetPlayerByID(unknown.PlayerID)
+		assert.NoError(t, err)
+		assert.Equal(t, &models.Player{ID: unknown.PlayerID, Name: "Will Smith"}, player)
+
+		smith, err := store.GetPlayerByExternalID("mlbam", "669257")
+		assert.NoError(t, err)
+		assert.Equal(t, dodger.PlayerID, smith.ID)
+		assert.Empty(t, smith.BBRefID)
+
+		// a two-way player's batting line without ids joins the pitcher added before it with ids
+		pitcher := newNola()
+		pitcher.Name, pitcher.Team = "Shohei Ohtani", "LAA"
+		pitcher.Player = &models.Player{Name: "Shohei Ohtani", FangraphsID: "19755", MLBAMID: "660271"}
+		_, err = store.AddPitcher(pitcher)
+		assert.NoError(t, err)
+		hitter := newJudge()
+		hitter.Name, hitter.Team = "Shohei Ohtani", "LAA"
+		_, err = store.AddPositionPlayer(hitter)
+		assert.NoError(t, err)
+		assert.Equal(t, pitcher.PlayerID, hitter.PlayerID)
+	})
+}
+
+func TestConformanceImport(t *testing.T) {
+	conform(t, func(t *testing.T, store Store) {
+		report, err := store.ImportPositionPlayers(strings.NewReader(invalidBattersCSV), &ImportOptions{Mode: ImportStrict, Write: WriteInsert, Season: 2022})
+		assert.ErrorIs(t, err, ErrInvalidRows)
+		assert.Equal(t, 2, report.Failed)
+		assert.Empty(t, allPositionPlayers(t, store))
+
+		report, err = store.ImportPositionPlayers(strings.NewReader(invalidBattersCSV), &ImportOptions{Mode: ImportLenient, Write: WriteInsert, Season: 2022})
+		assert.NoError(t, err)
+		assert.Equal(t, 1, report.Inserted)
+
+		report, err = store.ImportPositionPlayers(strings.NewReader(invalidBattersCSV), &ImportOptions{Mode: ImportLenient, Write: WriteInsert, Season: 2022})
+		assert.NoError(t, err)
+		assert.Equal(t, 0, report.Inserted)
+		assert.Equal(t, 1, report.Skipped)
+
+		report, err = store.ImportPositionPlayers(strings.NewReader(invalidBattersCSV), &ImportOptions{Mode: ImportLenient, Write: WriteUpsert, Season: 2022})
+		assert.NoError(t, err)
+		assert.Equal(t, 1, report.Unchanged)
+
+		changed := strings.Replace(invalidBattersCSV, "157,696,62", "157,696,63", 1)
+		report, err = store.ImportPositionPlayers(strings.NewReader(changed), &ImportOptions{Mode: ImportLenient, Write: WriteUpsert, Season: 2022})
+		assert.NoError(t, err)
+		assert.Equal(t, 1, report.Updated)
+		assert.Equal(t, 1, len(report.Changes))
+		assert.Equal(t, 63, allPositionPlayers(t, store)[0].HR)
+
+		report, err = store.ImportPositionPlayers(strings.NewReader(invalidBattersCSV), &ImportOptions{Mode: ImportLenient, Write: WriteReplace, Season: 2023})
+		assert.NoError(t, err)
+		assert.Equal(t, 0, report.Deleted)
+		assert.Equal(t, 1, report.Inserted)
+
+		report, err = store.ImportPositionPlayers(strings.NewReader(invalidBattersCSV), &ImportOptions{Mode: ImportLenient, Write: WriteReplace, Season: 2022})
+		assert.NoError(t, err)
+		assert.Equal(t, 1, report.Deleted)
+		assert.Equal(t, 1, report.Inserted)
+
+		lines := allPositionPlayers(t, store)
+		assert.Equal(t, 2, len(lines))
+		assert.Equal(t, lines[0].PlayerID, lines[1].PlayerID)
+	})
+}
+
+func TestConformanceImportRollback(t *testing.T) {
+	conform(t, func(t *testing.T, store Store) {
+		// the second line breaks a CHECK constraint, so the whole import is rolled back
+		csv := "Name,Team,G,PA,HR,R,RBI,SB,wRC+,BB%,K%,ISO,BABIP,AVG,OBP,SLG,wOBA,xwOBA,BsR,WAR\n" +
+			"Aaron Judge,NYY,157,696,62,133,131,16,207,.159,.251,.375,.340,.311,.425,.686,.458,.458,0.3,10.6\n" +
+			"Mookie Betts,LAD,142,639,-35,117,82,12,144,.086,.162,.264,.272,.269,.340,.533,.363,.359,2.0,6.6\n"
+
+		_, err := store.ImportPositionPlayers(strings.NewReader(csv), &ImportOptions{Mode: ImportLenient, Write: WriteInsert, Season: 2022})
+		assert.ErrorIs(t, err, ErrConstraint)
+		assert.Empty(t, allPositionPlayers(t, store))
+
+		// the line written before the invalid one was rolled back along with its key
+		_, err = store.ImportPositionPlayers(strings.NewReader(invalidBattersCSV), &ImportOptions{Mode: ImportLenient, Write: WriteInsert, Season: 2022})
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(allPositionPlayers(t, store)))
+
+		_, err = store.ImportPitchers(strings.NewReader(csv), &ImportOptions{Mode: ImportLenient, Write: WriteInsert, Season: 2022})
+		assert.ErrorIs(t, err, ErrInvalidCSV)
+	})
+}
+
+func TestConformanceImportStrict(t *testing.T) {
+	conform(t, func(t *testing.T, store Store) {
+		// the batches written before the invalid row are rolled back
+		report, err := store.ImportPositionPlayers(strings.NewReader(longBattersCSV(importBatchSize+1)), &ImportOptions{Mode: ImportStrict, Write: WriteInsert, Season: 2022})
+		assert.ErrorIs(t, err, ErrInvalidRows)
+		assert.Equal(t, 1, report.Failed)
+		assert.Zero(t, report.Inserted)
+		assert.Empty(t, allPositionPlayers(t, store))
+		_, err = store.GetLeagueAverages(2022)
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+}
+
+func TestConformanceHistory(t *testing.T) {
+	conform(t, func(t *testing.T, store Store) {
+		db := store.WithActor("scorer")
+
+		nola := newNola()
+		_, err := db.AddPitcher(nola)
+		assert.NoError(t, err)
+
+		// an empty patch writes nothing, so records nothing
+		_, err = db.PatchPitcher(nola.ID, &models.Pitcher{}, nil)
+		assert.NoError(t, err)
+
+		nola.W = 12
+		assert.NoError(t, db.UpdatePitcher(nola))
+		assert.NoError(t, db.DeletePitcher(nola.ID, 0))
+
+		history, err := store.GetPitcherHistory(nola.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, 3, len(history))
+
+		actions := []ChangeAction{}
+		for _, change := range history {
+			actions = append(actions, change.Action)
+			assert.Equal(t, "scorer", change.Actor)
+			assert.Equal(t, SourceAPI, change.Source)
+			assert.Equal(t, nola.ID, change.LineID)
+			assert.False(t, change.At.IsZero())
+		}
+		assert.Equal(t, []ChangeAction{ChangeCreate, ChangeUpdate, ChangeDelete}, actions)
+		assert.Nil(t, history[0].Before)
+		assert.Equal(t, 11, history[1].Before.W)
+		assert.Equal(t, 12, history[1].After.W)
+		// the history keeps lines as stored, without their derived stats
+		assert.Nil(t, history[1].After.Derived)
+		assert.Equal(t, 3, history[2].Version)
+		assert.Equal(t, 12, history[2].After.W)
+		assert.NotNil(t, history[2].After.DeletedAt)
+
+		// restoring the deleted line takes it out of the trash, at a new version
+		restored, err := store.RestorePitcher(nola.ID, 1)
+		assert.NoError(t, err)
+		assert.Equal(t, nola.ID, restored.ID)
+		assert.Equal(t, 11, restored.W)
+		assert.Equal(t, 4, restored.Version)
+		assert.Nil(t, restored.DeletedAt)
+
+		// the restored line is returned as a read returns it, with its derived stats
+		stored, err := store.GetPitcherByID(nola.ID)
+		assert.NoError(t, err)
+		assert.NotNil(t, restored.Derived)
+		assert.Equal(t, stored, restored)
+
+		// restoring an existing line moves it forward a version
+		restored, err = store.RestorePitcher(nola.ID, 2)
+		assert.NoError(t, err)
+		assert.Equal(t, 12, restored.W)
+		assert.Equal(t, 5, restored.Version)
+
+		history, err = store.GetPitcherHistory(nola.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, 5, len(history))
+		assert.Equal(t, ChangeRestore, history[4].Action)
+		assert.Equal(t, systemActor, history[4].Actor)
+		assert.Equal(t, 11, history[4].Before.W)
+
+		_, err = store.RestorePitcher(nola.ID, 10)
+		assert.ErrorIs(t, err, ErrNotFound)
+		_, err = store.GetPitcherHistory(nola.ID + 100)
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
+}
+
+func TestConformanceImportHistory(t *testing.T) {
+	conform(t, func(t *testing.T, store Store) {
+		opts := &ImportOptions{Mode: ImportLenient, Write: WriteUpsert, Season: 2022}
+		_, err := store.ImportPositionPlayers(strings.NewReader(invalidBattersCSV), opts)
+		assert.NoError(t, err)
+
+		changed := strings.Replace(invalidBattersCSV, "157,696,62", "157,696,63", 1)
+		_, err = store.WithActor("loader").ImportPositionPlayers(strings.NewReader(changed), opts)
+		assert.NoError(t, err)
+
+		opts.Write = WriteReplace
+		_, err = store.ImportPositionPlayers(strings.NewReader(invalidBattersCSV), opts)
+		assert.NoError(t, err)
+
+		lines := allPositionPlayers(t, store)
+		assert.Equal(t, 1, len(lines))
+
+		// the replaced line was purged along with its season, then inserted as a new line
+		history, err := store.GetPositionPlayerHistory(lines[0].ID)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(history))
+		assert.Equal(t, ChangeCreate, history[0].Action)
+
+		history, err = store.GetPositionPlayerHistory(lines[0].ID - 1)
+		assert.NoError(t, err)
+		assert.Equal(t, 3, len(history))
+		assert.Equal(t, ChangeUpdate, history[1].Action)
+		assert.Equal(t, "loader", history[1].Actor)
+		assert.Equal(t, SourceImport, history[1].Source)
+		assert.Equal(t, 62, history[1].Before.HR)
+		assert.Equal(t, 63, history[1].After.HR)
+		assert.Equal(t, ChangePurge, history[2].Action)
+		assert.Equal(t, systemActor, history[2].Actor)
+		assert.Nil(t, history[2].After)
+	})
+}
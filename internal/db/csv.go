@@ -0,0 +1,498 @@
+package db
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/e-berman/baseball_api/internal/models"
+)
+
+// ColumnMapping maps a csv column onto a field of a model
+//
+// Field is the JSON field name of the model, or player.<field> for the player's identity,
+// e.g. player.mlbamId. Headers lists every header name the column may appear under, matched
+// ignoring case and surrounding whitespace. Scale multiplies numeric values, e.g. 100 for
+// rates exported as fractions, and Precision rounds them to a number of decimal places
+type ColumnMapping struct {
+	Field     string   `json:"field"`
+	Headers   []string `json:"headers"`
+	Required  bool     `json:"required,omitempty"`
+	Scale     float64  `json:"scale,omitempty"`
+	Precision *int     `json:"precision,omitempty"`
+}
+
+// CSVMapping describes how the columns of a csv export map onto a model
+type CSVMapping struct {
+	Columns []ColumnMapping `json:"columns"`
+}
+
+// MissingColumnsError is returned when a csv header lacks required columns
+type MissingColumnsError struct {
+	Columns []string
+}
+
+func (e *MissingColumnsError) Error() string {
+	return "csv is missing required columns: " + strings.Join(e.Columns, ", ")
+}
+
+// Is reports a MissingColumnsError as ErrInvalidCSV
+func (e *MissingColumnsError) Is(target error) bool {
+	return target == ErrInvalidCSV
+}
+
+// places returns a pointer to a Precision for the default mappings
+func places(n int) *int {
+	return &n
+}
+
+// identity columns shared by the default mappings
+var identityColumns = []ColumnMapping{
+	{Field: "season", Headers: []string{"Season"}},
+	{Field: "player.nameAscii", Headers: []string{"NameASCII"}},
+	{Field: "player.fangraphsId", Headers: []string{"PlayerId", "playerid"}},
+	{Field: "player.mlbamId", Headers: []string{"MLBAMID", "xMLBAMID"}},
+}
+
+// DefaultPositionPlayerMapping maps the Fangraphs batting leaderboard export
+var DefaultPositionPlayerMapping = &CSVMapping{Columns: append([]ColumnMapping{
+	{Field: "name", Headers: []string{"Name"}, Required: true},
+	{Field: "team", Headers: []string{"Team", "Tm"}, Required: true},
+	{Field: "games", Headers: []string{"G"}, Required: true},
+	{Field: "plateAppearances", Headers: []string{"PA"}, Required: true},
+	{Field: "homeRuns", Headers: []string{"HR"}, Required: true},
+	{Field: "runs", Headers: []string{"R"}, Required: true},
+	{Field: "runsBattedIn", Headers: []string{"RBI"}, Required: true},
+	{Field: "stolenBases", Headers: []string{"SB"}, Required: true},
+	{Field: "weightedRunsCreatedPlus", Headers: []string{"wRC+"}, Required: true, Precision: places(0)},
+	{Field: "walkRate", Headers: []string{"BB%"}, Required: true, Scale: 100, Precision: places(1)},
+	{Field: "strikeoutRate", Headers: []string{"K%"}, Required: true, Scale: 100, Precision: places(1)},
+	{Field: "isolatedPower", Headers: []string{"ISO"}, Required: true, Precision: places(3)},
+	{Field: "battingAvgBallsInPlay", Headers: []string{"BABIP"}, Required: true, Precision: places(3)},
+	{Field: "battingAvg", Headers: []string{"AVG"}, Required: true, Precision: places(3)},
+	{Field: "onBasePct", Headers: []string{"OBP"}, Required: true, Precision: places(3)},
+	{Field: "sluggingPct", Headers: []string{"SLG"}, Required: true, Precision: places(3)},
+	{Field: "weightedOnBaseAvg", Headers: []string{"wOBA"}, Required: true, Precision: places(3)},
+	{Field: "expWeightedOnBaseAvg", Headers: []string{"xwOBA"}, Required: true, Precision: places(3)},
+	{Field: "baseRunning", Headers: []string{"BsR"}, Required: true, Precision: places(1)},
+	{Field: "winsAboveReplacement", Headers: []string{"WAR"}, Required: true, Precision: places(1)},
+}, identityColumns...)}
+
+// DefaultPitcherMapping maps the Fangraphs pitching leaderboard export
+var DefaultPitcherMapping = &CSVMapping{Columns: append([]ColumnMapping{
+	{Field: "name", Headers: []string{"Name"}, Required: true},
+	{Field: "team", Headers: []string{"Team", "Tm"}, Required: true},
+	{Field: "wins", Headers: []string{"W"}, Required: true},
+	{Field: "losses", Headers: []string{"L"}, Required: true},
+	{Field: "saves", Headers: []string{"SV"}, Required: true},
+	{Field: "games", Headers: []string{"G"}, Required: true},
+	{Field: "gamesSaved", Headers: []string{"GS"}, Required: true},
+	{Field: "inningsPitched", Headers: []string{"IP"}, Required: true},
+	{Field: "strikeoutsPerNine", Headers: []string{"K/9"}, Required: true, Precision: places(2)},
+	{Field: "walksPerNine", Headers: []string{"BB/9"}, Required: true, Precision: places(2)},
+	{Field: "homeRunsPerNine", Headers: []string{"HR/9"}, Required: true, Precision: places(2)},
+	{Field: "battingAvgBallsInPlay", Headers: []string{"BABIP"}, Required: true, Precision: places(3)},
+	{Field: "leftOnBase", Headers: []string{"LOB%"}, Required: true, Scale: 100, Precision: places(1)},
+	{Field: "groundballRate", Headers: []string{"GB%"}, Required: true, Scale: 100, Precision: places(1)},
+	{Field: "homeRunToFlyBallRatio", Headers: []string{"HR/FB"}, Required: true, Scale: 100, Precision: places(1)},
+	{Field: "fourseamFastballVelocity", Headers: []string{"vFA (pi)", "vFA (sc)", "FBv"}, Required: true, Precision: places(1)},
+	{Field: "earnedRunAvg", Headers: []string{"ERA"}, Required: true, Precision: places(2)},
+	{Field: "expectedEarnedRunAvg", Headers: []string{"xERA"}, Required: true, Precision: places(2)},
+	{Field: "fielderIndependentPitching", Headers: []string{"FIP"}, Required: true, Precision: places(2)},
+	{Field: "expectedFielderIndependentPitching", Headers: []string{"xFIP"}, Required: true, Precision: places(2)},
+	{Field: "winsAboveReplacement", Headers: []string{"WAR"}, Required: true, Precision: places(1)},
+}, identityColumns...)}
+
+// fields of a player's identity that a csv column may map onto
+var playerIdentityFields = newFieldSet(models.Player{}, map[string]string{
+	"nameAscii":   "name_ascii",
+	"fangraphsId": "fangraphs_id",
+	"mlbamId":     "mlbam_id",
+	"bbrefId":     "bbref_id",
+	"retroId":     "retro_id",
+})
+
+// LoadCSVMapping reads a column mapping definition from a JSON file
+//
+// the mapping is validated against the fields of the model it will be used for
+func LoadCSVMapping(path string, fields *FieldSet) (*CSVMapping, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	mapping := &CSVMapping{}
+	if err := json.NewDecoder(file).Decode(mapping); err != nil {
+		return nil, fmt.Errorf("invalid csv mapping %s: %w", path, err)
+	}
+
+	for _, column := range mapping.Columns {
+		if _, ok := mappedField(column.Field, fields); !ok {
+			return nil, fmt.Errorf("invalid csv mapping %s: unknown field %s", path, column.Field)
+		}
+		if len(column.Headers) == 0 {
+			return nil, fmt.Errorf("invalid csv mapping %s: no headers for field %s", path, column.Field)
+		}
+	}
+
+	return mapping, nil
+}
+
+// mappingFromEnv returns the mapping named by an environment variable, or the default mapping when unset
+func mappingFromEnv(env string, fields *FieldSet, defaultMapping *CSVMapping) (*CSVMapping, error) {
+	path := os.Getenv(env)
+	if path == "" {
+		return defaultMapping, nil
+	}
+
+	return LoadCSVMapping(path, fields)
+}
+
+// mappedField returns the model field a mapping targets, from either the model or the player's identity
+func mappedField(name string, fields *FieldSet) (Field, bool) {
+	if identityField, ok := strings.CutPrefix(name, "player."); ok {
+		return playerIdentityFields.Lookup(identityField)
+	}
+
+	return fields.Lookup(name)
+}
+
+// a column mapping bound to its position in a csv header
+type boundColumn struct {
+	ColumnMapping
+	index    int
+	field    Field
+	identity bool
+}
+
+// bind matches the mapping against a csv header
+//
+// returns a MissingColumnsError listing every required column not found in the header
+func (m *CSVMapping) bind(header []string, fields *FieldSet) ([]boundColumn, error) {
+	positions := map[string]int{}
+	for i, name := range header {
+		name = normalizeHeader(name)
+		if _, ok := positions[name]; !ok {
+			positions[name] = i
+		}
+	}
+
+	columns := []boundColumn{}
+	missing := []string{}
+	for _, column := range m.Columns {
+		index := -1
+		for _, name := range column.Headers {
+			if i, ok := positions[normalizeHeader(name)]; ok {
+				index = i
+				break
+			}
+		}
+
+		if index < 0 {
+			if column.Required {
+				missing = append(missing, column.Headers[0])
+			}
+			continue
+		}
+
+		field, ok := mappedField(column.Field, fields)
+		if !ok {
+			return nil, fmt.Errorf("csv mapping has unknown field: %s", column.Field)
+		}
+
+		columns = append(columns, boundColumn{
+			ColumnMapping: column,
+			index:         index,
+			field:         field,
+			identity:      strings.HasPrefix(column.Field, "player."),
+		})
+	}
+
+	if len(missing) > 0 {
+		return nil, &MissingColumnsError{Columns: missing}
+	}
+
+	return columns, nil
+}
+
+// normalizeHeader strips the UTF-8 byte order mark, whitespace and case from a header name
+func normalizeHeader(name string) string {
+	return strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+}
+
+// parse converts a raw csv cell into the value stored in the column's field
+//
+// percentages exported with a % sign are taken as already scaled. an integer field only takes whole
+// numbers, unless its mapping sets a Precision to round a decimal export to, as wRC+ is exported
+func (c boundColumn) parse(raw string) (any, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		if c.Required {
+			return nil, errors.New("value is required")
+		}
+		// optional columns left blank keep the field's zero value
+		return nil, nil
+	}
+	if c.field.Kind == KindString {
+		return raw, nil
+	}
+
+	scale := c.Scale
+	if trimmed, ok := strings.CutSuffix(raw, "%"); ok {
+		raw = strings.TrimSpace(trimmed)
+		scale = 0
+	}
+
+	if c.field.Kind == KindInt && c.Precision == nil {
+		// a count must be whole, rather than being cut down to one
+		val, err := ConvertToInt(raw)
+		if err != nil {
+			return nil, errors.New("not an integer")
+		}
+		if scale != 0 {
+			scaled := float64(val) * scale
+			if scaled != math.Trunc(scaled) {
+				return nil, errors.New("not an integer once scaled")
+			}
+			return int(scaled), nil
+		}
+		return val, nil
+	}
+
+	val, err := ConvertToFloat(raw)
+	if err != nil {
+		return nil, errors.New("not a number")
+	}
+	if scale != 0 {
+		val *= scale
+	}
+	if c.Precision != nil {
+		val = roundFloat(val, uint(*c.Precision))
+	}
+
+	if c.field.Kind == KindInt {
+		// an integer column exported with decimals is rounded to the nearest whole number
+		return int(math.Round(val)), nil
+	}
+
+	return val, nil
+}
+
+// csvReader reads the rows of a csv into models of type T one at a time
+type csvReader[T any] struct {
+	reader  *csv.Reader
+	columns []boundColumn
+	fields  *FieldSet
+	season  Field
+	name    Field
+	// default season for csvs without a season column
+	defaultSeason int
+	// attaches the identity of a row's player to the row
+	attach func(*T, *models.Player)
+}
+
+// newCSVReader reads the header of a csv and binds the mapping to it
+//
+// rows without a season column are given the season passed in
+func newCSVReader[T any](r io.Reader, mapping *CSVMapping, fields *FieldSet, season int, attach func(*T, *models.Player)) (*csvReader[T], error) {
+	reader := csv.NewReader(r)
+	// short rows are reported per row rather than failing the whole csv
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, &Error{Kind: ErrInvalidCSV, Err: fmt.Errorf("unable to read csv header: %w", err)}
+	}
+
+	columns, err := mapping.bind(header, fields)
+	if err != nil {
+		return nil, err
+	}
+
+	seasonField, _ := fields.Lookup("season")
+	nameField, _ := fields.Lookup("name")
+
+	return &csvReader[T]{
+		reader:        reader,
+		columns:       columns,
+		fields:        fields,
+		season:        seasonField,
+		name:          nameField,
+		defaultSeason: season,
+		attach:        attach,
+	}, nil
+}
+
+// Next returns the next row of the csv, or io.EOF once every row has been read
+//
+// a row with invalid values is returned as nil along with an error for each invalid value,
+// and reading can carry on with the following row. any other error ends the csv
+func (c *csvReader[T]) Next() (*T, []*RowError, error) {
+	record, err := c.reader.Read()
+	if err != nil {
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return nil, []*RowError{{Row: parseErr.StartLine, Reason: parseErr.Err.Error()}}, nil
+		}
+		return nil, nil, err
+	}
+	line, _ := c.reader.FieldPos(0)
+
+	row := new(T)
+	identity := &models.Player{}
+	hasIdentity := false
+	rowErrs := []*RowError{}
+
+	c.season.setValue(row, c.defaultSeason)
+	for _, column := range c.columns {
+		if column.index >= len(record) {
+			rowErrs = append(rowErrs, &RowError{Row: line, Column: column.Headers[0], Reason: "value is missing"})
+			continue
+		}
+
+		raw := record[column.index]
+		value, err := column.parse(raw)
+		if err != nil {
+			rowErrs = append(rowErrs, &RowError{Row: line, Column: column.Headers[0], Value: raw, Reason: err.Error()})
+			continue
+		}
+		if value == nil {
+			continue
+		}
+
+		if column.identity {
+			column.field.setValue(identity, value)
+			hasIdentity = true
+			continue
+		}
+		column.field.setValue(row, value)
+	}
+
+	if len(rowErrs) > 0 {
+		return nil, rowErrs, nil
+	}
+
+	if c.season.ValueOf(row).(int) <= 0 {
+		reason := "no season, add a Season column or a year to the file name"
+		return nil, []*RowError{{Row: line, Column: "Season", Reason: reason}}, nil
+	}
+
+	if hasIdentity {
+		identity.Name = c.name.ValueOf(row).(string)
+		c.attach(row, identity)
+	}
+
+	return row, nil, nil
+}
+
+// readAll reads every row of a csv
+//
+// rows with invalid values are left out and their errors returned alongside the valid rows
+func (c *csvReader[T]) readAll() ([]*T, []*RowError, error) {
+	rows := []*T{}
+	rowErrs := []*RowError{}
+	for {
+		row, errs, err := c.Next()
+		if err == io.EOF {
+			return rows, rowErrs, nil
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to read csv: %w", err)
+		}
+
+		if len(errs) > 0 {
+			rowErrs = append(rowErrs, errs...)
+			continue
+		}
+		rows = append(rows, row)
+	}
+}
+
+// setValue sets the field on a model to a value of the field's kind
+//
+// setting a derived field gives a model without derived stats its own
+func (f Field) setValue(model any, value any) {
+	target := reflect.ValueOf(model).Elem()
+	if f.derived {
+		target = target.FieldByName("Derived")
+		if target.IsNil() {
+			target.Set(reflect.New(target.Type().Elem()))
+		}
+		target = target.Elem()
+	}
+
+	target.Field(f.index).Set(reflect.ValueOf(value))
+}
+
+// matches the season year in a csv file name
+var seasonPattern = regexp.MustCompile(`\d{4}`)
+
+// SeasonFromFilename returns the season a csv file holds stats for
+//
+// the season is the four digit year in the file name, e.g. assets/batters_2022.csv,
+// or 0 when the file name has no year
+func SeasonFromFilename(path string) int {
+	year := seasonPattern.FindString(filepath.Base(path))
+	if year == "" {
+		return 0
+	}
+
+	season, _ := strconv.Atoi(year)
+	return season
+}
+
+// attaches a player's identity to their position player line
+func attachPositionPlayer(player *models.PositionPlayer, identity *models.Player) {
+	player.Player = identity
+}
+
+// attaches a player's identity to their pitcher line
+func attachPitcher(player *models.Pitcher, identity *models.Player) {
+	player.Player = identity
+}
+
+// newPositionPlayerReader returns a reader of position player csv rows
+func newPositionPlayerReader(r io.Reader, mapping *CSVMapping, season int) (*csvReader[models.PositionPlayer], error) {
+	return newCSVReader(r, mapping, PositionPlayerFields, season, attachPositionPlayer)
+}
+
+// newPitcherReader returns a reader of pitcher csv rows
+func newPitcherReader(r io.Reader, mapping *CSVMapping, season int) (*csvReader[models.Pitcher], error) {
+	return newCSVReader(r, mapping, PitcherFields, season, attachPitcher)
+}
+
+// ReadFromCSVPositionPlayer reads position players from a csv using a column mapping
+//
+// season is used for rows when the csv has no Season column.
+// rows with invalid values are left out and returned as row errors
+func ReadFromCSVPositionPlayer(r io.Reader, mapping *CSVMapping, season int) ([]*models.PositionPlayer, []*RowError, error) {
+	reader, err := newPositionPlayerReader(r, mapping, season)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return reader.readAll()
+}
+
+// ReadFromCSVPitcher reads pitchers from a csv using a column mapping
+//
+// season is used for rows when the csv has no Season column.
+// rows with invalid values are left out and returned as row errors
+func ReadFromCSVPitcher(r io.Reader, mapping *CSVMapping, season int) ([]*models.Pitcher, []*RowError, error) {
+	reader, err := newPitcherReader(r, mapping, season)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return reader.readAll()
+}
@@ -0,0 +1,100 @@
+package db
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// Cursor marks a position in a sorted list query
+//
+// Values holds the sort key values of the row the cursor was taken from, in OrderKeys order
+type Cursor struct {
+	Values []any
+}
+
+// the encoded form of a Cursor
+//
+// the sort is recorded so a cursor can't be reused with a different ordering
+type cursorToken struct {
+	Sort   string `json:"s"`
+	Values []any  `json:"v"`
+}
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// NewCursor returns a cursor positioned at a given row of a list query
+func NewCursor(keys []SortKey, row any) *Cursor {
+	values := make([]any, len(keys))
+	for i, key := range keys {
+		values[i] = key.Field.ValueOf(row)
+	}
+
+	return &Cursor{Values: values}
+}
+
+// EncodeCursor returns the opaque string form of a cursor for the given sort keys
+func EncodeCursor(keys []SortKey, cursor *Cursor) string {
+	data, _ := json.Marshal(cursorToken{Sort: sortSignature(keys), Values: cursor.Values})
+
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeCursor parses a cursor produced by EncodeCursor
+//
+// returns ErrInvalidCursor if the token is malformed or was produced for different sort keys
+func DecodeCursor(keys []SortKey, token string) (*Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	decoded := cursorToken{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	if decoded.Sort != sortSignature(keys) || len(decoded.Values) != len(keys) {
+		return nil, ErrInvalidCursor
+	}
+
+	// json numbers decode as float64, so convert each value back to its column type
+	values := make([]any, len(keys))
+	for i, key := range keys {
+		switch key.Field.Kind {
+		case KindInt:
+			val, ok := decoded.Values[i].(float64)
+			if !ok {
+				return nil, ErrInvalidCursor
+			}
+			values[i] = int(val)
+		case KindFloat:
+			val, ok := decoded.Values[i].(float64)
+			if !ok {
+				return nil, ErrInvalidCursor
+			}
+			values[i] = val
+		default:
+			val, ok := decoded.Values[i].(string)
+			if !ok {
+				return nil, ErrInvalidCursor
+			}
+			values[i] = val
+		}
+	}
+
+	return &Cursor{Values: values}, nil
+}
+
+// sortSignature returns the sort keys in sort query string form, e.g. -war,name,id
+func sortSignature(keys []SortKey) string {
+	names := make([]string, len(keys))
+	for i, key := range keys {
+		names[i] = key.Field.Name
+		if key.Desc {
+			names[i] = "-" + names[i]
+		}
+	}
+
+	return strings.Join(names, ",")
+}
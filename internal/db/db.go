@@ -2,44 +2,129 @@ package db
 
 import (
 	"context"
-	"encoding/csv"
+	"errors"
+	"io"
 	"log"
 	"math"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/e-berman/baseball_api/internal/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/joho/godotenv"
 )
 
 // creates the DB type for handlers.go to utilize in the various routes
+//
+// every implementation reports failures as an Error of the same kind, e.g. ErrNotFound
+// when updating or deleting a line that doesn't exist
+//
+// lines are written only while at the version given, by the line's Version when updating or patching
+// and the version argument when deleting, otherwise an ErrVersionMismatch Error is reported. a
+// version of 0 writes the line whatever its version
+//
+// every write to a line, including the writes of a batch or an import, is recorded in the line's history
+// with the line before and after it, in the same transaction as the write. writes are recorded as made
+// by the actor of a DB returned by WithActor, or by the system
+//
+// deleting a line moves it to the trash, where it is hidden from every read unless ListOptions.IncludeDeleted
+// is set, and can't be written until it is undeleted. a trashed line keeps its name, team and season,
+// so a line added with the same ones conflicts with it
+//
+// the league and team averages of each season are recomputed from the live lines by every import,
+// and the derived stats adjusted to the league, like OPS+, use those of the last import
 type DB interface {
-	AddPositionPlayer(*models.PositionPlayer) error
-	DeletePositionPlayer(int) error
+	AddPositionPlayer(*models.PositionPlayer) (*models.PositionPlayer, error)
+	DeletePositionPlayer(int, int) error
 	UpdatePositionPlayer(*models.PositionPlayer) error
-	GetPositionPlayers() ([]*models.PositionPlayer, error)
+	PatchPositionPlayer(int, *models.PositionPlayer, []string) (*models.PositionPlayer, error)
+	GetPositionPlayers(*ListOptions) (*Page[*models.PositionPlayer], error)
+	CountPositionPlayers(*ListOptions) (int, error)
 	GetPositionPlayerByID(int) (*models.PositionPlayer, error)
-	AddPitcher(*models.Pitcher) error
-	DeletePitcher(int) error
+	GetPositionPlayerCareer(int) ([]*models.PositionPlayer, error)
+	BatchPositionPlayers([]*BatchOp[models.PositionPlayer], BatchMode) ([]*BatchResult[models.PositionPlayer], error)
+	GetPositionPlayerHistory(int) ([]*Change[models.PositionPlayer], error)
+	RestorePositionPlayer(int, int) (*models.PositionPlayer, error)
+	UndeletePositionPlayer(int) (*models.PositionPlayer, error)
+	AddPitcher(*models.Pitcher) (*models.Pitcher, error)
+	DeletePitcher(int, int) error
 	UpdatePitcher(*models.Pitcher) error
-	GetPitchers() ([]*models.Pitcher, error)
+	PatchPitcher(int, *models.Pitcher, []string) (*models.Pitcher, error)
+	GetPitchers(*ListOptions) (*Page[*models.Pitcher], error)
+	CountPitchers(*ListOptions) (int, error)
 	GetPitcherByID(int) (*models.Pitcher, error)
+	GetPitcherCareer(int) ([]*models.Pitcher, error)
+	BatchPitchers([]*BatchOp[models.Pitcher], BatchMode) ([]*BatchResult[models.Pitcher], error)
+	GetPitcherHistory(int) ([]*Change[models.Pitcher], error)
+	RestorePitcher(int, int) (*models.Pitcher, error)
+	UndeletePitcher(int) (*models.Pitcher, error)
+	GetPlayerByID(int) (*models.Player, error)
+	GetPlayerByExternalID(string, string) (*models.Player, error)
+	GetLeagueAverages(int) ([]*models.LeagueAverages, error)
+	GetTeamAverages(string, int) ([]*models.LeagueAverages, error)
+	ImportPositionPlayers(io.Reader, *ImportOptions) (*ImportReport, error)
+	ImportPitchers(io.Reader, *ImportOptions) (*ImportReport, error)
+	WithActor(string) DB
+}
+
+// Store is a DB the server is started with, which also migrates its schema and imports the csvs in assets
+type Store interface {
+	DB
+	Initialize() error
+	MigrateTo(int) error
+	SchemaVersion() (int, error)
+	ImportPositionPlayerDataFromCSV(*ImportOptions) ([]*ImportReport, error)
+	ImportPitcherDataFromCSV(*ImportOptions) ([]*ImportReport, error)
+	PurgeDeleted(time.Time) (*PurgeReport, error)
+	Close()
+}
+
+// Open returns the Store named by DATABASE_URL, or by POSTGRES_URL when unset
+//
+// a url of memory: keeps every table in memory, so the server can run from the csvs without a database.
+// sqlite:path, e.g. sqlite:baseball.db, stores the tables in a SQLite file.
+// anything else is a Postgres connection string
+func Open() (Store, error) {
+	godotenv.Load()
+
+	url := os.Getenv("DATABASE_URL")
+	if url == "" {
+		url = os.Getenv("POSTGRES_URL")
+	}
+
+	if strings.HasPrefix(url, "memory:") {
+		log.Println("Using in-memory db")
+		return NewMemoryDB(), nil
+	}
+	if path, ok := strings.CutPrefix(url, "sqlite:"); ok {
+		return NewSQLiteDB(strings.TrimPrefix(path, "//"))
+	}
+
+	return NewDBPool(url)
+}
+
+// querier runs queries on either the pool or a transaction
+type querier interface {
+	Exec(context.Context, string, ...any) (pgconn.CommandTag, error)
+	QueryRow(context.Context, string, ...any) pgx.Row
 }
 
 // Holds the pgxpool.Pool type for the initialization of the Postgres database via the pgx driver
 type DBPool struct {
 	Poolconn *pgxpool.Pool
+	// who the writes are recorded as made by
+	actor string
 }
 
 // NewDBPool returns a DBPool instance
 //
-// sets up a new Postgres pgx pool
+// sets up a new Postgres pgx pool for the given connection string
 // if pgx pool is unable to be set up, will fail
-func NewDBPool() (*DBPool, error) {
-	godotenv.Load()
-	db_url := os.Getenv("POSTGRES_URL")
-
+func NewDBPool(db_url string) (*DBPool, error) {
 	pool, err := pgxpool.New(context.Background(), db_url)
 	if err != nil {
 		log.Fatal("Unable to create connection pool: ", err)
@@ -52,75 +137,24 @@ func NewDBPool() (*DBPool, error) {
 	}, nil
 }
 
-// InitializePlayerTable will create the position_players postgres table upon initialization
-func (pool *DBPool) InitializePositionPlayerTable() error {
-	return pool.CreatePositionPlayerTable()
-}
-
-func (pool *DBPool) InitializePitcherTable() error {
-	return pool.CreatePitcherTable()
-}
-
-// CreatePlayerTable executes the query to create the position_player table with pgx
-func (pool *DBPool) CreatePositionPlayerTable() error {
-	query := `create table if not exists position_players (
-		player_id serial primary key NOT NULL,
-		name text NOT NULL,
-		team text,
-		g int CHECK (g >= 0),
-		pa int CHECK (pa >= 0),
-		hr int CHECK (hr >= 0),
-		runs int CHECK (runs >= 0),
-		rbi int CHECK (rbi >= 0),
-		sb int CHECK (sb >= 0),
-		wrc_plus int CHECK (wrc_plus >= 0),
-		bb_rate float8 CHECK (bb_rate >= 0),
-		k_rate float8 CHECK (k_rate >= 0),
-		iso float8 CHECK (iso >= 0),
-	        babip float8 CHECK (babip >= 0),
-		average float8 CHECK (average >= 0),
-		obp float8 CHECK (obp >= 0),
-		slg float8 CHECK (slg >= 0),
-		woba float8 CHECK (woba >= 0),
-		x_woba float8 CHECK (x_woba >= 0),
-		bsr float8,
-		war float8,
-		unique (name, team))`
-
-	_, err := pool.Poolconn.Exec(context.Background(), query)
-
-	return err
-}
-
-func (pool *DBPool) CreatePitcherTable() error {
-	query := `CREATE table IF NOT EXISTS pitchers (
-		player_id serial primary key NOT NULL,
-		name text NOT NULL,
-		team text,
-		w int CHECK (w >= 0),
-		l int CHECK (l >= 0),
-	        sv int CHECK (sv >= 0),
-		g int CHECK (g >= 0),
-		gs int CHECK (gs >= 0),
-		ip float8 CHECK (ip >= 0),
-		k9 float8 CHECK (k9 >= 0),
-		bb9 float8 CHECK (bb9 >= 0),
-		hr9 float8 CHECK (hr9 >= 0),
-		babip float8 CHECK (babip >= 0),
-		lob float8 CHECK (lob >= 0),
-		gb float8 CHECK (gb >= 0),
-		hrfb float8 CHECK (hrfb >= 0),
-		vfa float8 CHECK (vfa >= 0),
-		era float8 CHECK (era >= 0),
-		xera float8 CHECK (xera >= 0),
-		fip float8 CHECK (fip >= 0),
-		xfip float8 CHECK (xfip >= 0),
-		war float8,
-		unique (name, team))`
-
-	_, err := pool.Poolconn.Exec(context.Background(), query)
-
-	return err
+// WithActor returns a DB sharing the pool whose writes are recorded as made by actor
+func (pool *DBPool) WithActor(actor string) DB {
+	return &DBPool{Poolconn: pool.Poolconn, actor: actor}
+}
+
+// author returns who the pool's api writes are made by
+func (pool *DBPool) author() author {
+	return newAuthor(pool.actor, SourceAPI)
+}
+
+// Initialize applies any schema migrations not yet applied
+func (pool *DBPool) Initialize() error {
+	return pool.MigrateTo(LatestVersion)
+}
+
+// Close closes every connection in the pool
+func (pool *DBPool) Close() {
+	pool.Poolconn.Close()
 }
 
 // ClearPlayerTable clears the position_players table for testing purposes
@@ -140,28 +174,24 @@ func (pool *DBPool) ClearPlayerTable() error {
 	return nil
 }
 
-func ConvertFloatToInt(record string) int {
-	val := ConvertToFloat(record)
-
-	return int(val)
-}
-
-func ConvertToInt(record string) int {
-	val, err := strconv.Atoi(record)
+// ConvertFloatToInt converts a csv value written as a decimal to an int, truncating it
+func ConvertFloatToInt(record string) (int, error) {
+	val, err := ConvertToFloat(record)
 	if err != nil {
-		log.Fatal(err)
+		return 0, err
 	}
 
-	return val
+	return int(val), nil
 }
 
-func ConvertToFloat(record string) float64 {
-	val, err := strconv.ParseFloat(record, 64)
-	if err != nil {
-		log.Fatal(err)
-	}
+// ConvertToInt converts a csv value to an int
+func ConvertToInt(record string) (int, error) {
+	return strconv.Atoi(strings.TrimSpace(record))
+}
 
-	return val
+// ConvertToFloat converts a csv value to a float
+func ConvertToFloat(record string) (float64, error) {
+	return strconv.ParseFloat(strings.TrimSpace(record), 64)
 }
 
 // round function used from:
@@ -171,152 +201,168 @@ func roundFloat(val float64, precision uint) float64 {
 	return math.Round(val*ratio) / ratio
 }
 
-func ReadFromCSVPositionPlayer() []*models.PositionPlayer {
-	file, err := os.Open("./assets/batters.csv")
+// columns selected for a position player, in scanPositionPlayer order
+const positionPlayerColumns = `player_id, name, team, season, g, pa, hr, runs, rbi, sb, wrc_plus,
+	bb_rate, k_rate, iso, babip, average, obp, slg, woba, x_woba, bsr, war, player_ref, version, deleted_at`
+
+// scanPositionPlayer scans a row of positionPlayerColumns into a PositionPlayer
+func scanPositionPlayer(row pgx.Row) (*models.PositionPlayer, error) {
+	player := &models.PositionPlayer{}
+	err := row.Scan(
+		&player.ID,
+		&player.Name,
+		&player.Team,
+		&player.Season,
+		&player.G,
+		&player.PA,
+		&player.HR,
+		&player.R,
+		&player.RBI,
+		&player.SB,
+		&player.WRCPlus,
+		&player.BbRate,
+		&player.KRate,
+		&player.ISO,
+		&player.BABIP,
+		&player.AVG,
+		&player.OBP,
+		&player.SLG,
+		&player.WOBA,
+		&player.XWOBA,
+		&player.BsR,
+		&player.WAR,
+		&player.PlayerID,
+		&player.Version,
+		&player.DeletedAt,
+	)
 	if err != nil {
-		log.Fatalf("Unable to open CSV file: %v\n", err)
+		return nil, pgError(err)
 	}
-	defer file.Close()
+	if player.DeletedAt != nil {
+		*player.DeletedAt = player.DeletedAt.UTC()
+	}
+
+	return player, nil
+}
 
-	reader := csv.NewReader(file)
-	records, err := reader.ReadAll()
+// GetPlayers will return a list of players
+//
+// retrieves a page of the players in the position_players table matching the list options, in sorted order,
+// each with its derived stats
+func (pool *DBPool) GetPositionPlayers(opts *ListOptions) (*Page[*models.PositionPlayer], error) {
+	clauses, args := listClauses(opts)
+	query := `SELECT ` + positionPlayerTable.readColumns() + ` FROM ` + positionPlayerTable.source() + clauses
+
+	rows, err := pool.Poolconn.Query(context.Background(), query, args...)
 	if err != nil {
-		log.Fatalf("Unable to read CSV file: %v\n", err)
+		return nil, pgError(err)
 	}
+	defer rows.Close()
 
-	var players []*models.PositionPlayer
-
-	for i, record := range records {
-		if i == 0 {
-			continue
+	players := []*models.PositionPlayer{}
+	for rows.Next() {
+		player, err := positionPlayerTable.scanDerived(rows)
+		if err != nil {
+			return nil, pgError(err)
 		}
 
-		adjusted_bb_rate := ConvertToFloat(record[9]) * 100
-		adjusted_k_rate := ConvertToFloat(record[10]) * 100
-
-		row := &models.PositionPlayer{
-			Name:    record[0],
-			Team:    record[1],
-			G:       ConvertToInt(record[2]),
-			PA:      ConvertToInt(record[3]),
-			HR:      ConvertToInt(record[4]),
-			R:       ConvertToInt(record[5]),
-			RBI:     ConvertToInt(record[6]),
-			SB:      ConvertToInt(record[7]),
-			WRCPlus: ConvertFloatToInt(record[8]),
-			BbRate:  roundFloat(adjusted_bb_rate, 1),
-			KRate:   roundFloat(adjusted_k_rate, 1),
-			ISO:     roundFloat(ConvertToFloat(record[11]), 3),
-			BABIP:   roundFloat(ConvertToFloat(record[12]), 3),
-			AVG:     roundFloat(ConvertToFloat(record[13]), 3),
-			OBP:     roundFloat(ConvertToFloat(record[14]), 3),
-			SLG:     roundFloat(ConvertToFloat(record[15]), 3),
-			WOBA:    roundFloat(ConvertToFloat(record[16]), 3),
-			XWOBA:   roundFloat(ConvertToFloat(record[17]), 3),
-			BsR:     roundFloat(ConvertToFloat(record[18]), 1),
-			WAR:     roundFloat(ConvertToFloat(record[19]), 1),
-		}
-		players = append(players, row)
+		players = append(players, player)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, pgError(err)
 	}
 
-	return players
+	return newPage(players, opts), nil
 }
 
-func ReadFromCSVPitcher() []*models.Pitcher {
-	file, err := os.Open("./assets/pitchers.csv")
-	if err != nil {
-		log.Fatalf("Unable to open CSV file: %v\n", err)
-	}
-	defer file.Close()
+// CountPositionPlayers returns the number of players in the position_players table matching the list filters
+func (pool *DBPool) CountPositionPlayers(opts *ListOptions) (int, error) {
+	where, args := whereClause(&ListOptions{Filters: opts.Filters, IncludeDeleted: opts.IncludeDeleted})
+	query := `SELECT count(*) FROM ` + positionPlayerTable.source() + where
 
-	reader := csv.NewReader(file)
-	records, err := reader.ReadAll()
-	if err != nil {
-		log.Fatalf("Unable to read CSV file: %v\n", err)
-	}
+	count := 0
+	err := pool.Poolconn.QueryRow(context.Background(), query, args...).Scan(&count)
 
-	var players []*models.Pitcher
+	return count, pgError(err)
+}
 
-	for i, record := range records {
-		if i == 0 {
-			continue
-		}
+// GetPlayerByID will return a player
+//
+// it will query the position_players table based on a given player id, leaving out a line in the trash.
+// the line is returned with its derived stats
+func (pool *DBPool) GetPositionPlayerByID(id int) (*models.PositionPlayer, error) {
+	query := `SELECT ` + positionPlayerTable.readColumns() + ` FROM ` + positionPlayerTable.source() + ` WHERE player_id = $1 AND ` + liveCondition
 
-		adjusted_lob := ConvertToFloat(record[12]) * 100
-		adjusted_gb_rate := ConvertToFloat(record[13]) * 100
-		adjusted_hrfb_rate := ConvertToFloat(record[14]) * 100
-
-		row := &models.Pitcher{
-			Name:  record[0],
-			Team:  record[1],
-			W:     ConvertToInt(record[2]),
-			L:     ConvertToInt(record[3]),
-			SV:    ConvertToInt(record[4]),
-			G:     ConvertToInt(record[5]),
-			GS:    ConvertToInt(record[6]),
-			IP:    ConvertToFloat(record[7]),
-			K9:    roundFloat(ConvertToFloat(record[8]), 2),
-			BB9:   roundFloat(ConvertToFloat(record[9]), 2),
-			HR9:   roundFloat(ConvertToFloat(record[10]), 2),
-			BABIP: roundFloat(ConvertToFloat(record[11]), 3),
-			LOB:   roundFloat(adjusted_lob, 1),
-			GB:    roundFloat(adjusted_gb_rate, 1),
-			HRFB:  roundFloat(adjusted_hrfb_rate, 1),
-			VFA:   roundFloat(ConvertToFloat(record[15]), 1),
-			ERA:   roundFloat(ConvertToFloat(record[16]), 2),
-			XERA:  roundFloat(ConvertToFloat(record[17]), 2),
-			FIP:   roundFloat(ConvertToFloat(record[18]), 2),
-			XFIP:  roundFloat(ConvertToFloat(record[19]), 2),
-			WAR:   roundFloat(ConvertToFloat(record[20]), 1),
-		}
-		players = append(players, row)
+	player, err := positionPlayerTable.scanDerived(pool.Poolconn.QueryRow(context.Background(), query, id))
+	if err != nil {
+		log.Println(err)
+		return nil, pgError(noRowsError(err, positionPlayerTable.name, id))
 	}
 
-	return players
+	return player, nil
 }
 
-func (pool *DBPool) ImportPositionPlayerDataFromCSV() error {
-	players := ReadFromCSVPositionPlayer()
-	for _, player := range players {
-		err := pool.AddPositionPlayer(player)
-		if err != nil {
-			return err
-		}
+// GetPositionPlayerCareer will return every season line of a player
+//
+// lines are matched on the player of the line with the given id and ordered by season
+// lines in the trash are left out, and an ErrNotFound Error is returned if no live line has the given id
+func (pool *DBPool) GetPositionPlayerCareer(id int) ([]*models.PositionPlayer, error) {
+	query := `SELECT ` + positionPlayerTable.readColumns() + ` FROM ` + positionPlayerTable.source() + `
+	WHERE player_ref = (SELECT player_ref FROM position_players WHERE player_id = $1 AND ` + liveCondition + `)
+	AND ` + liveCondition + `
+	ORDER BY season, player_id`
+
+	rows, err := pool.Poolconn.Query(context.Background(), query, id)
+	if err != nil {
+		return nil, pgError(err)
 	}
+	defer rows.Close()
 
-	return nil
-}
-
-func (pool *DBPool) ImportPitcherDataFromCSV() error {
-	players := ReadFromCSVPitcher()
-	for _, player := range players {
-		err := pool.AddPitcher(player)
+	players := []*models.PositionPlayer{}
+	for rows.Next() {
+		player, err := positionPlayerTable.scanDerived(rows)
 		if err != nil {
-			return err
+			return nil, pgError(err)
 		}
+
+		players = append(players, player)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, pgError(err)
 	}
 
-	return nil
+	if len(players) == 0 {
+		return nil, notFoundError(positionPlayerTable.name, id)
+	}
+
+	return players, nil
 }
 
-// GetPlayers will return a list of players
+// AddPlayer will add a player to the position_players table
 //
-// retrieves all existing players in the position_players table
-func (pool *DBPool) GetPositionPlayers() ([]*models.PositionPlayer, error) {
-	query := `SELECT * FROM position_players`
+// returns the inserted row, and sets the id, player and version of the given line
+// the player is resolved in the same transaction, so a rejected line doesn't create a player.
+// a line already existing for the same name, team and season is reported as an ErrConflict Error
+// holding its id
+func (pool *DBPool) AddPositionPlayer(player *models.PositionPlayer) (*models.PositionPlayer, error) {
+	var added *models.PositionPlayer
+	err := pgx.BeginFunc(context.Background(), pool.Poolconn, func(tx pgx.Tx) error {
+		ref, err := playerRef(tx, player.PlayerID, player.Player, player.Name)
+		if err != nil {
+			return err
+		}
+		player.PlayerID = ref
 
-	rows, err := pool.Poolconn.Query(context.Background(), query)
-	if err != nil {
-		return nil, err
-	}
+		query := `INSERT INTO position_players 
+		(name, team, season, g, pa, hr, runs, rbi, sb, wrc_plus, bb_rate, k_rate, iso, babip, average, obp, slg, woba, x_woba, bsr, war, player_ref)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22)
+		ON CONFLICT (name, team, season) DO NOTHING
+		RETURNING ` + positionPlayerColumns
 
-	players := []*models.PositionPlayer{}
-	for rows.Next() {
-		player := &models.PositionPlayer{}
-		err := rows.Scan(
-			&player.ID,
+		added, err = scanPositionPlayer(tx.QueryRow(context.Background(), query,
 			&player.Name,
 			&player.Team,
+			&player.Season,
 			&player.G,
 			&player.PA,
 			&player.HR,
@@ -335,183 +381,317 @@ func (pool *DBPool) GetPositionPlayers() ([]*models.PositionPlayer, error) {
 			&player.XWOBA,
 			&player.BsR,
 			&player.WAR,
-		)
-
+			&player.PlayerID,
+		))
+		// a line already exists for the same name, team and season
+		if errors.Is(err, pgx.ErrNoRows) {
+			return existingLineError(tx, "position_players", lineKey{player.Name, player.Team, player.Season})
+		}
 		if err != nil {
-			return nil, err
+			return err
 		}
 
-		players = append(players, player)
+		return recordChange(tx, positionPlayerTable, pool.author(), ChangeCreate, nil, added)
+	})
+	if err != nil {
+		return nil, pgError(err)
 	}
 
-	return players, nil
+	player.ID, player.Version = added.ID, added.Version
+	return added, nil
 }
 
-// GetPlayerByID will return a player
+// UpdatePlayer will update a player in the position_players table given a player id
 //
-// it will query the position_players table based on a given player id
-func (pool *DBPool) GetPositionPlayerByID(id int) (*models.PositionPlayer, error) {
-	query := `SELECT * FROM position_players WHERE player_id = $1`
-	player := &models.PositionPlayer{}
+// if unsuccessful, will return an error
+// the line is only updated while at player.Version, which is set to the line's new version
+func (pool *DBPool) UpdatePositionPlayer(player *models.PositionPlayer) error {
+	query := `UPDATE position_players SET
+	name = $1,
+	team = $2,
+	season = $3,
+	g = $4,
+	pa = $5,
+	hr = $6,
+	runs = $7,
+	rbi = $8,
+	sb = $9,
+	wrc_plus = $10,
+	bb_rate = $11,
+	k_rate = $12,
+	iso = $13,
+	babip = $14,
+	average = $15,
+	obp = $16,
+	slg = $17,
+	woba = $18,
+	x_woba = $19,
+	bsr = $20,
+	war = $21,
+	version = version + 1
+	WHERE player_id = $22 AND ` + liveCondition + ` AND ` + versionCondition(23) + `
+	RETURNING ` + positionPlayerColumns
+
+	version := player.Version
+	err := pgx.BeginFunc(context.Background(), pool.Poolconn, func(tx pgx.Tx) error {
+		updated, err := recordUpdate(tx, positionPlayerTable, pool.author(), player.ID, func() (*models.PositionPlayer, error) {
+			updated, err := scanPositionPlayer(tx.QueryRow(context.Background(), query,
+				&player.Name,
+				&player.Team,
+				&player.Season,
+				&player.G,
+				&player.PA,
+				&player.HR,
+				&player.R,
+				&player.RBI,
+				&player.SB,
+				&player.WRCPlus,
+				&player.BbRate,
+				&player.KRate,
+				&player.ISO,
+				&player.BABIP,
+				&player.AVG,
+				&player.OBP,
+				&player.SLG,
+				&player.WOBA,
+				&player.XWOBA,
+				&player.BsR,
+				&player.WAR,
+				&player.ID,
+				&player.Version,
+			))
+			if errors.Is(err, pgx.ErrNoRows) {
+				return nil, staleLineError(tx, "position_players", player.ID, version)
+			}
+			return updated, err
+		})
+		if err != nil {
+			return err
+		}
 
-	err := pool.Poolconn.QueryRow(context.Background(), query, id).Scan(
-		&player.ID,
-		&player.Name,
-		&player.Team,
-		&player.G,
-		&player.PA,
-		&player.HR,
-		&player.R,
-		&player.RBI,
-		&player.SB,
-		&player.WRCPlus,
-		&player.BbRate,
-		&player.KRate,
-		&player.ISO,
-		&player.BABIP,
-		&player.AVG,
-		&player.OBP,
-		&player.SLG,
-		&player.WOBA,
-		&player.XWOBA,
-		&player.BsR,
-		&player.WAR,
-	)
+		player.Version = updated.Version
+		return nil
+	})
+
+	return pgError(err)
+}
+
+// PatchPositionPlayer sets only the named fields of a line to their values in player, returning the updated line
+//
+// fields are named by their json or column name
+func (pool *DBPool) PatchPositionPlayer(id int, player *models.PositionPlayer, fields []string) (*models.PositionPlayer, error) {
+	var patched *models.PositionPlayer
+	err := pgx.BeginFunc(context.Background(), pool.Poolconn, func(tx pgx.Tx) error {
+		var err error
+		patched, err = recordUpdate(tx, positionPlayerTable, pool.author(), id, func() (*models.PositionPlayer, error) {
+			return patchLine(tx, positionPlayerTable, id, player, fields)
+		})
+		return err
+	})
 	if err != nil {
-		log.Println(err)
-		return nil, err
+		return nil, pgError(err)
 	}
 
-	return player, nil
+	return patched, nil
 }
 
-// AddPlayer will add a player to the position_players table
+// DeletePlayer deletes a player by player id in the position_players table
 //
-// will return nil if successful, error if unsuccessful
-func (pool *DBPool) AddPositionPlayer(player *models.PositionPlayer) error {
-	query := `INSERT INTO position_players 
-	(name, team, g, pa, hr, runs, rbi, sb, wrc_plus, bb_rate, k_rate, iso, babip, average, obp, slg, woba, x_woba, bsr, war)
-	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20)
-	ON CONFLICT (name, team) DO NOTHING`
-
-	_, err := pool.Poolconn.Exec(context.Background(), query,
+// the line is moved to the trash, and only while at the given version
+func (pool *DBPool) DeletePositionPlayer(id, version int) error {
+	return pgTrash(pool, positionPlayerTable, id, version)
+}
+
+// BatchPositionPlayers creates, updates and deletes position players in a single transaction
+func (pool *DBPool) BatchPositionPlayers(ops []*BatchOp[models.PositionPlayer], mode BatchMode) ([]*BatchResult[models.PositionPlayer], error) {
+	return pgBatch(pool, positionPlayerTable, ops, mode)
+}
+
+// GetPositionPlayerHistory returns every change recorded for the line with the given id, oldest first
+//
+// returns an ErrNotFound Error if no change was ever recorded for the id
+func (pool *DBPool) GetPositionPlayerHistory(id int) ([]*Change[models.PositionPlayer], error) {
+	return pgHistory(pool, positionPlayerTable, id)
+}
+
+// RestorePositionPlayer sets the line with the given id back to the line recorded at version, returning the restored line
+// with its derived stats
+func (pool *DBPool) RestorePositionPlayer(id, version int) (*models.PositionPlayer, error) {
+	return pgRestore(pool, positionPlayerTable, id, version)
+}
+
+// UndeletePositionPlayer takes the line with the given id out of the trash, returning the restored line
+func (pool *DBPool) UndeletePositionPlayer(id int) (*models.PositionPlayer, error) {
+	return pgUndelete(pool, positionPlayerTable, id)
+}
+
+// *******************
+// Pitcher methods
+// *******************
+
+// columns selected for a pitcher, in scanPitcher order
+const pitcherColumns = `player_id, name, team, season, w, l, sv, g, gs, ip, k9, bb9, hr9,
+	babip, lob, gb, hrfb, vfa, era, xera, fip, xfip, war, player_ref, version, deleted_at`
+
+// scanPitcher scans a row of pitcherColumns into a Pitcher
+func scanPitcher(row pgx.Row) (*models.Pitcher, error) {
+	player := &models.Pitcher{}
+	err := row.Scan(
+		&player.ID,
 		&player.Name,
 		&player.Team,
+		&player.Season,
+		&player.W,
+		&player.L,
+		&player.SV,
 		&player.G,
-		&player.PA,
-		&player.HR,
-		&player.R,
-		&player.RBI,
-		&player.SB,
-		&player.WRCPlus,
-		&player.BbRate,
-		&player.KRate,
-		&player.ISO,
+		&player.GS,
+		&player.IP,
+		&player.K9,
+		&player.BB9,
+		&player.HR9,
 		&player.BABIP,
-		&player.AVG,
-		&player.OBP,
-		&player.SLG,
-		&player.WOBA,
-		&player.XWOBA,
-		&player.BsR,
+		&player.LOB,
+		&player.GB,
+		&player.HRFB,
+		&player.VFA,
+		&player.ERA,
+		&player.XERA,
+		&player.FIP,
+		&player.XFIP,
 		&player.WAR,
+		&player.PlayerID,
+		&player.Version,
+		&player.DeletedAt,
 	)
 	if err != nil {
-		return err
+		return nil, pgError(err)
+	}
+	if player.DeletedAt != nil {
+		*player.DeletedAt = player.DeletedAt.UTC()
 	}
 
-	return nil
+	return player, nil
 }
 
-// UpdatePlayer will update a player in the position_players table given a player id
+// GetPitchers will return a list of pitchers
 //
-// if unsuccessful, will return an error
-func (pool *DBPool) UpdatePositionPlayer(player *models.PositionPlayer) error {
-	query := `UPDATE position_players SET
-	name = $1,
-	team = $2,
-	g = $3,
-	pa = $4,
-	hr = $5,
-	runs = $6,
-	rbi = $7,
-	sb = $8,
-	wrc_plus = $9,
-	bb_rate = $10,
-	k_rate = $11,
-	iso = $12,
-	babip = $13,
-	average = $14,
-	obp = $15,
-	slg = $16,
-	woba = $17,
-	x_woba = $18,
-	bsr = $19,
-	war = $20
-	WHERE player_id = $21`
-
-	res, err := pool.Poolconn.Exec(context.Background(), query,
-		&player.Name,
-		&player.Team,
-		&player.G,
-		&player.PA,
-		&player.HR,
-		&player.R,
-		&player.RBI,
-		&player.SB,
-		&player.WRCPlus,
-		&player.BbRate,
-		&player.KRate,
-		&player.ISO,
-		&player.BABIP,
-		&player.AVG,
-		&player.OBP,
-		&player.SLG,
-		&player.WOBA,
-		&player.XWOBA,
-		&player.BsR,
-		&player.WAR,
-		&player.ID,
-	)
+// retrieves a page of the players in the pitchers table matching the list options, in sorted order,
+// each with its derived stats
+func (pool *DBPool) GetPitchers(opts *ListOptions) (*Page[*models.Pitcher], error) {
+	clauses, args := listClauses(opts)
+	query := `SELECT ` + pitcherTable.readColumns() + ` FROM ` + pitcherTable.source() + clauses
+
+	rows, err := pool.Poolconn.Query(context.Background(), query, args...)
 	if err != nil {
-		return err
+		return nil, pgError(err)
 	}
+	defer rows.Close()
 
-	log.Println("rows affected:", res.RowsAffected())
+	players := []*models.Pitcher{}
+	for rows.Next() {
+		player, err := pitcherTable.scanDerived(rows)
+		if err != nil {
+			return nil, pgError(err)
+		}
 
-	return nil
+		players = append(players, player)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, pgError(err)
+	}
+
+	return newPage(players, opts), nil
 }
 
-// DeletePlayer deletes a player by player id in the position_players table
-func (pool *DBPool) DeletePositionPlayer(id int) error {
-	query := `DELETE FROM position_players WHERE player_id = $1`
+// CountPitchers returns the number of players in the pitchers table matching the list filters
+func (pool *DBPool) CountPitchers(opts *ListOptions) (int, error) {
+	where, args := whereClause(&ListOptions{Filters: opts.Filters, IncludeDeleted: opts.IncludeDeleted})
+	query := `SELECT count(*) FROM ` + pitcherTable.source() + where
 
-	_, err := pool.Poolconn.Exec(context.Background(), query, id)
-	return err
-}
+	count := 0
+	err := pool.Poolconn.QueryRow(context.Background(), query, args...).Scan(&count)
 
-// *******************
-// Pitcher methods
-// *******************
+	return count, pgError(err)
+}
 
-// GetPitchers will return a list of pitchers
+// GetPlayerByID will return a player
 //
-// retrieves all existing players in the pitchers table
-func (pool *DBPool) GetPitchers() ([]*models.Pitcher, error) {
-	query := `SELECT * FROM pitchers`
+// it will query the pitchers table based on a given player id, leaving out a line in the trash.
+// the line is returned with its derived stats
+func (pool *DBPool) GetPitcherByID(id int) (*models.Pitcher, error) {
+	query := `SELECT ` + pitcherTable.readColumns() + ` FROM ` + pitcherTable.source() + ` WHERE player_id = $1 AND ` + liveCondition
 
-	rows, err := pool.Poolconn.Query(context.Background(), query)
+	player, err := pitcherTable.scanDerived(pool.Poolconn.QueryRow(context.Background(), query, id))
 	if err != nil {
-		return nil, err
+		log.Println(err)
+		return nil, pgError(noRowsError(err, pitcherTable.name, id))
+	}
+
+	return player, nil
+}
+
+// GetPitcherCareer will return every season line of a player
+//
+// lines are matched on the player of the line with the given id and ordered by season
+// lines in the trash are left out, and an ErrNotFound Error is returned if no live line has the given id
+func (pool *DBPool) GetPitcherCareer(id int) ([]*models.Pitcher, error) {
+	query := `SELECT ` + pitcherTable.readColumns() + ` FROM ` + pitcherTable.source() + `
+	WHERE player_ref = (SELECT player_ref FROM pitchers WHERE player_id = $1 AND ` + liveCondition + `)
+	AND ` + liveCondition + `
+	ORDER BY season, player_id`
+
+	rows, err := pool.Poolconn.Query(context.Background(), query, id)
+	if err != nil {
+		return nil, pgError(err)
 	}
+	defer rows.Close()
 
 	players := []*models.Pitcher{}
 	for rows.Next() {
-		player := &models.Pitcher{}
-		err := rows.Scan(
-			&player.ID,
+		player, err := pitcherTable.scanDerived(rows)
+		if err != nil {
+			return nil, pgError(err)
+		}
+
+		players = append(players, player)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, pgError(err)
+	}
+
+	if len(players) == 0 {
+		return nil, notFoundError(pitcherTable.name, id)
+	}
+
+	return players, nil
+}
+
+// AddPlayer will add a player to the position_players table
+//
+// returns the inserted row, and sets the id, player and version of the given line
+// the player is resolved in the same transaction, so a rejected line doesn't create a player.
+// a line already existing for the same name, team and season is reported as an ErrConflict Error
+// holding its id
+func (pool *DBPool) AddPitcher(player *models.Pitcher) (*models.Pitcher, error) {
+	var added *models.Pitcher
+	err := pgx.BeginFunc(context.Background(), pool.Poolconn, func(tx pgx.Tx) error {
+		ref, err := playerRef(tx, player.PlayerID, player.Player, player.Name)
+		if err != nil {
+			return err
+		}
+		player.PlayerID = ref
+
+		query := `INSERT INTO pitchers 
+		(name, team, season, w, l, sv, g, gs, ip, k9, bb9, hr9, babip, lob, gb, hrfb, vfa, era, xera, fip, xfip, war, player_ref)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22, $23)
+		ON CONFLICT (name, team, season) DO NOTHING
+		RETURNING ` + pitcherColumns
+
+		added, err = scanPitcher(tx.QueryRow(context.Background(), query,
 			&player.Name,
 			&player.Team,
+			&player.Season,
 			&player.W,
 			&player.L,
 			&player.SV,
@@ -531,161 +711,148 @@ func (pool *DBPool) GetPitchers() ([]*models.Pitcher, error) {
 			&player.FIP,
 			&player.XFIP,
 			&player.WAR,
-		)
-
+			&player.PlayerID,
+		))
+		// a line already exists for the same name, team and season
+		if errors.Is(err, pgx.ErrNoRows) {
+			return existingLineError(tx, "pitchers", lineKey{player.Name, player.Team, player.Season})
+		}
 		if err != nil {
-			return nil, err
+			return err
 		}
 
-		players = append(players, player)
+		return recordChange(tx, pitcherTable, pool.author(), ChangeCreate, nil, added)
+	})
+	if err != nil {
+		return nil, pgError(err)
 	}
 
-	return players, nil
+	player.ID, player.Version = added.ID, added.Version
+	return added, nil
 }
 
-// GetPlayerByID will return a player
+// UpdatePlayer will update a player in the position_players table given a player id
 //
-// it will query the position_players table based on a given player id
-func (pool *DBPool) GetPitcherByID(id int) (*models.Pitcher, error) {
-	query := `SELECT * FROM pitchers WHERE player_id = $1`
-	player := &models.Pitcher{}
+// if unsuccessful, will return an error
+// the line is only updated while at player.Version, which is set to the line's new version
+func (pool *DBPool) UpdatePitcher(player *models.Pitcher) error {
+	query := `UPDATE pitchers SET
+	name = $1,
+	team = $2,
+	season = $3,
+	w = $4,
+	l = $5,
+	sv = $6,
+	g = $7,
+	gs = $8,
+	ip = $9,
+	k9 = $10,
+	bb9 = $11,
+	hr9 = $12,
+	babip = $13,
+	lob = $14,
+	gb = $15,
+	hrfb = $16,
+	vfa = $17,
+	era = $18,
+	xera = $19,
+	fip = $20,
+	xfip = $21,
+	war = $22,
+	version = version + 1
+	WHERE player_id = $23 AND ` + liveCondition + ` AND ` + versionCondition(24) + `
+	RETURNING ` + pitcherColumns
+
+	version := player.Version
+	err := pgx.BeginFunc(context.Background(), pool.Poolconn, func(tx pgx.Tx) error {
+		updated, err := recordUpdate(tx, pitcherTable, pool.author(), player.ID, func() (*models.Pitcher, error) {
+			updated, err := scanPitcher(tx.QueryRow(context.Background(), query,
+				&player.Name,
+				&player.Team,
+				&player.Season,
+				&player.W,
+				&player.L,
+				&player.SV,
+				&player.G,
+				&player.GS,
+				&player.IP,
+				&player.K9,
+				&player.BB9,
+				&player.HR9,
+				&player.BABIP,
+				&player.LOB,
+				&player.GB,
+				&player.HRFB,
+				&player.VFA,
+				&player.ERA,
+				&player.XERA,
+				&player.FIP,
+				&player.XFIP,
+				&player.WAR,
+				&player.ID,
+				&player.Version,
+			))
+			if errors.Is(err, pgx.ErrNoRows) {
+				return nil, staleLineError(tx, "pitchers", player.ID, version)
+			}
+			return updated, err
+		})
+		if err != nil {
+			return err
+		}
 
-	err := pool.Poolconn.QueryRow(context.Background(), query, id).Scan(
-		&player.ID,
-		&player.Name,
-		&player.Team,
-		&player.W,
-		&player.L,
-		&player.SV,
-		&player.G,
-		&player.GS,
-		&player.IP,
-		&player.K9,
-		&player.BB9,
-		&player.HR9,
-		&player.BABIP,
-		&player.LOB,
-		&player.GB,
-		&player.HRFB,
-		&player.VFA,
-		&player.ERA,
-		&player.XERA,
-		&player.FIP,
-		&player.XFIP,
-		&player.WAR,
-	)
-	if err != nil {
-		log.Println(err)
-		return nil, err
-	}
+		player.Version = updated.Version
+		return nil
+	})
 
-	return player, nil
+	return pgError(err)
 }
 
-// AddPlayer will add a player to the position_players table
+// PatchPitcher sets only the named fields of a line to their values in player, returning the updated line
 //
-// will return nil if successful, error if unsuccessful
-func (pool *DBPool) AddPitcher(player *models.Pitcher) error {
-	query := `INSERT INTO pitchers 
-	(name, team, w, l, sv, g, gs, ip, k9, bb9, hr9, babip, lob, gb, hrfb, vfa, era, xera, fip, xfip, war)
-	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21)
-	ON CONFLICT (name, team) DO NOTHING`
-
-	_, err := pool.Poolconn.Exec(context.Background(), query,
-		&player.Name,
-		&player.Team,
-		&player.W,
-		&player.L,
-		&player.SV,
-		&player.G,
-		&player.GS,
-		&player.IP,
-		&player.K9,
-		&player.BB9,
-		&player.HR9,
-		&player.BABIP,
-		&player.LOB,
-		&player.GB,
-		&player.HRFB,
-		&player.VFA,
-		&player.ERA,
-		&player.XERA,
-		&player.FIP,
-		&player.XFIP,
-		&player.WAR,
-	)
-	if err != nil {
+// fields are named by their json or column name
+func (pool *DBPool) PatchPitcher(id int, player *models.Pitcher, fields []string) (*models.Pitcher, error) {
+	var patched *models.Pitcher
+	err := pgx.BeginFunc(context.Background(), pool.Poolconn, func(tx pgx.Tx) error {
+		var err error
+		patched, err = recordUpdate(tx, pitcherTable, pool.author(), id, func() (*models.Pitcher, error) {
+			return patchLine(tx, pitcherTable, id, player, fields)
+		})
 		return err
+	})
+	if err != nil {
+		return nil, pgError(err)
 	}
 
-	return nil
+	return patched, nil
 }
 
-// UpdatePlayer will update a player in the position_players table given a player id
+// DeletePlayer deletes a player by player id in the pitchers table
 //
-// if unsuccessful, will return an error
-func (pool *DBPool) UpdatePitcher(player *models.Pitcher) error {
-	query := `UPDATE pitchers SET
-	name = $1,
-	team = $2,
-	w = $3,
-	l = $4,
-	sv = $5,
-	g = $6,
-	gs = $7,
-	ip = $8,
-	k9 = $9,
-	bb9 = $10,
-	hr9 = $11,
-	babip = $12,
-	lob = $13,
-	gb = $14,
-	hrfb = $15,
-	vfa = $16,
-	era = $17,
-	xera = $18,
-	fip = $19,
-	xfip = $20,
-	war = $21
-	WHERE player_id = $22`
-
-	res, err := pool.Poolconn.Exec(context.Background(), query,
-		&player.Name,
-		&player.Team,
-		&player.W,
-		&player.L,
-		&player.SV,
-		&player.G,
-		&player.GS,
-		&player.IP,
-		&player.K9,
-		&player.BB9,
-		&player.HR9,
-		&player.BABIP,
-		&player.LOB,
-		&player.GB,
-		&player.HRFB,
-		&player.VFA,
-		&player.ERA,
-		&player.XERA,
-		&player.FIP,
-		&player.XFIP,
-		&player.WAR,
-		&player.ID,
-	)
-	if err != nil {
-		return err
-	}
+// the line is moved to the trash, and only while at the given version
+func (pool *DBPool) DeletePitcher(id, version int) error {
+	return pgTrash(pool, pitcherTable, id, version)
+}
 
-	log.Println("rows affected:", res.RowsAffected())
+// BatchPitchers creates, updates and deletes pitchers in a single transaction
+func (pool *DBPool) BatchPitchers(ops []*BatchOp[models.Pitcher], mode BatchMode) ([]*BatchResult[models.Pitcher], error) {
+	return pgBatch(pool, pitcherTable, ops, mode)
+}
 
-	return nil
+// GetPitcherHistory returns every change recorded for the line with the given id, oldest first
+//
+// returns an ErrNotFound Error if no change was ever recorded for the id
+func (pool *DBPool) GetPitcherHistory(id int) ([]*Change[models.Pitcher], error) {
+	return pgHistory(pool, pitcherTable, id)
 }
 
-// DeletePlayer deletes a player by player id in the position_players table
-func (pool *DBPool) DeletePitcher(id int) error {
-	query := `DELETE FROM pitchers WHERE player_id = $1`
+// RestorePitcher sets the line with the given id back to the line recorded at version, returning the restored line
+// with its derived stats
+func (pool *DBPool) RestorePitcher(id, version int) (*models.Pitcher, error) {
+	return pgRestore(pool, pitcherTable, id, version)
+}
 
-	_, err := pool.Poolconn.Exec(context.Background(), query, id)
-	return err
+// UndeletePitcher takes the line with the given id out of the trash, returning the restored line
+func (pool *DBPool) UndeletePitcher(id int) (*models.Pitcher, error) {
+	return pgUndelete(pool, pitcherTable, id)
 }
@@ -1,21 +1,45 @@
 package db
 
 import (
+	"errors"
 	"fmt"
+	"math"
+	"os"
+	"strings"
 	"testing"
+	"testing/fstest"
 
+	"github.com/e-berman/baseball_api/internal/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestConvertToInt(t *testing.T) {
+	val, err := ConvertToInt("62")
+	assert.NoError(t, err)
+	assert.Equal(t, 62, val)
+
+	_, err = ConvertToInt("sixty")
+	assert.Error(t, err)
 }
 
 func TestConvertToFloat(t *testing.T) {
+	val, err := ConvertToFloat(" .311")
+	assert.NoError(t, err)
+	assert.Equal(t, 0.311, val)
 
+	_, err = ConvertToFloat("")
+	assert.Error(t, err)
 }
 
 func TestFloatToInt(t *testing.T) {
-	
+	val, err := ConvertFloatToInt("207.4")
+	assert.NoError(t, err)
+	assert.Equal(t, 207, val)
+
+	_, err = ConvertFloatToInt("n/a")
+	assert.Error(t, err)
 }
 
 func TestRoundFloat(t *testing.T) {
@@ -23,9 +47,719 @@ func TestRoundFloat(t *testing.T) {
 }
 
 func TestReadFromCSVPositionPlayer(t *testing.T) {
+	file, err := os.Open("../../assets/batters_2022.csv")
+	assert.NoError(t, err)
+	defer file.Close()
+
+	players, rowErrs, err := ReadFromCSVPositionPlayer(file, DefaultPositionPlayerMapping, 2022)
+	assert.NoError(t, err)
+	assert.Empty(t, rowErrs)
+	assert.Equal(t, 130, len(players))
 
+	judge := players[0]
+	assert.Equal(t, "Aaron Judge", judge.Name)
+	assert.Equal(t, "NYY", judge.Team)
+	assert.Equal(t, 2022, judge.Season)
+	assert.Equal(t, 62, judge.HR)
+	assert.Equal(t, 207, judge.WRCPlus)
+	assert.Equal(t, 15.9, judge.BbRate)
+	assert.Equal(t, 0.311, judge.AVG)
 }
 
 func TestReadFromCSVPitcher(t *testing.T) {
+	file, err := os.Open("../../assets/pitchers_2022.csv")
+	assert.NoError(t, err)
+	defer file.Close()
+
+	players, rowErrs, err := ReadFromCSVPitcher(file, DefaultPitcherMapping, 2022)
+	assert.NoError(t, err)
+	assert.Empty(t, rowErrs)
+	assert.Equal(t, 45, len(players))
+
+	nola := players[0]
+	assert.Equal(t, "Aaron Nola", nola.Name)
+	assert.Equal(t, "PHI", nola.Team)
+	assert.Equal(t, 2022, nola.Season)
+	assert.Equal(t, 205.0, nola.IP)
+	assert.Equal(t, 10.32, nola.K9)
+	assert.Equal(t, 73.0, nola.LOB)
+	assert.Equal(t, &models.Player{Name: "Aaron Nola", NameASCII: "Aaron Nola", FangraphsID: "16149", MLBAMID: "605400"}, nola.Player)
+}
+
+func TestReadFromCSVHeaderOrder(t *testing.T) {
+	csv := "WAR,BsR,xwOBA,wOBA,SLG,OBP,AVG,BABIP,ISO,K%,BB%,wRC+,SB,RBI,R,HR,PA,G,Team,Name,Season,MLBAMID\n" +
+		"10.6,0.3,.458,.458,.686,.425,.311,.340,.375,25.1%,.159,207,16,131,133,62,696,157,NYY,Aaron Judge,2022,592450\n"
+
+	players, rowErrs, err := ReadFromCSVPositionPlayer(strings.NewReader(csv), DefaultPositionPlayerMapping, 0)
+	assert.NoError(t, err)
+	assert.Empty(t, rowErrs)
+	assert.Equal(t, 1, len(players))
+
+	judge := players[0]
+	assert.Equal(t, "Aaron Judge", judge.Name)
+	assert.Equal(t, 2022, judge.Season)
+	assert.Equal(t, 62, judge.HR)
+	assert.Equal(t, 25.1, judge.KRate)
+	assert.Equal(t, 15.9, judge.BbRate)
+	assert.Equal(t, 10.6, judge.WAR)
+	assert.Equal(t, &models.Player{Name: "Aaron Judge", MLBAMID: "592450"}, judge.Player)
+}
+
+func TestReadFromCSVMissingColumns(t *testing.T) {
+	csv := "Name,Team,G,PA\nAaron Judge,NYY,157,696\n"
+
+	_, _, err := ReadFromCSVPositionPlayer(strings.NewReader(csv), DefaultPositionPlayerMapping, 2022)
+	var missing *MissingColumnsError
+	assert.True(t, errors.As(err, &missing))
+	assert.Contains(t, missing.Columns, "HR")
+	assert.Contains(t, missing.Columns, "WAR")
+	assert.NotContains(t, missing.Columns, "Season")
+	assert.ErrorIs(t, err, ErrInvalidCSV)
+}
+
+func TestReadFromCSVNoSeason(t *testing.T) {
+	csv := "Name,Team,W,L,SV,G,GS,IP,K/9,BB/9,HR/9,BABIP,LOB%,GB%,HR/FB,vFA (pi),ERA,xERA,FIP,xFIP,WAR\n" +
+		"Aaron Nola,PHI,11,13,0,32,32,205,10.32,1.27,0.83,.288,.730,.421,.095,93.0,3.25,3.04,2.58,2.77,6.3\n"
+
+	players, rowErrs, err := ReadFromCSVPitcher(strings.NewReader(csv), DefaultPitcherMapping, 0)
+	assert.NoError(t, err)
+	assert.Empty(t, players)
+	assert.Equal(t, 1, len(rowErrs))
+	assert.Equal(t, "Season", rowErrs[0].Column)
+}
+
+// a batters csv with one valid row, one row with two invalid values and one row missing its last columns
+const invalidBattersCSV = "Name,Team,G,PA,HR,R,RBI,SB,wRC+,BB%,K%,ISO,BABIP,AVG,OBP,SLG,wOBA,xwOBA,BsR,WAR\n" +
+	"Aaron Judge,NYY,157,696,62,133,131,16,207,.159,.251,.375,.340,.311,.425,.686,.458,.458,0.3,10.6\n" +
+	"Paul Goldschmidt,STL,one fifty one,651,35,106,115,7,177,.123,.215,.261,.368,.317,.404,.578,.419,.398,-1.1,n/a\n" +
+	"Yordan Alvarez,HOU,135,561,37,95,97,1,185,.144,.189,.307,.305,.306,.406,.613,.427\n"
+
+func TestReadFromCSVRowErrors(t *testing.T) {
+	players, rowErrs, err := ReadFromCSVPositionPlayer(strings.NewReader(invalidBattersCSV), DefaultPositionPlayerMapping, 2022)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(players))
+	assert.Equal(t, 5, len(rowErrs))
+
+	assert.Equal(t, &RowError{Row: 3, Column: "G", Value: "one fifty one", Reason: "not an integer"}, rowErrs[0])
+	assert.Equal(t, &RowError{Row: 3, Column: "WAR", Value: "n/a", Reason: "not a number"}, rowErrs[1])
+	assert.Equal(t, 4, rowErrs[2].Row)
+	assert.Equal(t, "xwOBA", rowErrs[2].Column)
+	assert.Equal(t, "value is missing", rowErrs[2].Reason)
+}
+
+func TestReadFromCSVIntegers(t *testing.T) {
+	// a count with decimals is invalid rather than cut down, while wRC+ is exported with decimals and rounded
+	csv := "Name,Team,G,PA,HR,R,RBI,SB,wRC+,BB%,K%,ISO,BABIP,AVG,OBP,SLG,wOBA,xwOBA,BsR,WAR\n" +
+		"Aaron Judge,NYY,157,696,62,133,131,16,206.5870,.159,.251,.375,.340,.311,.425,.686,.458,.458,0.3,10.6\n" +
+		"Yordan Alvarez,HOU,10.7,561,37,95,97,1,185,.144,.189,.307,.305,.306,.406,.613,.427,.420,-0.9,6.6\n"
+
+	players, rowErrs, err := ReadFromCSVPositionPlayer(strings.NewReader(csv), DefaultPositionPlayerMapping, 2022)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(players))
+	assert.Equal(t, 207, players[0].WRCPlus)
+	assert.Equal(t, []*RowError{{Row: 3, Column: "G", Value: "10.7", Reason: "not an integer"}}, rowErrs)
+}
+
+// fakeWriter records the rows written by an import, treating Aaron Judge as already imported
+type fakeWriter struct {
+	written []string
+	batches int
+	deleted []int
+}
+
+func (w *fakeWriter) deleteSeason(season int) (int, error) {
+	w.deleted = append(w.deleted, season)
+	return 1, nil
+}
+
+func (w *fakeWriter) writeLines(lines []*models.PositionPlayer, mode WriteMode, report *ImportReport) error {
+	w.batches++
+	for _, player := range lines {
+		w.written = append(w.written, player.Name)
+		switch {
+		case player.Name != "Aaron Judge":
+			report.Inserted++
+		case mode == WriteUpsert:
+			report.Updated++
+			report.Changes = append(report.Changes, &RowChange{Name: player.Name, Fields: []*FieldChange{{Field: "homeRuns", Old: 61, New: 62}}})
+		default:
+			report.Skipped++
+		}
+	}
+
+	return nil
+}
+
+func importTestCSV(t *testing.T, csv string, opts *ImportOptions, writer *fakeWriter) (*ImportReport, error) {
+	reader, err := newPositionPlayerReader(strings.NewReader(csv), DefaultPositionPlayerMapping, 2022)
+	assert.NoError(t, err)
+
+	report := newImportReport("", opts)
+	return report, importCSV(reader, opts, report, writer)
+}
+
+func TestImportCSV(t *testing.T) {
+	writer := &fakeWriter{}
+	report, err := importTestCSV(t, invalidBattersCSV, &ImportOptions{Mode: ImportLenient, Write: WriteInsert}, writer)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, report.RowsRead)
+	assert.Equal(t, 0, report.Inserted)
+	assert.Equal(t, 1, report.Skipped)
+	assert.Equal(t, 2, report.Failed)
+	assert.Equal(t, 5, len(report.Errors))
+
+	writer = &fakeWriter{}
+	report, err = importTestCSV(t, invalidBattersCSV, &ImportOptions{Mode: ImportLenient, Write: WriteUpsert}, writer)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Updated)
+	assert.Equal(t, "homeRuns", report.Changes[0].Fields[0].Field)
+
+	writer = &fakeWriter{}
+	report, err = importTestCSV(t, invalidBattersCSV, &ImportOptions{Mode: ImportStrict, Write: WriteInsert}, writer)
+	assert.ErrorIs(t, err, ErrInvalidRows)
+	assert.Empty(t, writer.written)
+	assert.Equal(t, 2, report.Failed)
+}
+
+// longBattersCSV returns a csv of the given number of valid rows, each of a different player,
+// followed by an invalid row and one more valid row
+func longBattersCSV(rows int) string {
+	csv := strings.Builder{}
+	csv.WriteString("Name,Team,G,PA,HR,R,RBI,SB,wRC+,BB%,K%,ISO,BABIP,AVG,OBP,SLG,wOBA,xwOBA,BsR,WAR\n")
+	for i := 0; i < rows; i++ {
+		fmt.Fprintf(&csv, "Player %d,NYY,157,696,62,133,131,16,207,.159,.251,.375,.340,.311,.425,.686,.458,.458,0.3,10.6\n", i)
+	}
+	csv.WriteString("Paul Goldschmidt,STL,one fifty one,651,35,106,115,7,177,.123,.215,.261,.368,.317,.404,.578,.419,.398,-1.1,6.9\n")
+	csv.WriteString("Mookie Betts,LAD,142,639,35,117,82,12,144,.086,.162,.264,.272,.269,.340,.533,.363,.359,2.0,6.6\n")
+
+	return csv.String()
+}
+
+func TestImportCSVStrict(t *testing.T) {
+	// rows are written as they are read, until the first invalid row
+	writer := &fakeWriter{}
+	report, err := importTestCSV(t, longBattersCSV(importBatchSize+1), &ImportOptions{Mode: ImportStrict, Write: WriteInsert}, writer)
+	assert.ErrorIs(t, err, ErrInvalidRows)
+	assert.Equal(t, importBatchSize, len(writer.written))
+	assert.Equal(t, importBatchSize+3, report.RowsRead)
+	assert.Equal(t, 1, report.Failed)
+	// the written rows are rolled back, so none are reported
+	assert.Zero(t, report.Inserted)
+}
+
+func TestImportCSVDryRun(t *testing.T) {
+	writer := &fakeWriter{}
+	report, err := importTestCSV(t, invalidBattersCSV, &ImportOptions{Mode: ImportLenient, Write: WriteReplace, DryRun: true}, writer)
+	assert.NoError(t, err)
+	assert.True(t, report.DryRun)
+	assert.Equal(t, 3, report.RowsRead)
+	assert.Equal(t, 2, report.Failed)
+	assert.Empty(t, writer.written)
+	assert.Empty(t, writer.deleted)
+}
+
+func TestImportCSVReplace(t *testing.T) {
+	csv := "Name,Team,Season,G,PA,HR,R,RBI,SB,wRC+,BB%,K%,ISO,BABIP,AVG,OBP,SLG,wOBA,xwOBA,BsR,WAR\n" +
+		"Aaron Judge,NYY,2022,157,696,62,133,131,16,207,.159,.251,.375,.340,.311,.425,.686,.458,.458,0.3,10.6\n" +
+		"Aaron Judge,NYY,2023,106,458,37,79,75,3,174,.192,.283,.347,.317,.267,.406,.613,.419,.440,-0.7,5.5\n" +
+		"Mookie Betts,LAD,2023,152,693,39,126,107,14,167,.145,.156,.272,.317,.307,.408,.579,.416,.413,3.1,8.3\n"
+
+	writer := &fakeWriter{}
+	report, err := importTestCSV(t, csv, &ImportOptions{Mode: ImportLenient, Write: WriteReplace}, writer)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{2022, 2023}, writer.deleted)
+	assert.Equal(t, 2, report.Deleted)
+	assert.Equal(t, 3, len(writer.written))
+}
+
+func TestImportCSVBatches(t *testing.T) {
+	// the same line twice is written in separate batches
+	csv := "Name,Team,G,PA,HR,R,RBI,SB,wRC+,BB%,K%,ISO,BABIP,AVG,OBP,SLG,wOBA,xwOBA,BsR,WAR\n" +
+		"Aaron Judge,NYY,157,696,62,133,131,16,207,.159,.251,.375,.340,.311,.425,.686,.458,.458,0.3,10.6\n" +
+		"Mookie Betts,LAD,142,639,35,117,82,12,144,.086,.162,.264,.272,.269,.340,.533,.363,.359,2.0,6.6\n" +
+		"Aaron Judge,NYY,157,696,62,133,131,16,207,.159,.251,.375,.340,.311,.425,.686,.458,.458,0.3,10.6\n"
+
+	writer := &fakeWriter{}
+	report, err := importTestCSV(t, csv, &ImportOptions{Mode: ImportLenient, Write: WriteInsert}, writer)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, writer.batches)
+	assert.Equal(t, 1, report.Inserted)
+	assert.Equal(t, 2, report.Skipped)
+}
+
+func TestDiffStats(t *testing.T) {
+	existing := &models.PositionPlayer{ID: 1, Name: "Aaron Judge", Team: "NYY", Season: 2022, HR: 61, AVG: 0.311, WAR: 10.6}
+	line := &models.PositionPlayer{Name: "Aaron Judge", Team: "NYY", Season: 2022, HR: 62, AVG: 0.311, WAR: 10.5, PlayerID: 7}
+
+	changes := diffStats(PositionPlayerFields, existing, line)
+	assert.Equal(t, []*FieldChange{
+		{Field: "homeRuns", Old: 61, New: 62},
+		{Field: "winsAboveReplacement", Old: 10.6, New: 10.5},
+	}, changes)
+
+	assert.Empty(t, diffStats(PositionPlayerFields, existing, existing))
+}
+
+func TestParseWriteMode(t *testing.T) {
+	mode, err := ParseWriteMode("")
+	assert.NoError(t, err)
+	assert.Equal(t, WriteInsert, mode)
+
+	mode, err = ParseWriteMode("replace")
+	assert.NoError(t, err)
+	assert.Equal(t, WriteReplace, mode)
+
+	_, err = ParseWriteMode("merge")
+	assert.Error(t, err)
+}
+
+func TestParseImportMode(t *testing.T) {
+	mode, err := ParseImportMode("")
+	assert.NoError(t, err)
+	assert.Equal(t, ImportLenient, mode)
+
+	mode, err = ParseImportMode("strict")
+	assert.NoError(t, err)
+	assert.Equal(t, ImportStrict, mode)
+
+	_, err = ParseImportMode("loose")
+	assert.Error(t, err)
+}
+
+func TestSeasonFromFilename(t *testing.T) {
+	assert.Equal(t, 2022, SeasonFromFilename("./assets/batters_2022.csv"))
+	assert.Equal(t, 0, SeasonFromFilename("./assets/batters.csv"))
+}
+
+func TestFieldSetLookup(t *testing.T) {
+	field, ok := PositionPlayerFields.Lookup("weightedRunsCreatedPlus")
+	assert.True(t, ok)
+	assert.Equal(t, "weightedRunsCreatedPlus", field.Name)
+	assert.Equal(t, "wrc_plus", field.Column)
+	assert.Equal(t, KindInt, field.Kind)
+
+	field, ok = PositionPlayerFields.Lookup("war")
+	assert.True(t, ok)
+	assert.Equal(t, "winsAboveReplacement", field.Name)
+	assert.Equal(t, KindFloat, field.Kind)
+
+	_, ok = PitcherFields.Lookup("homeRuns")
+	assert.False(t, ok)
+
+	field, ok = PositionPlayerFields.Lookup("ops")
+	assert.True(t, ok)
+	assert.Equal(t, "onBasePlusSlugging", field.Name)
+	assert.Equal(t, "ROUND(CAST(obp + slg AS numeric), 3)", field.Column)
+	assert.Equal(t, KindFloat, field.Kind)
+	assert.NotContains(t, PositionPlayerFields.Fields(), field)
+
+	field, ok = PitcherFields.Lookup("fip_minus")
+	assert.True(t, ok)
+	assert.Equal(t, KindInt, field.Kind)
+	assert.Equal(t, 0, field.ValueOf(&models.Pitcher{}))
+	assert.Equal(t, 87, field.ValueOf(&models.Pitcher{Derived: &models.PitcherDerived{FIPMinus: 87}}))
+
+	field, _ = PositionPlayerFields.Lookup("k_rate")
+	assert.True(t, field.LowerIsBetter)
+	field, _ = PitcherFields.Lookup("fip_minus")
+	assert.True(t, field.LowerIsBetter)
+	field, _ = PitcherFields.Lookup("k9")
+	assert.False(t, field.LowerIsBetter)
+}
+
+func TestPercentiles(t *testing.T) {
+	population := []*models.Pitcher{
+		{K9: 8, ERA: 4, W: 10},
+		{K9: 9, ERA: 3, W: 10},
+		{K9: 10, ERA: 2, W: 10},
+		{K9: 11, ERA: 1, W: 10},
+	}
+	stats := map[string]*models.StatPercentile{}
+	for _, stat := range Percentiles(PitcherFields, population[2], population) {
+		stats[stat.Stat] = stat
+	}
+
+	assert.NotContains(t, stats, "id")
+	assert.NotContains(t, stats, "season")
+	assert.Contains(t, stats, "fielderIndependentPitchingMinus")
+
+	// better than two of the four, and tied with itself
+	assert.Equal(t, 63, *stats["strikeoutsPerNine"].Percentile)
+	assert.InDelta(t, 0.5/math.Sqrt(1.25), *stats["strikeoutsPerNine"].ZScore, 1e-9)
+
+	// a lower era is better, so the ranks are inverted
+	assert.True(t, stats["earnedRunAvg"].LowerIsBetter)
+	assert.Equal(t, 63, *stats["earnedRunAvg"].Percentile)
+	assert.InDelta(t, 0.5/math.Sqrt(1.25), *stats["earnedRunAvg"].ZScore, 1e-9)
+
+	// everyone ties, so there is no deviation to score by
+	assert.Equal(t, 50, *stats["wins"].Percentile)
+	assert.Nil(t, stats["wins"].ZScore)
+
+	// a line outside the population is ranked against it
+	for _, stat := range Percentiles(PitcherFields, &models.Pitcher{K9: 12}, population) {
+		if stat.Stat == "strikeoutsPerNine" {
+			assert.Equal(t, 100, *stat.Percentile)
+		}
+	}
+
+	// nor is there anything to rank against in an empty population
+	for _, stat := range Percentiles(PitcherFields, population[0], nil) {
+		assert.Nil(t, stat.Percentile)
+		assert.Nil(t, stat.ZScore)
+	}
+}
+
+func TestLeaders(t *testing.T) {
+	lines := []*models.Pitcher{
+		{ID: 1, Name: "A", ERA: 3},
+		{ID: 2, Name: "B", ERA: 2},
+		{ID: 3, Name: "C", ERA: 3},
+		{ID: 4, Name: "D", ERA: 4},
+		{ID: 5, Name: "E", ERA: 5},
+	}
+	era, _ := PitcherFields.Lookup("era")
+
+	leaders := Leaders(PitcherFields, era, lines, 0)
+	ranks, ids := []int{}, []int{}
+	for _, leader := range leaders {
+		ranks = append(ranks, leader.Rank)
+		ids = append(ids, leader.ID)
+	}
+	// a lower era is better, and the tied lines share a rank ordered by id
+	assert.Equal(t, []int{1, 2, 2, 4, 5}, ranks)
+	assert.Equal(t, []int{2, 1, 3, 4, 5}, ids)
+	assert.False(t, leaders[0].Tied)
+	assert.True(t, leaders[1].Tied)
+	assert.True(t, leaders[2].Tied)
+
+	// lines tied with the last one kept are kept too
+	assert.Len(t, Leaders(PitcherFields, era, lines, 2), 3)
+	assert.Len(t, Leaders(PitcherFields, era, lines, 3), 3)
+	assert.Len(t, Leaders(PitcherFields, era, lines, 4), 4)
+}
+
+func TestQualificationMinimum(t *testing.T) {
+	assert.Equal(t, 502.0, PositionPlayerQualification.Minimum(162))
+	assert.Equal(t, 162.0, PitcherQualification.Minimum(162))
+}
+
+func TestParseOperator(t *testing.T) {
+	op, err := ParseOperator("")
+	assert.NoError(t, err)
+	assert.Equal(t, OpEq, op)
+
+	op, err = ParseOperator("gte")
+	assert.NoError(t, err)
+	assert.Equal(t, OpGte, op)
+
+	_, err = ParseOperator("like")
+	assert.Error(t, err)
+}
+
+func TestWhereClause(t *testing.T) {
+	where, args := whereClause(&ListOptions{IncludeDeleted: true})
+	assert.Equal(t, "", where)
+	assert.Nil(t, args)
+
+	where, args = whereClause(&ListOptions{})
+	assert.Equal(t, " WHERE deleted_at IS NULL", where)
+	assert.Empty(t, args)
+
+	team, _ := PositionPlayerFields.Lookup("team")
+	hr, _ := PositionPlayerFields.Lookup("homeRuns")
+	war, _ := PositionPlayerFields.Lookup("war")
+
+	filters := []Filter{
+		{Field: team, Op: OpIn, Value: []any{"NYY", "BOS"}},
+		{Field: hr, Op: OpGte, Value: 30},
+		{Field: war, Op: OpLt, Value: 2.0},
+	}
+	where, args = whereClause(&ListOptions{Filters: filters, IncludeDeleted: true})
+	assert.Equal(t, " WHERE team IN ($1, $2) AND hr >= $3 AND war < $4", where)
+	assert.Equal(t, []any{"NYY", "BOS", 30, 2.0}, args)
+
+	opts := &ListOptions{
+		Filters: filters[1:],
+		Sort:    []SortKey{{Field: war, Desc: true}},
+		After:   &Cursor{Values: []any{8.1, 12}},
+	}
+	where, args = whereClause(opts)
+	assert.Equal(t, " WHERE hr >= $1 AND war < $2 AND deleted_at IS NULL AND ((war < $3) OR (war = $3 AND player_id > $4))", where)
+	assert.Equal(t, []any{30, 2.0, 8.1, 12}, args)
+
+	opts.After, opts.Before = nil, opts.After
+	where, _ = whereClause(opts)
+	assert.Equal(t, " WHERE hr >= $1 AND war < $2 AND deleted_at IS NULL AND ((war > $3) OR (war = $3 AND player_id < $4))", where)
+}
+
+func TestOrderByClause(t *testing.T) {
+	opts := &ListOptions{}
+	assert.Equal(t, " ORDER BY player_id ASC", orderByClause(opts.OrderKeys(), false))
+
+	war, _ := PositionPlayerFields.Lookup("winsAboveReplacement")
+	name, _ := PositionPlayerFields.Lookup("name")
+	id, _ := PositionPlayerFields.Lookup("id")
+
+	opts = &ListOptions{Sort: []SortKey{{Field: war, Desc: true}, {Field: name}}}
+	assert.Equal(t, " ORDER BY war DESC, name ASC, player_id ASC", orderByClause(opts.OrderKeys(), false))
+	assert.Equal(t, " ORDER BY war ASC, name DESC, player_id DESC", orderByClause(opts.OrderKeys(), true))
+
+	opts = &ListOptions{Sort: []SortKey{{Field: id, Desc: true}, {Field: name}}}
+	assert.Equal(t, " ORDER BY player_id DESC", orderByClause(opts.OrderKeys(), false))
+}
+
+func TestCursor(t *testing.T) {
+	war, _ := PositionPlayerFields.Lookup("winsAboveReplacement")
+	opts := &ListOptions{Sort: []SortKey{{Field: war, Desc: true}}}
+	keys := opts.OrderKeys()
+
+	player := &models.PositionPlayer{ID: 1, Name: "Aaron Judge", WAR: 11.5}
+	token := EncodeCursor(keys, NewCursor(keys, player))
+
+	cursor, err := DecodeCursor(keys, token)
+	assert.NoError(t, err)
+	assert.Equal(t, []any{11.5, 1}, cursor.Values)
+
+	_, err = DecodeCursor(keys[1:], token)
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+
+	_, err = DecodeCursor(keys, "not a cursor")
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+}
+
+func TestNewPage(t *testing.T) {
+	page := newPage([]int{1, 2, 3}, &ListOptions{Limit: 2})
+	assert.Equal(t, []int{1, 2}, page.Items)
+	assert.True(t, page.HasNext)
+	assert.False(t, page.HasPrev)
+
+	page = newPage([]int{3, 2}, &ListOptions{Limit: 2, Before: &Cursor{}})
+	assert.Equal(t, []int{2, 3}, page.Items)
+	assert.True(t, page.HasNext)
+	assert.False(t, page.HasPrev)
+
+	page = newPage([]int{1, 2}, &ListOptions{Limit: 2, After: &Cursor{}})
+	assert.False(t, page.HasNext)
+	assert.True(t, page.HasPrev)
+}
+
+func TestLoadMigrations(t *testing.T) {
+	assert.Equal(t, migrations[len(migrations)-1].Version, LatestVersion)
+	for _, m := range migrations {
+		assert.NotEmpty(t, m.Up)
+		assert.NotEmpty(t, m.Down)
+	}
+
+	file := &fstest.MapFile{Data: []byte("select 1;")}
+	loaded, err := loadMigrations(fstest.MapFS{
+		"migrations/0002_add_column.down.sql":    file,
+		"migrations/0001_create_tables.up.sql":   file,
+		"migrations/0001_create_tables.down.sql": file,
+		"migrations/0002_add_column.up.sql":      file,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(loaded))
+	assert.Equal(t, "add_column", loaded[1].Name)
+
+	_, err = loadMigrations(fstest.MapFS{"migrations/0001_create_tables.up.sql": file})
+	assert.ErrorContains(t, err, "needs both an up and a down file")
+
+	// sqlite runs its own files in place of a migration's, when it has them
+	sqliteFile := &fstest.MapFile{Data: []byte("select 2;")}
+	loaded, err = loadMigrations(fstest.MapFS{
+		"migrations/0001_create_tables.up.sql":          file,
+		"migrations/0001_create_tables.down.sql":        file,
+		"migrations/0001_create_tables.sqlite.up.sql":   sqliteFile,
+		"migrations/0001_create_tables.sqlite.down.sql": sqliteFile,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "create_tables", loaded[0].Name)
+	assert.Equal(t, "select 1;", loaded[0].Up)
+	assert.Equal(t, "select 2;", loaded[0].sqlite(true))
+	assert.Equal(t, "select 2;", loaded[0].sqlite(false))
+
+	_, err = loadMigrations(fstest.MapFS{
+		"migrations/0001_create_tables.up.sql":        file,
+		"migrations/0001_create_tables.down.sql":      file,
+		"migrations/0001_create_tables.sqlite.up.sql": sqliteFile,
+	})
+	assert.ErrorContains(t, err, "needs both a sqlite up and a sqlite down file")
+
+	_, err = loadMigrations(fstest.MapFS{
+		"migrations/0002_add_column.up.sql":   file,
+		"migrations/0002_add_column.down.sql": file,
+	})
+	assert.ErrorContains(t, err, "migration 1 is missing")
+
+	_, err = loadMigrations(fstest.MapFS{"migrations/create_tables.sql": file})
+	assert.ErrorContains(t, err, "invalid migration file name")
+}
+
+func TestSQLiteMigrateTo(t *testing.T) {
+	store, err := NewSQLiteDB(":memory:")
+	assert.NoError(t, err)
+	defer store.Close()
+
+	version, err := store.SchemaVersion()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, version)
+
+	assert.NoError(t, store.Initialize())
+	version, err = store.SchemaVersion()
+	assert.NoError(t, err)
+	assert.Equal(t, LatestVersion, version)
+
+	// applying the migrations again changes nothing
+	assert.NoError(t, store.Initialize())
+	_, err = store.AddPositionPlayer(newJudge())
+	assert.NoError(t, err)
+
+	assert.NoError(t, store.MigrateTo(0))
+	version, err = store.SchemaVersion()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, version)
+	_, err = store.GetPositionPlayers(&ListOptions{})
+	assert.ErrorContains(t, err, "no such table")
+
+	assert.Error(t, store.MigrateTo(LatestVersion+1))
+}
+
+// baselineSchema is the schema the api created its tables with before migrations
+const baselineSchema = `create table if not exists position_players (
+	player_id serial primary key NOT NULL,
+	name text NOT NULL,
+	team text,
+	g int CHECK (g >= 0),
+	pa int CHECK (pa >= 0),
+	hr int CHECK (hr >= 0),
+	runs int CHECK (runs >= 0),
+	rbi int CHECK (rbi >= 0),
+	sb int CHECK (sb >= 0),
+	wrc_plus int CHECK (wrc_plus >= 0),
+	bb_rate float8 CHECK (bb_rate >= 0),
+	k_rate float8 CHECK (k_rate >= 0),
+	iso float8 CHECK (iso >= 0),
+	babip float8 CHECK (babip >= 0),
+	average float8 CHECK (average >= 0),
+	obp float8 CHECK (obp >= 0),
+	slg float8 CHECK (slg >= 0),
+	woba float8 CHECK (woba >= 0),
+	x_woba float8 CHECK (x_woba >= 0),
+	bsr float8,
+	war float8,
+	unique (name, team));
+
+CREATE table IF NOT EXISTS pitchers (
+	player_id serial primary key NOT NULL,
+	name text NOT NULL,
+	team text,
+	w int CHECK (w >= 0),
+	l int CHECK (l >= 0),
+	sv int CHECK (sv >= 0),
+	g int CHECK (g >= 0),
+	gs int CHECK (gs >= 0),
+	ip float8 CHECK (ip >= 0),
+	k9 float8 CHECK (k9 >= 0),
+	bb9 float8 CHECK (bb9 >= 0),
+	hr9 float8 CHECK (hr9 >= 0),
+	babip float8 CHECK (babip >= 0),
+	lob float8 CHECK (lob >= 0),
+	gb float8 CHECK (gb >= 0),
+	hrfb float8 CHECK (hrfb >= 0),
+	vfa float8 CHECK (vfa >= 0),
+	era float8 CHECK (era >= 0),
+	xera float8 CHECK (xera >= 0),
+	fip float8 CHECK (fip >= 0),
+	xfip float8 CHECK (xfip >= 0),
+	war float8,
+	unique (name, team));`
+
+func TestSQLiteMigrateFromBaseline(t *testing.T) {
+	store, err := NewSQLiteDB(":memory:")
+	assert.NoError(t, err)
+	defer store.Close()
+
+	_, err = store.conn.Exec(sqliteSchema(baselineSchema))
+	assert.NoError(t, err)
+	// a player traded during the season has a line for each team
+	_, err = store.conn.Exec(`INSERT INTO position_players
+		(name, team, g, pa, hr, runs, rbi, sb, wrc_plus, bb_rate, k_rate, iso, babip, average, obp, slg, woba, x_woba, bsr, war) VALUES
+		('Aaron Judge', 'NYY', 157, 696, 62, 133, 131, 16, 207, 15.9, 25.1, .375, .340, .311, .425, .686, .458, .463, 2.1, 11.5),
+		('Joey Gallo', 'NYY', 82, 277, 12, 32, 24, 2, 82, 12.3, 38.6, .200, .250, .159, .282, .339, .280, .313, .2, -.4),
+		('Joey Gallo', 'LAD', 44, 133, 7, 16, 23, 1, 117, 14.3, 39.1, .245, .237, .162, .301, .408, .316, .313, -.3, .2)`)
+	assert.NoError(t, err)
+	_, err = store.conn.Exec(`INSERT INTO pitchers
+		(name, team, w, l, sv, g, gs, ip, k9, bb9, hr9, babip, lob, gb, hrfb, vfa, era, xera, fip, xfip, war) VALUES
+		('Aaron Nola', 'PHI', 11, 13, 0, 32, 32, 205, 10.3, 1.3, 0.8, .289, 73.0, 43.6, 9.8, 92.9, 3.25, 2.74, 2.58, 2.77, 6.3)`)
+	assert.NoError(t, err)
+
+	assert.NoError(t, store.Initialize())
+	version, err := store.SchemaVersion()
+	assert.NoError(t, err)
+	assert.Equal(t, LatestVersion, version)
+
+	// the existing lines are backfilled as 2022 lines, with a player for each name
+	judge, err := store.GetPositionPlayerByID(1)
+	assert.NoError(t, err)
+	assert.Equal(t, 2022, judge.Season)
+	assert.Equal(t, 62, judge.HR)
+	assert.Equal(t, 1, judge.Version)
+	nyy, err := store.GetPositionPlayerByID(2)
+	assert.NoError(t, err)
+	lad, err := store.GetPositionPlayerByID(3)
+	assert.NoError(t, err)
+	assert.Equal(t, nyy.PlayerID, lad.PlayerID)
+	assert.NotEqual(t, judge.PlayerID, nyy.PlayerID)
+	nola, err := store.GetPitcherByID(1)
+	assert.NoError(t, err)
+	assert.Equal(t, 2022, nola.Season)
+	assert.NotZero(t, nola.PlayerID)
+
+	// lines are unique by season, so the next season of a line can be added, with the next id
+	_, err = store.AddPositionPlayer(newJudge())
+	assert.ErrorIs(t, err, ErrConflict)
+	nextSeason := newJudge()
+	nextSeason.Season = 2023
+	_, err = store.AddPositionPlayer(nextSeason)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, nextSeason.ID)
+	assert.Equal(t, judge.PlayerID, nextSeason.PlayerID)
+
+	// a line needs a player
+	_, err = store.conn.Exec(`INSERT INTO pitchers (name, team, season) VALUES ('Zack Wheeler', 'PHI', 2022)`)
+	assert.ErrorContains(t, err, "NOT NULL constraint failed")
+}
+
+func TestPgError(t *testing.T) {
+	assert.NoError(t, pgError(nil))
+
+	err := pgError(pgx.ErrNoRows)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.ErrorIs(t, err, pgx.ErrNoRows)
+
+	err = pgError(&pgconn.PgError{Code: "23505", ConstraintName: "position_players_name_team_season_key"})
+	assert.ErrorIs(t, err, ErrConflict)
+	var dbErr *Error
+	assert.True(t, errors.As(err, &dbErr))
+	assert.Equal(t, "position_players_name_team_season_key", dbErr.Constraint)
+
+	assert.ErrorIs(t, pgError(&pgconn.PgError{Code: "23514"}), ErrConstraint)
+	assert.ErrorIs(t, pgError(&pgconn.PgError{Code: "23503"}), ErrConstraint)
+	assert.ErrorIs(t, pgError(&pgconn.PgError{Code: "57P01"}), ErrUnavailable)
+	assert.ErrorIs(t, pgError(&pgconn.ConnectError{}), ErrUnavailable)
+
+	// a syntax error is no kind of Error
+	syntax := &pgconn.PgError{Code: "42601"}
+	assert.Equal(t, syntax, pgError(syntax))
+}
 
+func TestRoundNumeric(t *testing.T) {
+	// halves are rounded away from zero, even when their float is just below the half
+	assert.Equal(t, 0.044, roundNumeric(0.0435, 3))
+	assert.Equal(t, -0.044, roundNumeric(-0.0435, 3))
+	assert.Equal(t, 1.111, roundNumeric(.425+.686, 3))
+	assert.Equal(t, 0.044, roundNumeric(.458-.414, 3))
+	assert.Equal(t, 3.0, roundNumeric(2.5, 0))
+	assert.Equal(t, 115.0, roundNumeric(114.5, 0))
 }
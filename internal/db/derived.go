@@ -0,0 +1,234 @@
+package db
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/e-berman/baseball_api/internal/models"
+	"github.com/jackc/pgx/v5"
+)
+
+// derivedStat is a stat computed from the stored stats of a line and the league averages of its season
+//
+// a derived stat is never stored. it is computed in sql when lines are read, filtered or sorted,
+// and by value for the lines kept in memory, both rounded to places by roundedExpr and roundNumeric
+type derivedStat[T any] struct {
+	// json name of the stat in the line's derived stats, and a shorthand accepted in queries like a column name
+	name, shorthand string
+	// sql computing the unrounded stat from the columns of the line and the league averages joined by source
+	expr string
+	// computes the stat as expr does, given the league averages of the line's season by column
+	value func(line *T, league map[string]float64) float64
+	// decimal places the stat is rounded to, those of the stats it is computed from
+	places int
+}
+
+// roundedExpr returns the sql rounding expr to places, as roundNumeric does
+//
+// ROUND of a float8 rounds half to even on Postgres, so the value is rounded as a numeric, which rounds
+// half away from zero on Postgres and SQLite alike
+func roundedExpr(expr string, places int, kind FieldKind) string {
+	rounded := fmt.Sprintf("ROUND(CAST(%s AS numeric), %d)", expr, places)
+	if kind == KindInt {
+		return "CAST(" + rounded + " AS integer)"
+	}
+
+	return rounded
+}
+
+// roundNumeric rounds a value to places as roundedExpr does in sql
+//
+// casting a float8 to numeric keeps 15 significant digits, so the value is cut to them before it is
+// rounded half away from zero, and a value like 0.0435 is rounded up although its float is just below
+func roundNumeric(value float64, places int) float64 {
+	significant := func(v float64) float64 {
+		cut, _ := strconv.ParseFloat(strconv.FormatFloat(v, 'g', 15, 64), 64)
+		return cut
+	}
+
+	ratio := math.Pow(10, float64(places))
+	return math.Round(significant(significant(value)*ratio)) / ratio
+}
+
+// plusStat returns a stat scaled to its league average, 100 being average, or 0 without a league average
+func plusStat(stat, league float64) float64 {
+	if league == 0 {
+		return 0
+	}
+
+	return 100 * stat / league
+}
+
+// columns of the batting averages the derived stats of a position player are adjusted to
+var positionPlayerLeague = []string{"obp", "slg"}
+
+// the derived stats of a position player
+var positionPlayerDerived = []derivedStat[models.PositionPlayer]{
+	{
+		name:      "onBasePlusSlugging",
+		shorthand: "ops",
+		expr:      `obp + slg`,
+		value: func(player *models.PositionPlayer, _ map[string]float64) float64 {
+			return player.OBP + player.SLG
+		},
+		places: 3,
+	},
+	{
+		name:      "onBasePlusSluggingPlus",
+		shorthand: "ops_plus",
+		expr:      `COALESCE(100 * (obp / NULLIF(league_obp, 0) + slg / NULLIF(league_slg, 0) - 1), 0)`,
+		value: func(player *models.PositionPlayer, league map[string]float64) float64 {
+			if league["obp"] == 0 || league["slg"] == 0 {
+				return 0
+			}
+			return 100 * (player.OBP/league["obp"] + player.SLG/league["slg"] - 1)
+		},
+	},
+	{
+		name:      "weightedOnBaseAvgMinusExpected",
+		shorthand: "woba_minus_xwoba",
+		expr:      `woba - x_woba`,
+		value: func(player *models.PositionPlayer, _ map[string]float64) float64 {
+			return player.WOBA - player.XWOBA
+		},
+		places: 3,
+	},
+}
+
+// columns of the pitching averages the derived stats of a pitcher are adjusted to
+var pitcherLeague = []string{"fip"}
+
+// the derived stats of a pitcher
+var pitcherDerived = []derivedStat[models.Pitcher]{
+	{
+		name:      "strikeoutToWalkRatio",
+		shorthand: "k_bb",
+		expr:      `COALESCE(k9 / NULLIF(bb9, 0), 0)`,
+		value: func(player *models.Pitcher, _ map[string]float64) float64 {
+			if player.BB9 == 0 {
+				return 0
+			}
+			return player.K9 / player.BB9
+		},
+		places: 2,
+	},
+	{
+		name:      "strikeoutsMinusWalksPerNine",
+		shorthand: "k_minus_bb9",
+		expr:      `k9 - bb9`,
+		value: func(player *models.Pitcher, _ map[string]float64) float64 {
+			return player.K9 - player.BB9
+		},
+		places: 2,
+	},
+	{
+		name:      "earnedRunAvgMinusFip",
+		shorthand: "era_minus_fip",
+		expr:      `era - fip`,
+		value: func(player *models.Pitcher, _ map[string]float64) float64 {
+			return player.ERA - player.FIP
+		},
+		places: 2,
+	},
+	{
+		name:      "fielderIndependentPitchingMinus",
+		shorthand: "fip_minus",
+		expr:      `COALESCE(100 * fip / NULLIF(league_fip, 0), 0)`,
+		value: func(player *models.Pitcher, league map[string]float64) float64 {
+			return plusStat(player.FIP, league["fip"])
+		},
+	},
+}
+
+// withDerived adds the derived stats of a model to a FieldSet, so lines can be filtered and sorted by them
+//
+// the Column of a derived field is the sql computing it rounded, and its shorthand is accepted like a column name.
+// derived fields aren't returned by Fields, since they aren't stored
+func withDerived[T any](fs *FieldSet, stats []derivedStat[T]) *FieldSet {
+	modelType := reflect.TypeOf((*T)(nil)).Elem()
+	derived, _ := modelType.FieldByName("Derived")
+	statsType := derived.Type.Elem()
+
+	for _, stat := range stats {
+		for i := 0; i < statsType.NumField(); i++ {
+			sf := statsType.Field(i)
+			if strings.Split(sf.Tag.Get("json"), ",")[0] != stat.name {
+				continue
+			}
+
+			kind := fieldKind(sf.Type)
+			field := Field{Name: stat.name, Column: roundedExpr(stat.expr, stat.places, kind), Kind: kind, index: i, derived: true}
+			fs.derived = append(fs.derived, field)
+			fs.lookup[stat.name] = field
+			fs.lookup[stat.shorthand] = field
+		}
+	}
+
+	return fs
+}
+
+// source returns the FROM clause of a query reading a table's lines with their derived stats
+//
+// the table is joined to the league averages of each season stored by the last import,
+// each selected as league_<column>, e.g. league_obp
+func (t *lineTable[T]) source() string {
+	if len(t.league) == 0 {
+		return t.name
+	}
+
+	averages := make([]string, len(t.league))
+	for i, column := range t.league {
+		averages[i] = fmt.Sprintf("%s AS league_%s", column, column)
+	}
+
+	return t.name + ` LEFT JOIN (SELECT season AS league_season, ` + strings.Join(averages, ", ") + `
+	FROM ` + t.averages.name + ` WHERE team = '') league
+	ON league.league_season = ` + t.name + `.season`
+}
+
+// readColumns returns the columns selected from source for a line and its derived stats, in scanDerived order
+func (t *lineTable[T]) readColumns() string {
+	columns := []string{t.columns}
+	for _, field := range t.fields.derived {
+		columns = append(columns, field.Column)
+	}
+
+	return strings.Join(columns, ", ")
+}
+
+// scanDerived scans a row of readColumns into a line with its derived stats
+func (t *lineTable[T]) scanDerived(row pgx.Row) (*T, error) {
+	values := make([]any, len(t.fields.derived))
+	for i, field := range t.fields.derived {
+		if field.Kind == KindInt {
+			values[i] = new(int)
+		} else {
+			values[i] = new(float64)
+		}
+	}
+
+	line, err := t.scan(derivedRow{row: row, derived: values})
+	if err != nil {
+		return nil, err
+	}
+
+	for i, field := range t.fields.derived {
+		field.setValue(line, reflect.ValueOf(values[i]).Elem().Interface())
+	}
+
+	return line, nil
+}
+
+// derivedRow is a row of readColumns, whose derived stats following the columns of the line
+// are scanned into derived
+type derivedRow struct {
+	row     pgx.Row
+	derived []any
+}
+
+func (r derivedRow) Scan(dest ...any) error {
+	return r.row.Scan(append(dest, r.derived...)...)
+}
@@ -0,0 +1,194 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// kinds of Error, every backend reports its failures as one of these
+var (
+	// no row has the given id, or the player id source doesn't exist
+	ErrNotFound = errors.New("not found")
+	// a row already exists with the same unique key, e.g. the same name, team and season
+	ErrConflict = errors.New("conflict")
+	// a row breaks a CHECK or foreign key constraint, e.g. a negative stat or an unknown player
+	ErrConstraint = errors.New("constraint violation")
+	// a line was written since the version a write expected, e.g. by another editor
+	ErrVersionMismatch = errors.New("version mismatch")
+	// the database can't be reached or is refusing connections
+	ErrUnavailable = errors.New("database unavailable")
+)
+
+// ErrInvalidCSV is reported when a csv can't be imported at all, e.g. when its header
+// can't be read or is missing columns
+var ErrInvalidCSV = errors.New("invalid csv")
+
+// Error is a failure of a DB operation, matched with errors.Is against its Kind
+//
+// the error of the underlying driver is kept, so errors.Is(err, pgx.ErrNoRows) still holds for DBPool
+type Error struct {
+	Kind error
+	// name of the violated constraint, if known
+	Constraint string
+	// id of the existing line an added line collided with, for an ErrConflict on name, team and season
+	ID int
+	// what is reported in place of Err, e.g. when Err is the driver's own text
+	Message string
+	Err     error
+}
+
+func (e *Error) Error() string {
+	if e.Message != "" {
+		return e.Message
+	}
+	if e.Err == nil {
+		return e.Kind.Error()
+	}
+
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() []error {
+	return []error{e.Kind, e.Err}
+}
+
+// newError returns an Error of the given kind with a message
+func newError(kind error, constraint, message string) *Error {
+	return &Error{Kind: kind, Constraint: constraint, Err: errors.New(message)}
+}
+
+// notFoundError returns the ErrNotFound Error for a missing row of a table with the given id,
+// e.g. "position player 1 not found", wrapping pgx.ErrNoRows as pgError would
+func notFoundError(table string, id int) error {
+	return &Error{Kind: ErrNotFound, Message: fmt.Sprintf("%s %d not found", rowNoun(table), id), Err: pgx.ErrNoRows}
+}
+
+// rowNoun returns what a row of a table is called, e.g. "position player" for position_players
+func rowNoun(table string) string {
+	return strings.TrimSuffix(strings.ReplaceAll(table, "_", " "), "s")
+}
+
+// externalNotFoundError returns the ErrNotFound Error for a missing player with the given id of an outside source
+func externalNotFoundError(source, id string) error {
+	return &Error{Kind: ErrNotFound, Message: fmt.Sprintf("player with %s id %q not found", source, id), Err: pgx.ErrNoRows}
+}
+
+// noRowsError returns err classified as an ErrNotFound Error for the row of a table with the given id
+// when it is pgx.ErrNoRows, and as is otherwise
+func noRowsError(err error, table string, id int) error {
+	if errors.Is(err, pgx.ErrNoRows) {
+		return notFoundError(table, id)
+	}
+
+	return err
+}
+
+// lineConflictError returns the ErrConflict Error for a line colliding with the line with the given id
+func lineConflictError(table string, id int) error {
+	constraint := table + "_name_team_season_key"
+	return &Error{
+		Kind:       ErrConflict,
+		Constraint: constraint,
+		ID:         id,
+		Err:        fmt.Errorf("duplicate key value violates unique constraint %q, line %d has the same name, team and season", constraint, id),
+	}
+}
+
+// existingLineError returns the lineConflictError for the existing line of a table with the given key
+func existingLineError(q querier, table string, key lineKey) error {
+	id := 0
+	query := `SELECT player_id FROM ` + table + ` WHERE name = $1 AND team = $2 AND season = $3`
+	if err := q.QueryRow(context.Background(), query, key.name, key.team, key.season).Scan(&id); err != nil {
+		return err
+	}
+
+	return lineConflictError(table, id)
+}
+
+// pgError classifies an error returned by pgx
+//
+// errors that aren't any kind of Error are returned as is
+func pgError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var dbErr *Error
+	if errors.As(err, &dbErr) {
+		return err
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		return &Error{Kind: ErrNotFound, Message: ErrNotFound.Error(), Err: err}
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch {
+		case pgErr.Code == "23505":
+			return &Error{Kind: ErrConflict, Constraint: pgErr.ConstraintName, Err: err}
+		case pgErr.Code == "23514", pgErr.Code == "23503", pgErr.Code == "23502":
+			return &Error{Kind: ErrConstraint, Constraint: pgErr.ConstraintName, Err: err}
+		// connection exceptions, insufficient resources and operator intervention, e.g. a shutdown
+		case strings.HasPrefix(pgErr.Code, "08"), strings.HasPrefix(pgErr.Code, "53"), strings.HasPrefix(pgErr.Code, "57"):
+			return &Error{Kind: ErrUnavailable, Err: err}
+		}
+		return err
+	}
+
+	return unavailableError(err)
+}
+
+// sqliteError classifies an error returned by the sqlite driver
+//
+// errors that aren't any kind of Error are returned as is
+func sqliteError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var dbErr *Error
+	if errors.As(err, &dbErr) {
+		return err
+	}
+	if errors.Is(err, pgx.ErrNoRows) || errors.Is(err, sql.ErrNoRows) {
+		return &Error{Kind: ErrNotFound, Message: ErrNotFound.Error(), Err: err}
+	}
+
+	var liteErr *sqlite.Error
+	if errors.As(err, &liteErr) {
+		switch liteErr.Code() {
+		case sqlite3.SQLITE_CONSTRAINT_UNIQUE, sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY:
+			return &Error{Kind: ErrConflict, Err: err}
+		case sqlite3.SQLITE_CONSTRAINT_CHECK, sqlite3.SQLITE_CONSTRAINT_FOREIGNKEY, sqlite3.SQLITE_CONSTRAINT_NOTNULL:
+			return &Error{Kind: ErrConstraint, Err: err}
+		}
+
+		switch liteErr.Code() & 0xff {
+		case sqlite3.SQLITE_BUSY, sqlite3.SQLITE_LOCKED, sqlite3.SQLITE_CANTOPEN, sqlite3.SQLITE_IOERR, sqlite3.SQLITE_FULL:
+			return &Error{Kind: ErrUnavailable, Err: err}
+		}
+		return err
+	}
+
+	return unavailableError(err)
+}
+
+// unavailableError classifies failures to reach a database as ErrUnavailable
+func unavailableError(err error) error {
+	var connectErr *pgconn.ConnectError
+	var netErr net.Error
+	if errors.As(err, &connectErr) || errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, sql.ErrConnDone) {
+		return &Error{Kind: ErrUnavailable, Err: err}
+	}
+
+	return err
+}
@@ -0,0 +1,431 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ChangeAction is the kind of write recorded in the history of a line
+type ChangeAction string
+
+const (
+	ChangeCreate  ChangeAction = "create"
+	ChangeUpdate  ChangeAction = "update"
+	ChangeDelete  ChangeAction = "delete"
+	ChangeRestore ChangeAction = "restore"
+	// ChangePurge is the permanent removal of a line, by a purge of the trash or a replace import
+	ChangePurge ChangeAction = "purge"
+)
+
+// ChangeSource is what a change was made through
+type ChangeSource string
+
+const (
+	// SourceAPI is a write made through the api, including a batch
+	SourceAPI ChangeSource = "api"
+	// SourceImport is a write made by a csv import
+	SourceImport ChangeSource = "import"
+	// SourceMaintenance is a write made by a maintenance command, e.g. a purge of the trash
+	SourceMaintenance ChangeSource = "maintenance"
+)
+
+// actor recorded for changes made by a DB not given one, e.g. the import of the csvs in assets at startup
+const systemActor = "system"
+
+// Change is an entry of the append-only history of a season line
+//
+// Version is the version of the line after the change, or the version removed for a purge.
+// Before is nil for a create and After is nil for a purge. the After of a delete is the line
+// as it is in the trash
+type Change[T any] struct {
+	ID      int          `json:"id"`
+	LineID  int          `json:"lineId"`
+	Version int          `json:"version"`
+	Action  ChangeAction `json:"action"`
+	Actor   string       `json:"actor"`
+	Source  ChangeSource `json:"source"`
+	At      time.Time    `json:"at"`
+	Before  *T           `json:"before"`
+	After   *T           `json:"after"`
+}
+
+// author is who made a change and through what
+type author struct {
+	actor  string
+	source ChangeSource
+}
+
+// newAuthor returns the author of changes made by actor through source, the system when actor is empty
+func newAuthor(actor string, source ChangeSource) author {
+	if actor == "" {
+		actor = systemActor
+	}
+
+	return author{actor: actor, source: source}
+}
+
+// changeRow is a change as stored, with the line before and after it encoded as json
+type changeRow struct {
+	id      int
+	table   string
+	lineID  int
+	version int
+	action  ChangeAction
+	actor   string
+	source  ChangeSource
+	at      time.Time
+	before  []byte
+	after   []byte
+}
+
+// columns stored for a change, in changeRow order after its id
+const changeColumns = `line_table, line_id, version, action, actor, source, changed_at, before_line, after_line`
+
+// changeTime returns the current time, to the microsecond as Postgres keeps it
+func changeTime() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
+
+// newChangeRow returns the change of a line from before to after, either of which is nil for a create or purge
+func newChangeRow[T any](table *lineTable[T], by author, action ChangeAction, before, after *T) (*changeRow, error) {
+	row := &changeRow{
+		table:  table.name,
+		action: action,
+		actor:  by.actor,
+		source: by.source,
+		at:     changeTime(),
+	}
+
+	// the line as it is after the change, or as it was when purged
+	line := after
+	if line == nil {
+		line = before
+	}
+	id, _ := table.fields.Lookup("id")
+	row.lineID, row.version = id.ValueOf(line).(int), *table.version(line)
+
+	var err error
+	if before != nil {
+		if row.before, err = json.Marshal(before); err != nil {
+			return nil, err
+		}
+	}
+	if after != nil {
+		if row.after, err = json.Marshal(after); err != nil {
+			return nil, err
+		}
+	}
+
+	return row, nil
+}
+
+// values returns the values of the columns stored for a change
+func (row *changeRow) values() []any {
+	return []any{row.table, row.lineID, row.version, string(row.action), row.actor, string(row.source), row.at, jsonText(row.before), jsonText(row.after)}
+}
+
+// jsonText returns encoded json as text, or nil to store NULL
+func jsonText(data []byte) any {
+	if data == nil {
+		return nil
+	}
+
+	return string(data)
+}
+
+// scanChange scans a row of id and changeColumns into a changeRow
+func scanChange(row pgx.Row) (*changeRow, error) {
+	c := &changeRow{}
+	var action, source string
+	var before, after *string
+	err := row.Scan(&c.id, &c.table, &c.lineID, &c.version, &action, &c.actor, &source, &c.at, &before, &after)
+	if err != nil {
+		return nil, err
+	}
+
+	c.action, c.source, c.at = ChangeAction(action), ChangeSource(source), c.at.UTC()
+	if before != nil {
+		c.before = []byte(*before)
+	}
+	if after != nil {
+		c.after = []byte(*after)
+	}
+
+	return c, nil
+}
+
+// decodeChange returns a stored change with its lines decoded
+func decodeChange[T any](row *changeRow) (*Change[T], error) {
+	change := &Change[T]{
+		ID:      row.id,
+		LineID:  row.lineID,
+		Version: row.version,
+		Action:  row.action,
+		Actor:   row.actor,
+		Source:  row.source,
+		At:      row.at,
+	}
+
+	if row.before != nil {
+		change.Before = new(T)
+		if err := json.Unmarshal(row.before, change.Before); err != nil {
+			return nil, err
+		}
+	}
+	if row.after != nil {
+		change.After = new(T)
+		if err := json.Unmarshal(row.after, change.After); err != nil {
+			return nil, err
+		}
+	}
+
+	return change, nil
+}
+
+// decodeHistory returns the changes of the line of a table with the given id, reporting a line without any as ErrNotFound
+func decodeHistory[T any](table string, id int, rows []*changeRow) ([]*Change[T], error) {
+	if len(rows) == 0 {
+		return nil, notFoundError(table, id)
+	}
+
+	changes := make([]*Change[T], len(rows))
+	for i, row := range rows {
+		change, err := decodeChange[T](row)
+		if err != nil {
+			return nil, err
+		}
+		changes[i] = change
+	}
+
+	return changes, nil
+}
+
+// *******************
+// Recording changes
+// *******************
+
+// historyQuery selects the changes of a line, oldest first
+const historyQuery = `SELECT id, ` + changeColumns + ` FROM line_history WHERE line_table = $1 AND line_id = $2 ORDER BY id`
+
+// recordChange appends the change of a line from before to after to its history
+func recordChange[T any](q querier, table *lineTable[T], by author, action ChangeAction, before, after *T) error {
+	row, err := newChangeRow(table, by, action, before, after)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO line_history (` + changeColumns + `) VALUES (` + placeholders(9) + `)`
+	_, err = q.Exec(context.Background(), query, row.values()...)
+
+	return err
+}
+
+// lineAt returns the line of a table with the given id, reporting a missing or trashed line as pgx.ErrNoRows
+func lineAt[T any](q querier, table *lineTable[T], id int) (*T, error) {
+	query := `SELECT ` + table.columns + ` FROM ` + table.name + ` WHERE player_id = $1 AND ` + liveCondition
+
+	return table.scan(q.QueryRow(context.Background(), query, id))
+}
+
+// derivedLineAt returns the line of a table with the given id with its derived stats, as a read returns it,
+// whether or not it is in the trash
+func derivedLineAt[T any](q querier, table *lineTable[T], id int) (*T, error) {
+	query := `SELECT ` + table.readColumns() + ` FROM ` + table.source() + ` WHERE player_id = $1`
+
+	return table.scanDerived(q.QueryRow(context.Background(), query, id))
+}
+
+// storedLineAt returns the line of a table with the given id whether or not it is in the trash,
+// reporting a missing line as pgx.ErrNoRows
+func storedLineAt[T any](q querier, table *lineTable[T], id int) (*T, error) {
+	query := `SELECT ` + table.columns + ` FROM ` + table.name + ` WHERE player_id = $1`
+
+	return table.scan(q.QueryRow(context.Background(), query, id))
+}
+
+// recordCreate runs create, adding a line, and records the line created
+func recordCreate[T any](q querier, table *lineTable[T], by author, create func() (*T, error)) (*T, error) {
+	created, err := create()
+	if err != nil {
+		return nil, err
+	}
+
+	return created, recordChange(q, table, by, ChangeCreate, nil, created)
+}
+
+// recordUpdate runs update, a write to the line with the given id, recording the change when the line is written
+//
+// a write that leaves the line at the same version, e.g. an empty patch, isn't recorded
+func recordUpdate[T any](q querier, table *lineTable[T], by author, id int, update func() (*T, error)) (*T, error) {
+	before, err := lineAt(q, table, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, notFoundError(table.name, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	after, err := update()
+	if err != nil {
+		return nil, err
+	}
+	if *table.version(after) == *table.version(before) {
+		return after, nil
+	}
+
+	// the history keeps the stored line, without the derived stats it was read back with
+	stored, err := lineAt(q, table, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return after, recordChange(q, table, by, ChangeUpdate, before, stored)
+}
+
+// *******************
+// Restoring lines
+// *******************
+
+// versionNotFoundError returns the ErrNotFound Error for a version missing from the history of a line
+func versionNotFoundError(id, version int) error {
+	return newError(ErrNotFound, "", fmt.Sprintf("line %d has no version %d in its history", id, version))
+}
+
+// restoreTarget returns the line with the given id as it was at version, along with the latest
+// version in its history
+func restoreTarget[T any](q querier, table *lineTable[T], id, version int) (*T, int, error) {
+	ctx := context.Background()
+
+	var after *string
+	query := `SELECT after_line FROM line_history
+	WHERE line_table = $1 AND line_id = $2 AND version = $3 AND after_line IS NOT NULL
+	ORDER BY id DESC LIMIT 1`
+	err := q.QueryRow(ctx, query, table.name, id, version).Scan(&after)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, 0, versionNotFoundError(id, version)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	latest := 0
+	query = `SELECT max(version) FROM line_history WHERE line_table = $1 AND line_id = $2`
+	if err := q.QueryRow(ctx, query, table.name, id).Scan(&latest); err != nil {
+		return nil, 0, err
+	}
+
+	target := new(T)
+	if err := json.Unmarshal([]byte(*after), target); err != nil {
+		return nil, 0, err
+	}
+
+	return target, latest, nil
+}
+
+// restoreLine sets the line with the given id back to its name, team, season, player and stats
+// at version, returning the restored line with its derived stats
+//
+// the restore is a write of its own, so the line moves to a new version and the restore is recorded.
+// a line in the trash is taken out of it, and a purged line is recreated with the same id
+func restoreLine[T any](q querier, table *lineTable[T], by author, id, version int) (*T, error) {
+	target, latest, err := restoreTarget(q, table, id, version)
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := storedLineAt(q, table, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		current = nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	fields := table.copyFields()
+	columns := make([]string, len(fields))
+	values := make([]any, len(fields))
+	for i, field := range fields {
+		columns[i] = field.Column
+		values[i] = field.ValueOf(target)
+	}
+
+	var query string
+	if current != nil {
+		assignments := make([]string, len(columns))
+		for i, column := range columns {
+			assignments[i] = fmt.Sprintf("%s = $%d", column, i+1)
+		}
+		values = append(values, id)
+		query = `UPDATE ` + table.name + ` SET ` + strings.Join(assignments, ", ") + `, version = version + 1, deleted_at = NULL
+		WHERE player_id = $` + fmt.Sprint(len(values)) + ` RETURNING ` + table.columns
+	} else {
+		columns = append(columns, "player_id", "version")
+		values = append(values, id, latest+1)
+		query = `INSERT INTO ` + table.name + ` (` + strings.Join(columns, ", ") + `)
+		VALUES (` + placeholders(len(values)) + `)
+		ON CONFLICT (name, team, season) DO NOTHING
+		RETURNING ` + table.columns
+	}
+
+	restored, err := table.scan(q.QueryRow(context.Background(), query, values...))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, existingLineError(q, table.name, lineKeyOf(table.fields, target))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := recordChange(q, table, by, ChangeRestore, current, restored); err != nil {
+		return nil, err
+	}
+
+	return derivedLineAt(q, table, id)
+}
+
+// *******************
+// Postgres
+// *******************
+
+// pgHistory returns the changes of the line of a table with the given id, oldest first
+func pgHistory[T any](pool *DBPool, table *lineTable[T], id int) ([]*Change[T], error) {
+	rows, err := pool.Poolconn.Query(context.Background(), historyQuery, table.name, id)
+	if err != nil {
+		return nil, pgError(err)
+	}
+	defer rows.Close()
+
+	changes := []*changeRow{}
+	for rows.Next() {
+		change, err := scanChange(rows)
+		if err != nil {
+			return nil, pgError(err)
+		}
+
+		changes = append(changes, change)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, pgError(err)
+	}
+
+	return decodeHistory[T](table.name, id, changes)
+}
+
+// pgRestore restores the line of a table with the given id to version in a transaction
+func pgRestore[T any](pool *DBPool, table *lineTable[T], id, version int) (*T, error) {
+	var restored *T
+	err := pgx.BeginFunc(context.Background(), pool.Poolconn, func(tx pgx.Tx) error {
+		var err error
+		restored, err = restoreLine(tx, table, pool.author(), id, version)
+		return err
+	})
+	if err != nil {
+		return nil, pgError(err)
+	}
+
+	return restored, nil
+}
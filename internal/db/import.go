@@ -0,0 +1,470 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// ImportMode decides what happens to a csv import when some of its rows are invalid
+type ImportMode string
+
+const (
+	// ImportLenient skips invalid rows and imports the rest
+	ImportLenient ImportMode = "lenient"
+	// ImportStrict imports nothing from a csv with any invalid rows
+	ImportStrict ImportMode = "strict"
+)
+
+// WriteMode decides how imported rows are written when a line already exists for the same name, team and season
+type WriteMode string
+
+const (
+	// WriteInsert leaves existing lines as they are
+	WriteInsert WriteMode = "insert"
+	// WriteUpsert updates the stats of existing lines that differ, leaving unchanged lines untouched
+	WriteUpsert WriteMode = "upsert"
+	// WriteReplace deletes every existing line of each season in the csv before writing its rows
+	WriteReplace WriteMode = "replace"
+)
+
+// ErrInvalidRows is returned by a strict import of a csv with invalid rows
+var ErrInvalidRows = errors.New("csv has invalid rows, nothing was imported")
+
+// ParseImportMode returns the import mode with the given name, defaulting to lenient when empty
+func ParseImportMode(name string) (ImportMode, error) {
+	switch ImportMode(name) {
+	case "", ImportLenient:
+		return ImportLenient, nil
+	case ImportStrict:
+		return ImportStrict, nil
+	}
+
+	return "", fmt.Errorf("unknown import mode: %s", name)
+}
+
+// ParseWriteMode returns the write mode with the given name, defaulting to insert when empty
+func ParseWriteMode(name string) (WriteMode, error) {
+	switch WriteMode(name) {
+	case "", WriteInsert:
+		return WriteInsert, nil
+	case WriteUpsert, WriteReplace:
+		return WriteMode(name), nil
+	}
+
+	return "", fmt.Errorf("unknown write mode: %s", name)
+}
+
+// ImportOptions controls how a csv is imported
+//
+// Season is used for rows when the csv has no Season column. a nil Mapping uses the mapping
+// configured for the server. a DryRun validates every row without writing any of them
+type ImportOptions struct {
+	Mode    ImportMode
+	Write   WriteMode
+	DryRun  bool
+	Season  int
+	Mapping *CSVMapping
+}
+
+// RowError describes an invalid value in a row of a csv
+//
+// Row is the line number of the row in the csv, counting the header as line 1
+type RowError struct {
+	Row    int    `json:"row"`
+	Column string `json:"column,omitempty"`
+	Value  string `json:"value,omitempty"`
+	Reason string `json:"reason"`
+}
+
+func (e *RowError) Error() string {
+	if e.Column == "" {
+		return fmt.Sprintf("row %d: %s", e.Row, e.Reason)
+	}
+
+	return fmt.Sprintf("row %d, column %s: %q %s", e.Row, e.Column, e.Value, e.Reason)
+}
+
+// FieldChange is a stat of an existing line changed by an upsert
+type FieldChange struct {
+	Field string `json:"field"`
+	Old   any    `json:"old"`
+	New   any    `json:"new"`
+}
+
+// RowChange lists the stats an upsert changed on an existing line
+type RowChange struct {
+	ID     int            `json:"id"`
+	Name   string         `json:"name"`
+	Team   string         `json:"team"`
+	Season int            `json:"season"`
+	Fields []*FieldChange `json:"fields"`
+}
+
+// ImportReport summarizes the import of a csv
+//
+// rows are counted once each: Failed rows had invalid values, Skipped rows were already
+// in the database, and Unchanged rows matched an existing line when upserting.
+// Deleted counts the existing lines removed by a replace. Errors holds every invalid value,
+// so a row may have several, and Changes every line an upsert updated
+type ImportReport struct {
+	Source    string       `json:"source,omitempty"`
+	DryRun    bool         `json:"dryRun"`
+	RowsRead  int          `json:"rowsRead"`
+	Inserted  int          `json:"inserted"`
+	Updated   int          `json:"updated"`
+	Unchanged int          `json:"unchanged"`
+	Skipped   int          `json:"skipped"`
+	Failed    int          `json:"failed"`
+	Deleted   int          `json:"deleted"`
+	Errors    []*RowError  `json:"errors"`
+	Changes   []*RowChange `json:"changes"`
+}
+
+func (r *ImportReport) String() string {
+	return fmt.Sprintf("%s: %d rows read, %d inserted, %d updated, %d unchanged, %d skipped, %d failed, %d deleted",
+		r.Source, r.RowsRead, r.Inserted, r.Updated, r.Unchanged, r.Skipped, r.Failed, r.Deleted)
+}
+
+// discardWrites clears the outcome of every row written, for an import that was rolled back
+//
+// the invalid rows are still reported
+func (r *ImportReport) discardWrites() {
+	r.Inserted, r.Updated, r.Unchanged, r.Skipped, r.Deleted = 0, 0, 0, 0, 0
+	r.Changes = []*RowChange{}
+}
+
+// newImportReport returns an empty report for the import of a csv
+func newImportReport(source string, opts *ImportOptions) *ImportReport {
+	return &ImportReport{Source: source, DryRun: opts.DryRun, Errors: []*RowError{}, Changes: []*RowChange{}}
+}
+
+// number of rows written to the database at a time
+const importBatchSize = 1000
+
+// lineWriter writes the rows of an import to a table
+type lineWriter[T any] interface {
+	// deleteSeason deletes every line of a season, for replace
+	deleteSeason(season int) (int, error)
+	// writeLines writes a batch of rows, inserting or updating them as the write mode allows,
+	// and counts the outcome of each row in the report
+	writeLines(lines []*T, mode WriteMode, report *ImportReport) error
+}
+
+// lineKey identifies a season line, matching the unique key of the tables
+type lineKey struct {
+	name   string
+	team   string
+	season int
+}
+
+// lineKeyOf returns the key of a season line
+func lineKeyOf(fields *FieldSet, line any) lineKey {
+	name, _ := fields.Lookup("name")
+	team, _ := fields.Lookup("team")
+	season, _ := fields.Lookup("season")
+
+	return lineKey{
+		name:   name.ValueOf(line).(string),
+		team:   team.ValueOf(line).(string),
+		season: season.ValueOf(line).(int),
+	}
+}
+
+// lineBatch queues the rows of an import and writes them in batches
+type lineBatch[T any] struct {
+	reader *csvReader[T]
+	writer lineWriter[T]
+	opts   *ImportOptions
+	report *ImportReport
+	lines  []*T
+	keys   map[lineKey]bool
+	// seasons whose existing lines have been deleted, for replace
+	replaced map[int]bool
+}
+
+// add queues a row, writing the batch once it is full
+//
+// a batch never holds two rows for the same line, so a repeated line is written after the first
+func (b *lineBatch[T]) add(line *T) error {
+	key := lineKeyOf(b.reader.fields, line)
+
+	if b.opts.Write == WriteReplace && !b.replaced[key.season] {
+		deleted, err := b.writer.deleteSeason(key.season)
+		if err != nil {
+			return err
+		}
+		b.report.Deleted += deleted
+		b.replaced[key.season] = true
+	}
+
+	if b.keys[key] {
+		if err := b.flush(); err != nil {
+			return err
+		}
+	}
+
+	b.lines = append(b.lines, line)
+	b.keys[key] = true
+	if len(b.lines) >= importBatchSize {
+		return b.flush()
+	}
+
+	return nil
+}
+
+// flush writes every queued row
+func (b *lineBatch[T]) flush() error {
+	if len(b.lines) == 0 {
+		return nil
+	}
+
+	err := b.writer.writeLines(b.lines, b.opts.Write, b.report)
+	b.lines = b.lines[:0]
+	b.keys = map[lineKey]bool{}
+
+	return err
+}
+
+// importCSV reads the rows of a csv and writes them, recording the outcome of each row in the report
+//
+// rows are written in batches as they are read in both modes, so a csv is never held in memory.
+// in strict mode writing stops at the first invalid row, while the rest of the csv is still read to
+// report every invalid row, and ErrInvalidRows is returned so the rows already written are rolled
+// back with the transaction the import runs in
+func importCSV[T any](reader *csvReader[T], opts *ImportOptions, report *ImportReport, writer lineWriter[T]) error {
+	batch := &lineBatch[T]{
+		reader:   reader,
+		writer:   writer,
+		opts:     opts,
+		report:   report,
+		keys:     map[lineKey]bool{},
+		replaced: map[int]bool{},
+	}
+
+	for {
+		row, rowErrs, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return &Error{Kind: ErrInvalidCSV, Err: fmt.Errorf("unable to read csv: %w", err)}
+		}
+
+		report.RowsRead++
+		if len(rowErrs) > 0 {
+			report.Failed++
+			report.Errors = append(report.Errors, rowErrs...)
+			continue
+		}
+		if opts.DryRun || (opts.Mode == ImportStrict && report.Failed > 0) {
+			continue
+		}
+
+		if err := batch.add(row); err != nil {
+			return err
+		}
+	}
+
+	if report.Failed > 0 && opts.Mode == ImportStrict {
+		report.discardWrites()
+		return ErrInvalidRows
+	}
+
+	return batch.flush()
+}
+
+// fields that identify a season line rather than hold its stats
+var lineKeyFields = map[string]bool{"id": true, "name": true, "team": true, "season": true, "playerId": true}
+
+// diffStats returns every stat of a line that differs between its existing and new values
+func diffStats(fields *FieldSet, existing, line any) []*FieldChange {
+	changes := []*FieldChange{}
+	for _, field := range fields.Fields() {
+		if lineKeyFields[field.Name] {
+			continue
+		}
+
+		old, updated := field.ValueOf(existing), field.ValueOf(line)
+		if old != updated {
+			changes = append(changes, &FieldChange{Field: field.Name, Old: old, New: updated})
+		}
+	}
+
+	return changes
+}
+
+// importFiles imports every csv file matching a glob pattern, returning a report for each file
+//
+// the season of a file is taken from its name when it has no Season column
+func importFiles(pattern string, opts *ImportOptions, importFile func(io.Reader, *ImportOptions, *ImportReport) error) ([]*ImportReport, error) {
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	reports := []*ImportReport{}
+	for _, path := range paths {
+		report := newImportReport(path, opts)
+		reports = append(reports, report)
+
+		fileOpts := *opts
+		fileOpts.Season = SeasonFromFilename(path)
+
+		file, err := os.Open(path)
+		if err != nil {
+			return reports, err
+		}
+
+		err = importFile(file, &fileOpts, report)
+		file.Close()
+		if err != nil {
+			return reports, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	return reports, nil
+}
+
+// positionPlayerMapping returns the mapping of an import of position players,
+// the mapping named by BATTERS_CSV_MAPPING when the options don't set one
+func positionPlayerMapping(opts *ImportOptions) (*CSVMapping, error) {
+	if opts.Mapping != nil {
+		return opts.Mapping, nil
+	}
+
+	return mappingFromEnv("BATTERS_CSV_MAPPING", PositionPlayerFields, DefaultPositionPlayerMapping)
+}
+
+// pitcherMapping returns the mapping of an import of pitchers,
+// the mapping named by PITCHERS_CSV_MAPPING when the options don't set one
+func pitcherMapping(opts *ImportOptions) (*CSVMapping, error) {
+	if opts.Mapping != nil {
+		return opts.Mapping, nil
+	}
+
+	return mappingFromEnv("PITCHERS_CSV_MAPPING", PitcherFields, DefaultPitcherMapping)
+}
+
+// importPositionPlayers imports a csv of position players into the report within a transaction,
+// recording its changes as made by the given author
+//
+// the league and team averages are recomputed from the imported lines, unless it is a dry run
+func importPositionPlayers(tx pgx.Tx, by author, r io.Reader, opts *ImportOptions, report *ImportReport) error {
+	mapping, err := positionPlayerMapping(opts)
+	if err != nil {
+		return err
+	}
+
+	reader, err := newPositionPlayerReader(r, mapping, opts.Season)
+	if err != nil {
+		return err
+	}
+
+	writer, err := newBulkWriter(tx, positionPlayerTable, by)
+	if err != nil {
+		return err
+	}
+
+	if err := importCSV(reader, opts, report, writer); err != nil || opts.DryRun {
+		return err
+	}
+
+	return recomputeAverages(tx, positionPlayerTable)
+}
+
+// importPitchers imports a csv of pitchers into the report within a transaction,
+// recording its changes as made by the given author
+//
+// the league and team averages are recomputed from the imported lines, unless it is a dry run
+func importPitchers(tx pgx.Tx, by author, r io.Reader, opts *ImportOptions, report *ImportReport) error {
+	mapping, err := pitcherMapping(opts)
+	if err != nil {
+		return err
+	}
+
+	reader, err := newPitcherReader(r, mapping, opts.Season)
+	if err != nil {
+		return err
+	}
+
+	writer, err := newBulkWriter(tx, pitcherTable, by)
+	if err != nil {
+		return err
+	}
+
+	if err := importCSV(reader, opts, report, writer); err != nil || opts.DryRun {
+		return err
+	}
+
+	return recomputeAverages(tx, pitcherTable)
+}
+
+// ImportPositionPlayers imports a csv of position players, e.g. an uploaded file
+//
+// the csv is imported in a single transaction, so nothing is written when the import fails.
+// the report is returned even when the import fails part way
+func (pool *DBPool) ImportPositionPlayers(r io.Reader, opts *ImportOptions) (*ImportReport, error) {
+	report := newImportReport("", opts)
+	err := pgx.BeginFunc(context.Background(), pool.Poolconn, func(tx pgx.Tx) error {
+		return importPositionPlayers(tx, newAuthor(pool.actor, SourceImport), r, opts, report)
+	})
+
+	return report, pgError(err)
+}
+
+// ImportPitchers imports a csv of pitchers, e.g. an uploaded file
+//
+// the csv is imported in a single transaction, so nothing is written when the import fails.
+// the report is returned even when the import fails part way
+func (pool *DBPool) ImportPitchers(r io.Reader, opts *ImportOptions) (*ImportReport, error) {
+	report := newImportReport("", opts)
+	err := pgx.BeginFunc(context.Background(), pool.Poolconn, func(tx pgx.Tx) error {
+		return importPitchers(tx, newAuthor(pool.actor, SourceImport), r, opts, report)
+	})
+
+	return report, pgError(err)
+}
+
+// ImportPositionPlayerDataFromCSV imports every assets/batters*.csv file
+//
+// columns are mapped by header using the mapping file named by BATTERS_CSV_MAPPING,
+// or DefaultPositionPlayerMapping when unset. every file is imported in a single transaction,
+// so nothing is written when any file fails. a report is returned for each file,
+// including the file that failed when an error is returned
+func (pool *DBPool) ImportPositionPlayerDataFromCSV(opts *ImportOptions) ([]*ImportReport, error) {
+	var reports []*ImportReport
+	err := pgx.BeginFunc(context.Background(), pool.Poolconn, func(tx pgx.Tx) error {
+		var err error
+		reports, err = importFiles("./assets/batters*.csv", opts, func(r io.Reader, opts *ImportOptions, report *ImportReport) error {
+			return importPositionPlayers(tx, newAuthor(pool.actor, SourceImport), r, opts, report)
+		})
+		return err
+	})
+
+	return reports, pgError(err)
+}
+
+// ImportPitcherDataFromCSV imports every assets/pitchers*.csv file
+//
+// columns are mapped by header using the mapping file named by PITCHERS_CSV_MAPPING,
+// or DefaultPitcherMapping when unset. every file is imported in a single transaction,
+// so nothing is written when any file fails. a report is returned for each file,
+// including the file that failed when an error is returned
+func (pool *DBPool) ImportPitcherDataFromCSV(opts *ImportOptions) ([]*ImportReport, error) {
+	var reports []*ImportReport
+	err := pgx.BeginFunc(context.Background(), pool.Poolconn, func(tx pgx.Tx) error {
+		var err error
+		reports, err = importFiles("./assets/pitchers*.csv", opts, func(r io.Reader, opts *ImportOptions, report *ImportReport) error {
+			return importPitchers(tx, newAuthor(pool.actor, SourceImport), r, opts, report)
+		})
+		return err
+	})
+
+	return reports, pgError(err)
+}
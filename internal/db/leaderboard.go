@@ -0,0 +1,69 @@
+package db
+
+import (
+	"math"
+	"sort"
+
+	"github.com/e-berman/baseball_api/internal/models"
+)
+
+// Qualification is the playing time a line needs for each game its team played to qualify for a leaderboard
+type Qualification struct {
+	// stat measuring the playing time, e.g. plateAppearances
+	Stat    string
+	PerGame float64
+}
+
+// Minimum returns the playing time a line of a team that played the given games needs to qualify
+//
+// it is rounded down, so a hitter of a team that played 162 games qualifies with 502 plate appearances
+func (q Qualification) Minimum(teamGames int) float64 {
+	return math.Floor(q.PerGame * float64(teamGames))
+}
+
+var (
+	// a hitter qualifies with 3.1 plate appearances per team game
+	PositionPlayerQualification = Qualification{Stat: "plateAppearances", PerGame: 3.1}
+	// a pitcher qualifies with an inning pitched per team game
+	PitcherQualification = Qualification{Stat: "inningsPitched", PerGame: 1}
+)
+
+// Leaders ranks lines by a stat, best first, keeping the best limit of them along with any tied with the last
+// one kept, or every line when limit is 0
+//
+// lines with the same value share a rank and are ordered by id
+func Leaders[T any](fields *FieldSet, stat Field, lines []*T, limit int) []*models.Leader {
+	id, _ := fields.Lookup("id")
+	name, _ := fields.Lookup("name")
+	team, _ := fields.Lookup("team")
+
+	leaders := []*models.Leader{}
+	for _, line := range lines {
+		leaders = append(leaders, &models.Leader{
+			ID:    id.ValueOf(line).(int),
+			Name:  name.ValueOf(line).(string),
+			Team:  team.ValueOf(line).(string),
+			Value: floatValue(stat.ValueOf(line)),
+		})
+	}
+
+	sort.Slice(leaders, func(i, j int) bool {
+		if leaders[i].Value != leaders[j].Value {
+			return (leaders[i].Value < leaders[j].Value) == stat.LowerIsBetter
+		}
+		return leaders[i].ID < leaders[j].ID
+	})
+
+	for i, leader := range leaders {
+		leader.Rank = i + 1
+		if i > 0 && leader.Value == leaders[i-1].Value {
+			leader.Rank = leaders[i-1].Rank
+			leader.Tied, leaders[i-1].Tied = true, true
+		}
+		if limit > 0 && i >= limit && leader.Rank != leaders[limit-1].Rank {
+			return leaders[:i]
+		}
+	}
+
+	return leaders
+}
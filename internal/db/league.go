@@ -0,0 +1,352 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/e-berman/baseball_api/internal/models"
+)
+
+// aggregate is how a column of an averages table is computed from the season lines of a league or team
+type aggregate int
+
+const (
+	// the average of the column, weighted by playing time
+	aggWeighted aggregate = iota
+	// the number of lines
+	aggCount
+	// the sum of the column
+	aggSum
+	// the largest value of the column
+	aggMax
+)
+
+// averagesTable describes the table holding the league and team averages of a table of season lines
+//
+// each season has a row for the league, whose team is empty, and a row for each team.
+// the averages are computed from the live lines and recomputed on every import
+type averagesTable struct {
+	name string
+	// fields of the averages model, by json name and column
+	fields *FieldSet
+	// returns an empty averages model
+	model func() any
+	// column weighting the rate stats of a line by its playing time
+	weight string
+	// how each column is computed, aggWeighted when missing
+	aggregates map[string]aggregate
+}
+
+var battingAverages = &averagesTable{
+	name: "batting_averages",
+	fields: newFieldSet(models.BattingAverages{}, map[string]string{
+		"lines":                   "lines",
+		"games":                   "g",
+		"plateAppearances":        "pa",
+		"weightedRunsCreatedPlus": "wrc_plus",
+		"walkRate":                "bb_rate",
+		"strikeoutRate":           "k_rate",
+		"isolatedPower":           "iso",
+		"battingAvgBallsInPlay":   "babip",
+		"battingAvg":              "average",
+		"onBasePct":               "obp",
+		"sluggingPct":             "slg",
+		"weightedOnBaseAvg":       "woba",
+		"expWeightedOnBaseAvg":    "x_woba",
+	}),
+	model:      func() any { return &models.BattingAverages{} },
+	weight:     "pa",
+	aggregates: map[string]aggregate{"lines": aggCount, "g": aggMax, "pa": aggSum},
+}
+
+var pitchingAverages = &averagesTable{
+	name: "pitching_averages",
+	fields: newFieldSet(models.PitchingAverages{}, map[string]string{
+		"lines":                              "lines",
+		"inningsPitched":                     "ip",
+		"strikeoutsPerNine":                  "k9",
+		"walksPerNine":                       "bb9",
+		"homeRunsPerNine":                    "hr9",
+		"battingAvgBallsInPlay":              "babip",
+		"leftOnBase":                         "lob",
+		"groundballRate":                     "gb",
+		"homeRunToFlyBallRatio":              "hrfb",
+		"fourseamFastballVelocity":           "vfa",
+		"earnedRunAvg":                       "era",
+		"expectedEarnedRunAvg":               "xera",
+		"fielderIndependentPitching":         "fip",
+		"expectedFielderIndependentPitching": "xfip",
+	}),
+	model:      func() any { return &models.PitchingAverages{} },
+	weight:     "ip",
+	aggregates: map[string]aggregate{"lines": aggCount, "ip": aggSum},
+}
+
+// columns returns the columns of the averages, in model order
+func (a *averagesTable) columns() []string {
+	columns := []string{}
+	for _, field := range a.fields.Fields() {
+		columns = append(columns, field.Column)
+	}
+
+	return columns
+}
+
+// aggregateSQL returns the sql computing a column of the averages over a group of lines
+func (a *averagesTable) aggregateSQL(column string) string {
+	switch a.aggregates[column] {
+	case aggCount:
+		return `count(*)`
+	case aggSum:
+		return fmt.Sprintf("COALESCE(SUM(%s), 0)", column)
+	case aggMax:
+		return fmt.Sprintf("COALESCE(MAX(%s), 0)", column)
+	}
+
+	// cast so integer stats like wrc_plus aren't averaged by integer division
+	return fmt.Sprintf("COALESCE(SUM(CAST(%s AS float8) * %s) / NULLIF(SUM(%s), 0), 0)", column, a.weight, a.weight)
+}
+
+// newAverages returns an averages model holding the given values, by column
+func (a *averagesTable) newAverages(values map[string]float64) any {
+	averages := a.model()
+	for _, field := range a.fields.Fields() {
+		if field.Kind == KindInt {
+			field.setValue(averages, int(values[field.Column]))
+		} else {
+			field.setValue(averages, values[field.Column])
+		}
+	}
+
+	return averages
+}
+
+// averagesKey identifies the averages of a league, whose team is empty, or of a team in a season
+type averagesKey struct {
+	season int
+	team   string
+}
+
+// averagesRow is a row of an averages table, holding a *models.BattingAverages or *models.PitchingAverages
+type averagesRow struct {
+	averagesKey
+	averages any
+}
+
+// recomputeAverages replaces the league and team averages of every season with those of the live lines of a table
+//
+// lines without a team only count toward the league
+func recomputeAverages[T any](q querier, table *lineTable[T]) error {
+	ctx := context.Background()
+	a := table.averages
+
+	if _, err := q.Exec(ctx, `DELETE FROM `+a.name); err != nil {
+		return err
+	}
+
+	aggregates := []string{}
+	for _, column := range a.columns() {
+		aggregates = append(aggregates, a.aggregateSQL(column))
+	}
+	selected := strings.Join(aggregates, ", ")
+
+	query := `INSERT INTO ` + a.name + ` (season, team, ` + strings.Join(a.columns(), ", ") + `)
+	SELECT season, team, ` + selected + ` FROM ` + table.name + `
+	WHERE ` + liveCondition + ` AND team <> '' GROUP BY season, team
+	UNION ALL
+	SELECT season, '', ` + selected + ` FROM ` + table.name + `
+	WHERE ` + liveCondition + ` GROUP BY season`
+
+	_, err := q.Exec(ctx, query)
+	return err
+}
+
+// averagesQuery returns the query selecting the averages of a league, or of a team when set, in a season,
+// or in every season when 0, ordered by season
+func averagesQuery(a *averagesTable, team string, season int) (string, []any) {
+	query := `SELECT season, team, ` + strings.Join(a.columns(), ", ") + ` FROM ` + a.name + ` WHERE team = $1`
+	args := []any{team}
+	if season != 0 {
+		query += ` AND season = $2`
+		args = append(args, season)
+	}
+
+	return query + ` ORDER BY season`, args
+}
+
+// scanAverages scans the rows of averagesQuery
+func scanAverages(a *averagesTable, rows interface {
+	Next() bool
+	Scan(...any) error
+	Err() error
+}) ([]*averagesRow, error) {
+	scanned := []*averagesRow{}
+	for rows.Next() {
+		row := &averagesRow{averages: a.model()}
+		model := reflect.ValueOf(row.averages).Elem()
+
+		dest := []any{&row.season, &row.team}
+		for _, field := range a.fields.Fields() {
+			dest = append(dest, model.Field(field.index).Addr().Interface())
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+
+		scanned = append(scanned, row)
+	}
+
+	return scanned, rows.Err()
+}
+
+// getAverages returns the averages of a league, or of a team when set, in a season, or in every season when 0,
+// reading the rows of averagesQuery from each averages table with read
+func getAverages(team string, season int, read func(*averagesTable, string, []any) ([]*averagesRow, error)) ([]*models.LeagueAverages, error) {
+	rows := [][]*averagesRow{}
+	for _, a := range []*averagesTable{battingAverages, pitchingAverages} {
+		query, args := averagesQuery(a, team, season)
+		scanned, err := read(a, query, args)
+		if err != nil {
+			return nil, err
+		}
+
+		rows = append(rows, scanned)
+	}
+
+	return leagueAverages(rows[0], rows[1])
+}
+
+// leagueAverages combines the batting and pitching averages of a league or team into one LeagueAverages
+// for each season, ordered by season
+//
+// returns an ErrNotFound Error when there are none
+func leagueAverages(batting, pitching []*averagesRow) ([]*models.LeagueAverages, error) {
+	seasons := map[averagesKey]*models.LeagueAverages{}
+	averagesOf := func(key averagesKey) *models.LeagueAverages {
+		if seasons[key] == nil {
+			seasons[key] = &models.LeagueAverages{Season: key.season, Team: key.team}
+		}
+		return seasons[key]
+	}
+
+	for _, row := range batting {
+		averagesOf(row.averagesKey).Batting = row.averages.(*models.BattingAverages)
+	}
+	for _, row := range pitching {
+		averagesOf(row.averagesKey).Pitching = row.averages.(*models.PitchingAverages)
+	}
+
+	if len(seasons) == 0 {
+		return nil, newError(ErrNotFound, "", "no averages recorded")
+	}
+
+	averages := []*models.LeagueAverages{}
+	for _, season := range seasons {
+		averages = append(averages, season)
+	}
+	sort.Slice(averages, func(i, j int) bool { return averages[i].Season < averages[j].Season })
+
+	return averages, nil
+}
+
+// *******************
+// Postgres
+// *******************
+
+// pgAverages returns the averages of a league, or of a team when set, in a season, or in every season when 0
+func pgAverages(pool *DBPool, team string, season int) ([]*models.LeagueAverages, error) {
+	averages, err := getAverages(team, season, func(a *averagesTable, query string, args []any) ([]*averagesRow, error) {
+		rows, err := pool.Poolconn.Query(context.Background(), query, args...)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		return scanAverages(a, rows)
+	})
+
+	return averages, pgError(err)
+}
+
+// GetLeagueAverages returns the league averages of a season, or of every season when 0, ordered by season
+//
+// the averages are those of the last import. returns an ErrNotFound Error when there are none
+func (pool *DBPool) GetLeagueAverages(season int) ([]*models.LeagueAverages, error) {
+	return pgAverages(pool, "", season)
+}
+
+// GetTeamAverages returns the averages of a team in a season, or in every season when 0, ordered by season
+//
+// the averages are those of the last import. returns an ErrNotFound Error when there are none
+func (pool *DBPool) GetTeamAverages(team string, season int) ([]*models.LeagueAverages, error) {
+	return pgAverages(pool, team, season)
+}
+
+// *******************
+// SQLite
+// *******************
+
+// sqliteAverages returns the averages of a league, or of a team when set, in a season, or in every season when 0
+func sqliteAverages(s *SQLiteDB, team string, season int) ([]*models.LeagueAverages, error) {
+	averages, err := getAverages(team, season, func(a *averagesTable, query string, args []any) ([]*averagesRow, error) {
+		rows, err := s.conn.Query(query, args...)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		return scanAverages(a, rows)
+	})
+
+	return averages, sqliteError(err)
+}
+
+// GetLeagueAverages returns the league averages of a season, or of every season when 0, as DBPool does
+func (s *SQLiteDB) GetLeagueAverages(season int) ([]*models.LeagueAverages, error) {
+	return sqliteAverages(s, "", season)
+}
+
+// GetTeamAverages returns the averages of a team in a season, or in every season when 0, as DBPool does
+func (s *SQLiteDB) GetTeamAverages(team string, season int) ([]*models.LeagueAverages, error) {
+	return sqliteAverages(s, team, season)
+}
+
+// *******************
+// Memory
+// *******************
+
+// memAverages returns the averages of a league, or of a team when set, in a season, or in every season when 0
+func memAverages(m *MemoryDB, team string, season int) ([]*models.LeagueAverages, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return leagueAverages(
+		memAveragesRows(m.state.positionPlayers.leagues, battingAverages, team, season),
+		memAveragesRows(m.state.pitchers.leagues, pitchingAverages, team, season),
+	)
+}
+
+// memAveragesRows returns the rows of the averages stored by a table for a league or team
+func memAveragesRows(leagues map[averagesKey]map[string]float64, a *averagesTable, team string, season int) []*averagesRow {
+	rows := []*averagesRow{}
+	for key, values := range leagues {
+		if key.team == team && (season == 0 || key.season == season) {
+			rows = append(rows, &averagesRow{averagesKey: key, averages: a.newAverages(values)})
+		}
+	}
+
+	return rows
+}
+
+// GetLeagueAverages returns the league averages of a season, or of every season when 0, as DBPool does
+func (m *MemoryDB) GetLeagueAverages(season int) ([]*models.LeagueAverages, error) {
+	return memAverages(m, "", season)
+}
+
+// GetTeamAverages returns the averages of a team in a season, or in every season when 0, as DBPool does
+func (m *MemoryDB) GetTeamAverages(team string, season int) ([]*models.LeagueAverages, error) {
+	return memAverages(m, team, season)
+}
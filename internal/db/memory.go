@@ -0,0 +1,1506 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/e-berman/baseball_api/internal/models"
+)
+
+// MemoryDB is an in-memory implementation of DB, safe for concurrent use
+//
+// it enforces the same constraints as the Postgres schema: lines are unique by name, team and season,
+// seasons are positive, stats other than bsr and war are non-negative, every line belongs to an
+// existing player and external player ids are unique. violations are reported as an Error
+// naming the constraint as Postgres would, and missing rows as ErrNotFound like DBPool.
+// strings are ordered bytewise rather than by a collation
+type MemoryDB struct {
+	*memTables
+	// who the writes are recorded as made by
+	actor string
+}
+
+// memTables holds the tables of a MemoryDB, shared by the DBs returned by WithActor
+type memTables struct {
+	mu    sync.RWMutex
+	state *memState
+}
+
+// the tables of a MemoryDB
+type memState struct {
+	players         *memPlayers
+	positionPlayers *memTable[models.PositionPlayer]
+	pitchers        *memTable[models.Pitcher]
+	history         *memHistory
+	// the writes of the running transaction, shared by the tables
+	undo *memUndo
+}
+
+// NewMemoryDB returns an empty MemoryDB
+func NewMemoryDB() *MemoryDB {
+	undo := &memUndo{}
+	return &MemoryDB{memTables: &memTables{state: &memState{
+		players:         &memPlayers{rows: map[int]*models.Player{}, undo: undo},
+		positionPlayers: newMemTable(positionPlayerTable, undo),
+		pitchers:        newMemTable(pitcherTable, undo),
+		history:         &memHistory{undo: undo},
+		undo:            undo,
+	}}}
+}
+
+// memUndo is the undo log of a transaction, the steps reversing each of its writes in the order they were made
+type memUndo struct {
+	steps []func()
+}
+
+// add records the step reversing a write
+func (u *memUndo) add(step func()) {
+	u.steps = append(u.steps, step)
+}
+
+// savepoint returns the point of the log rollback can return the tables to
+func (u *memUndo) savepoint() int {
+	return len(u.steps)
+}
+
+// rollback reverses the writes made since a savepoint, latest first
+func (u *memUndo) rollback(savepoint int) {
+	for i := len(u.steps) - 1; i >= savepoint; i-- {
+		u.steps[i]()
+	}
+	u.steps = u.steps[:savepoint]
+}
+
+// commit keeps the writes of the transaction, forgetting how to reverse them
+func (u *memUndo) commit() {
+	u.steps = nil
+}
+
+// WithActor returns a DB sharing the tables whose writes are recorded as made by actor
+func (m *MemoryDB) WithActor(actor string) DB {
+	return &MemoryDB{memTables: m.memTables, actor: actor}
+}
+
+// author returns who the db's api writes are made by
+func (m *MemoryDB) author() author {
+	return newAuthor(m.actor, SourceAPI)
+}
+
+// transaction runs f on the tables, keeping its changes only when it succeeds
+//
+// the writes of f are rolled back through the undo log when it fails or panics, so only the lines
+// it wrote are copied rather than every table
+func (m *MemoryDB) transaction(f func(*memState) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// rolls back nothing once the writes are committed
+	defer m.state.undo.rollback(0)
+	if err := f(m.state); err != nil {
+		return err
+	}
+
+	m.state.undo.commit()
+	return nil
+}
+
+// Initialize has no schema to migrate, it exists to match DBPool
+func (m *MemoryDB) Initialize() error {
+	return nil
+}
+
+// Close has no connection to close, it exists to match DBPool
+func (m *MemoryDB) Close() {}
+
+// PurgeDeleted permanently deletes every line moved to the trash before the given time, as DBPool does
+func (m *MemoryDB) PurgeDeleted(before time.Time) (*PurgeReport, error) {
+	report := &PurgeReport{}
+	err := m.transaction(func(state *memState) error {
+		var err error
+		if report.PositionPlayers, err = memPurge(state, state.positionPlayers, before); err != nil {
+			return err
+		}
+		report.Pitchers, err = memPurge(state, state.pitchers, before)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// *******************
+// Player methods
+// *******************
+
+// memPlayers is the in-memory players table
+type memPlayers struct {
+	rows   map[int]*models.Player
+	nextID int
+	undo   *memUndo
+}
+
+// save records how to restore the player with the given id, or its absence, before it is written
+func (p *memPlayers) save(id int) {
+	nextID := p.nextID
+	player, existed := p.rows[id]
+	var saved models.Player
+	if existed {
+		saved = *player
+	}
+
+	p.undo.add(func() {
+		p.nextID = nextID
+		if !existed {
+			delete(p.rows, id)
+			return
+		}
+		p.rows[id] = &saved
+	})
+}
+
+// withExternalID returns the player with an external id, or nil
+func (p *memPlayers) withExternalID(external externalID, value string) *models.Player {
+	for _, player := range p.rows {
+		if *external.id(player) == value {
+			return player
+		}
+	}
+
+	return nil
+}
+
+// setExternalID sets an external id of a player, keeping external ids unique
+func (p *memPlayers) setExternalID(player *models.Player, external externalID, value string) error {
+	if value == "" {
+		return nil
+	}
+	if other := p.withExternalID(external, value); other != nil && other.ID != player.ID {
+		constraint := "players_" + external.column + "_key"
+		return newError(ErrConflict, constraint, fmt.Sprintf("duplicate key value violates unique constraint %q", constraint))
+	}
+
+	*external.id(player) = value
+	return nil
+}
+
+// ref returns the id of the player a season line belongs to, matching playerRef
+func (p *memPlayers) ref(playerID int, identity *models.Player, name string) (int, error) {
+	if playerID != 0 {
+		return playerID, nil
+	}
+	if identity == nil {
+		identity = &models.Player{Name: name}
+	}
+
+	return p.resolve(identity)
+}
+
+// resolve returns the id of the player with the given identity, creating the player if needed
+//
+// players are matched as resolvePlayer matches them
+func (p *memPlayers) resolve(identity *models.Player) (int, error) {
+	var match *models.Player
+	for _, external := range externalIDs {
+		value := *external.id(identity)
+		if value == "" {
+			continue
+		}
+		if match = p.withExternalID(external, value); match != nil {
+			break
+		}
+	}
+
+	if match == nil {
+		named := []*models.Player{}
+		for _, player := range p.rows {
+			if player.Name == identity.Name && (!hasExternalIDs(identity) || !hasExternalIDs(player)) {
+				named = append(named, player)
+			}
+		}
+		if len(named) == 1 {
+			match = named[0]
+		}
+	}
+
+	if match == nil {
+		p.save(p.nextID + 1)
+		p.nextID++
+		match = &models.Player{ID: p.nextID, Name: identity.Name}
+	} else {
+		p.save(match.ID)
+	}
+
+	if match.NameASCII == "" {
+		match.NameASCII = identity.NameASCII
+	}
+	for _, external := range externalIDs {
+		if *external.id(match) != "" {
+			continue
+		}
+		if err := p.setExternalID(match, external, *external.id(identity)); err != nil {
+			return 0, err
+		}
+	}
+
+	p.rows[match.ID] = match
+	return match.ID, nil
+}
+
+func (m *MemoryDB) GetPlayerByID(id int) (*models.Player, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	player, ok := m.state.players.rows[id]
+	if !ok {
+		return nil, notFoundError("players", id)
+	}
+
+	copied := *player
+	return &copied, nil
+}
+
+func (m *MemoryDB) GetPlayerByExternalID(source, id string) (*models.Player, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, external := range externalIDs {
+		if external.source != source {
+			continue
+		}
+
+		player := m.state.players.withExternalID(external, id)
+		if player == nil || id == "" {
+			return nil, externalNotFoundError(source, id)
+		}
+
+		copied := *player
+		return &copied, nil
+	}
+
+	return nil, newError(ErrNotFound, "", "unknown player id source: "+source)
+}
+
+// *******************
+// Season line tables
+// *******************
+
+// columns of a season line allowed to be negative
+var memSignedColumns = map[string]bool{"bsr": true, "war": true}
+
+// memTable is an in-memory table of season lines
+type memTable[T any] struct {
+	*lineTable[T]
+	rows map[int]*T
+	// ids of the lines, by their unique key
+	keys   map[lineKey]int
+	nextID int
+	// league and team averages stored by the last import, by season and team and by column
+	leagues map[averagesKey]map[string]float64
+	undo    *memUndo
+}
+
+// newMemTable returns an empty in-memory form of a table, recording its writes in undo
+func newMemTable[T any](table *lineTable[T], undo *memUndo) *memTable[T] {
+	return &memTable[T]{
+		lineTable: table,
+		rows:      map[int]*T{},
+		keys:      map[lineKey]int{},
+		undo:      undo,
+	}
+}
+
+// save records how to restore the line with the given id, or its absence, before it is written
+func (t *memTable[T]) save(id int) {
+	nextID := t.nextID
+	line, existed := t.rows[id]
+	var saved T
+	if existed {
+		saved = *line
+	}
+
+	t.undo.add(func() {
+		t.nextID = nextID
+		if current, ok := t.rows[id]; ok {
+			delete(t.keys, lineKeyOf(t.fields, current))
+			delete(t.rows, id)
+		}
+		if existed {
+			t.rows[id] = &saved
+			t.keys[lineKeyOf(t.fields, &saved)] = id
+		}
+	})
+}
+
+// field returns a field of the table's lines by name
+func (t *memTable[T]) field(name string) Field {
+	field, _ := t.fields.Lookup(name)
+	return field
+}
+
+// idOf returns the id of a line
+func (t *memTable[T]) idOf(line *T) int {
+	return t.field("id").ValueOf(line).(int)
+}
+
+// checkVersion reports an ErrVersionMismatch Error unless a line is at version, or version is 0
+func (t *memTable[T]) checkVersion(line *T, version int) error {
+	if current := *t.version(line); version != 0 && current != version {
+		return versionMismatchError(t.idOf(line), current, version)
+	}
+
+	return nil
+}
+
+// check enforces the CHECK and foreign key constraints of the table on a line
+func (t *memTable[T]) check(line *T, players *memPlayers) error {
+	if err := t.checkStats(line); err != nil {
+		return err
+	}
+
+	return t.checkRef(line, players)
+}
+
+// checkStats enforces the CHECK constraints of the table on a line
+func (t *memTable[T]) checkStats(line *T) error {
+	for _, field := range t.fields.Fields() {
+		if field.Kind == KindString || memSignedColumns[field.Column] || field.Name == "id" || field.Name == "playerId" {
+			continue
+		}
+
+		var value float64
+		switch v := field.ValueOf(line).(type) {
+		case int:
+			value = float64(v)
+		case float64:
+			value = v
+		}
+
+		if value > 0 || (value == 0 && field.Name != "season") {
+			continue
+		}
+
+		constraint := t.name + "_" + field.Column + "_check"
+		return newError(ErrConstraint, constraint, fmt.Sprintf("new row for relation %q violates check constraint %q", t.name, constraint))
+	}
+
+	return nil
+}
+
+// checkRef enforces the foreign key constraint of the table on a line
+func (t *memTable[T]) checkRef(line *T, players *memPlayers) error {
+	if _, ok := players.rows[t.field("playerId").ValueOf(line).(int)]; !ok {
+		constraint := t.name + "_player_ref_fkey"
+		return newError(ErrConstraint, constraint, fmt.Sprintf("insert or update on table %q violates foreign key constraint %q", t.name, constraint))
+	}
+
+	return nil
+}
+
+// insert adds a line unless one already exists for the same name, team and season
+//
+// the line must already have been checked. returns whether it was inserted
+func (t *memTable[T]) insert(line *T) bool {
+	key := lineKeyOf(t.fields, line)
+	if _, ok := t.keys[key]; ok {
+		return false
+	}
+
+	t.save(t.nextID + 1)
+	t.nextID++
+	t.field("id").setValue(line, t.nextID)
+	*t.version(line) = 1
+	copied := *line
+
+	t.rows[t.nextID] = &copied
+	t.keys[key] = t.nextID
+	return true
+}
+
+// update sets the name, team, season and stats of the line with the same id, keeping its player
+//
+// the line is only updated while at its version, which is set to the line's new version
+func (t *memTable[T]) update(line *T, players *memPlayers) error {
+	id := t.idOf(line)
+	existing, ok := t.live(id)
+	if !ok {
+		return notFoundError(t.name, id)
+	}
+	if err := t.checkVersion(existing, *t.version(line)); err != nil {
+		return err
+	}
+
+	updated := *line
+	playerID := t.field("playerId")
+	playerID.setValue(&updated, playerID.ValueOf(existing))
+	*t.version(&updated) = *t.version(existing) + 1
+	if err := t.check(&updated, players); err != nil {
+		return err
+	}
+
+	oldKey, newKey := lineKeyOf(t.fields, existing), lineKeyOf(t.fields, &updated)
+	if owner, ok := t.keys[newKey]; ok && owner != id {
+		return lineConflictError(t.name, owner)
+	}
+
+	t.save(id)
+	delete(t.keys, oldKey)
+	t.keys[newKey] = id
+	t.rows[id] = &updated
+	*t.version(line) = *t.version(&updated)
+	return nil
+}
+
+// put writes a line at its id, replacing the line with that id if there is one
+//
+// the line keeps its player and version, as restoring a line sets them
+func (t *memTable[T]) put(line *T, players *memPlayers) error {
+	if err := t.check(line, players); err != nil {
+		return err
+	}
+
+	id, key := t.idOf(line), lineKeyOf(t.fields, line)
+	if owner, ok := t.keys[key]; ok && owner != id {
+		return lineConflictError(t.name, owner)
+	}
+
+	t.save(id)
+	if existing, ok := t.rows[id]; ok {
+		delete(t.keys, lineKeyOf(t.fields, existing))
+	}
+	copied := *line
+	t.rows[id] = &copied
+	t.keys[key] = id
+	return nil
+}
+
+// patch sets the named fields of the line with the given id to their values in line, returning the line
+// with its derived stats, as patchLine does
+func (t *memTable[T]) patch(id int, line *T, names []string, players *memPlayers) (*T, error) {
+	fields, err := patchFields(t.fields, names)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, ok := t.live(id)
+	if !ok {
+		return nil, notFoundError(t.name, id)
+	}
+	if len(fields) == 0 {
+		if err := t.checkVersion(existing, *t.version(line)); err != nil {
+			return nil, err
+		}
+		return t.read(id)
+	}
+
+	updated := *existing
+	for _, field := range fields {
+		field.setValue(&updated, field.ValueOf(line))
+	}
+	*t.version(&updated) = *t.version(line)
+	if err := t.update(&updated, players); err != nil {
+		return nil, err
+	}
+
+	return t.read(id)
+}
+
+// delete deletes the line with the given id, returning false when there is none
+func (t *memTable[T]) delete(id int) bool {
+	line, ok := t.rows[id]
+	if !ok {
+		return false
+	}
+
+	t.save(id)
+	delete(t.keys, lineKeyOf(t.fields, line))
+	delete(t.rows, id)
+	return true
+}
+
+// trash moves the line with the given id to the trash at a time while it is at version, as trashLine does
+func (t *memTable[T]) trash(id, version int, at time.Time) (*T, error) {
+	line, ok := t.live(id)
+	if !ok {
+		return nil, notFoundError(t.name, id)
+	}
+	if err := t.checkVersion(line, version); err != nil {
+		return nil, err
+	}
+
+	t.save(id)
+	*t.deletedAt(line) = &at
+	*t.version(line)++
+	return t.stored(id), nil
+}
+
+// untrash takes the line with the given id out of the trash, as undeleteLine does
+func (t *memTable[T]) untrash(id int) (*T, error) {
+	line, ok := t.rows[id]
+	if !ok || *t.deletedAt(line) == nil {
+		return nil, notInTrashError(id)
+	}
+
+	t.save(id)
+	*t.deletedAt(line) = nil
+	*t.version(line)++
+	return t.stored(id), nil
+}
+
+// purge permanently deletes every line trashed before a time, returning the lines deleted ordered by id
+func (t *memTable[T]) purge(before time.Time) []*T {
+	purged := []*T{}
+	for id, line := range t.rows {
+		if at := *t.deletedAt(line); at != nil && at.Before(before) {
+			t.delete(id)
+			purged = append(purged, line)
+		}
+	}
+	sort.Slice(purged, func(i, j int) bool { return t.idOf(purged[i]) < t.idOf(purged[j]) })
+
+	return purged
+}
+
+// deleteSeason permanently deletes every line of a season, including the lines in the trash,
+// returning the lines deleted ordered by id
+func (t *memTable[T]) deleteSeason(season int) []*T {
+	deleted := []*T{}
+	for id, line := range t.rows {
+		if t.field("season").ValueOf(line).(int) == season {
+			t.delete(id)
+			deleted = append(deleted, line)
+		}
+	}
+	sort.Slice(deleted, func(i, j int) bool { return t.idOf(deleted[i]) < t.idOf(deleted[j]) })
+
+	return deleted
+}
+
+// live returns the line with the given id, reporting false when there is none or it is in the trash
+func (t *memTable[T]) live(id int) (*T, bool) {
+	line, ok := t.rows[id]
+	if !ok || *t.deletedAt(line) != nil {
+		return nil, false
+	}
+
+	return line, true
+}
+
+// stored returns a copy of the line with the given id whether or not it is in the trash, or nil
+func (t *memTable[T]) stored(id int) *T {
+	line, ok := t.rows[id]
+	if !ok {
+		return nil
+	}
+
+	copied := *line
+	return &copied
+}
+
+// get returns a copy of the line with the given id, unless it is in the trash
+func (t *memTable[T]) get(id int) (*T, error) {
+	if _, ok := t.live(id); !ok {
+		return nil, notFoundError(t.name, id)
+	}
+
+	return t.stored(id), nil
+}
+
+// read returns a copy of the line with the given id with its derived stats, unless it is in the trash
+func (t *memTable[T]) read(id int) (*T, error) {
+	line, err := t.get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	t.derive(line, t.leagueAverages())
+	return line, nil
+}
+
+// matching returns a copy of every line matching the filters with its derived stats,
+// leaving out the lines in the trash unless includeDeleted is set
+func (t *memTable[T]) matching(filters []Filter, includeDeleted bool) []*T {
+	leagues := t.leagueAverages()
+
+	lines := []*T{}
+	for _, line := range t.rows {
+		if *t.deletedAt(line) != nil && !includeDeleted {
+			continue
+		}
+
+		copied := *line
+		t.derive(&copied, leagues)
+		if matchesFilters(filters, &copied) {
+			lines = append(lines, &copied)
+		}
+	}
+
+	return lines
+}
+
+// leagueAverages returns the league averages of every season stored by the last import,
+// by season and column, as the league join of lineTable.source reads them
+func (t *memTable[T]) leagueAverages() map[int]map[string]float64 {
+	leagues := map[int]map[string]float64{}
+	for key, averages := range t.leagues {
+		if key.team == "" {
+			leagues[key.season] = averages
+		}
+	}
+
+	return leagues
+}
+
+// recomputeAverages replaces the league and team averages of every season with those of the live lines,
+// as recomputeAverages does for a database
+func (t *memTable[T]) recomputeAverages() {
+	groups := map[averagesKey][]*T{}
+	season, team := t.field("season"), t.field("team")
+	for _, line := range t.rows {
+		if *t.deletedAt(line) != nil {
+			continue
+		}
+
+		s := season.ValueOf(line).(int)
+		groups[averagesKey{season: s}] = append(groups[averagesKey{season: s}], line)
+		if name := team.ValueOf(line).(string); name != "" {
+			key := averagesKey{season: s, team: name}
+			groups[key] = append(groups[key], line)
+		}
+	}
+
+	// the averages are replaced rather than changed, so restoring them is restoring the map
+	leagues := t.leagues
+	t.undo.add(func() { t.leagues = leagues })
+
+	t.leagues = map[averagesKey]map[string]float64{}
+	for key, lines := range groups {
+		t.leagues[key] = t.aggregate(lines)
+	}
+}
+
+// aggregate returns the averages of a group of lines by column
+func (t *memTable[T]) aggregate(lines []*T) map[string]float64 {
+	averages := t.averages
+	weight := t.field(averages.weight)
+
+	values := map[string]float64{}
+	for _, column := range averages.columns() {
+		field := t.field(column)
+		var total, weights float64
+		for _, line := range lines {
+			value := floatValue(field.ValueOf(line))
+			switch averages.aggregates[column] {
+			case aggCount:
+				total++
+			case aggSum:
+				total += value
+			case aggMax:
+				total = math.Max(total, value)
+			default:
+				total += value * floatValue(weight.ValueOf(line))
+				weights += floatValue(weight.ValueOf(line))
+			}
+		}
+
+		if averages.aggregates[column] == aggWeighted {
+			if weights != 0 {
+				values[column] = total / weights
+			}
+			continue
+		}
+		values[column] = total
+	}
+
+	return values
+}
+
+// derive sets the derived stats of a copy of a line, given the league averages of every season
+func (t *memTable[T]) derive(line *T, leagues map[int]map[string]float64) {
+	// the copy gets its own derived stats rather than sharing those of the line it was copied from
+	derived := reflect.ValueOf(line).Elem().FieldByName("Derived")
+	derived.Set(reflect.Zero(derived.Type()))
+
+	league := leagues[t.field("season").ValueOf(line).(int)]
+	for _, stat := range t.derived {
+		field := t.field(stat.name)
+		value := roundNumeric(stat.value(line, league), stat.places)
+		if field.Kind == KindInt {
+			field.setValue(line, int(value))
+			continue
+		}
+		field.setValue(line, value)
+	}
+}
+
+// floatValue returns a numeric field value as a float64
+func floatValue(value any) float64 {
+	if i, ok := value.(int); ok {
+		return float64(i)
+	}
+
+	return value.(float64)
+}
+
+// list returns a page of lines, applying the list options as listClauses does
+func (t *memTable[T]) list(opts *ListOptions) *Page[*T] {
+	lines := t.matching(opts.Filters, opts.IncludeDeleted)
+	keys := opts.OrderKeys()
+	reverse := opts.Before != nil
+
+	sort.Slice(lines, func(i, j int) bool {
+		return compareKeys(keys, reverse, keyValues(keys, lines[i]), keyValues(keys, lines[j])) < 0
+	})
+
+	if cursor := opts.cursor(); cursor != nil {
+		after := []*T{}
+		for _, line := range lines {
+			if compareKeys(keys, reverse, keyValues(keys, line), cursor.Values) > 0 {
+				after = append(after, line)
+			}
+		}
+		lines = after
+	}
+
+	if opts.Limit > 0 && len(lines) > opts.Limit+1 {
+		lines = lines[:opts.Limit+1]
+	}
+
+	return newPage(lines, opts)
+}
+
+// career returns every live line of the player of the line with the given id, ordered by season
+func (t *memTable[T]) career(id int) ([]*T, error) {
+	line, ok := t.live(id)
+	if !ok {
+		return nil, notFoundError(t.name, id)
+	}
+
+	playerID := t.field("playerId")
+	lines := t.matching([]Filter{{Field: playerID, Op: OpEq, Value: playerID.ValueOf(line)}}, false)
+	keys := []SortKey{{Field: t.field("season")}, {Field: idField}}
+	sort.Slice(lines, func(i, j int) bool {
+		return compareKeys(keys, false, keyValues(keys, lines[i]), keyValues(keys, lines[j])) < 0
+	})
+
+	return lines, nil
+}
+
+// keyValues returns the values of a line's sort keys
+func keyValues(keys []SortKey, line any) []any {
+	values := make([]any, len(keys))
+	for i, key := range keys {
+		values[i] = key.Field.ValueOf(line)
+	}
+
+	return values
+}
+
+// compareKeys compares two sets of sort key values in the order of the keys, reversed when set
+func compareKeys(keys []SortKey, reverse bool, a, b []any) int {
+	for i, key := range keys {
+		c := compareValues(a[i], b[i])
+		if key.Desc != reverse {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+
+	return 0
+}
+
+// compareValues compares two values of the same field
+func compareValues(a, b any) int {
+	switch a := a.(type) {
+	case int:
+		b := b.(int)
+		if a < b {
+			return -1
+		} else if a > b {
+			return 1
+		}
+	case float64:
+		b := b.(float64)
+		if a < b {
+			return -1
+		} else if a > b {
+			return 1
+		}
+	case string:
+		return strings.Compare(a, b.(string))
+	}
+
+	return 0
+}
+
+// matchesFilters reports whether a line meets every filter
+func matchesFilters(filters []Filter, line any) bool {
+	for _, filter := range filters {
+		value := filter.Field.ValueOf(line)
+
+		matched := false
+		switch filter.Op {
+		case OpIn:
+			for _, v := range filter.Value.([]any) {
+				if compareValues(value, v) == 0 {
+					matched = true
+				}
+			}
+		case OpEq:
+			matched = compareValues(value, filter.Value) == 0
+		case OpNe:
+			matched = compareValues(value, filter.Value) != 0
+		case OpGt:
+			matched = compareValues(value, filter.Value) > 0
+		case OpGte:
+			matched = compareValues(value, filter.Value) >= 0
+		case OpLt:
+			matched = compareValues(value, filter.Value) < 0
+		case OpLte:
+			matched = compareValues(value, filter.Value) <= 0
+		}
+
+		if !matched {
+			return false
+		}
+	}
+
+	return true
+}
+
+// *******************
+// History
+// *******************
+
+// memHistory is the in-memory history of every line
+type memHistory struct {
+	rows   []*changeRow
+	nextID int
+	undo   *memUndo
+}
+
+// of returns the changes of the line of a table with the given id, oldest first
+func (h *memHistory) of(table string, id int) []*changeRow {
+	rows := []*changeRow{}
+	for _, row := range h.rows {
+		if row.table == table && row.lineID == id {
+			rows = append(rows, row)
+		}
+	}
+
+	return rows
+}
+
+// memRecord appends the change of a line of a table from before to after to the history
+func memRecord[T any](h *memHistory, table *memTable[T], by author, action ChangeAction, before, after *T) error {
+	row, err := newChangeRow(table.lineTable, by, action, before, after)
+	if err != nil {
+		return err
+	}
+
+	recorded, nextID := len(h.rows), h.nextID
+	h.undo.add(func() {
+		h.rows, h.nextID = h.rows[:recorded], nextID
+	})
+
+	h.nextID++
+	row.id = h.nextID
+	h.rows = append(h.rows, row)
+	return nil
+}
+
+// memCreate adds a line to a table as addLine does, recording the line created
+func memCreate[T any](state *memState, table *memTable[T], by author, line *T) (*T, error) {
+	added, err := addLine(table, state.players, line)
+	if err != nil {
+		return nil, err
+	}
+
+	return added, memRecord(state.history, table, by, ChangeCreate, nil, added)
+}
+
+// memUpdate updates a line of a table as memTable.update does, recording the change
+func memUpdate[T any](state *memState, table *memTable[T], by author, line *T) error {
+	id := table.idOf(line)
+	before, err := table.get(id)
+	if err != nil {
+		return err
+	}
+
+	if err := table.update(line, state.players); err != nil {
+		return err
+	}
+
+	after, err := table.get(id)
+	if err != nil {
+		return err
+	}
+
+	return memRecord(state.history, table, by, ChangeUpdate, before, after)
+}
+
+// memPatch patches a line of a table as memTable.patch does, recording the change when the line is written
+func memPatch[T any](state *memState, table *memTable[T], by author, id int, line *T, names []string) (*T, error) {
+	before, err := table.get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	patched, err := table.patch(id, line, names, state.players)
+	if err != nil {
+		return nil, err
+	}
+	if *table.version(patched) == *table.version(before) {
+		return patched, nil
+	}
+
+	// the history keeps the stored line, without the derived stats it was read back with
+	after, err := table.get(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return patched, memRecord(state.history, table, by, ChangeUpdate, before, after)
+}
+
+// memDelete moves a line of a table to the trash as memTable.trash does, recording the deletion
+func memDelete[T any](state *memState, table *memTable[T], by author, id, version int) error {
+	before, err := table.get(id)
+	if err != nil {
+		return err
+	}
+
+	trashed, err := table.trash(id, version, changeTime())
+	if err != nil {
+		return err
+	}
+
+	return memRecord(state.history, table, by, ChangeDelete, before, trashed)
+}
+
+// memUndelete takes a line of a table out of the trash as memTable.untrash does, recording the restore
+func memUndelete[T any](state *memState, table *memTable[T], by author, id int) (*T, error) {
+	before := table.stored(id)
+	restored, err := table.untrash(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return restored, memRecord(state.history, table, by, ChangeRestore, before, restored)
+}
+
+// memPurge permanently deletes the lines of a table trashed before a time, recording each line purged
+func memPurge[T any](state *memState, table *memTable[T], before time.Time) (int, error) {
+	purged := table.purge(before)
+	for _, line := range purged {
+		if err := memRecord(state.history, table, purgeAuthor, ChangePurge, line, nil); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(purged), nil
+}
+
+// memRestore sets the line of a table with the given id back to the line recorded at version, as restoreLine does
+func memRestore[T any](state *memState, table *memTable[T], by author, id, version int) (*T, error) {
+	var target *changeRow
+	latest := 0
+	for _, row := range state.history.of(table.name, id) {
+		latest = max(latest, row.version)
+		if row.version == version && row.after != nil {
+			target = row
+		}
+	}
+	if target == nil {
+		return nil, versionNotFoundError(id, version)
+	}
+
+	restored := new(T)
+	if err := json.Unmarshal(target.after, restored); err != nil {
+		return nil, err
+	}
+	*table.deletedAt(restored) = nil
+
+	current := table.stored(id)
+	if current != nil {
+		*table.version(restored) = *table.version(current) + 1
+	} else {
+		*table.version(restored) = latest + 1
+	}
+
+	if err := table.put(restored, state.players); err != nil {
+		return nil, err
+	}
+
+	if err := memRecord(state.history, table, by, ChangeRestore, current, restored); err != nil {
+		return nil, err
+	}
+
+	return table.read(id)
+}
+
+// memWrite runs f in a transaction, returning the line it wrote
+func memWrite[T any](m *MemoryDB, f func(*memState) (*T, error)) (*T, error) {
+	var line *T
+	err := m.transaction(func(state *memState) error {
+		var err error
+		line, err = f(state)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return line, nil
+}
+
+// memWriter writes the lines of an import to an in-memory table
+type memWriter[T any] struct {
+	table   *memTable[T]
+	players *memPlayers
+	history *memHistory
+	// who the changes are recorded as made by
+	by author
+}
+
+func (w *memWriter[T]) deleteSeason(season int) (int, error) {
+	deleted := w.table.deleteSeason(season)
+	for _, line := range deleted {
+		if err := memRecord(w.history, w.table, w.by, ChangePurge, line, nil); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(deleted), nil
+}
+
+// writeLines writes a batch of lines as bulkWriter does
+func (w *memWriter[T]) writeLines(lines []*T, mode WriteMode, report *ImportReport) error {
+	playerID := w.table.field("playerId")
+	name := w.table.field("name")
+
+	for _, line := range lines {
+		ref, err := w.players.ref(playerID.ValueOf(line).(int), w.table.identity(line), name.ValueOf(line).(string))
+		if err != nil {
+			return err
+		}
+		playerID.setValue(line, ref)
+
+		if err := w.table.check(line, w.players); err != nil {
+			return err
+		}
+
+		key := lineKeyOf(w.table.fields, line)
+		id, exists := w.table.keys[key]
+		if !exists {
+			w.table.insert(line)
+			inserted, err := w.table.get(w.table.idOf(line))
+			if err != nil {
+				return err
+			}
+			if err := memRecord(w.history, w.table, w.by, ChangeCreate, nil, inserted); err != nil {
+				return err
+			}
+
+			report.Inserted++
+			continue
+		}
+		existing := w.table.rows[id]
+		if mode != WriteUpsert || *w.table.deletedAt(existing) != nil {
+			report.Skipped++
+			continue
+		}
+
+		changes := diffStats(w.table.fields, existing, line)
+		if len(changes) == 0 {
+			report.Unchanged++
+			continue
+		}
+
+		before, err := w.table.get(id)
+		if err != nil {
+			return err
+		}
+
+		updated := *line
+		w.table.field("id").setValue(&updated, id)
+		if err := w.table.update(&updated, w.players); err != nil {
+			return err
+		}
+
+		after, err := w.table.get(id)
+		if err != nil {
+			return err
+		}
+		if err := memRecord(w.history, w.table, w.by, ChangeUpdate, before, after); err != nil {
+			return err
+		}
+
+		report.Updated++
+		report.Changes = append(report.Changes, &RowChange{
+			ID:     id,
+			Name:   key.name,
+			Team:   key.team,
+			Season: key.season,
+			Fields: changes,
+		})
+	}
+
+	return nil
+}
+
+// memBatch applies a batch of operations to a table in a single transaction, as pgBatch does
+//
+// the writes of an operation that fails are rolled back to a savepoint taken before it, as pgBatch does
+func memBatch[T any](m *MemoryDB, ops []*BatchOp[T], mode BatchMode, tableOf func(*memState) *memTable[T]) ([]*BatchResult[T], error) {
+	var results []*BatchResult[T]
+	err := m.transaction(func(state *memState) error {
+		results = runBatch(ops, func(op *BatchOp[T]) (*T, error) {
+			savepoint := state.undo.savepoint()
+			line, err := memBatchOp(state, tableOf(state), m.author(), op)
+			if err != nil {
+				state.undo.rollback(savepoint)
+				return nil, err
+			}
+
+			return line, nil
+		})
+
+		return settleBatch(results, mode)
+	})
+	if err != nil && !errors.Is(err, errBatchFailed) {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// memBatchOp applies an operation of a batch to a table, returning the written line
+func memBatchOp[T any](state *memState, table *memTable[T], by author, op *BatchOp[T]) (*T, error) {
+	switch op.Action {
+	case BatchCreate:
+		return memCreate(state, table, by, op.Line)
+	case BatchUpdate:
+		updated := *op.Line
+		table.field("id").setValue(&updated, op.ID)
+		*table.version(&updated) = op.Version
+		if err := memUpdate(state, table, by, &updated); err != nil {
+			return nil, err
+		}
+		return table.read(op.ID)
+	case BatchDelete:
+		return nil, memDelete(state, table, by, op.ID, op.Version)
+	}
+
+	return nil, newError(ErrConstraint, "", fmt.Sprintf("unknown batch action: %s", op.Action))
+}
+
+// *******************
+// Position player methods
+// *******************
+
+func (m *MemoryDB) AddPositionPlayer(player *models.PositionPlayer) (*models.PositionPlayer, error) {
+	return memWrite(m, func(state *memState) (*models.PositionPlayer, error) {
+		return memCreate(state, state.positionPlayers, m.author(), player)
+	})
+}
+
+// addLine adds a line to a table, returning the inserted row, as DBPool does
+func addLine[T any](table *memTable[T], players *memPlayers, line *T) (*T, error) {
+	playerID := table.field("playerId")
+
+	// checked before the player is resolved so a rejected line doesn't create a player
+	if err := table.checkStats(line); err != nil {
+		return nil, err
+	}
+	if playerID.ValueOf(line).(int) != 0 {
+		if err := table.checkRef(line, players); err != nil {
+			return nil, err
+		}
+	}
+	if id, ok := table.keys[lineKeyOf(table.fields, line)]; ok {
+		return nil, lineConflictError(table.name, id)
+	}
+
+	ref, err := players.ref(playerID.ValueOf(line).(int), table.identity(line), table.field("name").ValueOf(line).(string))
+	if err != nil {
+		return nil, err
+	}
+	playerID.setValue(line, ref)
+
+	table.insert(line)
+	return table.get(table.idOf(line))
+}
+
+func (m *MemoryDB) DeletePositionPlayer(id, version int) error {
+	return m.transaction(func(state *memState) error {
+		return memDelete(state, state.positionPlayers, m.author(), id, version)
+	})
+}
+
+func (m *MemoryDB) UpdatePositionPlayer(player *models.PositionPlayer) error {
+	return m.transaction(func(state *memState) error {
+		return memUpdate(state, state.positionPlayers, m.author(), player)
+	})
+}
+
+func (m *MemoryDB) PatchPositionPlayer(id int, player *models.PositionPlayer, fields []string) (*models.PositionPlayer, error) {
+	return memWrite(m, func(state *memState) (*models.PositionPlayer, error) {
+		return memPatch(state, state.positionPlayers, m.author(), id, player, fields)
+	})
+}
+
+func (m *MemoryDB) GetPositionPlayers(opts *ListOptions) (*Page[*models.PositionPlayer], error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.state.positionPlayers.list(opts), nil
+}
+
+func (m *MemoryDB) CountPositionPlayers(opts *ListOptions) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return len(m.state.positionPlayers.matching(opts.Filters, opts.IncludeDeleted)), nil
+}
+
+func (m *MemoryDB) GetPositionPlayerByID(id int) (*models.PositionPlayer, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.state.positionPlayers.read(id)
+}
+
+func (m *MemoryDB) GetPositionPlayerCareer(id int) ([]*models.PositionPlayer, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.state.positionPlayers.career(id)
+}
+
+func (m *MemoryDB) BatchPositionPlayers(ops []*BatchOp[models.PositionPlayer], mode BatchMode) ([]*BatchResult[models.PositionPlayer], error) {
+	tableOf := func(state *memState) *memTable[models.PositionPlayer] { return state.positionPlayers }
+
+	return memBatch(m, ops, mode, tableOf)
+}
+
+func (m *MemoryDB) GetPositionPlayerHistory(id int) ([]*Change[models.PositionPlayer], error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return decodeHistory[models.PositionPlayer](m.state.positionPlayers.name, id, m.state.history.of(m.state.positionPlayers.name, id))
+}
+
+func (m *MemoryDB) RestorePositionPlayer(id, version int) (*models.PositionPlayer, error) {
+	return memWrite(m, func(state *memState) (*models.PositionPlayer, error) {
+		return memRestore(state, state.positionPlayers, m.author(), id, version)
+	})
+}
+
+func (m *MemoryDB) UndeletePositionPlayer(id int) (*models.PositionPlayer, error) {
+	return memWrite(m, func(state *memState) (*models.PositionPlayer, error) {
+		return memUndelete(state, state.positionPlayers, m.author(), id)
+	})
+}
+
+// positionPlayerWriter returns the writer importing position players into the tables
+func positionPlayerWriter(state *memState, by author) *memWriter[models.PositionPlayer] {
+	return &memWriter[models.PositionPlayer]{
+		table:   state.positionPlayers,
+		players: state.players,
+		history: state.history,
+		by:      by,
+	}
+}
+
+// importMemPositionPlayers imports a csv of position players into the tables,
+// then recomputes the league and team averages
+func importMemPositionPlayers(state *memState, by author, r io.Reader, opts *ImportOptions, report *ImportReport) error {
+	mapping, err := positionPlayerMapping(opts)
+	if err != nil {
+		return err
+	}
+
+	reader, err := newPositionPlayerReader(r, mapping, opts.Season)
+	if err != nil {
+		return err
+	}
+
+	if err := importCSV(reader, opts, report, positionPlayerWriter(state, by)); err != nil || opts.DryRun {
+		return err
+	}
+
+	state.positionPlayers.recomputeAverages()
+	return nil
+}
+
+// ImportPositionPlayers imports a csv of position players, keeping nothing when the import fails
+func (m *MemoryDB) ImportPositionPlayers(r io.Reader, opts *ImportOptions) (*ImportReport, error) {
+	report := newImportReport("", opts)
+	err := m.transaction(func(state *memState) error {
+		return importMemPositionPlayers(state, newAuthor(m.actor, SourceImport), r, opts, report)
+	})
+
+	return report, err
+}
+
+// ImportPositionPlayerDataFromCSV imports every assets/batters*.csv file as DBPool does
+func (m *MemoryDB) ImportPositionPlayerDataFromCSV(opts *ImportOptions) ([]*ImportReport, error) {
+	var reports []*ImportReport
+	err := m.transaction(func(state *memState) error {
+		var err error
+		reports, err = importFiles("./assets/batters*.csv", opts, func(r io.Reader, opts *ImportOptions, report *ImportReport) error {
+			return importMemPositionPlayers(state, newAuthor(m.actor, SourceImport), r, opts, report)
+		})
+		return err
+	})
+
+	return reports, err
+}
+
+// *******************
+// Pitcher methods
+// *******************
+
+func (m *MemoryDB) AddPitcher(player *models.Pitcher) (*models.Pitcher, error) {
+	return memWrite(m, func(state *memState) (*models.Pitcher, error) {
+		return memCreate(state, state.pitchers, m.author(), player)
+	})
+}
+
+func (m *MemoryDB) DeletePitcher(id, version int) error {
+	return m.transaction(func(state *memState) error {
+		return memDelete(state, state.pitchers, m.author(), id, version)
+	})
+}
+
+func (m *MemoryDB) UpdatePitcher(player *models.Pitcher) error {
+	return m.transaction(func(state *memState) error {
+		return memUpdate(state, state.pitchers, m.author(), player)
+	})
+}
+
+func (m *MemoryDB) PatchPitcher(id int, player *models.Pitcher, fields []string) (*models.Pitcher, error) {
+	return memWrite(m, func(state *memState) (*models.Pitcher, error) {
+		return memPatch(state, state.pitchers, m.author(), id, player, fields)
+	})
+}
+
+func (m *MemoryDB) GetPitchers(opts *ListOptions) (*Page[*models.Pitcher], error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.state.pitchers.list(opts), nil
+}
+
+func (m *MemoryDB) CountPitchers(opts *ListOptions) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return len(m.state.pitchers.matching(opts.Filters, opts.IncludeDeleted)), nil
+}
+
+func (m *MemoryDB) GetPitcherByID(id int) (*models.Pitcher, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.state.pitchers.read(id)
+}
+
+func (m *MemoryDB) GetPitcherCareer(id int) ([]*models.Pitcher, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.state.pitchers.career(id)
+}
+
+func (m *MemoryDB) BatchPitchers(ops []*BatchOp[models.Pitcher], mode BatchMode) ([]*BatchResult[models.Pitcher], error) {
+	tableOf := func(state *memState) *memTable[models.Pitcher] { return state.pitchers }
+
+	return memBatch(m, ops, mode, tableOf)
+}
+
+func (m *MemoryDB) GetPitcherHistory(id int) ([]*Change[models.Pitcher], error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return decodeHistory[models.Pitcher](m.state.pitchers.name, id, m.state.history.of(m.state.pitchers.name, id))
+}
+
+func (m *MemoryDB) RestorePitcher(id, version int) (*models.Pitcher, error) {
+	return memWrite(m, func(state *memState) (*models.Pitcher, error) {
+		return memRestore(state, state.pitchers, m.author(), id, version)
+	})
+}
+
+func (m *MemoryDB) UndeletePitcher(id int) (*models.Pitcher, error) {
+	return memWrite(m, func(state *memState) (*models.Pitcher, error) {
+		return memUndelete(state, state.pitchers, m.author(), id)
+	})
+}
+
+// pitcherWriter returns the writer importing pitchers into the tables
+func pitcherWriter(state *memState, by author) *memWriter[models.Pitcher] {
+	return &memWriter[models.Pitcher]{
+		table:   state.pitchers,
+		players: state.players,
+		history: state.history,
+		by:      by,
+	}
+}
+
+// importMemPitchers imports a csv of pitchers into the tables,
+// then recomputes the league and team averages
+func importMemPitchers(state *memState, by author, r io.Reader, opts *ImportOptions, report *ImportReport) error {
+	mapping, err := pitcherMapping(opts)
+	if err != nil {
+		return err
+	}
+
+	reader, err := newPitcherReader(r, mapping, opts.Season)
+	if err != nil {
+		return err
+	}
+
+	if err := importCSV(reader, opts, report, pitcherWriter(state, by)); err != nil || opts.DryRun {
+		return err
+	}
+
+	state.pitchers.recomputeAverages()
+	return nil
+}
+
+// ImportPitchers imports a csv of pitchers, keeping nothing when the import fails
+func (m *MemoryDB) ImportPitchers(r io.Reader, opts *ImportOptions) (*ImportReport, error) {
+	report := newImportReport("", opts)
+	err := m.transaction(func(state *memState) error {
+		return importMemPitchers(state, newAuthor(m.actor, SourceImport), r, opts, report)
+	})
+
+	return report, err
+}
+
+// ImportPitcherDataFromCSV imports every assets/pitchers*.csv file as DBPool does
+func (m *MemoryDB) ImportPitcherDataFromCSV(opts *ImportOptions) ([]*ImportReport, error) {
+	var reports []*ImportReport
+	err := m.transaction(func(state *memState) error {
+		var err error
+		reports, err = importFiles("./assets/pitchers*.csv", opts, func(r io.Reader, opts *ImportOptions, report *ImportReport) error {
+			return importMemPitchers(state, newAuthor(m.actor, SourceImport), r, opts, report)
+		})
+		return err
+	})
+
+	return reports, err
+}
@@ -0,0 +1,364 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// migrationFiles holds the schema migrations, a pair of NNNN_name.up.sql and NNNN_name.down.sql
+// files for each version
+//
+// migrations are written for Postgres, and run on SQLite with serial keys swapped by sqliteSchema.
+// a migration SQLite can't run, like one altering constraints, has NNNN_name.sqlite.up.sql and
+// NNNN_name.sqlite.down.sql files run on SQLite in its place
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migration is a single version of the schema
+type migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+	// run on SQLite in place of Up and Down, when set
+	SQLiteUp   string
+	SQLiteDown string
+}
+
+var migrationPattern = regexp.MustCompile(`^(\d+)_(\w+)(\.sqlite)?\.(up|down)\.sql$`)
+
+// sqlite returns the sqlite form of a migration up or down
+func (m *migration) sqlite(up bool) string {
+	if up && m.SQLiteUp != "" {
+		return sqliteSchema(m.SQLiteUp)
+	}
+	if !up && m.SQLiteDown != "" {
+		return sqliteSchema(m.SQLiteDown)
+	}
+	if up {
+		return sqliteSchema(m.Up)
+	}
+
+	return sqliteSchema(m.Down)
+}
+
+// migrations are the embedded migrations, in version order
+var migrations = mustLoadMigrations(migrationFiles)
+
+// LatestVersion is the version of the newest migration
+var LatestVersion = migrations[len(migrations)-1].Version
+
+// loadMigrations reads the migrations in a directory named migrations
+//
+// versions must start at 1 with no gaps, and each must have both an up and a down file, as must
+// the sqlite files of a version that has them
+func loadMigrations(files fs.FS) ([]*migration, error) {
+	entries, err := fs.ReadDir(files, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*migration{}
+	for _, entry := range entries {
+		match := migrationPattern.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration file name: %s", entry.Name())
+		}
+
+		data, err := fs.ReadFile(files, "migrations/"+entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		version, _ := strconv.Atoi(match[1])
+		m, ok := byVersion[version]
+		if !ok {
+			m = &migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, m.Name, match[2])
+		}
+
+		switch {
+		case match[3] == "" && match[4] == "up":
+			m.Up = string(data)
+		case match[3] == "":
+			m.Down = string(data)
+		case match[4] == "up":
+			m.SQLiteUp = string(data)
+		default:
+			m.SQLiteDown = string(data)
+		}
+	}
+
+	loaded := []*migration{}
+	for _, m := range byVersion {
+		loaded = append(loaded, m)
+	}
+	sort.Slice(loaded, func(i, j int) bool { return loaded[i].Version < loaded[j].Version })
+
+	for i, m := range loaded {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("migration %d is missing", i+1)
+		}
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d needs both an up and a down file", m.Version)
+		}
+		if (m.SQLiteUp == "") != (m.SQLiteDown == "") {
+			return nil, fmt.Errorf("migration %d needs both a sqlite up and a sqlite down file", m.Version)
+		}
+	}
+	if len(loaded) == 0 {
+		return nil, fmt.Errorf("no migrations")
+	}
+
+	return loaded, nil
+}
+
+func mustLoadMigrations(files fs.FS) []*migration {
+	loaded, err := loadMigrations(files)
+	if err != nil {
+		panic(err)
+	}
+
+	return loaded
+}
+
+// schema of the table recording which migrations have been applied
+const schemaMigrationsTable = `create table if not exists schema_migrations (
+	version int primary key NOT NULL,
+	name text NOT NULL,
+	applied_at timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP)`
+
+// schemaConn applies migrations to a database
+type schemaConn interface {
+	// lock blocks until no other process is migrating the database
+	lock() (unlock func(), err error)
+	// appliedVersion returns the version of the newest applied migration, 0 when none are
+	appliedVersion() (int, error)
+	// apply runs a migration up or down in a transaction, recording it in schema_migrations
+	apply(m *migration, up bool) error
+}
+
+// migrateTo applies or rolls back migrations until the schema is at version
+//
+// 0 rolls back every migration
+func migrateTo(conn schemaConn, version int) error {
+	if version < 0 || version > LatestVersion {
+		return fmt.Errorf("no schema version %d, the latest is %d", version, LatestVersion)
+	}
+
+	unlock, err := conn.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	current, err := conn.appliedVersion()
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if m.Version <= current || m.Version > version {
+			continue
+		}
+
+		log.Printf("Applying migration %d %s", m.Version, m.Name)
+		if err := conn.apply(m, true); err != nil {
+			return fmt.Errorf("migration %d %s: %w", m.Version, m.Name, err)
+		}
+	}
+
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if m.Version > current || m.Version <= version {
+			continue
+		}
+
+		log.Printf("Rolling back migration %d %s", m.Version, m.Name)
+		if err := conn.apply(m, false); err != nil {
+			return fmt.Errorf("rolling back migration %d %s: %w", m.Version, m.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// *******************
+// Postgres
+// *******************
+
+// key of the advisory lock held while migrating, so replicas starting together don't race
+const migrationLockKey = 4242_0001
+
+// pgSchema applies migrations on a single connection from the pool, which holds the advisory lock
+type pgSchema struct {
+	conn *pgx.Conn
+}
+
+func (s *pgSchema) lock() (func(), error) {
+	ctx := context.Background()
+	if _, err := s.conn.Exec(ctx, `SELECT pg_advisory_lock($1)`, migrationLockKey); err != nil {
+		return nil, err
+	}
+
+	unlock := func() {
+		if _, err := s.conn.Exec(ctx, `SELECT pg_advisory_unlock($1)`, migrationLockKey); err != nil {
+			log.Println("Unable to release migration lock:", err)
+		}
+	}
+
+	if _, err := s.conn.Exec(ctx, schemaMigrationsTable); err != nil {
+		unlock()
+		return nil, err
+	}
+
+	return unlock, nil
+}
+
+func (s *pgSchema) appliedVersion() (int, error) {
+	version := 0
+	err := s.conn.QueryRow(context.Background(), `SELECT COALESCE(max(version), 0) FROM schema_migrations`).Scan(&version)
+
+	return version, err
+}
+
+func (s *pgSchema) apply(m *migration, up bool) error {
+	return pgx.BeginFunc(context.Background(), s.conn, func(tx pgx.Tx) error {
+		ctx := context.Background()
+		if !up {
+			if _, err := tx.Exec(ctx, m.Down); err != nil {
+				return err
+			}
+			_, err := tx.Exec(ctx, `DELETE FROM schema_migrations WHERE version = $1`, m.Version)
+			return err
+		}
+
+		if _, err := tx.Exec(ctx, m.Up); err != nil {
+			return err
+		}
+		_, err := tx.Exec(ctx, `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, m.Version, m.Name)
+		return err
+	})
+}
+
+// MigrateTo applies or rolls back migrations until the schema is at version
+//
+// an advisory lock is held while migrating, so only one replica migrates at a time
+func (pool *DBPool) MigrateTo(version int) error {
+	conn, err := pool.Poolconn.Acquire(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	return migrateTo(&pgSchema{conn: conn.Conn()}, version)
+}
+
+// SchemaVersion returns the version of the newest applied migration, 0 when none are
+func (pool *DBPool) SchemaVersion() (int, error) {
+	conn, err := pool.Poolconn.Acquire(context.Background())
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Release()
+
+	schema := &pgSchema{conn: conn.Conn()}
+	unlock, err := schema.lock()
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+
+	return schema.appliedVersion()
+}
+
+// *******************
+// SQLite
+// *******************
+
+// sqliteSchemaConn applies migrations to a SQLite database
+//
+// the SQLiteDB's single connection serializes migrations within the process,
+// and sqlite's write lock keeps other processes out of each migration's transaction
+type sqliteSchemaConn struct {
+	db *SQLiteDB
+}
+
+func (s *sqliteSchemaConn) lock() (func(), error) {
+	if _, err := s.db.conn.Exec(schemaMigrationsTable); err != nil {
+		return nil, err
+	}
+
+	return func() {}, nil
+}
+
+func (s *sqliteSchemaConn) appliedVersion() (int, error) {
+	version := 0
+	err := s.db.conn.QueryRow(`SELECT COALESCE(max(version), 0) FROM schema_migrations`).Scan(&version)
+
+	return version, err
+}
+
+func (s *sqliteSchemaConn) apply(m *migration, up bool) error {
+	return s.db.transaction(func(tx *sql.Tx) error {
+		if !up {
+			if _, err := tx.Exec(m.sqlite(false)); err != nil {
+				return err
+			}
+			_, err := tx.Exec(`DELETE FROM schema_migrations WHERE version = $1`, m.Version)
+			return err
+		}
+
+		if _, err := tx.Exec(m.sqlite(true)); err != nil {
+			return err
+		}
+		_, err := tx.Exec(`INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, m.Version, m.Name)
+		return err
+	})
+}
+
+// MigrateTo applies or rolls back migrations until the schema is at version
+func (s *SQLiteDB) MigrateTo(version int) error {
+	return migrateTo(&sqliteSchemaConn{db: s}, version)
+}
+
+// SchemaVersion returns the version of the newest applied migration, 0 when none are
+func (s *SQLiteDB) SchemaVersion() (int, error) {
+	schema := &sqliteSchemaConn{db: s}
+	if _, err := schema.lock(); err != nil {
+		return 0, err
+	}
+
+	return schema.appliedVersion()
+}
+
+// *******************
+// Memory
+// *******************
+
+// MigrateTo only accepts the latest version, since a MemoryDB has no schema to migrate
+func (m *MemoryDB) MigrateTo(version int) error {
+	if version != LatestVersion {
+		return fmt.Errorf("the in-memory db is always at the latest schema version, %d", LatestVersion)
+	}
+
+	return nil
+}
+
+// SchemaVersion returns LatestVersion, since a MemoryDB has no schema to migrate
+func (m *MemoryDB) SchemaVersion() (int, error) {
+	return LatestVersion, nil
+}
@@ -0,0 +1,2 @@
+drop table if exists pitchers;
+drop table if exists position_players;
@@ -0,0 +1,52 @@
+-- the tables as they were created before migrations, so existing databases adopt this version as is.
+-- later migrations bring them up to date
+create table if not exists position_players (
+    player_id serial primary key NOT NULL,
+    name text NOT NULL,
+    team text,
+    g int CHECK (g >= 0),
+    pa int CHECK (pa >= 0),
+    hr int CHECK (hr >= 0),
+    runs int CHECK (runs >= 0),
+    rbi int CHECK (rbi >= 0),
+    sb int CHECK (sb >= 0),
+    wrc_plus int CHECK (wrc_plus >= 0),
+    bb_rate float8 CHECK (bb_rate >= 0),
+    k_rate float8 CHECK (k_rate >= 0),
+    iso float8 CHECK (iso >= 0),
+    babip float8 CHECK (babip >= 0),
+    average float8 CHECK (average >= 0),
+    obp float8 CHECK (obp >= 0),
+    slg float8 CHECK (slg >= 0),
+    woba float8 CHECK (woba >= 0),
+    x_woba float8 CHECK (x_woba >= 0),
+    bsr float8,
+    war float8,
+    unique (name, team)
+);
+
+create table if not exists pitchers (
+    player_id serial primary key NOT NULL,
+    name text NOT NULL,
+    team text,
+    w int CHECK (w >= 0),
+    l int CHECK (l >= 0),
+    sv int CHECK (sv >= 0),
+    g int CHECK (g >= 0),
+    gs int CHECK (gs >= 0),
+    ip float8 CHECK (ip >= 0),
+    k9 float8 CHECK (k9 >= 0),
+    bb9 float8 CHECK (bb9 >= 0),
+    hr9 float8 CHECK (hr9 >= 0),
+    babip float8 CHECK (babip >= 0),
+    lob float8 CHECK (lob >= 0),
+    gb float8 CHECK (gb >= 0),
+    hrfb float8 CHECK (hrfb >= 0),
+    vfa float8 CHECK (vfa >= 0),
+    era float8 CHECK (era >= 0),
+    xera float8 CHECK (xera >= 0),
+    fip float8 CHECK (fip >= 0),
+    xfip float8 CHECK (xfip >= 0),
+    war float8,
+    unique (name, team)
+);
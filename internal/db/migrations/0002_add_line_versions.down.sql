@@ -0,0 +1,2 @@
+alter table pitchers drop column version;
+alter table position_players drop column version;
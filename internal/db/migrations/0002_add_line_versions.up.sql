@@ -0,0 +1,3 @@
+-- version of each season line, incremented by every write so concurrent edits can be detected
+alter table position_players add column version int NOT NULL DEFAULT 1;
+alter table pitchers add column version int NOT NULL DEFAULT 1;
@@ -0,0 +1 @@
+drop table if exists line_history;
@@ -0,0 +1,15 @@
+-- append-only history of every write to a season line, the line is kept as json before and after each change
+create table line_history (
+    id serial primary key NOT NULL,
+    line_table text NOT NULL,
+    line_id int NOT NULL,
+    version int NOT NULL,
+    action text NOT NULL,
+    actor text NOT NULL,
+    source text NOT NULL,
+    changed_at timestamp NOT NULL,
+    before_line text,
+    after_line text
+);
+
+create index line_history_line_idx on line_history (line_table, line_id);
@@ -0,0 +1,4 @@
+delete from pitchers where deleted_at is not null;
+delete from position_players where deleted_at is not null;
+alter table pitchers drop column deleted_at;
+alter table position_players drop column deleted_at;
@@ -0,0 +1,3 @@
+-- when a season line was moved to the trash, NULL while it is live. trashed lines are hidden from reads until restored or purged
+alter table position_players add column deleted_at timestamp;
+alter table pitchers add column deleted_at timestamp;
@@ -0,0 +1,2 @@
+drop table if exists pitching_averages;
+drop table if exists batting_averages;
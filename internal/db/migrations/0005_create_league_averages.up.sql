@@ -0,0 +1,40 @@
+-- league and team averages of each season, recomputed from the live season lines on every import.
+-- the league row of a season has an empty team
+create table batting_averages (
+    season int NOT NULL,
+    team text NOT NULL,
+    lines int NOT NULL,
+    g int NOT NULL,
+    pa int NOT NULL,
+    wrc_plus float8 NOT NULL,
+    bb_rate float8 NOT NULL,
+    k_rate float8 NOT NULL,
+    iso float8 NOT NULL,
+    babip float8 NOT NULL,
+    average float8 NOT NULL,
+    obp float8 NOT NULL,
+    slg float8 NOT NULL,
+    woba float8 NOT NULL,
+    x_woba float8 NOT NULL,
+    primary key (season, team)
+);
+
+create table pitching_averages (
+    season int NOT NULL,
+    team text NOT NULL,
+    lines int NOT NULL,
+    ip float8 NOT NULL,
+    k9 float8 NOT NULL,
+    bb9 float8 NOT NULL,
+    hr9 float8 NOT NULL,
+    babip float8 NOT NULL,
+    lob float8 NOT NULL,
+    gb float8 NOT NULL,
+    hrfb float8 NOT NULL,
+    vfa float8 NOT NULL,
+    era float8 NOT NULL,
+    xera float8 NOT NULL,
+    fip float8 NOT NULL,
+    xfip float8 NOT NULL,
+    primary key (season, team)
+);
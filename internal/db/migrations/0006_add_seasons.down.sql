@@ -0,0 +1,2 @@
+alter table pitchers drop column season;
+alter table position_players drop column season;
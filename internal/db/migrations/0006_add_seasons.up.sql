@@ -0,0 +1,4 @@
+-- season of each line. lines from before seasons were the 2022 lines the api shipped with, so they're
+-- backfilled as 2022. the default only backfills them, and is dropped once lines are keyed by season
+alter table position_players add column season int NOT NULL DEFAULT 2022 CHECK (season > 0);
+alter table pitchers add column season int NOT NULL DEFAULT 2022 CHECK (season > 0);
@@ -0,0 +1,3 @@
+alter table pitchers drop column player_ref;
+alter table position_players drop column player_ref;
+drop table if exists players;
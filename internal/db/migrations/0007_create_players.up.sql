@@ -0,0 +1,24 @@
+-- canonical players, keyed by their external ids, that the season lines of a player share
+create table players (
+    id serial primary key NOT NULL,
+    name text NOT NULL,
+    name_ascii text,
+    fangraphs_id text unique,
+    mlbam_id text unique,
+    bbref_id text unique,
+    retro_id text unique
+);
+
+-- existing lines have no external ids, so each name becomes a player without ids, as an import
+-- would resolve it, shared by hitting and pitching lines
+insert into players (name)
+select name from position_players
+union
+select name from pitchers
+order by name;
+
+alter table position_players add column player_ref int REFERENCES players (id);
+alter table pitchers add column player_ref int REFERENCES players (id);
+
+update position_players set player_ref = (select id from players where players.name = position_players.name);
+update pitchers set player_ref = (select id from players where players.name = pitchers.name);
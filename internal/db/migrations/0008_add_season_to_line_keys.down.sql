@@ -0,0 +1,9 @@
+alter table pitchers drop constraint pitchers_name_team_season_key;
+alter table pitchers add constraint pitchers_name_team_key unique (name, team);
+alter table pitchers alter column player_ref drop NOT NULL;
+alter table pitchers alter column season set DEFAULT 2022;
+
+alter table position_players drop constraint position_players_name_team_season_key;
+alter table position_players add constraint position_players_name_team_key unique (name, team);
+alter table position_players alter column player_ref drop NOT NULL;
+alter table position_players alter column season set DEFAULT 2022;
@@ -0,0 +1,71 @@
+-- the line tables are rebuilt as 0007 left them
+create table pitchers_rebuilt (
+    player_id serial primary key NOT NULL,
+    name text NOT NULL,
+    team text,
+    season int NOT NULL DEFAULT 2022 CHECK (season > 0),
+    w int CHECK (w >= 0),
+    l int CHECK (l >= 0),
+    sv int CHECK (sv >= 0),
+    g int CHECK (g >= 0),
+    gs int CHECK (gs >= 0),
+    ip float8 CHECK (ip >= 0),
+    k9 float8 CHECK (k9 >= 0),
+    bb9 float8 CHECK (bb9 >= 0),
+    hr9 float8 CHECK (hr9 >= 0),
+    babip float8 CHECK (babip >= 0),
+    lob float8 CHECK (lob >= 0),
+    gb float8 CHECK (gb >= 0),
+    hrfb float8 CHECK (hrfb >= 0),
+    vfa float8 CHECK (vfa >= 0),
+    era float8 CHECK (era >= 0),
+    xera float8 CHECK (xera >= 0),
+    fip float8 CHECK (fip >= 0),
+    xfip float8 CHECK (xfip >= 0),
+    war float8,
+    version int NOT NULL DEFAULT 1,
+    deleted_at timestamp,
+    player_ref int REFERENCES players (id),
+    unique (name, team)
+);
+
+insert into pitchers_rebuilt (player_id, name, team, season, w, l, sv, g, gs, ip, k9, bb9, hr9, babip, lob, gb, hrfb, vfa, era, xera, fip, xfip, war, version, deleted_at, player_ref)
+select player_id, name, team, season, w, l, sv, g, gs, ip, k9, bb9, hr9, babip, lob, gb, hrfb, vfa, era, xera, fip, xfip, war, version, deleted_at, player_ref from pitchers;
+
+drop table pitchers;
+alter table pitchers_rebuilt rename to pitchers;
+
+create table position_players_rebuilt (
+    player_id serial primary key NOT NULL,
+    name text NOT NULL,
+    team text,
+    season int NOT NULL DEFAULT 2022 CHECK (season > 0),
+    g int CHECK (g >= 0),
+    pa int CHECK (pa >= 0),
+    hr int CHECK (hr >= 0),
+    runs int CHECK (runs >= 0),
+    rbi int CHECK (rbi >= 0),
+    sb int CHECK (sb >= 0),
+    wrc_plus int CHECK (wrc_plus >= 0),
+    bb_rate float8 CHECK (bb_rate >= 0),
+    k_rate float8 CHECK (k_rate >= 0),
+    iso float8 CHECK (iso >= 0),
+    babip float8 CHECK (babip >= 0),
+    average float8 CHECK (average >= 0),
+    obp float8 CHECK (obp >= 0),
+    slg float8 CHECK (slg >= 0),
+    woba float8 CHECK (woba >= 0),
+    x_woba float8 CHECK (x_woba >= 0),
+    bsr float8,
+    war float8,
+    version int NOT NULL DEFAULT 1,
+    deleted_at timestamp,
+    player_ref int REFERENCES players (id),
+    unique (name, team)
+);
+
+insert into position_players_rebuilt (player_id, name, team, season, g, pa, hr, runs, rbi, sb, wrc_plus, bb_rate, k_rate, iso, babip, average, obp, slg, woba, x_woba, bsr, war, version, deleted_at, player_ref)
+select player_id, name, team, season, g, pa, hr, runs, rbi, sb, wrc_plus, bb_rate, k_rate, iso, babip, average, obp, slg, woba, x_woba, bsr, war, version, deleted_at, player_ref from position_players;
+
+drop table position_players;
+alter table position_players_rebuilt rename to position_players;
@@ -0,0 +1,11 @@
+-- a player has a line for each season they played for a team, so lines are unique by name, team and season.
+-- every line has a season and a player by now
+alter table position_players alter column season drop default;
+alter table position_players alter column player_ref set NOT NULL;
+alter table position_players drop constraint position_players_name_team_key;
+alter table position_players add constraint position_players_name_team_season_key unique (name, team, season);
+
+alter table pitchers alter column season drop default;
+alter table pitchers alter column player_ref set NOT NULL;
+alter table pitchers drop constraint pitchers_name_team_key;
+alter table pitchers add constraint pitchers_name_team_season_key unique (name, team, season);
@@ -0,0 +1,77 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// patchFields returns the fields named in a patch, by json or column name
+//
+// a line's id and player can't be patched, nor can its derived stats, which aren't stored
+func patchFields(set *FieldSet, names []string) ([]Field, error) {
+	fields := []Field{}
+	seen := map[string]bool{}
+	for _, name := range names {
+		field, ok := set.Lookup(name)
+		if !ok {
+			return nil, newError(ErrConstraint, "", "unknown field: "+name)
+		}
+		if field.Name == "id" || field.Name == "playerId" {
+			return nil, newError(ErrConstraint, "", field.Name+" can't be patched")
+		}
+		if field.derived {
+			return nil, newError(ErrConstraint, "", field.Name+" is derived and can't be patched")
+		}
+
+		if !seen[field.Name] {
+			fields = append(fields, field)
+			seen[field.Name] = true
+		}
+	}
+
+	return fields, nil
+}
+
+// patchLine sets the named fields of the line with the given id to their values in line, returning the updated line
+// with its derived stats, as a read returns it
+//
+// the fields are written in a single UPDATE, so the other columns are left as they are. when line has
+// a version, the line is only patched if it is still at that version. a line in the trash isn't patched
+func patchLine[T any](q querier, table *lineTable[T], id int, line *T, names []string) (*T, error) {
+	fields, err := patchFields(table.fields, names)
+	if err != nil {
+		return nil, err
+	}
+
+	version := *table.version(line)
+	if len(fields) == 0 {
+		query := `SELECT player_id FROM ` + table.name + ` WHERE player_id = $1 AND ` + liveCondition + ` AND ` + versionCondition(2)
+		err = q.QueryRow(context.Background(), query, id, version).Scan(&id)
+	} else {
+		assignments := make([]string, len(fields))
+		values := make([]any, len(fields))
+		for i, field := range fields {
+			assignments[i] = fmt.Sprintf("%s = $%d", field.Column, i+1)
+			values[i] = field.ValueOf(line)
+		}
+		assignments = append(assignments, "version = version + 1")
+		values = append(values, id, version)
+
+		query := `UPDATE ` + table.name + ` SET ` + strings.Join(assignments, ", ") +
+			fmt.Sprintf(` WHERE player_id = $%d AND %s AND %s RETURNING player_id`, len(values)-1, liveCondition, versionCondition(len(values)))
+		err = q.QueryRow(context.Background(), query, values...).Scan(&id)
+	}
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, staleLineError(q, table.name, id, version)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return derivedLineAt(q, table, id)
+}
@@ -0,0 +1,71 @@
+package db
+
+import (
+	"math"
+
+	"github.com/e-berman/baseball_api/internal/models"
+)
+
+// fields identifying a line rather than holding its stats, which aren't ranked
+var unrankedFields = map[string]bool{"id": true, "season": true, "playerId": true}
+
+// RankedFields returns the numeric stats of a FieldSet, stored then derived, in model order
+func (fs *FieldSet) RankedFields() []Field {
+	ranked := []Field{}
+	for _, fields := range [][]Field{fs.fields, fs.derived} {
+		for _, field := range fields {
+			if field.Kind != KindString && !unrankedFields[field.Name] {
+				ranked = append(ranked, field)
+			}
+		}
+	}
+
+	return ranked
+}
+
+// Percentiles returns the percentile rank and z-score of every numeric stat of a line within a population
+// of lines, in RankedFields order
+//
+// the line doesn't need to be in the population. the stats of an empty population have no percentile or
+// z-score, and a stat the whole population shares has no z-score, rather than ranks of 0 that read as real
+func Percentiles[T any](fields *FieldSet, line *T, population []*T) []*models.StatPercentile {
+	stats := []*models.StatPercentile{}
+	for _, field := range fields.RankedFields() {
+		value := floatValue(field.ValueOf(line))
+		stat := &models.StatPercentile{Stat: field.Name, Value: value, LowerIsBetter: field.LowerIsBetter}
+		stats = append(stats, stat)
+		if len(population) == 0 {
+			continue
+		}
+
+		var worse, ties, sum float64
+		for _, other := range population {
+			otherValue := floatValue(field.ValueOf(other))
+			sum += otherValue
+
+			switch {
+			case otherValue == value:
+				ties++
+			case (otherValue < value) != field.LowerIsBetter:
+				worse++
+			}
+		}
+		n := float64(len(population))
+		percentile := int(math.Round(100 * (worse + ties/2) / n))
+		stat.Percentile = &percentile
+
+		mean, squares := sum/n, 0.0
+		for _, other := range population {
+			squares += math.Pow(floatValue(field.ValueOf(other))-mean, 2)
+		}
+		if deviation := math.Sqrt(squares / n); deviation != 0 {
+			zScore := (value - mean) / deviation
+			if field.LowerIsBetter {
+				zScore = -zScore
+			}
+			stat.ZScore = &zScore
+		}
+	}
+
+	return stats
+}
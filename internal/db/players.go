@@ -0,0 +1,183 @@
+package db
+
+import (
+	"context"
+	"errors"
+
+	"github.com/e-berman/baseball_api/internal/models"
+	"github.com/jackc/pgx/v5"
+)
+
+// externalID is an identifier of a player assigned by an outside source
+type externalID struct {
+	source string
+	column string
+	id     func(*models.Player) *string
+}
+
+// external ids of a player, in order of precedence when resolving the identity of a player
+var externalIDs = []externalID{
+	{"fangraphs", "fangraphs_id", func(p *models.Player) *string { return &p.FangraphsID }},
+	{"mlbam", "mlbam_id", func(p *models.Player) *string { return &p.MLBAMID }},
+	{"bbref", "bbref_id", func(p *models.Player) *string { return &p.BBRefID }},
+	{"retro", "retro_id", func(p *models.Player) *string { return &p.RetroID }},
+}
+
+// columns selected for a player, in scanPlayer order
+const playerColumns = `id, name, COALESCE(name_ascii, ''), COALESCE(fangraphs_id, ''),
+	COALESCE(mlbam_id, ''), COALESCE(bbref_id, ''), COALESCE(retro_id, '')`
+
+// scanPlayer scans a row of playerColumns into a Player
+func scanPlayer(row pgx.Row) (*models.Player, error) {
+	player := &models.Player{}
+	err := row.Scan(
+		&player.ID,
+		&player.Name,
+		&player.NameASCII,
+		&player.FangraphsID,
+		&player.MLBAMID,
+		&player.BBRefID,
+		&player.RetroID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return player, nil
+}
+
+// GetPlayerByID will return a player
+//
+// it will query the players table based on a given player id
+func (pool *DBPool) GetPlayerByID(id int) (*models.Player, error) {
+	query := `SELECT ` + playerColumns + ` FROM players WHERE id = $1`
+
+	player, err := scanPlayer(pool.Poolconn.QueryRow(context.Background(), query, id))
+	return player, pgError(noRowsError(err, "players", id))
+}
+
+// GetPlayerByExternalID will return a player given the id assigned to them by an outside source
+//
+// source is one of fangraphs, mlbam, bbref or retro
+func (pool *DBPool) GetPlayerByExternalID(source, id string) (*models.Player, error) {
+	for _, external := range externalIDs {
+		if external.source != source {
+			continue
+		}
+
+		query := `SELECT ` + playerColumns + ` FROM players WHERE ` + external.column + ` = $1`
+		player, err := scanPlayer(pool.Poolconn.QueryRow(context.Background(), query, id))
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, externalNotFoundError(source, id)
+		}
+		return player, pgError(err)
+	}
+
+	return nil, newError(ErrNotFound, "", "unknown player id source: "+source)
+}
+
+// hasExternalIDs reports whether a player has any external id
+func hasExternalIDs(player *models.Player) bool {
+	for _, external := range externalIDs {
+		if *external.id(player) != "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// playerRef returns the id of the player a season line belongs to
+//
+// an explicit player id is used as is, otherwise the player is resolved from the line's identity,
+// or from its name when the line has no identity
+func playerRef(q querier, playerID int, identity *models.Player, name string) (int, error) {
+	if playerID != 0 {
+		return playerID, nil
+	}
+	if identity == nil {
+		identity = &models.Player{Name: name}
+	}
+
+	return resolvePlayer(q, identity)
+}
+
+// resolvePlayer returns the id of the player with the given identity, creating the player if needed
+//
+// players are matched on their external ids first. failing that, a line is matched by name when
+// a single player has its name, so lines without ids (e.g. batters csv rows) link to the player of
+// the same name whatever ids it has, and a new player is created when the name is shared. a line
+// with ids is only matched by name to a player without ids, since a player with other ids is someone
+// else. any external ids the matched player is missing are filled in. the queries are shared by the
+// Postgres and SQLite backends
+func resolvePlayer(q querier, identity *models.Player) (int, error) {
+	ctx := context.Background()
+	id := 0
+
+	for _, external := range externalIDs {
+		value := *external.id(identity)
+		if value == "" {
+			continue
+		}
+
+		query := `SELECT id FROM players WHERE ` + external.column + ` = $1`
+		err := q.QueryRow(ctx, query, value).Scan(&id)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return 0, err
+		}
+	}
+
+	if id == 0 {
+		query := `SELECT COALESCE(MIN(id), 0), COUNT(*) FROM players WHERE name = $1`
+		if hasExternalIDs(identity) {
+			query += ` AND fangraphs_id IS NULL AND mlbam_id IS NULL AND bbref_id IS NULL AND retro_id IS NULL`
+		}
+
+		var match, players int
+		if err := q.QueryRow(ctx, query, identity.Name).Scan(&match, &players); err != nil {
+			return 0, err
+		}
+		if players == 1 {
+			id = match
+		}
+	}
+
+	if id == 0 {
+		query := `INSERT INTO players (name, name_ascii, fangraphs_id, mlbam_id, bbref_id, retro_id)
+		VALUES ($1, NULLIF(CAST($2 AS text), ''), NULLIF(CAST($3 AS text), ''), NULLIF(CAST($4 AS text), ''), NULLIF(CAST($5 AS text), ''), NULLIF(CAST($6 AS text), ''))
+		RETURNING id`
+
+		err := q.QueryRow(ctx, query,
+			identity.Name,
+			identity.NameASCII,
+			identity.FangraphsID,
+			identity.MLBAMID,
+			identity.BBRefID,
+			identity.RetroID,
+		).Scan(&id)
+
+		return id, err
+	}
+
+	query := `UPDATE players SET
+	name_ascii = COALESCE(name_ascii, NULLIF(CAST($2 AS text), '')),
+	fangraphs_id = COALESCE(fangraphs_id, NULLIF(CAST($3 AS text), '')),
+	mlbam_id = COALESCE(mlbam_id, NULLIF(CAST($4 AS text), '')),
+	bbref_id = COALESCE(bbref_id, NULLIF(CAST($5 AS text), '')),
+	retro_id = COALESCE(retro_id, NULLIF(CAST($6 AS text), ''))
+	WHERE id = $1`
+
+	_, err := q.Exec(ctx, query,
+		id,
+		identity.NameASCII,
+		identity.FangraphsID,
+		identity.MLBAMID,
+		identity.BBRefID,
+		identity.RetroID,
+	)
+
+	return id, err
+}
@@ -0,0 +1,428 @@
+package db
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/e-berman/baseball_api/internal/models"
+)
+
+// Operator is a comparison used by a list filter, e.g. the gte in homeRuns[gte]=30
+type Operator string
+
+const (
+	OpEq  Operator = "eq"
+	OpNe  Operator = "ne"
+	OpGt  Operator = "gt"
+	OpGte Operator = "gte"
+	OpLt  Operator = "lt"
+	OpLte Operator = "lte"
+	OpIn  Operator = "in"
+)
+
+// sql operators for each supported filter operator
+var operatorSQL = map[Operator]string{
+	OpEq:  "=",
+	OpNe:  "<>",
+	OpGt:  ">",
+	OpGte: ">=",
+	OpLt:  "<",
+	OpLte: "<=",
+}
+
+// ParseOperator returns the Operator for a given name
+//
+// an empty name defaults to OpEq
+func ParseOperator(name string) (Operator, error) {
+	if name == "" {
+		return OpEq, nil
+	}
+	op := Operator(name)
+	if _, ok := operatorSQL[op]; ok || op == OpIn {
+		return op, nil
+	}
+
+	return "", fmt.Errorf("unknown operator: %s", name)
+}
+
+// FieldKind is the value type stored in a column
+type FieldKind int
+
+const (
+	KindString FieldKind = iota
+	KindInt
+	KindFloat
+)
+
+// Field maps a JSON field name of a model to its column in the database
+type Field struct {
+	Name   string
+	Column string
+	Kind   FieldKind
+	// set for a stat where a lower value is better, e.g. strikeoutRate or earnedRunAvg
+	LowerIsBetter bool
+	// index of the field in the model struct, or in its Derived stats for a derived field
+	index int
+	// set for a stat derived from the stored ones, whose Column is the sql computing it
+	derived bool
+}
+
+// ValueOf returns the value of the field on a model, e.g. a *models.PositionPlayer
+//
+// a derived field is zero on a model without derived stats
+func (f Field) ValueOf(model any) any {
+	value := reflect.Indirect(reflect.ValueOf(model))
+	if f.derived {
+		value = value.FieldByName("Derived")
+		if value.IsNil() {
+			return reflect.Zero(value.Type().Elem().Field(f.index).Type).Interface()
+		}
+		value = value.Elem()
+	}
+
+	return value.Field(f.index).Interface()
+}
+
+// ParseValue converts a raw query string value into the type stored in the field's column
+func (f Field) ParseValue(raw string) (any, error) {
+	switch f.Kind {
+	case KindInt:
+		val, err := strconv.Atoi(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s expects an integer", f.Name)
+		}
+		return val, nil
+	case KindFloat:
+		val, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s expects a number", f.Name)
+		}
+		return val, nil
+	}
+
+	return raw, nil
+}
+
+// FieldSet is the whitelist of fields that may be used to query a table
+type FieldSet struct {
+	fields []Field
+	// the stats derived from the stored fields
+	derived []Field
+	lookup  map[string]Field
+}
+
+// Lookup returns the field for a JSON field name
+//
+// the column name is accepted as a shorthand, e.g. war for winsAboveReplacement
+func (fs *FieldSet) Lookup(name string) (Field, bool) {
+	field, ok := fs.lookup[name]
+	return field, ok
+}
+
+// Fields returns every field in the set in model order
+func (fs *FieldSet) Fields() []Field {
+	return fs.fields
+}
+
+// newFieldSet builds a FieldSet from the json tags of a model and a map of json name to column
+func newFieldSet(model any, columns map[string]string) *FieldSet {
+	fs := &FieldSet{lookup: map[string]Field{}}
+
+	t := reflect.TypeOf(model)
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		name := strings.Split(sf.Tag.Get("json"), ",")[0]
+		column, ok := columns[name]
+		if !ok {
+			continue
+		}
+
+		field := Field{Name: name, Column: column, Kind: fieldKind(sf.Type), index: i}
+		fs.fields = append(fs.fields, field)
+		fs.lookup[name] = field
+		fs.lookup[column] = field
+	}
+
+	return fs
+}
+
+// fieldKind returns the FieldKind of a model field of the given type
+func fieldKind(t reflect.Type) FieldKind {
+	switch t.Kind() {
+	case reflect.Int:
+		return KindInt
+	case reflect.Float64:
+		return KindFloat
+	}
+
+	return KindString
+}
+
+// withLowerIsBetter marks the stats of a FieldSet, stored or derived, where a lower value is better
+func withLowerIsBetter(fs *FieldSet, names ...string) *FieldSet {
+	lower := map[string]bool{}
+	for _, name := range names {
+		lower[name] = true
+	}
+
+	for _, fields := range [][]Field{fs.fields, fs.derived} {
+		for i := range fields {
+			fields[i].LowerIsBetter = lower[fields[i].Name]
+		}
+	}
+	for key, field := range fs.lookup {
+		field.LowerIsBetter = lower[field.Name]
+		fs.lookup[key] = field
+	}
+
+	return fs
+}
+
+// PositionPlayerFields is the whitelist of queryable position_players columns and derived stats
+var PositionPlayerFields = withLowerIsBetter(withDerived(newFieldSet(models.PositionPlayer{}, map[string]string{
+	"id":                      "player_id",
+	"name":                    "name",
+	"team":                    "team",
+	"season":                  "season",
+	"games":                   "g",
+	"plateAppearances":        "pa",
+	"homeRuns":                "hr",
+	"runs":                    "runs",
+	"runsBattedIn":            "rbi",
+	"stolenBases":             "sb",
+	"weightedRunsCreatedPlus": "wrc_plus",
+	"walkRate":                "bb_rate",
+	"strikeoutRate":           "k_rate",
+	"isolatedPower":           "iso",
+	"battingAvgBallsInPlay":   "babip",
+	"battingAvg":              "average",
+	"onBasePct":               "obp",
+	"sluggingPct":             "slg",
+	"weightedOnBaseAvg":       "woba",
+	"expWeightedOnBaseAvg":    "x_woba",
+	"baseRunning":             "bsr",
+	"winsAboveReplacement":    "war",
+	"playerId":                "player_ref",
+}), positionPlayerDerived), "strikeoutRate")
+
+// PitcherFields is the whitelist of queryable pitchers columns and derived stats
+var PitcherFields = withLowerIsBetter(withDerived(newFieldSet(models.Pitcher{}, map[string]string{
+	"id":                                 "player_id",
+	"name":                               "name",
+	"team":                               "team",
+	"season":                             "season",
+	"wins":                               "w",
+	"losses":                             "l",
+	"saves":                              "sv",
+	"games":                              "g",
+	"gamesSaved":                         "gs",
+	"inningsPitched":                     "ip",
+	"strikeoutsPerNine":                  "k9",
+	"walksPerNine":                       "bb9",
+	"homeRunsPerNine":                    "hr9",
+	"battingAvgBallsInPlay":              "babip",
+	"leftOnBase":                         "lob",
+	"groundballRate":                     "gb",
+	"homeRunToFlyBallRatio":              "hrfb",
+	"fourseamFastballVelocity":           "vfa",
+	"earnedRunAvg":                       "era",
+	"expectedEarnedRunAvg":               "xera",
+	"fielderIndependentPitching":         "fip",
+	"expectedFielderIndependentPitching": "xfip",
+	"winsAboveReplacement":               "war",
+	"playerId":                           "player_ref",
+}), pitcherDerived),
+	"losses", "walksPerNine", "homeRunsPerNine", "battingAvgBallsInPlay", "homeRunToFlyBallRatio", "earnedRunAvg",
+	"expectedEarnedRunAvg", "fielderIndependentPitching", "expectedFielderIndependentPitching",
+	"fielderIndependentPitchingMinus",
+)
+
+// Filter is a single condition applied to a list query
+//
+// Value holds the parsed value, or a []any of values for OpIn
+type Filter struct {
+	Field Field
+	Op    Operator
+	Value any
+}
+
+// SortKey orders a list query by a single field
+type SortKey struct {
+	Field Field
+	Desc  bool
+}
+
+// ListOptions narrows, orders and pages the rows returned when listing players
+//
+// every implementation of DB applies the filters, then orders by the sort keys
+// with ties broken by ascending id so that results are stable.
+// After and Before are keyset cursors, at most one is set,
+// and Limit caps the number of rows returned (0 means no limit).
+// lines in the trash are left out unless IncludeDeleted is set
+type ListOptions struct {
+	Filters        []Filter
+	Sort           []SortKey
+	Limit          int
+	After          *Cursor
+	Before         *Cursor
+	IncludeDeleted bool
+}
+
+// Page is a single page of a list query
+type Page[T any] struct {
+	Items   []T
+	HasNext bool
+	HasPrev bool
+}
+
+// whereClause compiles the filters, cursor and trash condition into a parameterized WHERE clause
+//
+// returns an empty string when there is nothing to filter on
+func whereClause(opts *ListOptions) (string, []any) {
+	conditions, args := filterConditions(opts.Filters)
+	if !opts.IncludeDeleted {
+		conditions = append(conditions, liveCondition)
+	}
+
+	if cursor := opts.cursor(); cursor != nil {
+		condition, cursorArgs := keysetCondition(opts.OrderKeys(), cursor, opts.Before != nil, len(args))
+		conditions = append(conditions, condition)
+		args = append(args, cursorArgs...)
+	}
+
+	if len(conditions) == 0 {
+		return "", nil
+	}
+
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// filterConditions compiles each filter into a parameterized SQL condition
+func filterConditions(filters []Filter) ([]string, []any) {
+	conditions := []string{}
+	args := []any{}
+	for _, filter := range filters {
+		if filter.Op == OpIn {
+			values := filter.Value.([]any)
+			placeholders := make([]string, len(values))
+			for i, value := range values {
+				args = append(args, value)
+				placeholders[i] = fmt.Sprintf("$%d", len(args))
+			}
+			conditions = append(conditions, fmt.Sprintf("%s IN (%s)", filter.Field.Column, strings.Join(placeholders, ", ")))
+			continue
+		}
+
+		args = append(args, filter.Value)
+		conditions = append(conditions, fmt.Sprintf("%s %s $%d", filter.Field.Column, operatorSQL[filter.Op], len(args)))
+	}
+
+	return conditions, args
+}
+
+// keysetCondition compiles a cursor into the condition selecting the rows after it in sort order,
+// or before it when reverse is set
+//
+// for keys (a, b) this is (a > $1) OR (a = $1 AND b > $2), with < used for descending keys
+func keysetCondition(keys []SortKey, cursor *Cursor, reverse bool, argOffset int) (string, []any) {
+	terms := []string{}
+	for i, key := range keys {
+		parts := []string{}
+		for j := 0; j < i; j++ {
+			parts = append(parts, fmt.Sprintf("%s = $%d", keys[j].Field.Column, argOffset+j+1))
+		}
+
+		op := ">"
+		if key.Desc != reverse {
+			op = "<"
+		}
+		parts = append(parts, fmt.Sprintf("%s %s $%d", key.Field.Column, op, argOffset+i+1))
+
+		terms = append(terms, "("+strings.Join(parts, " AND ")+")")
+	}
+
+	return "(" + strings.Join(terms, " OR ") + ")", cursor.Values
+}
+
+// idField is the primary key shared by every player table
+var idField = Field{Name: "id", Column: "player_id", Kind: KindInt}
+
+// OrderKeys returns the sort keys with id appended as the final tie-breaker
+//
+// keys following an explicit id key are dropped since id is already unique
+func (opts *ListOptions) OrderKeys() []SortKey {
+	keys := []SortKey{}
+	for _, key := range opts.Sort {
+		keys = append(keys, key)
+		if key.Field.Column == idField.Column {
+			return keys
+		}
+	}
+
+	return append(keys, SortKey{Field: idField})
+}
+
+// cursor returns whichever of the After and Before cursors is set
+func (opts *ListOptions) cursor() *Cursor {
+	if opts.After != nil {
+		return opts.After
+	}
+
+	return opts.Before
+}
+
+// listClauses compiles the list options into the WHERE, ORDER BY and LIMIT clauses of a list query
+//
+// when paging backwards the order is reversed so the rows nearest the cursor are selected,
+// newPage restores the order. one row beyond the limit is requested
+// to detect whether another page exists
+func listClauses(opts *ListOptions) (string, []any) {
+	where, args := whereClause(opts)
+	clauses := where + orderByClause(opts.OrderKeys(), opts.Before != nil)
+	if opts.Limit > 0 {
+		clauses += fmt.Sprintf(" LIMIT %d", opts.Limit+1)
+	}
+
+	return clauses, args
+}
+
+// newPage trims the extra row requested by listClauses and restores the order of a backwards page
+func newPage[T any](items []T, opts *ListOptions) *Page[T] {
+	page := &Page[T]{
+		HasPrev: opts.After != nil,
+		HasNext: opts.Before != nil,
+	}
+
+	more := opts.Limit > 0 && len(items) > opts.Limit
+	if more {
+		items = items[:opts.Limit]
+	}
+
+	if opts.Before != nil {
+		for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+			items[i], items[j] = items[j], items[i]
+		}
+		page.HasPrev = more
+	} else {
+		page.HasNext = more
+	}
+
+	page.Items = items
+	return page
+}
+
+// orderByClause compiles the sort keys into an ORDER BY clause, in reverse when set
+func orderByClause(keys []SortKey, reverse bool) string {
+	terms := make([]string, len(keys))
+	for i, key := range keys {
+		direction := "ASC"
+		if key.Desc != reverse {
+			direction = "DESC"
+		}
+		terms[i] = key.Field.Column + " " + direction
+	}
+
+	return " ORDER BY " + strings.Join(terms, ", ")
+}
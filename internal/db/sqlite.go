@@ -0,0 +1,825 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/e-berman/baseball_api/internal/models"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	_ "modernc.org/sqlite"
+)
+
+// SQLiteDB is an implementation of DB backed by a SQLite database, using the pure Go modernc.org/sqlite driver
+//
+// the tables are created by the same migrations as the Postgres tables, and errors are classified
+// by sqliteError into the same kinds of Error as DBPool reports
+type SQLiteDB struct {
+	conn *sql.DB
+	// who the writes are recorded as made by
+	actor string
+}
+
+// NewSQLiteDB returns a SQLiteDB for the database file at path, created if it doesn't exist
+//
+// a path of :memory: keeps the database in memory until it is closed
+func NewSQLiteDB(path string) (*SQLiteDB, error) {
+	conn, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+
+	// sqlite allows a single writer at a time, and each connection to :memory: opens a separate database
+	conn.SetMaxOpenConns(1)
+
+	if _, err := conn.Exec(`PRAGMA foreign_keys = ON`); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	log.Println("Opened sqlite db:", path)
+
+	return &SQLiteDB{conn: conn}, nil
+}
+
+// sqliteSchema returns the sqlite form of a migration
+//
+// serial columns become autoincrementing integer keys, so ids aren't reused once deleted
+func sqliteSchema(schema string) string {
+	return strings.ReplaceAll(schema, "serial primary key", "integer primary key autoincrement")
+}
+
+// WithActor returns a DB sharing the database whose writes are recorded as made by actor
+func (s *SQLiteDB) WithActor(actor string) DB {
+	return &SQLiteDB{conn: s.conn, actor: actor}
+}
+
+// author returns who the database's api writes are made by
+func (s *SQLiteDB) author() author {
+	return newAuthor(s.actor, SourceAPI)
+}
+
+// Initialize applies any schema migrations not yet applied
+func (s *SQLiteDB) Initialize() error {
+	return s.MigrateTo(LatestVersion)
+}
+
+// Close closes the database
+func (s *SQLiteDB) Close() {
+	s.conn.Close()
+}
+
+// transaction runs f in a transaction, committed when f succeeds and rolled back otherwise
+func (s *SQLiteDB) transaction(f func(*sql.Tx) error) error {
+	tx, err := s.conn.Begin()
+	if err != nil {
+		return err
+	}
+
+	if err := f(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// sqlQuerier adapts a database/sql connection or transaction to querier
+type sqlQuerier struct {
+	q interface {
+		ExecContext(context.Context, string, ...any) (sql.Result, error)
+		QueryRowContext(context.Context, string, ...any) *sql.Row
+	}
+}
+
+func (q sqlQuerier) Exec(ctx context.Context, query string, args ...any) (pgconn.CommandTag, error) {
+	res, err := q.q.ExecContext(ctx, query, args...)
+	if err != nil {
+		return pgconn.CommandTag{}, err
+	}
+
+	affected, err := res.RowsAffected()
+	return pgconn.NewCommandTag(fmt.Sprintf("UPDATE %d", affected)), err
+}
+
+func (q sqlQuerier) QueryRow(ctx context.Context, query string, args ...any) pgx.Row {
+	return sqlRow{q.q.QueryRowContext(ctx, query, args...)}
+}
+
+// sqlRow reports a missing row with pgx.ErrNoRows, as DBPool does
+type sqlRow struct {
+	row *sql.Row
+}
+
+func (r sqlRow) Scan(dest ...any) error {
+	err := r.row.Scan(dest...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return pgx.ErrNoRows
+	}
+
+	return err
+}
+
+// placeholders returns n query parameters, starting at $1
+func placeholders(n int) string {
+	params := make([]string, n)
+	for i := range params {
+		params[i] = fmt.Sprintf("$%d", i+1)
+	}
+
+	return strings.Join(params, ", ")
+}
+
+// *******************
+// Player methods
+// *******************
+
+func (s *SQLiteDB) GetPlayerByID(id int) (*models.Player, error) {
+	query := `SELECT ` + playerColumns + ` FROM players WHERE id = $1`
+
+	player, err := scanPlayer(sqlRow{s.conn.QueryRow(query, id)})
+	return player, sqliteError(noRowsError(err, "players", id))
+}
+
+func (s *SQLiteDB) GetPlayerByExternalID(source, id string) (*models.Player, error) {
+	for _, external := range externalIDs {
+		if external.source != source {
+			continue
+		}
+
+		query := `SELECT ` + playerColumns + ` FROM players WHERE ` + external.column + ` = $1`
+		player, err := scanPlayer(sqlRow{s.conn.QueryRow(query, id)})
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, externalNotFoundError(source, id)
+		}
+		return player, sqliteError(err)
+	}
+
+	return nil, newError(ErrNotFound, "", "unknown player id source: "+source)
+}
+
+// *******************
+// Season line tables
+// *******************
+
+// sqliteLines runs a query selecting a table's lines, on the database or within a transaction, scanning each with scan
+func sqliteLines[T any](q interface {
+	Query(string, ...any) (*sql.Rows, error)
+}, scan func(pgx.Row) (*T, error), query string, args ...any) ([]*T, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lines := []*T{}
+	for rows.Next() {
+		line, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		lines = append(lines, line)
+	}
+
+	return lines, rows.Err()
+}
+
+// sqliteList returns a page of a table's lines matching the list options, with their derived stats
+func sqliteList[T any](conn *sql.DB, table *lineTable[T], opts *ListOptions) (*Page[*T], error) {
+	clauses, args := listClauses(opts)
+	query := `SELECT ` + table.readColumns() + ` FROM ` + table.source() + clauses
+
+	lines, err := sqliteLines(conn, table.scanDerived, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return newPage(lines, opts), nil
+}
+
+// sqliteCount returns the number of a table's lines matching the list filters
+func sqliteCount[T any](conn *sql.DB, table *lineTable[T], opts *ListOptions) (int, error) {
+	where, args := whereClause(&ListOptions{Filters: opts.Filters, IncludeDeleted: opts.IncludeDeleted})
+	query := `SELECT count(*) FROM ` + table.source() + where
+
+	count := 0
+	err := conn.QueryRow(query, args...).Scan(&count)
+
+	return count, err
+}
+
+// sqliteGet returns the line of a table with the given id with its derived stats, unless it is in the trash
+func sqliteGet[T any](conn *sql.DB, table *lineTable[T], id int) (*T, error) {
+	query := `SELECT ` + table.readColumns() + ` FROM ` + table.source() + ` WHERE player_id = $1 AND ` + liveCondition
+
+	line, err := table.scanDerived(sqlRow{conn.QueryRow(query, id)})
+	return line, noRowsError(err, table.name, id)
+}
+
+// sqliteCareer returns every live line of the player of the line with the given id, ordered by season
+func sqliteCareer[T any](conn *sql.DB, table *lineTable[T], id int) ([]*T, error) {
+	query := `SELECT ` + table.readColumns() + ` FROM ` + table.source() + `
+	WHERE player_ref = (SELECT player_ref FROM ` + table.name + ` WHERE player_id = $1 AND ` + liveCondition + `)
+	AND ` + liveCondition + `
+	ORDER BY season, player_id`
+
+	lines, err := sqliteLines(conn, table.scanDerived, query, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 {
+		return nil, notFoundError(table.name, id)
+	}
+
+	return lines, nil
+}
+
+// sqliteInsert inserts a line unless one already exists for the same name, team and season
+//
+// returns the inserted row, or nil when the line already exists
+func sqliteInsert[T any](tx *sql.Tx, table *lineTable[T], line *T) (*T, error) {
+	fields := table.copyFields()
+	columns := make([]string, len(fields))
+	values := make([]any, len(fields))
+	for i, field := range fields {
+		columns[i] = field.Column
+		values[i] = field.ValueOf(line)
+	}
+
+	query := `INSERT INTO ` + table.name + ` (` + strings.Join(columns, ", ") + `)
+	VALUES (` + placeholders(len(fields)) + `)
+	ON CONFLICT (name, team, season) DO NOTHING
+	RETURNING ` + table.columns
+
+	inserted, err := table.scan(sqlRow{tx.QueryRow(query, values...)})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+
+	return inserted, err
+}
+
+// sqliteAdd adds a line to a table, returning the inserted row, as DBPool does
+//
+// the player is resolved in the same transaction, so a rejected line doesn't create a player
+func sqliteAdd[T any](s *SQLiteDB, table *lineTable[T], line *T) (*T, error) {
+	var added *T
+	err := s.transaction(func(tx *sql.Tx) error {
+		if err := resolveRefs(sqlQuerier{tx}, table, map[models.Player]int{}, []*T{line}); err != nil {
+			return err
+		}
+
+		var err error
+		if added, err = sqliteInsert(tx, table, line); err != nil {
+			return err
+		}
+		if added == nil {
+			return existingLineError(sqlQuerier{tx}, table.name, lineKeyOf(table.fields, line))
+		}
+
+		return recordChange(sqlQuerier{tx}, table, s.author(), ChangeCreate, nil, added)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	id, _ := table.fields.Lookup("id")
+	id.setValue(line, id.ValueOf(added))
+	*table.version(line) = *table.version(added)
+	return added, nil
+}
+
+// sqliteUpdate sets the fields of the line with the given id, except its player, returning its new version
+//
+// only the fields for which set returns true are written, and only while the line is at version.
+// a line that isn't, or is in the trash, is reported as pgx.ErrNoRows
+func sqliteUpdate[T any](q interface {
+	QueryRow(string, ...any) *sql.Row
+}, table *lineTable[T], line *T, id, version int, set func(Field) bool) (int, error) {
+	assignments := []string{}
+	values := []any{}
+	for _, field := range table.copyFields() {
+		if field.Name == "playerId" || !set(field) {
+			continue
+		}
+
+		values = append(values, field.ValueOf(line))
+		assignments = append(assignments, fmt.Sprintf("%s = $%d", field.Column, len(values)))
+	}
+	assignments = append(assignments, "version = version + 1")
+
+	values = append(values, id, version)
+	query := `UPDATE ` + table.name + ` SET ` + strings.Join(assignments, ", ") +
+		fmt.Sprintf(` WHERE player_id = $%d AND %s AND %s RETURNING version`, len(values)-1, liveCondition, versionCondition(len(values)))
+
+	updated := 0
+	err := sqlRow{q.QueryRow(query, values...)}.Scan(&updated)
+
+	return updated, err
+}
+
+// sqliteUpdateLine sets the name, team, season and stats of the line with the same id
+//
+// the line is only updated while at its version, which is set to the line's new version
+func sqliteUpdateLine[T any](s *SQLiteDB, table *lineTable[T], line *T) error {
+	idField, _ := table.fields.Lookup("id")
+	id, version := idField.ValueOf(line).(int), *table.version(line)
+
+	return s.transaction(func(tx *sql.Tx) error {
+		q := sqlQuerier{tx}
+		updated, err := recordUpdate(q, table, s.author(), id, func() (*T, error) {
+			_, err := sqliteUpdate(tx, table, line, id, version, func(Field) bool { return true })
+			if errors.Is(err, pgx.ErrNoRows) {
+				return nil, staleLineError(q, table.name, id, version)
+			}
+			if err != nil {
+				return nil, err
+			}
+
+			return lineAt(q, table, id)
+		})
+		if err != nil {
+			return err
+		}
+
+		*table.version(line) = *table.version(updated)
+		return nil
+	})
+}
+
+// sqlitePatch sets the named fields of the line with the given id as patchLine does, recording the change
+func sqlitePatch[T any](s *SQLiteDB, table *lineTable[T], id int, line *T, names []string) (*T, error) {
+	var patched *T
+	err := s.transaction(func(tx *sql.Tx) error {
+		var err error
+		patched, err = recordUpdate(sqlQuerier{tx}, table, s.author(), id, func() (*T, error) {
+			return patchLine(sqlQuerier{tx}, table, id, line, names)
+		})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return patched, nil
+}
+
+// sqliteDelete moves the line of a table with the given id to the trash while it is at version
+func sqliteDelete[T any](s *SQLiteDB, table *lineTable[T], id, version int) error {
+	return s.transaction(func(tx *sql.Tx) error {
+		_, err := trashLine(sqlQuerier{tx}, table, s.author(), id, version)
+		return err
+	})
+}
+
+// sqliteUndelete takes the line of a table with the given id out of the trash in a transaction
+func sqliteUndelete[T any](s *SQLiteDB, table *lineTable[T], id int) (*T, error) {
+	var restored *T
+	err := s.transaction(func(tx *sql.Tx) error {
+		var err error
+		restored, err = undeleteLine(sqlQuerier{tx}, table, s.author(), id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return restored, nil
+}
+
+// sqlitePurge permanently deletes the lines of a table trashed before a time, recording each line purged
+func sqlitePurge[T any](tx *sql.Tx, table *lineTable[T], before time.Time) (int, error) {
+	purged, err := sqliteLines(tx, table.scan, purgeQuery(table), before.UTC())
+	if err != nil {
+		return 0, err
+	}
+
+	for _, line := range purged {
+		if err := recordChange(sqlQuerier{tx}, table, purgeAuthor, ChangePurge, line, nil); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(purged), nil
+}
+
+// PurgeDeleted permanently deletes every line moved to the trash before the given time, as DBPool does
+func (s *SQLiteDB) PurgeDeleted(before time.Time) (*PurgeReport, error) {
+	report := &PurgeReport{}
+	err := s.transaction(func(tx *sql.Tx) error {
+		var err error
+		if report.PositionPlayers, err = sqlitePurge(tx, positionPlayerTable, before); err != nil {
+			return err
+		}
+		report.Pitchers, err = sqlitePurge(tx, pitcherTable, before)
+		return err
+	})
+	if err != nil {
+		return nil, sqliteError(err)
+	}
+
+	return report, nil
+}
+
+// sqliteHistory returns the changes of the line of a table with the given id, oldest first
+func sqliteHistory[T any](conn *sql.DB, table *lineTable[T], id int) ([]*Change[T], error) {
+	rows, err := conn.Query(historyQuery, table.name, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := []*changeRow{}
+	for rows.Next() {
+		change, err := scanChange(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		changes = append(changes, change)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return decodeHistory[T](table.name, id, changes)
+}
+
+// sqliteRestore restores the line of a table with the given id to version in a transaction
+func sqliteRestore[T any](s *SQLiteDB, table *lineTable[T], id, version int) (*T, error) {
+	var restored *T
+	err := s.transaction(func(tx *sql.Tx) error {
+		var err error
+		restored, err = restoreLine(sqlQuerier{tx}, table, s.author(), id, version)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return restored, nil
+}
+
+// sqliteBatch applies a batch of operations to a table in a single transaction, as pgBatch does
+func sqliteBatch[T any](s *SQLiteDB, table *lineTable[T], ops []*BatchOp[T], mode BatchMode) ([]*BatchResult[T], error) {
+	var results []*BatchResult[T]
+	err := s.transaction(func(tx *sql.Tx) error {
+		results = runBatch(ops, func(op *BatchOp[T]) (*T, error) {
+			if _, err := tx.Exec(`SAVEPOINT batch_op`); err != nil {
+				return nil, sqliteError(err)
+			}
+
+			line, err := batchOp(sqlQuerier{tx}, table, s.author(), op)
+			if err != nil {
+				if _, err := tx.Exec(`ROLLBACK TO batch_op`); err != nil {
+					return nil, sqliteError(err)
+				}
+			}
+			if _, err := tx.Exec(`RELEASE batch_op`); err != nil {
+				return nil, sqliteError(err)
+			}
+
+			return line, sqliteError(err)
+		})
+
+		return settleBatch(results, mode)
+	})
+	if err != nil && !errors.Is(err, errBatchFailed) {
+		return nil, sqliteError(err)
+	}
+
+	return results, nil
+}
+
+// sqliteWriter writes the lines of an import within a transaction
+//
+// sqlite runs in process, so each line is written with its own statements rather than staged
+type sqliteWriter[T any] struct {
+	tx    *sql.Tx
+	table *lineTable[T]
+	// ids of the players already resolved in the import, by identity
+	refs map[models.Player]int
+	// who the changes are recorded as made by
+	by author
+}
+
+func newSQLiteWriter[T any](tx *sql.Tx, table *lineTable[T], by author) *sqliteWriter[T] {
+	return &sqliteWriter[T]{tx: tx, table: table, refs: map[models.Player]int{}, by: by}
+}
+
+// deleteSeason permanently deletes every line of a season, including the lines in the trash,
+// returning the number of lines deleted
+func (w *sqliteWriter[T]) deleteSeason(season int) (int, error) {
+	deleted, err := sqliteLines(w.tx, w.table.scan, `DELETE FROM `+w.table.name+` WHERE season = $1 RETURNING `+w.table.columns, season)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, line := range deleted {
+		if err := recordChange(sqlQuerier{w.tx}, w.table, w.by, ChangePurge, line, nil); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(deleted), nil
+}
+
+// writeLines writes a batch of lines as bulkWriter does
+func (w *sqliteWriter[T]) writeLines(lines []*T, mode WriteMode, report *ImportReport) error {
+	if err := resolveRefs(sqlQuerier{w.tx}, w.table, w.refs, lines); err != nil {
+		return err
+	}
+
+	for _, line := range lines {
+		if mode == WriteUpsert {
+			exists, err := w.updateLine(line, report)
+			if err != nil {
+				return err
+			}
+			if exists {
+				continue
+			}
+		}
+
+		inserted, err := sqliteInsert(w.tx, w.table, line)
+		if err != nil {
+			return err
+		}
+		if inserted == nil {
+			report.Skipped++
+			continue
+		}
+
+		if err := recordChange(sqlQuerier{w.tx}, w.table, w.by, ChangeCreate, nil, inserted); err != nil {
+			return err
+		}
+		report.Inserted++
+	}
+
+	return nil
+}
+
+// updateLine updates the stats of the existing line with the same name, team and season, if they differ
+//
+// returns whether the line exists outside the trash
+func (w *sqliteWriter[T]) updateLine(line *T, report *ImportReport) (bool, error) {
+	key := lineKeyOf(w.table.fields, line)
+	query := `SELECT ` + w.table.columns + ` FROM ` + w.table.name + ` WHERE name = $1 AND team = $2 AND season = $3 AND ` + liveCondition
+
+	existing, err := w.table.scan(sqlRow{w.tx.QueryRow(query, key.name, key.team, key.season)})
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	changes := diffStats(w.table.fields, existing, line)
+	if len(changes) == 0 {
+		report.Unchanged++
+		return true, nil
+	}
+
+	idField, _ := w.table.fields.Lookup("id")
+	id := idField.ValueOf(existing).(int)
+	_, err = sqliteUpdate(w.tx, w.table, line, id, 0, func(field Field) bool { return !lineKeyFields[field.Name] })
+	if err != nil {
+		return false, err
+	}
+
+	updated, err := lineAt(sqlQuerier{w.tx}, w.table, id)
+	if err != nil {
+		return false, err
+	}
+	if err := recordChange(sqlQuerier{w.tx}, w.table, w.by, ChangeUpdate, existing, updated); err != nil {
+		return false, err
+	}
+
+	report.Updated++
+	report.Changes = append(report.Changes, &RowChange{
+		ID:     id,
+		Name:   key.name,
+		Team:   key.team,
+		Season: key.season,
+		Fields: changes,
+	})
+
+	return true, nil
+}
+
+// *******************
+// Position player methods
+// *******************
+
+func (s *SQLiteDB) AddPositionPlayer(player *models.PositionPlayer) (*models.PositionPlayer, error) {
+	added, err := sqliteAdd(s, positionPlayerTable, player)
+	return added, sqliteError(err)
+}
+
+func (s *SQLiteDB) DeletePositionPlayer(id, version int) error {
+	return sqliteError(sqliteDelete(s, positionPlayerTable, id, version))
+}
+
+func (s *SQLiteDB) UpdatePositionPlayer(player *models.PositionPlayer) error {
+	return sqliteError(sqliteUpdateLine(s, positionPlayerTable, player))
+}
+
+func (s *SQLiteDB) PatchPositionPlayer(id int, player *models.PositionPlayer, fields []string) (*models.PositionPlayer, error) {
+	patched, err := sqlitePatch(s, positionPlayerTable, id, player, fields)
+	return patched, sqliteError(err)
+}
+
+func (s *SQLiteDB) GetPositionPlayers(opts *ListOptions) (*Page[*models.PositionPlayer], error) {
+	page, err := sqliteList(s.conn, positionPlayerTable, opts)
+	return page, sqliteError(err)
+}
+
+func (s *SQLiteDB) CountPositionPlayers(opts *ListOptions) (int, error) {
+	count, err := sqliteCount(s.conn, positionPlayerTable, opts)
+	return count, sqliteError(err)
+}
+
+func (s *SQLiteDB) GetPositionPlayerByID(id int) (*models.PositionPlayer, error) {
+	player, err := sqliteGet(s.conn, positionPlayerTable, id)
+	return player, sqliteError(err)
+}
+
+func (s *SQLiteDB) GetPositionPlayerCareer(id int) ([]*models.PositionPlayer, error) {
+	lines, err := sqliteCareer(s.conn, positionPlayerTable, id)
+	return lines, sqliteError(err)
+}
+
+func (s *SQLiteDB) BatchPositionPlayers(ops []*BatchOp[models.PositionPlayer], mode BatchMode) ([]*BatchResult[models.PositionPlayer], error) {
+	return sqliteBatch(s, positionPlayerTable, ops, mode)
+}
+
+func (s *SQLiteDB) GetPositionPlayerHistory(id int) ([]*Change[models.PositionPlayer], error) {
+	changes, err := sqliteHistory(s.conn, positionPlayerTable, id)
+	return changes, sqliteError(err)
+}
+
+func (s *SQLiteDB) RestorePositionPlayer(id, version int) (*models.PositionPlayer, error) {
+	restored, err := sqliteRestore(s, positionPlayerTable, id, version)
+	return restored, sqliteError(err)
+}
+
+func (s *SQLiteDB) UndeletePositionPlayer(id int) (*models.PositionPlayer, error) {
+	restored, err := sqliteUndelete(s, positionPlayerTable, id)
+	return restored, sqliteError(err)
+}
+
+// importSQLitePositionPlayers imports a csv of position players within a transaction,
+// then recomputes the league and team averages
+func importSQLitePositionPlayers(tx *sql.Tx, by author, r io.Reader, opts *ImportOptions, report *ImportReport) error {
+	mapping, err := positionPlayerMapping(opts)
+	if err != nil {
+		return err
+	}
+
+	reader, err := newPositionPlayerReader(r, mapping, opts.Season)
+	if err != nil {
+		return err
+	}
+
+	if err := importCSV(reader, opts, report, newSQLiteWriter(tx, positionPlayerTable, by)); err != nil || opts.DryRun {
+		return err
+	}
+
+	return recomputeAverages(sqlQuerier{tx}, positionPlayerTable)
+}
+
+// ImportPositionPlayers imports a csv of position players in a single transaction
+func (s *SQLiteDB) ImportPositionPlayers(r io.Reader, opts *ImportOptions) (*ImportReport, error) {
+	report := newImportReport("", opts)
+	err := s.transaction(func(tx *sql.Tx) error {
+		return importSQLitePositionPlayers(tx, newAuthor(s.actor, SourceImport), r, opts, report)
+	})
+
+	return report, sqliteError(err)
+}
+
+// ImportPositionPlayerDataFromCSV imports every assets/batters*.csv file as DBPool does
+func (s *SQLiteDB) ImportPositionPlayerDataFromCSV(opts *ImportOptions) ([]*ImportReport, error) {
+	var reports []*ImportReport
+	err := s.transaction(func(tx *sql.Tx) error {
+		var err error
+		reports, err = importFiles("./assets/batters*.csv", opts, func(r io.Reader, opts *ImportOptions, report *ImportReport) error {
+			return importSQLitePositionPlayers(tx, newAuthor(s.actor, SourceImport), r, opts, report)
+		})
+		return err
+	})
+
+	return reports, sqliteError(err)
+}
+
+// *******************
+// Pitcher methods
+// *******************
+
+func (s *SQLiteDB) AddPitcher(player *models.Pitcher) (*models.Pitcher, error) {
+	added, err := sqliteAdd(s, pitcherTable, player)
+	return added, sqliteError(err)
+}
+
+func (s *SQLiteDB) DeletePitcher(id, version int) error {
+	return sqliteError(sqliteDelete(s, pitcherTable, id, version))
+}
+
+func (s *SQLiteDB) UpdatePitcher(player *models.Pitcher) error {
+	return sqliteError(sqliteUpdateLine(s, pitcherTable, player))
+}
+
+func (s *SQLiteDB) PatchPitcher(id int, player *models.Pitcher, fields []string) (*models.Pitcher, error) {
+	patched, err := sqlitePatch(s, pitcherTable, id, player, fields)
+	return patched, sqliteError(err)
+}
+
+func (s *SQLiteDB) GetPitchers(opts *ListOptions) (*Page[*models.Pitcher], error) {
+	page, err := sqliteList(s.conn, pitcherTable, opts)
+	return page, sqliteError(err)
+}
+
+func (s *SQLiteDB) CountPitchers(opts *ListOptions) (int, error) {
+	count, err := sqliteCount(s.conn, pitcherTable, opts)
+	return count, sqliteError(err)
+}
+
+func (s *SQLiteDB) GetPitcherByID(id int) (*models.Pitcher, error) {
+	player, err := sqliteGet(s.conn, pitcherTable, id)
+	return player, sqliteError(err)
+}
+
+func (s *SQLiteDB) GetPitcherCareer(id int) ([]*models.Pitcher, error) {
+	lines, err := sqliteCareer(s.conn, pitcherTable, id)
+	return lines, sqliteError(err)
+}
+
+func (s *SQLiteDB) BatchPitchers(ops []*BatchOp[models.Pitcher], mode BatchMode) ([]*BatchResult[models.Pitcher], error) {
+	return sqliteBatch(s, pitcherTable, ops, mode)
+}
+
+func (s *SQLiteDB) GetPitcherHistory(id int) ([]*Change[models.Pitcher], error) {
+	changes, err := sqliteHistory(s.conn, pitcherTable, id)
+	return changes, sqliteError(err)
+}
+
+func (s *SQLiteDB) RestorePitcher(id, version int) (*models.Pitcher, error) {
+	restored, err := sqliteRestore(s, pitcherTable, id, version)
+	return restored, sqliteError(err)
+}
+
+func (s *SQLiteDB) UndeletePitcher(id int) (*models.Pitcher, error) {
+	restored, err := sqliteUndelete(s, pitcherTable, id)
+	return restored, sqliteError(err)
+}
+
+// importSQLitePitchers imports a csv of pitchers within a transaction,
+// then recomputes the league and team averages
+func importSQLitePitchers(tx *sql.Tx, by author, r io.Reader, opts *ImportOptions, report *ImportReport) error {
+	mapping, err := pitcherMapping(opts)
+	if err != nil {
+		return err
+	}
+
+	reader, err := newPitcherReader(r, mapping, opts.Season)
+	if err != nil {
+		return err
+	}
+
+	if err := importCSV(reader, opts, report, newSQLiteWriter(tx, pitcherTable, by)); err != nil || opts.DryRun {
+		return err
+	}
+
+	return recomputeAverages(sqlQuerier{tx}, pitcherTable)
+}
+
+// ImportPitchers imports a csv of pitchers in a single transaction
+func (s *SQLiteDB) ImportPitchers(r io.Reader, opts *ImportOptions) (*ImportReport, error) {
+	report := newImportReport("", opts)
+	err := s.transaction(func(tx *sql.Tx) error {
+		return importSQLitePitchers(tx, newAuthor(s.actor, SourceImport), r, opts, report)
+	})
+
+	return report, sqliteError(err)
+}
+
+// ImportPitcherDataFromCSV imports every assets/pitchers*.csv file as DBPool does
+func (s *SQLiteDB) ImportPitcherDataFromCSV(opts *ImportOptions) ([]*ImportReport, error) {
+	var reports []*ImportReport
+	err := s.transaction(func(tx *sql.Tx) error {
+		var err error
+		reports, err = importFiles("./assets/pitchers*.csv", opts, func(r io.Reader, opts *ImportOptions, report *ImportReport) error {
+			return importSQLitePitchers(tx, newAuthor(s.actor, SourceImport), r, opts, report)
+		})
+		return err
+	})
+
+	return reports, sqliteError(err)
+}
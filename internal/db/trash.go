@@ -0,0 +1,172 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// liveCondition matches the lines of a table that aren't in the trash
+const liveCondition = `deleted_at IS NULL`
+
+// PurgeReport counts the lines permanently deleted from each table by a purge of the trash
+type PurgeReport struct {
+	PositionPlayers int `json:"positionPlayers"`
+	Pitchers        int `json:"pitchers"`
+}
+
+func (r *PurgeReport) String() string {
+	return fmt.Sprintf("%d position players and %d pitchers", r.PositionPlayers, r.Pitchers)
+}
+
+// purgeAuthor is who a purge of the trash is recorded as made by
+var purgeAuthor = newAuthor("", SourceMaintenance)
+
+// notInTrashError returns the ErrNotFound Error for restoring a line that isn't in the trash
+func notInTrashError(id int) error {
+	return newError(ErrNotFound, "", fmt.Sprintf("line %d isn't in the trash", id))
+}
+
+// trashLine moves the line of a table with the given id to the trash while it is at version,
+// returning the trashed line
+//
+// the trashed line is kept at a new version, hidden from reads until it is restored or purged.
+// the deletion is recorded with the line before and after it
+func trashLine[T any](q querier, table *lineTable[T], by author, id, version int) (*T, error) {
+	before, err := lineAt(q, table, id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, notFoundError(table.name, id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	query := `UPDATE ` + table.name + ` SET deleted_at = $3, version = version + 1
+	WHERE player_id = $1 AND ` + liveCondition + ` AND ` + versionCondition(2) + `
+	RETURNING ` + table.columns
+
+	trashed, err := table.scan(q.QueryRow(context.Background(), query, id, version, changeTime()))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, staleLineError(q, table.name, id, version)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return trashed, recordChange(q, table, by, ChangeDelete, before, trashed)
+}
+
+// undeleteLine takes the line of a table with the given id out of the trash, returning the restored line
+//
+// the line moves to a new version and the restore is recorded. a line that isn't in the trash
+// is reported as an ErrNotFound Error
+func undeleteLine[T any](q querier, table *lineTable[T], by author, id int) (*T, error) {
+	ctx := context.Background()
+
+	query := `SELECT ` + table.columns + ` FROM ` + table.name + ` WHERE player_id = $1 AND deleted_at IS NOT NULL`
+	before, err := table.scan(q.QueryRow(ctx, query, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, notInTrashError(id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	query = `UPDATE ` + table.name + ` SET deleted_at = NULL, version = version + 1
+	WHERE player_id = $1 RETURNING ` + table.columns
+
+	restored, err := table.scan(q.QueryRow(ctx, query, id))
+	if err != nil {
+		return nil, err
+	}
+
+	return restored, recordChange(q, table, by, ChangeRestore, before, restored)
+}
+
+// purgeQuery returns the query permanently deleting the lines of a table trashed before its first parameter
+func purgeQuery[T any](table *lineTable[T]) string {
+	return `DELETE FROM ` + table.name + ` WHERE deleted_at IS NOT NULL AND deleted_at < $1 RETURNING ` + table.columns
+}
+
+// *******************
+// Postgres
+// *******************
+
+// pgTrash moves the line of a table with the given id to the trash in a transaction
+func pgTrash[T any](pool *DBPool, table *lineTable[T], id, version int) error {
+	err := pgx.BeginFunc(context.Background(), pool.Poolconn, func(tx pgx.Tx) error {
+		_, err := trashLine(tx, table, pool.author(), id, version)
+		return err
+	})
+
+	return pgError(err)
+}
+
+// pgUndelete takes the line of a table with the given id out of the trash in a transaction
+func pgUndelete[T any](pool *DBPool, table *lineTable[T], id int) (*T, error) {
+	var restored *T
+	err := pgx.BeginFunc(context.Background(), pool.Poolconn, func(tx pgx.Tx) error {
+		var err error
+		restored, err = undeleteLine(tx, table, pool.author(), id)
+		return err
+	})
+	if err != nil {
+		return nil, pgError(err)
+	}
+
+	return restored, nil
+}
+
+// pgPurge permanently deletes the lines of a table trashed before a time, recording each line purged
+func pgPurge[T any](tx pgx.Tx, table *lineTable[T], before time.Time) (int, error) {
+	rows, err := tx.Query(context.Background(), purgeQuery(table), before.UTC())
+	if err != nil {
+		return 0, err
+	}
+
+	purged := []*T{}
+	for rows.Next() {
+		line, err := table.scan(rows)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+
+		purged = append(purged, line)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, line := range purged {
+		if err := recordChange(tx, table, purgeAuthor, ChangePurge, line, nil); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(purged), nil
+}
+
+// PurgeDeleted permanently deletes every line moved to the trash before the given time
+//
+// both tables are purged in a single transaction, and each line purged is recorded in its history
+func (pool *DBPool) PurgeDeleted(before time.Time) (*PurgeReport, error) {
+	report := &PurgeReport{}
+	err := pgx.BeginFunc(context.Background(), pool.Poolconn, func(tx pgx.Tx) error {
+		var err error
+		if report.PositionPlayers, err = pgPurge(tx, positionPlayerTable, before); err != nil {
+			return err
+		}
+		report.Pitchers, err = pgPurge(tx, pitcherTable, before)
+		return err
+	})
+	if err != nil {
+		return nil, pgError(err)
+	}
+
+	return report, nil
+}
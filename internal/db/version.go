@@ -0,0 +1,38 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// versionCondition returns the condition matching a line at the version in query parameter param
+//
+// a version of 0 matches any version, so a write that doesn't expect a version is unconditional
+func versionCondition(param int) string {
+	return fmt.Sprintf("($%d = 0 OR version = $%d)", param, param)
+}
+
+// versionMismatchError returns the ErrVersionMismatch Error for a write expecting a line at version
+func versionMismatchError(id, current, version int) error {
+	return newError(ErrVersionMismatch, "", fmt.Sprintf("line %d is at version %d, not %d", id, current, version))
+}
+
+// staleLineError returns why a write to the line with the given id, expecting version, matched no row
+//
+// the line either doesn't exist or is in the trash, an ErrNotFound Error, or was written since,
+// an ErrVersionMismatch Error
+func staleLineError(q querier, table string, id, version int) error {
+	current := 0
+	err := q.QueryRow(context.Background(), `SELECT version FROM `+table+` WHERE player_id = $1 AND `+liveCondition, id).Scan(&current)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return notFoundError(table, id)
+	}
+	if err != nil {
+		return err
+	}
+
+	return versionMismatchError(id, current, version)
+}
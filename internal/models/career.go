@@ -0,0 +1,158 @@
+package models
+
+import "math"
+
+// *************
+// Career Models
+// *************
+
+// PositionPlayerCareer holds every season line of a position player with their career totals
+type PositionPlayerCareer struct {
+	Name    string            `json:"name"`
+	Seasons []*PositionPlayer `json:"seasons"`
+	Career  *PositionPlayer   `json:"career"`
+}
+
+// PitcherCareer holds every season line of a pitcher with their career totals
+type PitcherCareer struct {
+	Name    string     `json:"name"`
+	Seasons []*Pitcher `json:"seasons"`
+	Career  *Pitcher   `json:"career"`
+}
+
+// NewPositionPlayerCareer returns the career of a position player given their season lines
+//
+// counting stats are summed and rate stats are weighted by plate appearances.
+// the career line has no id, team or season
+func NewPositionPlayerCareer(seasons []*PositionPlayer) *PositionPlayerCareer {
+	career := &PositionPlayer{}
+	var wrcPlus, bbRate, kRate, iso, babip, avg, obp, slg, woba, xWoba float64
+
+	for _, season := range seasons {
+		career.G += season.G
+		career.PA += season.PA
+		career.HR += season.HR
+		career.R += season.R
+		career.RBI += season.RBI
+		career.SB += season.SB
+		career.BsR += season.BsR
+		career.WAR += season.WAR
+
+		pa := float64(season.PA)
+		wrcPlus += float64(season.WRCPlus) * pa
+		bbRate += season.BbRate * pa
+		kRate += season.KRate * pa
+		iso += season.ISO * pa
+		babip += season.BABIP * pa
+		avg += season.AVG * pa
+		obp += season.OBP * pa
+		slg += season.SLG * pa
+		woba += season.WOBA * pa
+		xWoba += season.XWOBA * pa
+	}
+
+	if career.PA > 0 {
+		pa := float64(career.PA)
+		career.WRCPlus = int(math.Round(wrcPlus / pa))
+		career.BbRate = round(bbRate/pa, 1)
+		career.KRate = round(kRate/pa, 1)
+		career.ISO = round(iso/pa, 3)
+		career.BABIP = round(babip/pa, 3)
+		career.AVG = round(avg/pa, 3)
+		career.OBP = round(obp/pa, 3)
+		career.SLG = round(slg/pa, 3)
+		career.WOBA = round(woba/pa, 3)
+		career.XWOBA = round(xWoba/pa, 3)
+	}
+	career.BsR = round(career.BsR, 1)
+	career.WAR = round(career.WAR, 1)
+
+	res := &PositionPlayerCareer{Seasons: seasons, Career: career}
+	if len(seasons) > 0 {
+		res.Name = seasons[0].Name
+		career.Name = seasons[0].Name
+	}
+
+	return res
+}
+
+// NewPitcherCareer returns the career of a pitcher given their season lines
+//
+// counting stats are summed and rate stats are weighted by innings pitched.
+// the career line has no id, team or season
+func NewPitcherCareer(seasons []*Pitcher) *PitcherCareer {
+	career := &Pitcher{}
+	var outs int
+	var k9, bb9, hr9, babip, lob, gb, hrfb, vfa, era, xera, fip, xfip float64
+
+	for _, season := range seasons {
+		career.W += season.W
+		career.L += season.L
+		career.SV += season.SV
+		career.G += season.G
+		career.GS += season.GS
+		career.WAR += season.WAR
+
+		seasonOuts := InningsToOuts(season.IP)
+		outs += seasonOuts
+
+		ip := float64(seasonOuts) / 3
+		k9 += season.K9 * ip
+		bb9 += season.BB9 * ip
+		hr9 += season.HR9 * ip
+		babip += season.BABIP * ip
+		lob += season.LOB * ip
+		gb += season.GB * ip
+		hrfb += season.HRFB * ip
+		vfa += season.VFA * ip
+		era += season.ERA * ip
+		xera += season.XERA * ip
+		fip += season.FIP * ip
+		xfip += season.XFIP * ip
+	}
+
+	career.IP = OutsToInnings(outs)
+	if outs > 0 {
+		ip := float64(outs) / 3
+		career.K9 = round(k9/ip, 2)
+		career.BB9 = round(bb9/ip, 2)
+		career.HR9 = round(hr9/ip, 2)
+		career.BABIP = round(babip/ip, 3)
+		career.LOB = round(lob/ip, 1)
+		career.GB = round(gb/ip, 1)
+		career.HRFB = round(hrfb/ip, 1)
+		career.VFA = round(vfa/ip, 1)
+		career.ERA = round(era/ip, 2)
+		career.XERA = round(xera/ip, 2)
+		career.FIP = round(fip/ip, 2)
+		career.XFIP = round(xfip/ip, 2)
+	}
+	career.WAR = round(career.WAR, 1)
+
+	res := &PitcherCareer{Seasons: seasons, Career: career}
+	if len(seasons) > 0 {
+		res.Name = seasons[0].Name
+		career.Name = seasons[0].Name
+	}
+
+	return res
+}
+
+// InningsToOuts converts innings pitched in baseball notation to outs
+//
+// the tenths digit counts outs, so 205.1 is 205 innings and one out
+func InningsToOuts(ip float64) int {
+	whole := math.Floor(ip)
+	return int(whole)*3 + int(math.Round((ip-whole)*10))
+}
+
+// OutsToInnings converts outs to innings pitched in baseball notation
+func OutsToInnings(outs int) float64 {
+	return float64(outs/3) + float64(outs%3)/10
+}
+
+// round rounds a float to a given number of decimal places
+func round(val float64, precision int) float64 {
+	ratio := math.Pow(10, float64(precision))
+	return math.Round(val*ratio) / ratio
+}
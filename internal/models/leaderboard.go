@@ -0,0 +1,31 @@
+package models
+
+// *************
+// Leaderboard Models
+// *************
+
+// Leaderboard ranks the qualified lines of a season by a stat, best first
+type Leaderboard struct {
+	Stat string `json:"stat"`
+	// batting or pitching, the lines ranked
+	Group         string `json:"group"`
+	Season        int    `json:"season"`
+	LowerIsBetter bool   `json:"lowerIsBetter"`
+	// how a line qualified to be ranked, e.g. 3.1 plateAppearances per team game, along with the
+	// season length team games were counted over
+	Qualification string    `json:"qualification"`
+	Leaders       []*Leader `json:"leaders"`
+}
+
+// Leader is a line's place on a Leaderboard
+//
+// lines with the same value share the best rank among them and are marked Tied,
+// so ranks skip the places they take up, e.g. 1, 2, 2, 4
+type Leader struct {
+	Rank  int     `json:"rank"`
+	Tied  bool    `json:"tied"`
+	ID    int     `json:"id"`
+	Name  string  `json:"name"`
+	Team  string  `json:"team"`
+	Value float64 `json:"value"`
+}
@@ -0,0 +1,55 @@
+package models
+
+// *************
+// League Models
+// *************
+
+// LeagueAverages holds the averages of the season lines of a league, or of one of its teams, in a season
+//
+// Team is empty for the whole league. Batting or Pitching is nil when there are no lines of that kind
+type LeagueAverages struct {
+	Season   int               `json:"season"`
+	Team     string            `json:"team,omitempty"`
+	Batting  *BattingAverages  `json:"batting"`
+	Pitching *PitchingAverages `json:"pitching"`
+}
+
+// BattingAverages are the averages of position player lines
+//
+// rate stats are weighted by plate appearances. G is the most games played by any of the lines,
+// so for a team it is the number of games the team played
+type BattingAverages struct {
+	Lines   int     `json:"lines"`
+	G       int     `json:"games"`
+	PA      int     `json:"plateAppearances"`
+	WRCPlus float64 `json:"weightedRunsCreatedPlus"`
+	BbRate  float64 `json:"walkRate"`
+	KRate   float64 `json:"strikeoutRate"`
+	ISO     float64 `json:"isolatedPower"`
+	BABIP   float64 `json:"battingAvgBallsInPlay"`
+	AVG     float64 `json:"battingAvg"`
+	OBP     float64 `json:"onBasePct"`
+	SLG     float64 `json:"sluggingPct"`
+	WOBA    float64 `json:"weightedOnBaseAvg"`
+	XWOBA   float64 `json:"expWeightedOnBaseAvg"`
+}
+
+// PitchingAverages are the averages of pitcher lines
+//
+// rate stats are weighted by innings pitched
+type PitchingAverages struct {
+	Lines int     `json:"lines"`
+	IP    float64 `json:"inningsPitched"`
+	K9    float64 `json:"strikeoutsPerNine"`
+	BB9   float64 `json:"walksPerNine"`
+	HR9   float64 `json:"homeRunsPerNine"`
+	BABIP float64 `json:"battingAvgBallsInPlay"`
+	LOB   float64 `json:"leftOnBase"`
+	GB    float64 `json:"groundballRate"`
+	HRFB  float64 `json:"homeRunToFlyBallRatio"`
+	VFA   float64 `json:"fourseamFastballVelocity"`
+	ERA   float64 `json:"earnedRunAvg"`
+	XERA  float64 `json:"expectedEarnedRunAvg"`
+	FIP   float64 `json:"fielderIndependentPitching"`
+	XFIP  float64 `json:"expectedFielderIndependentPitching"`
+}
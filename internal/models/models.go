@@ -1,5 +1,7 @@
 package models
 
+import "time"
+
 // *************
 // Position Player Model
 // *************
@@ -9,6 +11,7 @@ type PositionPlayer struct {
 	ID      int     `json:"id"`
 	Name    string  `json:"name"`
 	Team    string  `json:"team"`
+	Season  int     `json:"season"`
 	G       int     `json:"games"`
 	PA      int     `json:"plateAppearances"`
 	HR      int     `json:"homeRuns"`
@@ -27,11 +30,32 @@ type PositionPlayer struct {
 	XWOBA   float64 `json:"expWeightedOnBaseAvg"`
 	BsR     float64 `json:"baseRunning"`
 	WAR     float64 `json:"winsAboveReplacement"`
+
+	// id of the player in the players table this season line belongs to
+	PlayerID int `json:"playerId"`
+	// version of the line, incremented every time it is written
+	Version int `json:"version"`
+	// when the line was moved to the trash, nil while it is live
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+	// stats computed from the stored ones, set on the lines read from the database
+	Derived *PositionPlayerDerived `json:"derived,omitempty"`
+	// identity used to link the line to a player when it's written, not returned
+	Player *Player `json:"-"`
+}
+
+// PositionPlayerDerived holds the stats of a position player computed from their stored stats
+//
+// OPS+ is adjusted to the league average OBP and SLG of the season, 100 being average
+type PositionPlayerDerived struct {
+	OPS            float64 `json:"onBasePlusSlugging"`
+	OPSPlus        int     `json:"onBasePlusSluggingPlus"`
+	WOBAMinusXWOBA float64 `json:"weightedOnBaseAvgMinusExpected"`
 }
 
 type CreatePositionPlayerRequest struct {
 	Name    string  `json:"name"`
 	Team    string  `json:"team"`
+	Season  int     `json:"season"`
 	G       int     `json:"games"`
 	PA      int     `json:"plateAppearances"`
 	HR      int     `json:"homeRuns"`
@@ -50,11 +74,14 @@ type CreatePositionPlayerRequest struct {
 	XWOBA   float64 `json:"expWeightedOnBaseAvg"`
 	BsR     float64 `json:"baseRunning"`
 	WAR     float64 `json:"winsAboveReplacement"`
+	// optional id of an existing player to link the season line to
+	PlayerID int `json:"playerId"`
 }
 
 type UpdatePositionPlayerRequest struct {
 	Name    string  `json:"name"`
 	Team    string  `json:"team"`
+	Season  int     `json:"season"`
 	G       int     `json:"games"`
 	PA      int     `json:"plateAppearances"`
 	HR      int     `json:"homeRuns"`
@@ -83,10 +110,11 @@ type DeletedPositionPlayer struct {
 	DeletedMap map[string]int
 }
 
-func NewPositionPlayer(name, team string, g, pa, hr, r, rbi, sb, wrcPlus int, bbRate, kRate, iso, babip, avg, obp, slg, woba, xWoba, bsr, war float64) *PositionPlayer {
+func NewPositionPlayer(name, team string, season, g, pa, hr, r, rbi, sb, wrcPlus int, bbRate, kRate, iso, babip, avg, obp, slg, woba, xWoba, bsr, war float64) *PositionPlayer {
 	return &PositionPlayer{
 		Name:    name,
 		Team:    team,
+		Season:  season,
 		G:       g,
 		PA:      pa,
 		HR:      hr,
@@ -114,76 +142,103 @@ func NewPositionPlayer(name, team string, g, pa, hr, r, rbi, sb, wrcPlus int, bb
 
 type Pitcher struct {
 	// Player id (auto incremented by database)
-	ID    int     `json:"id"`
-	Name  string  `json:"name"`
-	Team  string  `json:"team"`
-	W     int     `json:"wins"`
-	L     int     `json:"losses"`
-	SV    int     `json:"saves"`
-	G     int     `json:"games"`
-	GS    int     `json:"gamesSaved"`
-	IP    float64 `json:"inningsPitched"`
-	K9    float64 `json:"strikeoutsPerNine"`
-	BB9   float64 `json:"walksPerNine"`
-	HR9   float64 `json:"homeRunsPerNine"`
-	BABIP float64 `json:"battingAvgBallsInPlay"`
-	LOB   float64 `json:"leftOnBase"`
-	GB    float64 `json:"groundballRate"`
-	HRFB  float64 `json:"homeRunToFlyBallRatio"`
-	VFA   float64 `json:"fourseamFastballVelocity"`
-	ERA   float64 `json:"earnedRunAvg"`
-	XERA  float64 `json:"expectedEarnedRunAvg"`
-	FIP   float64 `json:"fielderIndependentPitching"`
-	XFIP  float64 `json:"expectedFielderIndependentPitching"`
-	WAR   float64 `json:"winsAboveReplacement"`
+	ID     int     `json:"id"`
+	Name   string  `json:"name"`
+	Team   string  `json:"team"`
+	Season int     `json:"season"`
+	W      int     `json:"wins"`
+	L      int     `json:"losses"`
+	SV     int     `json:"saves"`
+	G      int     `json:"games"`
+	GS     int     `json:"gamesSaved"`
+	IP     float64 `json:"inningsPitched"`
+	K9     float64 `json:"strikeoutsPerNine"`
+	BB9    float64 `json:"walksPerNine"`
+	HR9    float64 `json:"homeRunsPerNine"`
+	BABIP  float64 `json:"battingAvgBallsInPlay"`
+	LOB    float64 `json:"leftOnBase"`
+	GB     float64 `json:"groundballRate"`
+	HRFB   float64 `json:"homeRunToFlyBallRatio"`
+	VFA    float64 `json:"fourseamFastballVelocity"`
+	ERA    float64 `json:"earnedRunAvg"`
+	XERA   float64 `json:"expectedEarnedRunAvg"`
+	FIP    float64 `json:"fielderIndependentPitching"`
+	XFIP   float64 `json:"expectedFielderIndependentPitching"`
+	WAR    float64 `json:"winsAboveReplacement"`
+
+	// id of the player in the players table this season line belongs to
+	PlayerID int `json:"playerId"`
+	// version of the line, incremented every time it is written
+	Version int `json:"version"`
+	// when the line was moved to the trash, nil while it is live
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+	// stats computed from the stored ones, set on the lines read from the database
+	Derived *PitcherDerived `json:"derived,omitempty"`
+	// identity used to link the line to a player when it's written, not returned
+	Player *Player `json:"-"`
+}
+
+// PitcherDerived holds the stats of a pitcher computed from their stored stats
+//
+// FIP- is adjusted to the league average FIP of the season, 100 being average and lower being better.
+// K/BB is 0 for a pitcher without a walk
+type PitcherDerived struct {
+	KPerBB      float64 `json:"strikeoutToWalkRatio"`
+	KMinusBB9   float64 `json:"strikeoutsMinusWalksPerNine"`
+	ERAMinusFIP float64 `json:"earnedRunAvgMinusFip"`
+	FIPMinus    int     `json:"fielderIndependentPitchingMinus"`
 }
 
 type CreatePitcherRequest struct {
-	Name  string  `json:"name"`
-	Team  string  `json:"team"`
-	W     int     `json:"wins"`
-	L     int     `json:"losses"`
-	SV    int     `json:"saves"`
-	G     int     `json:"games"`
-	GS    int     `json:"gamesSaved"`
-	IP    float64 `json:"inningsPitched"`
-	K9    float64 `json:"strikeoutsPerNine"`
-	BB9   float64 `json:"walksPerNine"`
-	HR9   float64 `json:"homeRunsPerNine"`
-	BABIP float64 `json:"battingAvgBallsInPlay"`
-	LOB   float64 `json:"leftOnBase"`
-	GB    float64 `json:"groundballRate"`
-	HRFB  float64 `json:"homeRunToFlyBallRatio"`
-	VFA   float64 `json:"fourseamFastballVelocity"`
-	ERA   float64 `json:"earnedRunAvg"`
-	XERA  float64 `json:"expectedEarnedRunAvg"`
-	FIP   float64 `json:"fielderIndependentPitching"`
-	XFIP  float64 `json:"expectedFielderIndependentPitching"`
-	WAR   float64 `json:"winsAboveReplacement"`
+	Name   string  `json:"name"`
+	Team   string  `json:"team"`
+	Season int     `json:"season"`
+	W      int     `json:"wins"`
+	L      int     `json:"losses"`
+	SV     int     `json:"saves"`
+	G      int     `json:"games"`
+	GS     int     `json:"gamesSaved"`
+	IP     float64 `json:"inningsPitched"`
+	K9     float64 `json:"strikeoutsPerNine"`
+	BB9    float64 `json:"walksPerNine"`
+	HR9    float64 `json:"homeRunsPerNine"`
+	BABIP  float64 `json:"battingAvgBallsInPlay"`
+	LOB    float64 `json:"leftOnBase"`
+	GB     float64 `json:"groundballRate"`
+	HRFB   float64 `json:"homeRunToFlyBallRatio"`
+	VFA    float64 `json:"fourseamFastballVelocity"`
+	ERA    float64 `json:"earnedRunAvg"`
+	XERA   float64 `json:"expectedEarnedRunAvg"`
+	FIP    float64 `json:"fielderIndependentPitching"`
+	XFIP   float64 `json:"expectedFielderIndependentPitching"`
+	WAR    float64 `json:"winsAboveReplacement"`
+	// optional id of an existing player to link the season line to
+	PlayerID int `json:"playerId"`
 }
 
 type UpdatePitcherRequest struct {
-	Name  string  `json:"name"`
-	Team  string  `json:"team"`
-	W     int     `json:"wins"`
-	L     int     `json:"losses"`
-	SV    int     `json:"saves"`
-	G     int     `json:"games"`
-	GS    int     `json:"gamesSaved"`
-	IP    float64 `json:"inningsPitched"`
-	K9    float64 `json:"strikeoutsPerNine"`
-	BB9   float64 `json:"walksPerNine"`
-	HR9   float64 `json:"homeRunsPerNine"`
-	BABIP float64 `json:"battingAvgBallsInPlay"`
-	LOB   float64 `json:"leftOnBase"`
-	GB    float64 `json:"groundballRate"`
-	HRFB  float64 `json:"homeRunToFlyBallRatio"`
-	VFA   float64 `json:"fourseamFastballVelocity"`
-	ERA   float64 `json:"earnedRunAvg"`
-	XERA  float64 `json:"expectedEarnedRunAvg"`
-	FIP   float64 `json:"fielderIndependentPitching"`
-	XFIP  float64 `json:"expectedFielderIndependentPitching"`
-	WAR   float64 `json:"winsAboveReplacement"`
+	Name   string  `json:"name"`
+	Team   string  `json:"team"`
+	Season int     `json:"season"`
+	W      int     `json:"wins"`
+	L      int     `json:"losses"`
+	SV     int     `json:"saves"`
+	G      int     `json:"games"`
+	GS     int     `json:"gamesSaved"`
+	IP     float64 `json:"inningsPitched"`
+	K9     float64 `json:"strikeoutsPerNine"`
+	BB9    float64 `json:"walksPerNine"`
+	HR9    float64 `json:"homeRunsPerNine"`
+	BABIP  float64 `json:"battingAvgBallsInPlay"`
+	LOB    float64 `json:"leftOnBase"`
+	GB     float64 `json:"groundballRate"`
+	HRFB   float64 `json:"homeRunToFlyBallRatio"`
+	VFA    float64 `json:"fourseamFastballVelocity"`
+	ERA    float64 `json:"earnedRunAvg"`
+	XERA   float64 `json:"expectedEarnedRunAvg"`
+	FIP    float64 `json:"fielderIndependentPitching"`
+	XFIP   float64 `json:"expectedFielderIndependentPitching"`
+	WAR    float64 `json:"winsAboveReplacement"`
 }
 
 type UpdatedPitcher struct {
@@ -194,28 +249,54 @@ type DeletedPitcher struct {
 	DeletedMap map[string]int
 }
 
-func NewPitcher(name, team string, w, l, sv, g, gs int, ip, k9, bb9, hr9, babip, lob, gb, hrfb, vfa, era, xera, fip, xfip, war float64) *Pitcher {
+func NewPitcher(name, team string, season, w, l, sv, g, gs int, ip, k9, bb9, hr9, babip, lob, gb, hrfb, vfa, era, xera, fip, xfip, war float64) *Pitcher {
 	return &Pitcher{
-		Name:  name,
-		Team:  team,
-		W:     w,
-		L:     l,
-		SV:    sv,
-		G:     g,
-		GS:    gs,
-		IP:    ip,
-		K9:    k9,
-		BB9:   bb9,
-		HR9:   hr9,
-		BABIP: babip,
-		LOB:   lob,
-		GB:    gb,
-		HRFB:  hrfb,
-		VFA:   vfa,
-		ERA:   era,
-		XERA:  xera,
-		FIP:   fip,
-		XFIP:  xfip,
-		WAR:   war,
+		Name:   name,
+		Team:   team,
+		Season: season,
+		W:      w,
+		L:      l,
+		SV:     sv,
+		G:      g,
+		GS:     gs,
+		IP:     ip,
+		K9:     k9,
+		BB9:    bb9,
+		HR9:    hr9,
+		BABIP:  babip,
+		LOB:    lob,
+		GB:     gb,
+		HRFB:   hrfb,
+		VFA:    vfa,
+		ERA:    era,
+		XERA:   xera,
+		FIP:    fip,
+		XFIP:   xfip,
+		WAR:    war,
 	}
 }
+
+// *************
+// Player Model
+// *************
+
+// Player is the canonical identity of a player, shared by their batting and pitching season lines
+//
+// external ids are empty when unknown
+type Player struct {
+	// Player id (auto incremented by database)
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	NameASCII   string `json:"nameAscii,omitempty"`
+	FangraphsID string `json:"fangraphsId,omitempty"`
+	MLBAMID     string `json:"mlbamId,omitempty"`
+	BBRefID     string `json:"bbrefId,omitempty"`
+	RetroID     string `json:"retroId,omitempty"`
+}
+
+// PlayerProfile is a player along with every one of their batting and pitching season lines
+type PlayerProfile struct {
+	*Player
+	Batting  []*PositionPlayer `json:"batting"`
+	Pitching []*Pitcher        `json:"pitching"`
+}
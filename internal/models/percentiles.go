@@ -0,0 +1,35 @@
+package models
+
+// *************
+// Percentile Models
+// *************
+
+// Percentiles ranks every stat of a season line against the lines of its season with enough playing time
+//
+// a line without enough playing time of its own is still ranked, against the lines that have it
+type Percentiles struct {
+	ID     int    `json:"id"`
+	Name   string `json:"name"`
+	Team   string `json:"team"`
+	Season int    `json:"season"`
+	// stat a line needs at least Minimum of to be in the population, plateAppearances or inningsPitched
+	QualifyingStat string  `json:"qualifyingStat"`
+	Minimum        float64 `json:"minimum"`
+	// number of lines the stats are ranked against
+	Population int               `json:"population"`
+	Stats      []*StatPercentile `json:"stats"`
+}
+
+// StatPercentile is where a stat of a line ranks in its population
+//
+// Percentile is the share of the population the stat is better than, counting ties as half, from 0 to 100,
+// and nil for an empty population. ZScore is the number of standard deviations the stat is better than the
+// population's mean, and nil when the population has no deviation, being empty or sharing the stat.
+// both are inverted for a stat where lower is better, so higher is always better
+type StatPercentile struct {
+	Stat          string   `json:"stat"`
+	Value         float64  `json:"value"`
+	Percentile    *int     `json:"percentile"`
+	ZScore        *float64 `json:"zScore"`
+	LowerIsBetter bool     `json:"lowerIsBetter"`
+}
@@ -0,0 +1,208 @@
+package models
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// *************
+// Request Validation
+// *************
+
+// FieldError describes a single invalid field of a request body, by its JSON field path
+type FieldError struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+// first season of the National Association, the earliest season a line can be from
+const firstSeason = 1871
+
+// rate stats are rounded to three places, so derived rates may be off by a point or two
+const rateTolerance = 0.002
+
+// validator collects the FieldErrors of a request
+type validator struct {
+	errs []FieldError
+}
+
+func (v *validator) fail(field, format string, args ...any) {
+	v.errs = append(v.errs, FieldError{Field: field, Reason: fmt.Sprintf(format, args...)})
+}
+
+// required checks a string field isn't empty
+func (v *validator) required(field, value string) {
+	if value == "" {
+		v.fail(field, "is required")
+	}
+}
+
+// season checks a season is given and isn't in the future
+func (v *validator) season(field string, value int) {
+	if value == 0 {
+		v.fail(field, "is required")
+		return
+	}
+
+	latest := time.Now().Year() + 1
+	if value < firstSeason || value > latest {
+		v.fail(field, "must be between %d and %d", firstSeason, latest)
+	}
+}
+
+// count checks a counting stat isn't negative
+func (v *validator) count(field string, value int) {
+	if value < 0 {
+		v.fail(field, "must not be negative")
+	}
+}
+
+// between checks a rate stat is within a range
+func (v *validator) between(field string, value, min, max float64) {
+	if math.IsNaN(value) || value < min || value > max {
+		v.fail(field, "must be between %g and %g", min, max)
+	}
+}
+
+// check records a failed cross-field rule
+func (v *validator) check(ok bool, field, reason string) {
+	if !ok {
+		v.fail(field, reason)
+	}
+}
+
+// validatePositionPlayer checks the fields of a position player line
+//
+// percentages (walkRate, strikeoutRate) are 0 to 100, averages are fractions
+func validatePositionPlayer(p *PositionPlayer) []FieldError {
+	v := &validator{}
+
+	v.required("name", p.Name)
+	v.required("team", p.Team)
+	v.season("season", p.Season)
+
+	v.count("games", p.G)
+	v.count("plateAppearances", p.PA)
+	v.count("homeRuns", p.HR)
+	v.count("runs", p.R)
+	v.count("runsBattedIn", p.RBI)
+	v.count("stolenBases", p.SB)
+	v.count("weightedRunsCreatedPlus", p.WRCPlus)
+
+	v.between("walkRate", p.BbRate, 0, 100)
+	v.between("strikeoutRate", p.KRate, 0, 100)
+	v.between("battingAvg", p.AVG, 0, 1)
+	v.between("onBasePct", p.OBP, 0, 1)
+	v.between("battingAvgBallsInPlay", p.BABIP, 0, 1)
+	// four bases a hit at most
+	v.between("sluggingPct", p.SLG, 0, 4)
+	v.between("isolatedPower", p.ISO, 0, 3)
+	v.between("weightedOnBaseAvg", p.WOBA, 0, 3)
+	v.between("expWeightedOnBaseAvg", p.XWOBA, 0, 3)
+	v.between("baseRunning", p.BsR, -100, 100)
+	v.between("winsAboveReplacement", p.WAR, -20, 20)
+
+	v.check(p.HR <= p.PA, "homeRuns", "must not be more than plateAppearances")
+	v.check(p.R >= p.HR, "runs", "must be at least homeRuns")
+	v.check(p.RBI >= p.HR, "runsBattedIn", "must be at least homeRuns")
+	v.check(p.OBP >= p.AVG-rateTolerance, "onBasePct", "must be at least battingAvg")
+	v.check(p.SLG >= p.AVG-rateTolerance, "sluggingPct", "must be at least battingAvg")
+	v.check(math.Abs(p.ISO-(p.SLG-p.AVG)) <= rateTolerance, "isolatedPower", "must equal sluggingPct minus battingAvg")
+
+	return v.errs
+}
+
+// validatePitcher checks the fields of a pitcher line
+//
+// percentages (leftOnBase, groundballRate, homeRunToFlyBallRatio) are 0 to 100
+func validatePitcher(p *Pitcher) []FieldError {
+	v := &validator{}
+
+	v.required("name", p.Name)
+	v.required("team", p.Team)
+	v.season("season", p.Season)
+
+	v.count("wins", p.W)
+	v.count("losses", p.L)
+	v.count("saves", p.SV)
+	v.count("games", p.G)
+	v.count("gamesSaved", p.GS)
+
+	v.between("inningsPitched", p.IP, 0, 500)
+	v.between("strikeoutsPerNine", p.K9, 0, 100)
+	v.between("walksPerNine", p.BB9, 0, 100)
+	v.between("homeRunsPerNine", p.HR9, 0, 100)
+	v.between("battingAvgBallsInPlay", p.BABIP, 0, 1)
+	v.between("leftOnBase", p.LOB, 0, 100)
+	v.between("groundballRate", p.GB, 0, 100)
+	v.between("homeRunToFlyBallRatio", p.HRFB, 0, 100)
+	v.between("fourseamFastballVelocity", p.VFA, 0, 110)
+	v.between("earnedRunAvg", p.ERA, 0, 200)
+	v.between("expectedEarnedRunAvg", p.XERA, 0, 200)
+	v.between("fielderIndependentPitching", p.FIP, 0, 200)
+	v.between("expectedFielderIndependentPitching", p.XFIP, 0, 200)
+	v.between("winsAboveReplacement", p.WAR, -20, 20)
+
+	v.check(p.GS <= p.G, "gamesSaved", "must not be more than games")
+	v.check(p.W+p.L+p.SV <= p.G, "games", "must be at least wins, losses and saves combined")
+	// innings are written in thirds, e.g. 200.1 is 200 and one third
+	outs := math.Round(math.Mod(p.IP, 1) * 10)
+	v.check(outs <= 2 && math.Abs(math.Mod(p.IP, 1)*10-outs) < 1e-6, "inningsPitched", "must be whole innings plus .0, .1 or .2")
+
+	return v.errs
+}
+
+// Validate returns every invalid field of the request
+func (r *CreatePositionPlayerRequest) Validate() []FieldError {
+	errs := validatePositionPlayer(r.Line())
+	if r.PlayerID < 0 {
+		errs = append(errs, FieldError{Field: "playerId", Reason: "must not be negative"})
+	}
+
+	return errs
+}
+
+// Validate returns every invalid field of the request
+func (r *UpdatePositionPlayerRequest) Validate() []FieldError {
+	return validatePositionPlayer(r.Line())
+}
+
+// Validate returns every invalid field of the request
+func (r *CreatePitcherRequest) Validate() []FieldError {
+	errs := validatePitcher(r.Line())
+	if r.PlayerID < 0 {
+		errs = append(errs, FieldError{Field: "playerId", Reason: "must not be negative"})
+	}
+
+	return errs
+}
+
+// Validate returns every invalid field of the request
+func (r *UpdatePitcherRequest) Validate() []FieldError {
+	return validatePitcher(r.Line())
+}
+
+// Line returns the position player line of the request
+func (r *CreatePositionPlayerRequest) Line() *PositionPlayer {
+	return NewPositionPlayer(r.Name, r.Team, r.Season, r.G, r.PA, r.HR, r.R, r.RBI, r.SB, r.WRCPlus,
+		r.BbRate, r.KRate, r.ISO, r.BABIP, r.AVG, r.OBP, r.SLG, r.WOBA, r.XWOBA, r.BsR, r.WAR)
+}
+
+// Line returns the position player line of the request
+func (r *UpdatePositionPlayerRequest) Line() *PositionPlayer {
+	return NewPositionPlayer(r.Name, r.Team, r.Season, r.G, r.PA, r.HR, r.R, r.RBI, r.SB, r.WRCPlus,
+		r.BbRate, r.KRate, r.ISO, r.BABIP, r.AVG, r.OBP, r.SLG, r.WOBA, r.XWOBA, r.BsR, r.WAR)
+}
+
+// Line returns the pitcher line of the request
+func (r *CreatePitcherRequest) Line() *Pitcher {
+	return NewPitcher(r.Name, r.Team, r.Season, r.W, r.L, r.SV, r.G, r.GS, r.IP, r.K9, r.BB9, r.HR9,
+		r.BABIP, r.LOB, r.GB, r.HRFB, r.VFA, r.ERA, r.XERA, r.FIP, r.XFIP, r.WAR)
+}
+
+// Line returns the pitcher line of the request
+func (r *UpdatePitcherRequest) Line() *Pitcher {
+	return NewPitcher(r.Name, r.Team, r.Season, r.W, r.L, r.SV, r.G, r.GS, r.IP, r.K9, r.BB9, r.HR9,
+		r.BABIP, r.LOB, r.GB, r.HRFB, r.VFA, r.ERA, r.XERA, r.FIP, r.XFIP, r.WAR)
+}
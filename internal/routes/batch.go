@@ -0,0 +1,315 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+
+	"github.com/e-berman/baseball_api/internal/db"
+	"github.com/e-berman/baseball_api/internal/models"
+)
+
+// largest number of operations in a batch
+const maxBatchSize = 1000
+
+// batchOp is an operation in the body of a batch request
+//
+// a create has the line to add. an update has the id of the line and the line's new values,
+// a delete only the id. both may give the version the line is expected to be at
+type batchOp struct {
+	Op      string          `json:"op"`
+	ID      int             `json:"id"`
+	Version int             `json:"version"`
+	Line    json.RawMessage `json:"line"`
+}
+
+// Validate returns every invalid field of the operation, leaving its line to be validated on its own
+func (op *batchOp) Validate() []models.FieldError {
+	errs := []models.FieldError{}
+	action := db.BatchAction(op.Op)
+
+	switch action {
+	case db.BatchCreate:
+		if op.ID != 0 {
+			errs = append(errs, models.FieldError{Field: "id", Reason: "can't be given when creating a line"})
+		}
+		if op.Version != 0 {
+			errs = append(errs, models.FieldError{Field: "version", Reason: "can't be given when creating a line"})
+		}
+	case db.BatchUpdate, db.BatchDelete:
+		if op.ID <= 0 {
+			errs = append(errs, models.FieldError{Field: "id", Reason: "is required"})
+		}
+		if op.Version < 0 {
+			errs = append(errs, models.FieldError{Field: "version", Reason: "must not be negative"})
+		}
+	default:
+		return append(errs, models.FieldError{Field: "op", Reason: "must be create, update or delete"})
+	}
+
+	if action == db.BatchDelete && op.Line != nil {
+		errs = append(errs, models.FieldError{Field: "line", Reason: "can't be given when deleting a line"})
+	}
+	if action != db.BatchDelete && op.Line == nil {
+		errs = append(errs, models.FieldError{Field: "line", Reason: "is required"})
+	}
+
+	return errs
+}
+
+// batchResult is the outcome of an operation of a batch, at the same index as the operation
+//
+// a failed operation has the problem it failed with, with the status it would have had as a request of its own
+type batchResult[T any] struct {
+	Index  int      `json:"index"`
+	Op     string   `json:"op,omitempty"`
+	Status int      `json:"status"`
+	ID     int      `json:"id,omitempty"`
+	Line   *T       `json:"line,omitempty"`
+	Error  *problem `json:"error,omitempty"`
+}
+
+// batchResponse reports the outcome of every operation of a batch, in the order they were given
+type batchResponse[T any] struct {
+	Mode      db.BatchMode      `json:"mode"`
+	Succeeded int               `json:"succeeded"`
+	Failed    int               `json:"failed"`
+	Results   []*batchResult[T] `json:"results"`
+}
+
+// fail records the failure of an operation
+func (res *batchResponse[T]) fail(req *http.Request, i int, err error) {
+	p := newProblem(req, err)
+	res.Results[i].Status = p.Status
+	res.Results[i].Error = p
+	res.Failed++
+}
+
+// batchTable describes how the operations of a table's batch are decoded and written
+type batchTable[T any] struct {
+	kind string
+	// create decodes and validates the line of a create operation
+	create func([]byte) (*T, error)
+	// update decodes and validates the line of an update operation
+	update func([]byte) (*T, error)
+	write  func([]*db.BatchOp[T], db.BatchMode) ([]*db.BatchResult[T], error)
+	id     func(*T) int
+}
+
+// handleBatch creates, updates and deletes the lines of a table in a single transaction
+//
+// the body is a json array of operations. every operation is validated before any is written: in
+// atomic mode, the default, nothing is written when any operation is invalid or fails, while in
+// partial mode every valid operation is tried and the ones that succeed are kept. responds with
+// 200 when every operation succeeded and 207 Multi-Status otherwise, with a result for each
+func handleBatch[T any](rw http.ResponseWriter, req *http.Request, table *batchTable[T]) error {
+	mode, err := parseBatchQuery(req.URL.Query())
+	if err != nil {
+		return err
+	}
+
+	data, err := readBody(req)
+	if err != nil {
+		return err
+	}
+	raw := []json.RawMessage{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return badRequest(fmt.Errorf("request body must be a json array of operations: %w", err))
+	}
+	if len(raw) == 0 {
+		return badRequest(errors.New("batch has no operations"))
+	}
+	if len(raw) > maxBatchSize {
+		return &statusErr{status: http.StatusRequestEntityTooLarge, err: fmt.Errorf("batch has more than %d operations", maxBatchSize)}
+	}
+
+	res := &batchResponse[T]{Mode: mode, Results: make([]*batchResult[T], len(raw))}
+	ops := []*db.BatchOp[T]{}
+	// index of each decoded operation in the batch
+	indexes := []int{}
+	for i, data := range raw {
+		res.Results[i] = &batchResult[T]{Index: i}
+
+		op, err := decodeBatchOp(data, table, res.Results[i])
+		if err != nil {
+			res.fail(req, i, err)
+			continue
+		}
+
+		ops = append(ops, op)
+		indexes = append(indexes, i)
+	}
+
+	if mode == db.BatchAtomic && res.Failed > 0 {
+		for _, i := range indexes {
+			res.fail(req, i, db.ErrRolledBack)
+		}
+		ops = nil
+	}
+
+	if len(ops) > 0 {
+		written, err := table.write(ops, mode)
+		if err != nil {
+			return err
+		}
+
+		for j, result := range written {
+			i := indexes[j]
+			if result.Err != nil {
+				res.fail(req, i, result.Err)
+				continue
+			}
+
+			res.Succeeded++
+			res.Results[i].Status = http.StatusOK
+			if ops[j].Action == db.BatchCreate {
+				res.Results[i].Status = http.StatusCreated
+			}
+			if result.Line != nil {
+				res.Results[i].ID = table.id(result.Line)
+				res.Results[i].Line = result.Line
+			}
+		}
+	}
+
+	log.Printf("POST batch %s (%s): %d succeeded, %d failed", table.kind, mode, res.Succeeded, res.Failed)
+
+	if res.Failed > 0 {
+		return ToJSON(rw, http.StatusMultiStatus, res)
+	}
+	return ToJSON(rw, http.StatusOK, res)
+}
+
+// decodeBatchOp decodes and validates an operation of a batch, recording its op and id in result
+//
+// the invalid fields of its line are reported under line, e.g. line.homeRuns
+func decodeBatchOp[T any](data []byte, table *batchTable[T], result *batchResult[T]) (*db.BatchOp[T], error) {
+	op := batchOp{}
+	err := decodeObject(data, &op)
+	result.Op, result.ID = op.Op, op.ID
+	if err != nil {
+		return nil, err
+	}
+
+	write := &db.BatchOp[T]{Action: db.BatchAction(op.Op), ID: op.ID, Version: op.Version}
+	switch write.Action {
+	case db.BatchCreate:
+		write.Line, err = table.create(op.Line)
+	case db.BatchUpdate:
+		write.Line, err = table.update(op.Line)
+	}
+
+	var body *invalidBodyErr
+	if errors.As(err, &body) {
+		for i := range body.Fields {
+			body.Fields[i].Field = "line." + body.Fields[i].Field
+		}
+	}
+
+	return write, err
+}
+
+// parseBatchQuery returns the mode given in the query string of a batch, atomic or partial
+func parseBatchQuery(query url.Values) (db.BatchMode, error) {
+	mode := db.BatchAtomic
+	invalid := []queryParamErr{}
+
+	// sorted so errors are reported in a stable order
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		raw := query.Get(key)
+
+		var err error
+		switch key {
+		case "mode":
+			mode, err = db.ParseBatchMode(raw)
+		default:
+			err = fmt.Errorf("unknown parameter")
+		}
+
+		if err != nil {
+			invalid = append(invalid, queryParamErr{Param: key, Value: raw, Reason: err.Error()})
+		}
+	}
+
+	if len(invalid) > 0 {
+		return "", &invalidQueryErr{Params: invalid}
+	}
+
+	return mode, nil
+}
+
+// *******************
+// Handlers
+// *******************
+
+// handleBatchPositionPlayers creates, updates and deletes position players in a single transaction
+func (s *Server) handleBatchPositionPlayers(rw http.ResponseWriter, req *http.Request) error {
+	writer, err := s.writer(req)
+	if err != nil {
+		return err
+	}
+	return handleBatch(rw, req, &batchTable[models.PositionPlayer]{
+		kind: "position players",
+		create: func(data []byte) (*models.PositionPlayer, error) {
+			create := models.CreatePositionPlayerRequest{}
+			if err := decodeObject(data, &create); err != nil {
+				return nil, err
+			}
+
+			player := create.Line()
+			player.PlayerID = create.PlayerID
+			return player, nil
+		},
+		update: func(data []byte) (*models.PositionPlayer, error) {
+			update := models.UpdatePositionPlayerRequest{}
+			if err := decodeObject(data, &update); err != nil {
+				return nil, err
+			}
+
+			return update.Line(), nil
+		},
+		write: writer.BatchPositionPlayers,
+		id:    func(player *models.PositionPlayer) int { return player.ID },
+	})
+}
+
+// handleBatchPitchers creates, updates and deletes pitchers in a single transaction
+func (s *Server) handleBatchPitchers(rw http.ResponseWriter, req *http.Request) error {
+	writer, err := s.writer(req)
+	if err != nil {
+		return err
+	}
+	return handleBatch(rw, req, &batchTable[models.Pitcher]{
+		kind: "pitchers",
+		create: func(data []byte) (*models.Pitcher, error) {
+			create := models.CreatePitcherRequest{}
+			if err := decodeObject(data, &create); err != nil {
+				return nil, err
+			}
+
+			player := create.Line()
+			player.PlayerID = create.PlayerID
+			return player, nil
+		},
+		update: func(data []byte) (*models.Pitcher, error) {
+			update := models.UpdatePitcherRequest{}
+			if err := decodeObject(data, &update); err != nil {
+				return nil, err
+			}
+
+			return update.Line(), nil
+		},
+		write: writer.BatchPitchers,
+		id:    func(player *models.Pitcher) int { return player.ID },
+	})
+}
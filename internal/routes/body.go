@@ -0,0 +1,162 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/e-berman/baseball_api/internal/models"
+)
+
+// largest request body decoded as json
+const maxBodySize = 1 << 20
+
+// error type returned when a request body has invalid fields, listing every one of them
+type invalidBodyErr struct {
+	Fields []models.FieldError
+}
+
+func (e *invalidBodyErr) Error() string {
+	return "invalid request body"
+}
+
+// validatable is a request body that checks its own fields
+type validatable interface {
+	Validate() []models.FieldError
+}
+
+// decodeBody decodes the json body of a request into v and validates it
+//
+// unknown fields, values of the wrong type and every field failing v's validation are reported
+// together in an invalidBodyErr. a body that isn't json at all is a bad request
+func decodeBody(req *http.Request, v validatable) error {
+	data, err := readBody(req)
+	if err != nil {
+		return err
+	}
+
+	return decodeObject(data, v)
+}
+
+// readBody reads the body of a request, up to maxBodySize
+func readBody(req *http.Request) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(req.Body, maxBodySize+1))
+	if err != nil {
+		return nil, badRequest(err)
+	}
+	if len(data) > maxBodySize {
+		return nil, &statusErr{status: http.StatusRequestEntityTooLarge, err: fmt.Errorf("request body is larger than %d bytes", maxBodySize)}
+	}
+
+	return data, nil
+}
+
+// decodeObject decodes a json object into v and validates it, as decodeBody does
+func decodeObject(data []byte, v validatable) error {
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return badRequest(fmt.Errorf("request body must be a json object: %w", err))
+	}
+
+	invalid := unknownFields(fields, v)
+	mistyped, err := decodeFields(fields, v)
+	if err != nil {
+		return err
+	}
+	invalid = append(invalid, mistyped...)
+
+	// fields of the wrong type are reported as such rather than by validation
+	reported := map[string]bool{}
+	for _, field := range mistyped {
+		reported[field.Field] = true
+	}
+	for _, field := range v.Validate() {
+		if !reported[field.Field] {
+			invalid = append(invalid, field)
+		}
+	}
+	if len(invalid) > 0 {
+		return &invalidBodyErr{Fields: invalid}
+	}
+
+	return nil
+}
+
+// decodeFields decodes each field of a json object that v has a field for on its own, returning a
+// FieldError for every one of the wrong type, which is left as it was
+//
+// a json.Decoder stops at the first value of the wrong type, so the fields are decoded one by one
+// to report all of them
+func decodeFields(fields map[string]json.RawMessage, v any) ([]models.FieldError, error) {
+	value := reflect.ValueOf(v).Elem()
+
+	invalid := []models.FieldError{}
+	for i := 0; i < value.NumField(); i++ {
+		name := jsonName(value.Type().Field(i))
+		raw, ok := fields[name]
+		if !ok {
+			continue
+		}
+
+		decoded := reflect.New(value.Field(i).Type())
+		decoded.Elem().Set(value.Field(i))
+		err := json.Unmarshal(raw, decoded.Interface())
+
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			invalid = append(invalid, models.FieldError{Field: name, Reason: "must be " + jsonType(typeErr.Type)})
+			continue
+		}
+		if err != nil {
+			return nil, badRequest(err)
+		}
+		value.Field(i).Set(decoded.Elem())
+	}
+
+	return invalid, nil
+}
+
+// jsonName returns the name of a struct field in json
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	return name
+}
+
+// unknownFields returns a FieldError for every field of a json object that v has no field for
+func unknownFields(fields map[string]json.RawMessage, v any) []models.FieldError {
+	known := map[string]bool{}
+	t := reflect.TypeOf(v).Elem()
+	for i := 0; i < t.NumField(); i++ {
+		known[jsonName(t.Field(i))] = true
+	}
+
+	invalid := []models.FieldError{}
+	for name := range fields {
+		if !known[name] {
+			invalid = append(invalid, models.FieldError{Field: name, Reason: "unknown field"})
+		}
+	}
+	// sorted so errors are reported in a stable order
+	sort.Slice(invalid, func(i, j int) bool { return invalid[i].Field < invalid[j].Field })
+
+	return invalid
+}
+
+// jsonType describes the json type of a Go value, e.g. an integer
+func jsonType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int64:
+		return "an integer"
+	case reflect.Float64:
+		return "a number"
+	case reflect.String:
+		return "a string"
+	}
+
+	return "a " + t.String()
+}
@@ -0,0 +1,207 @@
+package routes
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/e-berman/baseball_api/internal/db"
+	"github.com/e-berman/baseball_api/internal/models"
+)
+
+// problem is an RFC 7807 problem details body, sent as application/problem+json for every failed request
+type problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	// id of the request, also sent in the X-Request-ID header and logged with server errors
+	RequestID     string              `json:"requestId,omitempty"`
+	InvalidParams []queryParamErr     `json:"invalidParams,omitempty"`
+	InvalidFields []models.FieldError `json:"invalidFields,omitempty"`
+	Report        *db.ImportReport    `json:"report,omitempty"`
+	// id of the line a created line collides with
+	ExistingID int `json:"existingId,omitempty"`
+}
+
+// statusErr is an error reported with a given status, e.g. a request body that can't be decoded
+type statusErr struct {
+	status int
+	err    error
+}
+
+func (e *statusErr) Error() string {
+	return e.err.Error()
+}
+
+func (e *statusErr) Unwrap() error {
+	return e.err
+}
+
+// badRequest reports err with a 400 Bad Request
+func badRequest(err error) error {
+	return &statusErr{status: http.StatusBadRequest, err: err}
+}
+
+// unsupportedMediaType reports err with a 415 Unsupported Media Type
+func unsupportedMediaType(err error) error {
+	return &statusErr{status: http.StatusUnsupportedMediaType, err: err}
+}
+
+// methodErr is returned when a route doesn't support the method of a request
+type methodErr struct {
+	method  string
+	allowed []string
+}
+
+func (e *methodErr) Error() string {
+	return fmt.Sprintf("method %s not allowed, expected %s", e.method, strings.Join(e.allowed, " or "))
+}
+
+// newMethodErr returns a methodErr for a request, listing the methods the route allows
+func newMethodErr(req *http.Request, allowed ...string) error {
+	return &methodErr{method: req.Method, allowed: allowed}
+}
+
+// newProblem returns the problem reported for an error
+//
+// invalid request bodies are reported as 422, listing every invalid field. db errors are reported
+// by kind: ErrNotFound as 404, ErrConflict as 409 with the id of the existing line, ErrVersionMismatch
+// as 412, ErrConstraint and invalid import rows as 422 and ErrUnavailable as 503. an operation of a
+// batch rolled back because another failed is reported as 424. errors of no known kind are reported
+// as 500 without their detail, which is only logged
+func newProblem(req *http.Request, err error) *problem {
+	p := &problem{
+		Type:      "about:blank",
+		Status:    http.StatusBadRequest,
+		Detail:    err.Error(),
+		Instance:  req.URL.Path,
+		RequestID: requestIDFrom(req.Context()),
+	}
+
+	var status *statusErr
+	var method *methodErr
+	var query *invalidQueryErr
+	var body *invalidBodyErr
+	var rows *importErr
+	var dbErr *db.Error
+	switch {
+	case errors.As(err, &status):
+		p.Status = status.status
+	case errors.As(err, &method):
+		p.Status = http.StatusMethodNotAllowed
+	case errors.As(err, &query):
+		p.InvalidParams = query.Params
+	case errors.As(err, &body):
+		p.Status = http.StatusUnprocessableEntity
+		p.InvalidFields = body.Fields
+	case errors.As(err, &rows):
+		p.Status = http.StatusUnprocessableEntity
+		p.Report = rows.report
+	case errors.Is(err, db.ErrRolledBack):
+		p.Status = http.StatusFailedDependency
+	case errors.Is(err, db.ErrNotFound):
+		p.Status = http.StatusNotFound
+	case errors.Is(err, db.ErrConflict):
+		p.Status = http.StatusConflict
+		if errors.As(err, &dbErr) {
+			p.ExistingID = dbErr.ID
+		}
+	case errors.Is(err, db.ErrVersionMismatch):
+		p.Status = http.StatusPreconditionFailed
+	case errors.Is(err, db.ErrConstraint):
+		p.Status = http.StatusUnprocessableEntity
+	case errors.Is(err, db.ErrInvalidCSV):
+		p.Status = http.StatusBadRequest
+	case errors.Is(err, db.ErrUnavailable):
+		p.Status = http.StatusServiceUnavailable
+		p.Detail = "the database is unavailable, try again later"
+	default:
+		p.Status = http.StatusInternalServerError
+		p.Detail = "an unexpected error occurred"
+	}
+
+	p.Title = http.StatusText(p.Status)
+	return p
+}
+
+// writeProblem responds with the problem reported for an error
+func writeProblem(rw http.ResponseWriter, req *http.Request, err error) {
+	p := newProblem(req, err)
+	if p.Status >= http.StatusInternalServerError {
+		log.Printf("request %s: %s %s failed: %v", p.RequestID, req.Method, req.URL.Path, err)
+	}
+
+	var method *methodErr
+	if errors.As(err, &method) {
+		rw.Header().Set("Allow", strings.Join(method.allowed, ", "))
+	}
+
+	rw.Header().Set("Content-Type", "application/problem+json")
+	rw.WriteHeader(p.Status)
+	json.NewEncoder(rw).Encode(p)
+}
+
+// *******************
+// Request ids
+// *******************
+
+// the header carrying the id of a request
+const requestIDHeader = "X-Request-ID"
+
+// longest request id accepted from a client
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+// withRequestID gives every request an id, kept in its context and sent back in the X-Request-ID header
+//
+// the id given by the client is used when it is valid, so a request can be traced through a proxy
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		id := req.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+
+		rw.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(rw, req.WithContext(context.WithValue(req.Context(), requestIDKey{}, id)))
+	})
+}
+
+// validRequestID reports whether a request id given by a client is safe to log and echo back
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+
+	for _, c := range id {
+		isAlnum := c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+		if !isAlnum && !strings.ContainsRune("-_.:", c) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// newRequestID returns a random request id
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
+
+// requestIDFrom returns the id of the request with the given context
+func requestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
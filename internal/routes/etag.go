@@ -0,0 +1,62 @@
+package routes
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// etag returns the entity tag of a line at a version
+func etag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// etagMatches reports whether an If-Match or If-None-Match header lists a tag, * matching any tag
+//
+// weak tags, e.g. W/"3", only match when compared weakly, as If-None-Match does
+func etagMatches(header, tag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == "*" || candidate == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ifMatch returns the version a write to a line at version must be made against
+//
+// a request with an If-Match header is written only while the line is still at version, and fails
+// with 412 Precondition Failed when the header doesn't match it. a request without one is written
+// whatever the line's version, so the version returned is 0
+func ifMatch(req *http.Request, version int) (int, error) {
+	header := req.Header.Get("If-Match")
+	if header == "" {
+		return 0, nil
+	}
+	if !etagMatches(header, etag(version), false) {
+		return 0, &statusErr{status: http.StatusPreconditionFailed, err: fmt.Errorf("the line is at %s, not %s", etag(version), header)}
+	}
+
+	return version, nil
+}
+
+// writeLine responds with a line and its ETag
+//
+// when the request has an If-None-Match header matching the ETag, it responds with 304 Not Modified instead
+func writeLine(rw http.ResponseWriter, req *http.Request, version int, line any) error {
+	tag := etag(version)
+	rw.Header().Set("ETag", tag)
+
+	if header := req.Header.Get("If-None-Match"); header != "" && etagMatches(header, tag, true) {
+		rw.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+
+	return ToJSON(rw, http.StatusOK, line)
+}
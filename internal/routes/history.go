@@ -0,0 +1,166 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/e-berman/baseball_api/internal/db"
+)
+
+// longest actor accepted in the X-Actor header, in characters
+const maxActorLength = 128
+
+// actor recorded for writes made without an X-Actor header
+const anonymousActor = "anonymous"
+
+// actorOf returns who a request is made by, as named by its X-Actor header
+//
+// a request without one is made by anonymous. the actor is recorded in the history of every line
+// the request writes, so it is limited to printable characters
+func actorOf(req *http.Request) (string, error) {
+	actor := strings.TrimSpace(req.Header.Get("X-Actor"))
+	if actor == "" {
+		return anonymousActor, nil
+	}
+
+	if !utf8.ValidString(actor) {
+		return "", badRequest(errors.New("X-Actor header must be valid utf-8"))
+	}
+	if utf8.RuneCountInString(actor) > maxActorLength {
+		return "", badRequest(fmt.Errorf("X-Actor header must be at most %d characters", maxActorLength))
+	}
+	for _, r := range actor {
+		if unicode.IsControl(r) {
+			return "", badRequest(errors.New("X-Actor header must not contain control characters"))
+		}
+	}
+
+	return actor, nil
+}
+
+// writer returns the DB the writes of a request are made through, recorded as made by its actor
+func (s *Server) writer(req *http.Request) (db.DB, error) {
+	actor, err := actorOf(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.db.WithActor(actor), nil
+}
+
+// getVersionFromPath returns the version to restore a line to
+//
+// parses the version from the url path, i.e. the segment after /api/<players>/<id>/history/
+func getVersionFromPath(req *http.Request) (int, error) {
+	segments := strings.Split(req.URL.Path, "/")
+	if len(segments) < 7 || segments[4] != "history" || segments[6] != "restore" {
+		return -1, &statusErr{status: http.StatusNotFound, err: fmt.Errorf("no route for path: %s", req.URL.Path)}
+	}
+
+	version, err := strconv.Atoi(segments[5])
+	if err != nil || version <= 0 {
+		return -1, badRequest(fmt.Errorf("invalid version: %s", segments[5]))
+	}
+
+	return version, nil
+}
+
+// *******************
+// Handlers
+// *******************
+
+// handleGetPositionPlayerHistory returns every change made to a position player line, oldest first
+func (s *Server) handleGetPositionPlayerHistory(rw http.ResponseWriter, req *http.Request) error {
+	id, err := s.getIDFromPath(req)
+	if err != nil {
+		return err
+	}
+
+	history, err := s.db.GetPositionPlayerHistory(id)
+	if err != nil {
+		return err
+	}
+
+	log.Println("GET history of player id:", id)
+
+	return ToJSON(rw, http.StatusOK, history)
+}
+
+// handleRestorePositionPlayer sets a position player line back to a version from its history
+//
+// responds with the restored line, which is at a new version
+func (s *Server) handleRestorePositionPlayer(rw http.ResponseWriter, req *http.Request) error {
+	writer, err := s.writer(req)
+	if err != nil {
+		return err
+	}
+	id, err := s.getIDFromPath(req)
+	if err != nil {
+		return err
+	}
+	version, err := getVersionFromPath(req)
+	if err != nil {
+		return err
+	}
+
+	restored, err := writer.RestorePositionPlayer(id, version)
+	if err != nil {
+		return err
+	}
+
+	log.Println("RESTORE player id:", id, "to version", version)
+	rw.Header().Set("ETag", etag(restored.Version))
+
+	return ToJSON(rw, http.StatusOK, restored)
+}
+
+// handleGetPitcherHistory returns every change made to a pitcher line, oldest first
+func (s *Server) handleGetPitcherHistory(rw http.ResponseWriter, req *http.Request) error {
+	id, err := s.getIDFromPath(req)
+	if err != nil {
+		return err
+	}
+
+	history, err := s.db.GetPitcherHistory(id)
+	if err != nil {
+		return err
+	}
+
+	log.Println("GET history of pitcher id:", id)
+
+	return ToJSON(rw, http.StatusOK, history)
+}
+
+// handleRestorePitcher sets a pitcher line back to a version from its history
+//
+// responds with the restored line, which is at a new version
+func (s *Server) handleRestorePitcher(rw http.ResponseWriter, req *http.Request) error {
+	writer, err := s.writer(req)
+	if err != nil {
+		return err
+	}
+	id, err := s.getIDFromPath(req)
+	if err != nil {
+		return err
+	}
+	version, err := getVersionFromPath(req)
+	if err != nil {
+		return err
+	}
+
+	restored, err := writer.RestorePitcher(id, version)
+	if err != nil {
+		return err
+	}
+
+	log.Println("RESTORE pitcher id:", id, "to version", version)
+	rw.Header().Set("ETag", etag(restored.Version))
+
+	return ToJSON(rw, http.StatusOK, restored)
+}
@@ -0,0 +1,183 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/e-berman/baseball_api/internal/db"
+)
+
+// an upload is given longer than the server's read and write timeouts to be streamed in and imported
+const importTimeout = 5 * time.Minute
+
+// error type returned when a strict import finds invalid rows, along with the report of every row
+type importErr struct {
+	err    error
+	report *db.ImportReport
+}
+
+func (e *importErr) Error() string {
+	return e.err.Error()
+}
+
+func (e *importErr) Unwrap() error {
+	return e.err
+}
+
+// importer imports a csv stream into a table
+type importer func(io.Reader, *db.ImportOptions) (*db.ImportReport, error)
+
+// handleImport handles the csv upload routes
+//
+// a csv of position players is posted to /api/import/position_players and a csv of
+// pitchers to /api/import/pitchers
+func (s *Server) handleImport(rw http.ResponseWriter, req *http.Request) error {
+	if req.Method != http.MethodPost {
+		return newMethodErr(req, http.MethodPost)
+	}
+	writer, err := s.writer(req)
+	if err != nil {
+		return err
+	}
+
+	switch req.URL.Path {
+	case "/api/import/position_players":
+		return s.handleImportCSV(rw, req, "position players", writer.ImportPositionPlayers)
+	case "/api/import/pitchers":
+		return s.handleImportCSV(rw, req, "pitchers", writer.ImportPitchers)
+	}
+
+	return &statusErr{status: http.StatusNotFound, err: fmt.Errorf("no import for path: %s", req.URL.Path)}
+}
+
+// handleImportCSV imports the csv in the body of a request and responds with the import report
+//
+// the body is either the csv itself, sent as text/csv, or a multipart form with the csv in
+// its file field. the csv is read a row at a time as it is uploaded
+func (s *Server) handleImportCSV(rw http.ResponseWriter, req *http.Request, kind string, importCSV importer) error {
+	opts, err := parseImportQuery(req.URL.Query())
+	if err != nil {
+		return err
+	}
+
+	// not every ResponseWriter supports deadlines, in which case the server timeouts apply
+	rc := http.NewResponseController(rw)
+	rc.SetReadDeadline(time.Now().Add(importTimeout))
+	rc.SetWriteDeadline(time.Now().Add(importTimeout))
+
+	body, filename, err := csvBody(req)
+	if err != nil {
+		return err
+	}
+	if opts.Season == 0 {
+		opts.Season = db.SeasonFromFilename(filename)
+	}
+
+	report, err := importCSV(body, opts)
+	if report != nil {
+		report.Source = filename
+	}
+	if errors.Is(err, db.ErrInvalidRows) {
+		log.Println("POST import", kind, "failed:", report)
+		return &importErr{err: err, report: report}
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Println("POST import", kind+":", report)
+
+	return ToJSON(rw, http.StatusOK, report)
+}
+
+// csvBody returns the csv uploaded in a request along with its file name, if it has one
+func csvBody(req *http.Request) (io.Reader, string, error) {
+	mediaType, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, "", unsupportedMediaType(fmt.Errorf("invalid content type: %w", err))
+	}
+
+	switch mediaType {
+	case "text/csv", "application/csv":
+		return req.Body, "", nil
+	case "multipart/form-data":
+		reader, err := req.MultipartReader()
+		if err != nil {
+			return nil, "", badRequest(err)
+		}
+
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				return nil, "", badRequest(errors.New("multipart form has no file field"))
+			}
+			if err != nil {
+				return nil, "", badRequest(err)
+			}
+
+			if part.FormName() == "file" {
+				return part, part.FileName(), nil
+			}
+		}
+	}
+
+	return nil, "", unsupportedMediaType(fmt.Errorf("unsupported content type: %s, expected text/csv or multipart/form-data", mediaType))
+}
+
+// parseImportQuery returns the import options given in the query string of an upload
+//
+// mode is insert, upsert or replace and validation is lenient or strict. dryRun=true reports
+// on every row without writing any. season sets the season of a csv without a Season column
+func parseImportQuery(query url.Values) (*db.ImportOptions, error) {
+	opts := &db.ImportOptions{Mode: db.ImportLenient, Write: db.WriteInsert}
+	invalid := []queryParamErr{}
+
+	// sorted so errors are reported in a stable order
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		raw := query.Get(key)
+
+		var err error
+		switch key {
+		case "mode":
+			opts.Write, err = db.ParseWriteMode(raw)
+		case "validation":
+			opts.Mode, err = db.ParseImportMode(raw)
+		case "dryRun":
+			opts.DryRun, err = strconv.ParseBool(raw)
+			if err != nil {
+				err = fmt.Errorf("dryRun expects true or false")
+			}
+		case "season":
+			opts.Season, err = strconv.Atoi(raw)
+			if err != nil || opts.Season <= 0 {
+				err = fmt.Errorf("season must be a year")
+			}
+		default:
+			err = fmt.Errorf("unknown parameter")
+		}
+
+		if err != nil {
+			invalid = append(invalid, queryParamErr{Param: key, Value: raw, Reason: err.Error()})
+		}
+	}
+
+	if len(invalid) > 0 {
+		return nil, &invalidQueryErr{Params: invalid}
+	}
+
+	return opts, nil
+}
@@ -0,0 +1,208 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/e-berman/baseball_api/internal/db"
+	"github.com/e-berman/baseball_api/internal/models"
+)
+
+// number of leaders returned when no limit is given, more when lines are tied for the last place
+const defaultLeaders = 10
+
+// leaderboardQuery is the parsed query string of a leaderboard
+type leaderboardQuery struct {
+	season int
+	limit  int
+	// playing time a line needs to be ranked in place of the qualification rule, nil to apply the rule
+	minimum *float64
+}
+
+// parseLeaderboardQuery parses the query string of a leaderboard, whose playing time minimum is set by param,
+// e.g. minPA, reporting every invalid parameter
+func parseLeaderboardQuery(query url.Values, param string) (*leaderboardQuery, error) {
+	board := &leaderboardQuery{limit: defaultLeaders}
+	invalid := []queryParamErr{}
+
+	season, err := parseSeason(query)
+	var seasonErr *invalidQueryErr
+	if errors.As(err, &seasonErr) {
+		invalid = append(invalid, seasonErr.Params...)
+	}
+	board.season = season
+
+	if query.Has("limit") {
+		raw := query.Get("limit")
+		if board.limit, err = parseLimit(raw); err != nil {
+			invalid = append(invalid, queryParamErr{Param: "limit", Value: raw, Reason: err.Error()})
+		}
+	}
+
+	if query.Has(param) {
+		minimum, err := parseMinimum(query, param, 0)
+		var minimumErr *invalidQueryErr
+		if errors.As(err, &minimumErr) {
+			invalid = append(invalid, minimumErr.Params...)
+		}
+		board.minimum = &minimum
+	}
+
+	if len(invalid) > 0 {
+		return nil, &invalidQueryErr{Params: invalid}
+	}
+
+	return board, nil
+}
+
+// games in a season when the schedule length isn't configured and can't be counted from the hitters
+const defaultTeamGames = 162
+
+// rankLines ranks the qualified lines of a season by a stat, listing them with list
+//
+// a line qualifies under the rule unless the query sets a minimum of playing time. every team, including
+// the lines of players who played for several, is taken to have played a season of teamGames, or of the
+// most games any line of the season played when teamGames is 0. the season defaults to the latest one
+// with averages recorded by an import
+func rankLines[T any](store db.DB, list func(*db.ListOptions) (*db.Page[*T], error), fields *db.FieldSet, rule db.Qualification, stat db.Field, query *leaderboardQuery, teamGames int) (*models.Leaderboard, error) {
+	if query.season == 0 {
+		league, err := store.GetLeagueAverages(0)
+		if err != nil {
+			return nil, err
+		}
+		query.season = league[len(league)-1].Season
+	}
+
+	season, _ := fields.Lookup("season")
+	playingTime, _ := fields.Lookup(rule.Stat)
+	opts := &db.ListOptions{Filters: []db.Filter{{Field: season, Op: db.OpEq, Value: query.season}}}
+	if query.minimum != nil {
+		opts.Filters = append(opts.Filters, atLeast(playingTime, *query.minimum))
+	}
+
+	lines, err := list(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	board := &models.Leaderboard{
+		Stat:          stat.Name,
+		Season:        query.season,
+		LowerIsBetter: stat.LowerIsBetter,
+	}
+
+	qualified := lines.Items
+	if query.minimum != nil {
+		board.Qualification = fmt.Sprintf("at least %g %s", *query.minimum, rule.Stat)
+	} else {
+		if teamGames == 0 {
+			teamGames = mostGames(fields, lines.Items)
+		}
+		board.Qualification = fmt.Sprintf("%g %s per team game of a %d game season", rule.PerGame, rule.Stat, teamGames)
+
+		qualified = []*T{}
+		for _, line := range lines.Items {
+			if toFloat(playingTime.ValueOf(line)) >= rule.Minimum(teamGames) {
+				qualified = append(qualified, line)
+			}
+		}
+	}
+
+	board.Leaders = db.Leaders(fields, stat, qualified, query.limit)
+	return board, nil
+}
+
+// mostGames returns the most games any of the lines played
+func mostGames[T any](fields *db.FieldSet, lines []*T) int {
+	games, _ := fields.Lookup("games")
+
+	most := 0
+	for _, line := range lines {
+		most = max(most, games.ValueOf(line).(int))
+	}
+
+	return most
+}
+
+// toFloat returns a numeric field value as a float64
+func toFloat(value any) float64 {
+	if i, ok := value.(int); ok {
+		return float64(i)
+	}
+
+	return value.(float64)
+}
+
+// rankedField returns the stat of a leaderboard among the ranked fields of a FieldSet
+func rankedField(fields *db.FieldSet, name string) (db.Field, bool) {
+	field, ok := fields.Lookup(name)
+	if !ok {
+		return db.Field{}, false
+	}
+	for _, ranked := range fields.RankedFields() {
+		if ranked.Name == field.Name {
+			return field, true
+		}
+	}
+
+	return db.Field{}, false
+}
+
+// handleLeaderboards ranks the qualified lines of a season by a stat, /api/leaderboards/{stat}
+//
+// the stat is ranked among hitters when it is a batting stat, otherwise among pitchers. group=pitching
+// ranks a stat both have, like winsAboveReplacement, among pitchers. hitters qualify with 3.1 plate
+// appearances per team game unless minPA is set, and pitchers with an inning pitched per team game
+// unless minIP is set. a season is the server's team games long when they are set, otherwise as long as
+// the most games a hitter played for hitters, and 162 games for pitchers, whose games aren't the team's
+func (s *Server) handleLeaderboards(rw http.ResponseWriter, req *http.Request) error {
+	if req.Method != http.MethodGet {
+		return newMethodErr(req, http.MethodGet)
+	}
+
+	path_segments := strings.Split(req.URL.Path, "/")
+	if len(path_segments) != 4 || path_segments[3] == "" {
+		return &statusErr{status: http.StatusNotFound, err: fmt.Errorf("no leaderboard for path: %s", req.URL.Path)}
+	}
+	name := path_segments[3]
+
+	group := req.URL.Query().Get("group")
+	if group != "" && group != "batting" && group != "pitching" {
+		return &invalidQueryErr{Params: []queryParamErr{{Param: "group", Value: group, Reason: "group expects batting or pitching"}}}
+	}
+
+	var board *models.Leaderboard
+	if stat, ok := rankedField(db.PositionPlayerFields, name); ok && group != "pitching" {
+		query, err := parseLeaderboardQuery(req.URL.Query(), "minPA")
+		if err != nil {
+			return err
+		}
+		if board, err = rankLines(s.db, s.db.GetPositionPlayers, db.PositionPlayerFields, db.PositionPlayerQualification, stat, query, s.teamGames); err != nil {
+			return err
+		}
+		board.Group = "batting"
+	} else if stat, ok := rankedField(db.PitcherFields, name); ok && group != "batting" {
+		query, err := parseLeaderboardQuery(req.URL.Query(), "minIP")
+		if err != nil {
+			return err
+		}
+		teamGames := s.teamGames
+		if teamGames == 0 {
+			teamGames = defaultTeamGames
+		}
+		if board, err = rankLines(s.db, s.db.GetPitchers, db.PitcherFields, db.PitcherQualification, stat, query, teamGames); err != nil {
+			return err
+		}
+		board.Group = "pitching"
+	} else {
+		return &statusErr{status: http.StatusNotFound, err: fmt.Errorf("no leaderboard for stat: %s", name)}
+	}
+
+	log.Println("GET leaderboard:", board.Stat, board.Group, "season:", board.Season)
+
+	return ToJSON(rw, http.StatusOK, board)
+}
@@ -0,0 +1,80 @@
+package routes
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// parseSeason parses the season query parameter of an averages endpoint, 0 when it isn't given
+func parseSeason(query url.Values) (int, error) {
+	if !query.Has("season") {
+		return 0, nil
+	}
+
+	raw := query.Get("season")
+	season, err := strconv.Atoi(raw)
+	if err != nil || season <= 0 {
+		return 0, &invalidQueryErr{Params: []queryParamErr{{Param: "season", Value: raw, Reason: "season expects a positive integer"}}}
+	}
+
+	return season, nil
+}
+
+// handleLeague handles the league routes
+//
+// /api/league/averages responds with the league averages of every season, or of one with ?season=
+func (s *Server) handleLeague(rw http.ResponseWriter, req *http.Request) error {
+	if req.Method != http.MethodGet {
+		return newMethodErr(req, http.MethodGet)
+	}
+	if req.URL.Path != "/api/league/averages" {
+		return &statusErr{status: http.StatusNotFound, err: fmt.Errorf("no league route for path: %s", req.URL.Path)}
+	}
+
+	season, err := parseSeason(req.URL.Query())
+	if err != nil {
+		return err
+	}
+
+	averages, err := s.db.GetLeagueAverages(season)
+	if err != nil {
+		return err
+	}
+
+	log.Println("GET league averages, season:", season)
+
+	return ToJSON(rw, http.StatusOK, averages)
+}
+
+// handleTeams handles the team routes
+//
+// /api/teams/{team}/averages responds with the averages of a team in every season, or in one with ?season=
+func (s *Server) handleTeams(rw http.ResponseWriter, req *http.Request) error {
+	if req.Method != http.MethodGet {
+		return newMethodErr(req, http.MethodGet)
+	}
+
+	path_segments := strings.Split(req.URL.Path, "/")
+	if len(path_segments) != 5 || path_segments[3] == "" || path_segments[4] != "averages" {
+		return &statusErr{status: http.StatusNotFound, err: fmt.Errorf("no team route for path: %s", req.URL.Path)}
+	}
+	team := path_segments[3]
+
+	season, err := parseSeason(req.URL.Query())
+	if err != nil {
+		return err
+	}
+
+	averages, err := s.db.GetTeamAverages(team, season)
+	if err != nil {
+		return err
+	}
+
+	log.Println("GET team averages:", team, "season:", season)
+
+	return ToJSON(rw, http.StatusOK, averages)
+}
@@ -0,0 +1,510 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/e-berman/baseball_api/internal/models"
+)
+
+const (
+	// content type of an RFC 7396 JSON Merge Patch
+	mergePatchType = "application/merge-patch+json"
+	// content type of an RFC 6902 JSON Patch
+	jsonPatchType = "application/json-patch+json"
+)
+
+// fields of a line a patch can't change, including the derived stats computed from the others
+var readOnlyFields = []string{"id", "playerId", "version", "derived"}
+
+// patchConflict reports a patch that can't be applied to the resource, e.g. a failed test operation
+func patchConflict(err error) error {
+	return &statusErr{status: http.StatusConflict, err: err}
+}
+
+// patchLine applies the patch in the body of a request to a line, returning the patched line
+// along with the json names of the fields the patch changed
+//
+// the patched line is validated as a whole by decoding it into update, so a patch can't leave
+// a line that a PUT would reject
+func patchLine[T any](req *http.Request, line *T, update validatable) (*T, []string, error) {
+	data, err := readBody(req)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	current, err := json.Marshal(line)
+	if err != nil {
+		return nil, nil, err
+	}
+	doc := map[string]any{}
+	if err := json.Unmarshal(current, &doc); err != nil {
+		return nil, nil, err
+	}
+
+	patched, err := applyPatch(req.Header.Get("Content-Type"), deepCopy(doc), data)
+	if err != nil {
+		return nil, nil, err
+	}
+	obj, ok := patched.(map[string]any)
+	if !ok {
+		return nil, nil, &invalidBodyErr{Fields: []models.FieldError{{Field: "", Reason: "must be an object"}}}
+	}
+
+	invalid := []models.FieldError{}
+	for _, name := range readOnlyFields {
+		if !reflect.DeepEqual(obj[name], doc[name]) {
+			invalid = append(invalid, models.FieldError{Field: name, Reason: "can't be changed"})
+		}
+		delete(obj, name)
+	}
+	removed := []string{}
+	for name := range doc {
+		if _, ok := obj[name]; !ok && !isReadOnly(name) {
+			removed = append(removed, name)
+		}
+	}
+	// sorted so errors are reported in a stable order
+	sort.Strings(removed)
+	for _, name := range removed {
+		invalid = append(invalid, models.FieldError{Field: name, Reason: "can't be removed"})
+	}
+	if len(invalid) > 0 {
+		return nil, nil, &invalidBodyErr{Fields: invalid}
+	}
+
+	data, err = json.Marshal(obj)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := decodeObject(data, update); err != nil {
+		return nil, nil, err
+	}
+
+	updated := *line
+	if err := json.Unmarshal(data, &updated); err != nil {
+		return nil, nil, err
+	}
+
+	changed := []string{}
+	for name, value := range obj {
+		if !reflect.DeepEqual(value, doc[name]) {
+			changed = append(changed, name)
+		}
+	}
+
+	return &updated, changed, nil
+}
+
+func isReadOnly(name string) bool {
+	for _, readOnly := range readOnlyFields {
+		if name == readOnly {
+			return true
+		}
+	}
+
+	return false
+}
+
+// applyPatch applies a patch document of the given content type to a json document
+//
+// application/json is taken as a merge patch
+func applyPatch(contentType string, doc any, patch []byte) (any, error) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, unsupportedMediaType(fmt.Errorf("invalid content type: %w", err))
+	}
+
+	switch mediaType {
+	case mergePatchType, "application/json":
+		var merge any
+		if err := json.Unmarshal(patch, &merge); err != nil {
+			return nil, badRequest(fmt.Errorf("invalid merge patch: %w", err))
+		}
+		return mergePatch(doc, merge), nil
+	case jsonPatchType:
+		ops := []patchOp{}
+		if err := json.Unmarshal(patch, &ops); err != nil {
+			return nil, badRequest(fmt.Errorf("invalid json patch: %w", err))
+		}
+		return jsonPatch(doc, ops)
+	}
+
+	return nil, unsupportedMediaType(fmt.Errorf("unsupported content type: %s, expected %s or %s", mediaType, mergePatchType, jsonPatchType))
+}
+
+// *******************
+// JSON Merge Patch
+// *******************
+
+// mergePatch applies an RFC 7396 merge patch to a document
+//
+// members of a patch object replace those of the document, recursively, and null removes a member
+func mergePatch(doc, patch any) any {
+	patchObj, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+
+	docObj, ok := doc.(map[string]any)
+	if !ok {
+		docObj = map[string]any{}
+	}
+
+	for name, value := range patchObj {
+		if value == nil {
+			delete(docObj, name)
+			continue
+		}
+		docObj[name] = mergePatch(docObj[name], value)
+	}
+
+	return docObj
+}
+
+// *******************
+// JSON Patch
+// *******************
+
+// patchOp is a single operation of an RFC 6902 JSON Patch
+type patchOp struct {
+	Op   string `json:"op"`
+	Path string `json:"path"`
+	From string `json:"from"`
+	// nil when the operation has no value, as opposed to a null value
+	Value json.RawMessage `json:"value"`
+}
+
+// jsonPatch applies the operations of an RFC 6902 JSON Patch to a document in order
+//
+// a malformed operation is a bad request, and an operation that can't be applied, e.g. to a
+// path that doesn't exist or a failed test, is a conflict. nothing is applied unless every
+// operation is
+func jsonPatch(doc any, ops []patchOp) (any, error) {
+	for i, op := range ops {
+		var err error
+		doc, err = applyOp(doc, op)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d (%s %s): %w", i, op.Op, op.Path, err)
+		}
+	}
+
+	return doc, nil
+}
+
+func applyOp(doc any, op patchOp) (any, error) {
+	path, err := parsePointer(op.Path)
+	if err != nil {
+		return nil, badRequest(err)
+	}
+
+	var value any
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return nil, badRequest(errors.New("value is required"))
+		}
+		if err := json.Unmarshal(op.Value, &value); err != nil {
+			return nil, badRequest(err)
+		}
+	case "move", "copy":
+		from, err := parsePointer(op.From)
+		if err != nil {
+			return nil, badRequest(err)
+		}
+		if value, err = pointerGet(doc, from); err != nil {
+			return nil, patchConflict(err)
+		}
+		if op.Op == "move" {
+			if len(path) > len(from) && reflect.DeepEqual(path[:len(from)], from) {
+				return nil, badRequest(errors.New("a value can't be moved into one of its children"))
+			}
+			if doc, err = pointerRemove(doc, from); err != nil {
+				return nil, patchConflict(err)
+			}
+		} else {
+			value = deepCopy(value)
+		}
+	case "remove":
+	default:
+		return nil, badRequest(fmt.Errorf("unknown operation: %s", op.Op))
+	}
+
+	switch op.Op {
+	case "remove":
+		doc, err = pointerRemove(doc, path)
+	case "replace":
+		if doc, err = pointerRemove(doc, path); err == nil {
+			doc, err = pointerAdd(doc, path, value)
+		}
+	case "test":
+		var current any
+		if current, err = pointerGet(doc, path); err == nil && !reflect.DeepEqual(current, value) {
+			err = errors.New("test failed")
+		}
+	default:
+		doc, err = pointerAdd(doc, path, value)
+	}
+	if err != nil {
+		return nil, patchConflict(err)
+	}
+
+	return doc, nil
+}
+
+// parsePointer splits an RFC 6901 JSON Pointer into its unescaped reference tokens
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return []string{}, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("invalid json pointer: %s", pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+
+	return tokens, nil
+}
+
+// arrayIndex returns the index an array token refers to
+//
+// when adding, the index may be one past the end, written as -
+func arrayIndex(token string, length int, adding bool) (int, error) {
+	if adding && token == "-" {
+		return length, nil
+	}
+
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || (token != "0" && strings.HasPrefix(token, "0")) {
+		return 0, fmt.Errorf("invalid array index: %s", token)
+	}
+	if i > length || (!adding && i == length) {
+		return 0, fmt.Errorf("array index out of range: %s", token)
+	}
+
+	return i, nil
+}
+
+// pointerGet returns the value at a path of a document
+func pointerGet(doc any, path []string) (any, error) {
+	for _, token := range path {
+		switch container := doc.(type) {
+		case map[string]any:
+			value, ok := container[token]
+			if !ok {
+				return nil, fmt.Errorf("no member %s", token)
+			}
+			doc = value
+		case []any:
+			i, err := arrayIndex(token, len(container), false)
+			if err != nil {
+				return nil, err
+			}
+			doc = container[i]
+		default:
+			return nil, fmt.Errorf("no member %s", token)
+		}
+	}
+
+	return doc, nil
+}
+
+// pointerAdd adds a value at a path of a document, returning the updated document
+//
+// an object member is added or replaced, and a value is inserted into an array
+func pointerAdd(doc any, path []string, value any) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+
+	token, last := path[0], len(path) == 1
+	switch container := doc.(type) {
+	case map[string]any:
+		if last {
+			container[token] = value
+			return container, nil
+		}
+		child, ok := container[token]
+		if !ok {
+			return nil, fmt.Errorf("no member %s", token)
+		}
+		updated, err := pointerAdd(child, path[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		container[token] = updated
+		return container, nil
+	case []any:
+		i, err := arrayIndex(token, len(container), last)
+		if err != nil {
+			return nil, err
+		}
+		if last {
+			container = append(container, nil)
+			copy(container[i+1:], container[i:])
+			container[i] = value
+			return container, nil
+		}
+		updated, err := pointerAdd(container[i], path[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		container[i] = updated
+		return container, nil
+	}
+
+	return nil, fmt.Errorf("no member %s", token)
+}
+
+// pointerRemove removes the value at a path of a document, returning the updated document
+func pointerRemove(doc any, path []string) (any, error) {
+	if len(path) == 0 {
+		return nil, errors.New("the whole document can't be removed")
+	}
+
+	token, last := path[0], len(path) == 1
+	switch container := doc.(type) {
+	case map[string]any:
+		child, ok := container[token]
+		if !ok {
+			return nil, fmt.Errorf("no member %s", token)
+		}
+		if last {
+			delete(container, token)
+			return container, nil
+		}
+		updated, err := pointerRemove(child, path[1:])
+		if err != nil {
+			return nil, err
+		}
+		container[token] = updated
+		return container, nil
+	case []any:
+		i, err := arrayIndex(token, len(container), false)
+		if err != nil {
+			return nil, err
+		}
+		if last {
+			return append(container[:i], container[i+1:]...), nil
+		}
+		updated, err := pointerRemove(container[i], path[1:])
+		if err != nil {
+			return nil, err
+		}
+		container[i] = updated
+		return container, nil
+	}
+
+	return nil, fmt.Errorf("no member %s", token)
+}
+
+// deepCopy copies a decoded json value, so a copied value isn't shared with its source
+func deepCopy(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		copied := make(map[string]any, len(v))
+		for name, child := range v {
+			copied[name] = deepCopy(child)
+		}
+		return copied
+	case []any:
+		copied := make([]any, len(v))
+		for i, child := range v {
+			copied[i] = deepCopy(child)
+		}
+		return copied
+	}
+
+	return value
+}
+
+// *******************
+// Handlers
+// *******************
+
+// handlePatchPositionPlayer updates only the fields of a position player changed by a merge patch or json patch
+//
+// responds with the whole updated line
+func (s *Server) handlePatchPositionPlayer(rw http.ResponseWriter, req *http.Request) error {
+	writer, err := s.writer(req)
+	if err != nil {
+		return err
+	}
+	id, err := s.getIDFromPath(req)
+	if err != nil {
+		return err
+	}
+	player, err := s.db.GetPositionPlayerByID(id)
+	if err != nil {
+		return err
+	}
+	version, err := ifMatch(req, player.Version)
+	if err != nil {
+		return err
+	}
+
+	patched, fields, err := patchLine(req, player, &models.UpdatePositionPlayerRequest{})
+	if err != nil {
+		return err
+	}
+
+	patched.Version = version
+	updated, err := writer.PatchPositionPlayer(id, patched, fields)
+	if err != nil {
+		return err
+	}
+
+	log.Println("PATCH player id:", id, fields)
+	rw.Header().Set("ETag", etag(updated.Version))
+
+	return ToJSON(rw, http.StatusOK, updated)
+}
+
+// handlePatchPitcher updates only the fields of a pitcher changed by a merge patch or json patch
+//
+// responds with the whole updated line
+func (s *Server) handlePatchPitcher(rw http.ResponseWriter, req *http.Request) error {
+	writer, err := s.writer(req)
+	if err != nil {
+		return err
+	}
+	id, err := s.getIDFromPath(req)
+	if err != nil {
+		return err
+	}
+	player, err := s.db.GetPitcherByID(id)
+	if err != nil {
+		return err
+	}
+	version, err := ifMatch(req, player.Version)
+	if err != nil {
+		return err
+	}
+
+	patched, fields, err := patchLine(req, player, &models.UpdatePitcherRequest{})
+	if err != nil {
+		return err
+	}
+
+	patched.Version = version
+	updated, err := writer.PatchPitcher(id, patched, fields)
+	if err != nil {
+		return err
+	}
+
+	log.Println("PATCH pitcher id:", id, fields)
+	rw.Header().Set("ETag", etag(updated.Version))
+
+	return ToJSON(rw, http.StatusOK, updated)
+}
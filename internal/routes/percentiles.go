@@ -0,0 +1,140 @@
+package routes
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/e-berman/baseball_api/internal/db"
+	"github.com/e-berman/baseball_api/internal/models"
+)
+
+const (
+	// playing time a line needs to be in the population its stats are ranked against,
+	// unless the request sets minPA or minIP
+	defaultMinPA = 100
+	defaultMinIP = 30
+)
+
+// parseMinimum parses a query parameter setting the least of a qualifying stat a line needs to be
+// in a population, e.g. minPA, or returns fallback when it isn't given
+func parseMinimum(query url.Values, param string, fallback float64) (float64, error) {
+	if !query.Has(param) {
+		return fallback, nil
+	}
+
+	raw := query.Get(param)
+	minimum, err := strconv.ParseFloat(raw, 64)
+	if err != nil || minimum < 0 {
+		return 0, &invalidQueryErr{Params: []queryParamErr{{Param: param, Value: raw, Reason: param + " expects a non-negative number"}}}
+	}
+
+	return minimum, nil
+}
+
+// atLeast returns the filter keeping the lines with at least minimum of a stat
+func atLeast(field db.Field, minimum float64) db.Filter {
+	if field.Kind == db.KindInt {
+		// a count like plate appearances is whole, so a fractional minimum rounds up
+		return db.Filter{Field: field, Op: db.OpGte, Value: int(math.Ceil(minimum))}
+	}
+
+	return db.Filter{Field: field, Op: db.OpGte, Value: minimum}
+}
+
+// percentiles ranks the stats of a line against the lines of its season with at least minimum of
+// the qualifying stat, listing them with list
+//
+// returns a 422 error naming param, the query parameter setting the minimum, when no line has enough
+// playing time to rank against
+func percentiles[T any](list func(*db.ListOptions) (*db.Page[*T], error), fields *db.FieldSet, line *T, qualifying string, minimum float64, param string) (*models.Percentiles, error) {
+	season, _ := fields.Lookup("season")
+	stat, _ := fields.Lookup(qualifying)
+	population, err := list(&db.ListOptions{Filters: []db.Filter{
+		{Field: season, Op: db.OpEq, Value: season.ValueOf(line)},
+		atLeast(stat, minimum),
+	}})
+	if err != nil {
+		return nil, err
+	}
+	if len(population.Items) == 0 {
+		return nil, &statusErr{
+			status: http.StatusUnprocessableEntity,
+			err:    fmt.Errorf("no line of the season has at least %g %s to rank against, lower %s", minimum, qualifying, param),
+		}
+	}
+
+	id, _ := fields.Lookup("id")
+	name, _ := fields.Lookup("name")
+	team, _ := fields.Lookup("team")
+
+	return &models.Percentiles{
+		ID:             id.ValueOf(line).(int),
+		Name:           name.ValueOf(line).(string),
+		Team:           team.ValueOf(line).(string),
+		Season:         season.ValueOf(line).(int),
+		QualifyingStat: qualifying,
+		Minimum:        minimum,
+		Population:     len(population.Items),
+		Stats:          db.Percentiles(fields, line, population.Items),
+	}, nil
+}
+
+// handleGetPositionPlayerPercentiles responds with the percentile ranks of a position player line's stats
+// against the hitters of its season with at least minPA plate appearances
+func (s *Server) handleGetPositionPlayerPercentiles(rw http.ResponseWriter, req *http.Request) error {
+	id, err := s.getIDFromPath(req)
+	if err != nil {
+		return err
+	}
+
+	minimum, err := parseMinimum(req.URL.Query(), "minPA", defaultMinPA)
+	if err != nil {
+		return err
+	}
+
+	line, err := s.db.GetPositionPlayerByID(id)
+	if err != nil {
+		return err
+	}
+
+	ranks, err := percentiles(s.db.GetPositionPlayers, db.PositionPlayerFields, line, "plateAppearances", minimum, "minPA")
+	if err != nil {
+		return err
+	}
+
+	log.Println("GET percentiles:", line.Name, "against", ranks.Population, "players")
+
+	return ToJSON(rw, http.StatusOK, ranks)
+}
+
+// handleGetPitcherPercentiles responds with the percentile ranks of a pitcher line's stats
+// against the pitchers of its season with at least minIP innings pitched
+func (s *Server) handleGetPitcherPercentiles(rw http.ResponseWriter, req *http.Request) error {
+	id, err := s.getIDFromPath(req)
+	if err != nil {
+		return err
+	}
+
+	minimum, err := parseMinimum(req.URL.Query(), "minIP", defaultMinIP)
+	if err != nil {
+		return err
+	}
+
+	line, err := s.db.GetPitcherByID(id)
+	if err != nil {
+		return err
+	}
+
+	ranks, err := percentiles(s.db.GetPitchers, db.PitcherFields, line, "inningsPitched", minimum, "minIP")
+	if err != nil {
+		return err
+	}
+
+	log.Println("GET percentiles:", line.Name, "against", ranks.Population, "pitchers")
+
+	return ToJSON(rw, http.StatusOK, ranks)
+}
@@ -0,0 +1,86 @@
+package routes
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/e-berman/baseball_api/internal/db"
+	"github.com/e-berman/baseball_api/internal/models"
+)
+
+// handlePlayers handles the player identity routes
+//
+// a player is retrieved by id, /api/players/{id}, or by an external id,
+// e.g. /api/players/by-mlbam/605400
+func (s *Server) handlePlayers(rw http.ResponseWriter, req *http.Request) error {
+	if req.Method != http.MethodGet {
+		return newMethodErr(req, http.MethodGet)
+	}
+
+	path_segments := strings.Split(req.URL.Path, "/")
+	if len(path_segments) == 5 && strings.HasPrefix(path_segments[3], "by-") {
+		return s.handleGetPlayerByExternalID(rw, req, strings.TrimPrefix(path_segments[3], "by-"), path_segments[4])
+	}
+
+	return s.handleGetPlayerByID(rw, req)
+}
+
+func (s *Server) handleGetPlayerByID(rw http.ResponseWriter, req *http.Request) error {
+	id, err := s.getIDFromPath(req)
+	if err != nil {
+		return err
+	}
+
+	player, err := s.db.GetPlayerByID(id)
+	if err != nil {
+		return err
+	}
+
+	log.Println("GET player profile:", player.Name)
+
+	return s.writePlayerProfile(rw, player)
+}
+
+func (s *Server) handleGetPlayerByExternalID(rw http.ResponseWriter, req *http.Request, source, id string) error {
+	player, err := s.db.GetPlayerByExternalID(source, id)
+	if err != nil {
+		return err
+	}
+
+	log.Println("GET player profile:", player.Name, "by", source, "id")
+
+	return s.writePlayerProfile(rw, player)
+}
+
+// writePlayerProfile responds with a player and every one of their season lines
+func (s *Server) writePlayerProfile(rw http.ResponseWriter, player *models.Player) error {
+	batting, err := s.db.GetPositionPlayers(playerLinesOptions(db.PositionPlayerFields, player.ID))
+	if err != nil {
+		return err
+	}
+
+	pitching, err := s.db.GetPitchers(playerLinesOptions(db.PitcherFields, player.ID))
+	if err != nil {
+		return err
+	}
+
+	profile := models.PlayerProfile{
+		Player:   player,
+		Batting:  batting.Items,
+		Pitching: pitching.Items,
+	}
+
+	return ToJSON(rw, http.StatusOK, profile)
+}
+
+// playerLinesOptions returns the list options selecting every season line of a player, ordered by season
+func playerLinesOptions(fields *db.FieldSet, playerID int) *db.ListOptions {
+	playerField, _ := fields.Lookup("playerId")
+	seasonField, _ := fields.Lookup("season")
+
+	return &db.ListOptions{
+		Filters: []db.Filter{{Field: playerField, Op: db.OpEq, Value: playerID}},
+		Sort:    []db.SortKey{{Field: seasonField}},
+	}
+}
@@ -0,0 +1,244 @@
+package routes
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/e-berman/baseball_api/internal/db"
+)
+
+// describes a single invalid query string parameter
+type queryParamErr struct {
+	Param  string `json:"param"`
+	Value  string `json:"value"`
+	Reason string `json:"reason"`
+}
+
+// error type returned when one or more query string parameters are invalid
+type invalidQueryErr struct {
+	Params []queryParamErr
+}
+
+func (e *invalidQueryErr) Error() string {
+	return "invalid query parameters"
+}
+
+const (
+	// number of rows returned by a list endpoint when no limit is given
+	defaultLimit = 50
+	maxLimit     = 500
+)
+
+// the parsed query string of a list endpoint
+type listQuery struct {
+	opts *db.ListOptions
+	// whether the total number of matching rows was requested
+	withTotal bool
+}
+
+// parseListQuery returns the list options for a list endpoint
+//
+// sort is a comma separated list of field names, each prefixed with - for descending order,
+// e.g. sort=-winsAboveReplacement,name
+// limit, after and before page through the results, and count=true requests the total.
+// includeDeleted=true lists the lines in the trash along with the live ones
+// every other query string key is a field name with an optional operator in brackets,
+// e.g. team=NYY&homeRuns[gte]=30&war[lt]=2
+// fields are validated against the given whitelist and every invalid parameter is reported
+func parseListQuery(query url.Values, fields *db.FieldSet) (*listQuery, error) {
+	list := &listQuery{opts: &db.ListOptions{Limit: defaultLimit}}
+	invalid := []queryParamErr{}
+
+	// sorted so filters and errors are reported in a stable order
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		for _, raw := range query[key] {
+			var err error
+			switch key {
+			case "sort":
+				var sortKeys []db.SortKey
+				sortKeys, err = parseSort(raw, fields)
+				list.opts.Sort = append(list.opts.Sort, sortKeys...)
+			case "limit":
+				list.opts.Limit, err = parseLimit(raw)
+			case "count":
+				list.withTotal, err = strconv.ParseBool(raw)
+				if err != nil {
+					err = fmt.Errorf("count expects true or false")
+				}
+			case "includeDeleted":
+				list.opts.IncludeDeleted, err = parseIncludeDeleted(raw)
+			case "after", "before":
+				// decoded below, once every sort key is known
+			default:
+				var filter db.Filter
+				filter, err = parseFilter(key, raw, fields)
+				if err == nil {
+					list.opts.Filters = append(list.opts.Filters, filter)
+				}
+			}
+
+			if err != nil {
+				invalid = append(invalid, queryParamErr{Param: key, Value: raw, Reason: err.Error()})
+			}
+		}
+	}
+
+	// a cursor is only valid for the sort it was created with
+	if query.Has("after") && query.Has("before") {
+		invalid = append(invalid, queryParamErr{Param: "before", Value: query.Get("before"), Reason: "after and before can't be combined"})
+	} else if query.Has("after") || query.Has("before") {
+		key := "after"
+		if query.Has("before") {
+			key = "before"
+		}
+
+		cursor, err := db.DecodeCursor(list.opts.OrderKeys(), query.Get(key))
+		if err != nil {
+			invalid = append(invalid, queryParamErr{Param: key, Value: query.Get(key), Reason: err.Error()})
+		} else if key == "after" {
+			list.opts.After = cursor
+		} else {
+			list.opts.Before = cursor
+		}
+	}
+
+	if len(invalid) > 0 {
+		return nil, &invalidQueryErr{Params: invalid}
+	}
+
+	return list, nil
+}
+
+// parseLimit parses the number of rows to return, between 1 and maxLimit
+func parseLimit(raw string) (int, error) {
+	limit, err := strconv.Atoi(raw)
+	if err != nil || limit < 1 || limit > maxLimit {
+		return 0, fmt.Errorf("limit expects an integer between 1 and %d", maxLimit)
+	}
+
+	return limit, nil
+}
+
+// parseFilter parses a single key[op]=value query string parameter into a filter
+func parseFilter(key, raw string, fields *db.FieldSet) (db.Filter, error) {
+	name, opName := key, ""
+	if i := strings.Index(key, "["); i >= 0 && strings.HasSuffix(key, "]") {
+		name, opName = key[:i], key[i+1:len(key)-1]
+	}
+
+	field, ok := fields.Lookup(name)
+	if !ok {
+		return db.Filter{}, fmt.Errorf("unknown field: %s", name)
+	}
+
+	op, err := db.ParseOperator(opName)
+	if err != nil {
+		return db.Filter{}, err
+	}
+
+	if op == db.OpIn {
+		values := []any{}
+		for _, part := range strings.Split(raw, ",") {
+			value, err := field.ParseValue(part)
+			if err != nil {
+				return db.Filter{}, err
+			}
+			values = append(values, value)
+		}
+		return db.Filter{Field: field, Op: op, Value: values}, nil
+	}
+
+	value, err := field.ParseValue(raw)
+	if err != nil {
+		return db.Filter{}, err
+	}
+
+	return db.Filter{Field: field, Op: op, Value: value}, nil
+}
+
+// parseSort parses a sort=-field,field query string value into sort keys
+func parseSort(raw string, fields *db.FieldSet) ([]db.SortKey, error) {
+	keys := []db.SortKey{}
+	for _, name := range strings.Split(raw, ",") {
+		key := db.SortKey{}
+		if strings.HasPrefix(name, "-") {
+			key.Desc = true
+			name = name[1:]
+		}
+
+		field, ok := fields.Lookup(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown sort field: %s", name)
+		}
+		key.Field = field
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// response envelope for list endpoints
+//
+// next and prev are cursors for the adjacent pages, omitted on the first and last page
+type pageResponse struct {
+	Data  any    `json:"data"`
+	Next  string `json:"next,omitempty"`
+	Prev  string `json:"prev,omitempty"`
+	Total *int   `json:"total,omitempty"`
+}
+
+// newPageResponse returns the response envelope for a page of a list query
+//
+// cursors are taken from the first and last rows of the page
+func newPageResponse[T any](page *db.Page[T], opts *db.ListOptions) *pageResponse {
+	res := &pageResponse{Data: page.Items}
+	if len(page.Items) == 0 {
+		return res
+	}
+
+	keys := opts.OrderKeys()
+	if page.HasNext {
+		res.Next = db.EncodeCursor(keys, db.NewCursor(keys, page.Items[len(page.Items)-1]))
+	}
+	if page.HasPrev {
+		res.Prev = db.EncodeCursor(keys, db.NewCursor(keys, page.Items[0]))
+	}
+
+	return res
+}
+
+// setLinkHeader adds a Link header with the next and prev page urls of a list response
+func setLinkHeader(rw http.ResponseWriter, req *http.Request, res *pageResponse) {
+	links := []string{}
+	for _, link := range []struct{ rel, param, cursor string }{
+		{"next", "after", res.Next},
+		{"prev", "before", res.Prev},
+	} {
+		if link.cursor == "" {
+			continue
+		}
+
+		query := req.URL.Query()
+		query.Del("after")
+		query.Del("before")
+		query.Set(link.param, link.cursor)
+
+		u := url.URL{Path: req.URL.Path, RawQuery: query.Encode()}
+		links = append(links, fmt.Sprintf(`<%s>; rel="%s"`, u.String(), link.rel))
+	}
+
+	if len(links) > 0 {
+		rw.Header().Set("Link", strings.Join(links, ", "))
+	}
+}
@@ -17,22 +17,23 @@ import (
 type Server struct {
 	addr string
 	db   db.DB
+	// token an admin sends to see and restore the lines in the trash, none when empty
+	adminToken string
+	// games every team plays in a season, counted from the lines of the season when 0
+	teamGames int
 }
 
 // reduces code clutter for handleFunc
 type apiFunc func(http.ResponseWriter, *http.Request) error
 
-// error type for apiFunc
-type apiErr struct {
-	Error string
-}
-
 // decorates apiFunc and handles error to reduce code clutter.
 // returns an http.HandlerFunc
+//
+// an error is sent as a problem, with a status given by its kind
 func toHandleFunc(f apiFunc) http.HandlerFunc {
 	return func(rw http.ResponseWriter, req *http.Request) {
 		if err := f(rw, req); err != nil {
-			ToJSON(rw, http.StatusBadRequest, apiErr{Error: err.Error()})
+			writeProblem(rw, req, err)
 		}
 	}
 }
@@ -45,41 +46,58 @@ func ToJSON(rw http.ResponseWriter, status int, v any) error {
 }
 
 // NewServer returns a Server struct given a passed server address and database connection
-func NewServer(addr string, db db.DB) *Server {
+//
+// teamGames is the length of a season leaderboards qualify lines by, 0 to count it from the lines
+func NewServer(addr string, db db.DB, adminToken string, teamGames int) *Server {
 	return &Server{
-		addr: addr,
-		db:   db,
+		addr:       addr,
+		db:         db,
+		adminToken: adminToken,
+		teamGames:  teamGames,
 	}
 }
 
 // StartServer starts the HTTP server and handles given routes
 func (s *Server) StartServer() {
-	sm := http.NewServeMux()
 	server := &http.Server{
 		Addr:         s.addr,
-		Handler:      sm,
+		Handler:      s.routes(),
 		IdleTimeout:  120 * time.Second,
 		ReadTimeout:  5 * time.Second,
 		WriteTimeout: 5 * time.Second,
 	}
 
-	sm.HandleFunc("/api/position_players/", toHandleFunc(s.handlePositionPlayers))
-	sm.HandleFunc("/api/pitchers/", toHandleFunc(s.handlePitchers))
-
 	log.Println("Server started on port", server.Addr)
 
 	log.Fatal(server.ListenAndServe())
 }
 
+// routes returns the handler serving every route of the api
+func (s *Server) routes() http.Handler {
+	sm := http.NewServeMux()
+	sm.HandleFunc("/api/position_players/", toHandleFunc(s.handlePositionPlayers))
+	sm.HandleFunc("/api/pitchers/", toHandleFunc(s.handlePitchers))
+	sm.HandleFunc("/api/players/", toHandleFunc(s.handlePlayers))
+	sm.HandleFunc("/api/import/", toHandleFunc(s.handleImport))
+	sm.HandleFunc("/api/league/", toHandleFunc(s.handleLeague))
+	sm.HandleFunc("/api/teams/", toHandleFunc(s.handleTeams))
+	sm.HandleFunc("/api/leaderboards/", toHandleFunc(s.handleLeaderboards))
+
+	return withRequestID(sm)
+}
+
 // getIDFromPath returns a player id
 //
-// parses the player id from the url path
+// parses the player id from the url path, i.e. the segment after /api/<players>/
 func (s *Server) getIDFromPath(req *http.Request) (int, error) {
 	path_segments := strings.Split(req.URL.Path, "/")
-	player_id_string := path_segments[len(path_segments)-1]
+	if len(path_segments) < 4 {
+		return -1, badRequest(fmt.Errorf("no player id in path: %s", req.URL.Path))
+	}
+	player_id_string := path_segments[3]
 	player_id, err := strconv.Atoi(player_id_string)
 	if err != nil {
-		return -1, err
+		return -1, badRequest(fmt.Errorf("invalid player id: %s", player_id_string))
 	}
 
 	return player_id, nil
@@ -96,19 +114,62 @@ func (s *Server) handlePositionPlayers(rw http.ResponseWriter, req *http.Request
 		if req.Method == http.MethodPost {
 			return s.handleAddPositionPlayer(rw, req)
 		}
-	} else {
-		if req.Method == http.MethodDelete {
-			return s.handleDeletePositionPlayer(rw, req)
+		return newMethodErr(req, http.MethodGet, http.MethodPost)
+	}
+
+	if req.URL.Path == "/api/position_players/batch" {
+		if req.Method == http.MethodPost {
+			return s.handleBatchPositionPlayers(rw, req)
 		}
+		return newMethodErr(req, http.MethodPost)
+	}
+
+	if strings.HasSuffix(req.URL.Path, "/career") {
 		if req.Method == http.MethodGet {
-			return s.handleGetPositionPlayerByID(rw, req)
+			return s.handleGetPositionPlayerCareer(rw, req)
 		}
-		if req.Method == http.MethodPut {
-			return s.handleUpdatePositionPlayer(rw, req)
+		return newMethodErr(req, http.MethodGet)
+	}
+
+	if strings.HasSuffix(req.URL.Path, "/percentiles") {
+		if req.Method == http.MethodGet {
+			return s.handleGetPositionPlayerPercentiles(rw, req)
+		}
+		return newMethodErr(req, http.MethodGet)
+	}
+
+	if strings.HasSuffix(req.URL.Path, "/history") {
+		if req.Method == http.MethodGet {
+			return s.handleGetPositionPlayerHistory(rw, req)
 		}
+		return newMethodErr(req, http.MethodGet)
 	}
 
-	return fmt.Errorf("invalid method for position players: %s", req.Method)
+	// /<id>/restore takes a line out of the trash, /<id>/history/<version>/restore restores a version
+	if strings.HasSuffix(req.URL.Path, "/restore") {
+		if req.Method == http.MethodPost {
+			if len(strings.Split(req.URL.Path, "/")) == 5 {
+				return s.handleUndeletePositionPlayer(rw, req)
+			}
+			return s.handleRestorePositionPlayer(rw, req)
+		}
+		return newMethodErr(req, http.MethodPost)
+	}
+
+	if req.Method == http.MethodDelete {
+		return s.handleDeletePositionPlayer(rw, req)
+	}
+	if req.Method == http.MethodGet {
+		return s.handleGetPositionPlayerByID(rw, req)
+	}
+	if req.Method == http.MethodPut {
+		return s.handleUpdatePositionPlayer(rw, req)
+	}
+	if req.Method == http.MethodPatch {
+		return s.handlePatchPositionPlayer(rw, req)
+	}
+
+	return newMethodErr(req, http.MethodGet, http.MethodPut, http.MethodPatch, http.MethodDelete)
 }
 
 // handlePlayers handles the various routes given the respective request method
@@ -122,29 +183,92 @@ func (s *Server) handlePitchers(rw http.ResponseWriter, req *http.Request) error
 		if req.Method == http.MethodPost {
 			return s.handleAddPitcher(rw, req)
 		}
-	} else {
-		if req.Method == http.MethodDelete {
-			return s.handleDeletePitcher(rw, req)
+		return newMethodErr(req, http.MethodGet, http.MethodPost)
+	}
+
+	if req.URL.Path == "/api/pitchers/batch" {
+		if req.Method == http.MethodPost {
+			return s.handleBatchPitchers(rw, req)
 		}
+		return newMethodErr(req, http.MethodPost)
+	}
+
+	if strings.HasSuffix(req.URL.Path, "/career") {
 		if req.Method == http.MethodGet {
-			return s.handleGetPitcherByID(rw, req)
+			return s.handleGetPitcherCareer(rw, req)
 		}
-		if req.Method == http.MethodPut {
-			return s.handleUpdatePitcher(rw, req)
+		return newMethodErr(req, http.MethodGet)
+	}
+
+	if strings.HasSuffix(req.URL.Path, "/percentiles") {
+		if req.Method == http.MethodGet {
+			return s.handleGetPitcherPercentiles(rw, req)
+		}
+		return newMethodErr(req, http.MethodGet)
+	}
+
+	if strings.HasSuffix(req.URL.Path, "/history") {
+		if req.Method == http.MethodGet {
+			return s.handleGetPitcherHistory(rw, req)
+		}
+		return newMethodErr(req, http.MethodGet)
+	}
+
+	// /<id>/restore takes a line out of the trash, /<id>/history/<version>/restore restores a version
+	if strings.HasSuffix(req.URL.Path, "/restore") {
+		if req.Method == http.MethodPost {
+			if len(strings.Split(req.URL.Path, "/")) == 5 {
+				return s.handleUndeletePitcher(rw, req)
+			}
+			return s.handleRestorePitcher(rw, req)
 		}
+		return newMethodErr(req, http.MethodPost)
 	}
 
-	return fmt.Errorf("invalid method for pitchers: %s", req.Method)
+	if req.Method == http.MethodDelete {
+		return s.handleDeletePitcher(rw, req)
+	}
+	if req.Method == http.MethodGet {
+		return s.handleGetPitcherByID(rw, req)
+	}
+	if req.Method == http.MethodPut {
+		return s.handleUpdatePitcher(rw, req)
+	}
+	if req.Method == http.MethodPatch {
+		return s.handlePatchPitcher(rw, req)
+	}
+
+	return newMethodErr(req, http.MethodGet, http.MethodPut, http.MethodPatch, http.MethodDelete)
 }
 
 func (s *Server) handleGetPositionPlayers(rw http.ResponseWriter, req *http.Request) error {
+	list, err := parseListQuery(req.URL.Query(), db.PositionPlayerFields)
+	if err != nil {
+		return err
+	}
+	if list.opts.IncludeDeleted {
+		if err := s.requireAdmin(req, "includeDeleted"); err != nil {
+			return err
+		}
+	}
+
 	log.Println("GET all position players")
-	players, err := s.db.GetPositionPlayers()
+	page, err := s.db.GetPositionPlayers(list.opts)
 	if err != nil {
 		return err
 	}
 
-	return ToJSON(rw, http.StatusOK, players)
+	res := newPageResponse(page, list.opts)
+	if list.withTotal {
+		total, err := s.db.CountPositionPlayers(list.opts)
+		if err != nil {
+			return err
+		}
+		res.Total = &total
+	}
+
+	setLinkHeader(rw, req, res)
+	return ToJSON(rw, http.StatusOK, res)
 }
 
 func (s *Server) handleGetPositionPlayerByID(rw http.ResponseWriter, req *http.Request) error {
@@ -153,19 +277,42 @@ func (s *Server) handleGetPositionPlayerByID(rw http.ResponseWriter, req *http.R
 		return err
 	}
 
-	player, err := s.db.GetPositionPlayerByID(id)
+	player, err := s.getPositionPlayer(req, id)
 	if err != nil {
 		return err
 	}
 
 	log.Println("GET player:", player.Name)
 
-	return ToJSON(rw, http.StatusOK, player)
+	return writeLine(rw, req, player.Version, player)
+}
+
+// handleGetPositionPlayerCareer returns every season line of a position player along with their career totals
+func (s *Server) handleGetPositionPlayerCareer(rw http.ResponseWriter, req *http.Request) error {
+	id, err := s.getIDFromPath(req)
+	if err != nil {
+		return err
+	}
+
+	seasons, err := s.db.GetPositionPlayerCareer(id)
+	if err != nil {
+		return err
+	}
+
+	career := models.NewPositionPlayerCareer(seasons)
+
+	log.Println("GET career:", career.Name)
+
+	return ToJSON(rw, http.StatusOK, career)
 }
 
 func (s *Server) handleAddPositionPlayer(rw http.ResponseWriter, req *http.Request) error {
+	writer, err := s.writer(req)
+	if err != nil {
+		return err
+	}
 	createPositionPlayerReq := models.CreatePositionPlayerRequest{}
-	if err := json.NewDecoder(req.Body).Decode(&createPositionPlayerReq); err != nil {
+	if err := decodeBody(req, &createPositionPlayerReq); err != nil {
 		return err
 	}
 
@@ -174,6 +321,7 @@ func (s *Server) handleAddPositionPlayer(rw http.ResponseWriter, req *http.Reque
 	player := models.NewPositionPlayer(
 		createPositionPlayerReq.Name,
 		createPositionPlayerReq.Team,
+		createPositionPlayerReq.Season,
 		createPositionPlayerReq.G,
 		createPositionPlayerReq.PA,
 		createPositionPlayerReq.HR,
@@ -193,15 +341,23 @@ func (s *Server) handleAddPositionPlayer(rw http.ResponseWriter, req *http.Reque
 		createPositionPlayerReq.BsR,
 		createPositionPlayerReq.WAR,
 	)
+	player.PlayerID = createPositionPlayerReq.PlayerID
 
-	if err := s.db.AddPositionPlayer(player); err != nil {
+	created, err := writer.AddPositionPlayer(player)
+	if err != nil {
 		return err
 	}
 
-	return ToJSON(rw, http.StatusOK, createPositionPlayerReq)
+	rw.Header().Set("Location", fmt.Sprintf("/api/position_players/%d", created.ID))
+	rw.Header().Set("ETag", etag(created.Version))
+	return ToJSON(rw, http.StatusCreated, created)
 }
 
 func (s *Server) handleUpdatePositionPlayer(rw http.ResponseWriter, req *http.Request) error {
+	writer, err := s.writer(req)
+	if err != nil {
+		return err
+	}
 	id, err := s.getIDFromPath(req)
 	if err != nil {
 		return err
@@ -210,14 +366,18 @@ func (s *Server) handleUpdatePositionPlayer(rw http.ResponseWriter, req *http.Re
 	if err != nil {
 		return err
 	}
+	if player.Version, err = ifMatch(req, player.Version); err != nil {
+		return err
+	}
 
 	updatePositionPlayerReq := models.UpdatePositionPlayerRequest{}
-	if err := json.NewDecoder(req.Body).Decode(&updatePositionPlayerReq); err != nil {
+	if err := decodeBody(req, &updatePositionPlayerReq); err != nil {
 		return err
 	}
 
 	player.Name = updatePositionPlayerReq.Name
 	player.Team = updatePositionPlayerReq.Team
+	player.Season = updatePositionPlayerReq.Season
 	player.G = updatePositionPlayerReq.G
 	player.PA = updatePositionPlayerReq.PA
 	player.HR = updatePositionPlayerReq.HR
@@ -237,12 +397,13 @@ func (s *Server) handleUpdatePositionPlayer(rw http.ResponseWriter, req *http.Re
 	player.BsR = updatePositionPlayerReq.BsR
 	player.WAR = updatePositionPlayerReq.WAR
 
-	if err := s.db.UpdatePositionPlayer(player); err != nil {
+	if err := writer.UpdatePositionPlayer(player); err != nil {
 		log.Println("error updating position player in database")
 		return err
 	}
 
 	log.Println("UPDATE player id:", id)
+	rw.Header().Set("ETag", etag(player.Version))
 
 	resMap := models.UpdatedPositionPlayer{
 		UpdatedMap: map[string]int{
@@ -254,13 +415,28 @@ func (s *Server) handleUpdatePositionPlayer(rw http.ResponseWriter, req *http.Re
 }
 
 func (s *Server) handleDeletePositionPlayer(rw http.ResponseWriter, req *http.Request) error {
+	writer, err := s.writer(req)
+	if err != nil {
+		return err
+	}
 	id, err := s.getIDFromPath(req)
 	if err != nil {
 		return err
 	}
 
-	err = s.db.DeletePositionPlayer(id)
-	if err != nil {
+	// the line is only read when the delete is conditional
+	version := 0
+	if req.Header.Get("If-Match") != "" {
+		player, err := s.db.GetPositionPlayerByID(id)
+		if err != nil {
+			return err
+		}
+		if version, err = ifMatch(req, player.Version); err != nil {
+			return err
+		}
+	}
+
+	if err := writer.DeletePositionPlayer(id, version); err != nil {
 		return err
 	}
 
@@ -275,13 +451,33 @@ func (s *Server) handleDeletePositionPlayer(rw http.ResponseWriter, req *http.Re
 }
 
 func (s *Server) handleGetPitchers(rw http.ResponseWriter, req *http.Request) error {
+	list, err := parseListQuery(req.URL.Query(), db.PitcherFields)
+	if err != nil {
+		return err
+	}
+	if list.opts.IncludeDeleted {
+		if err := s.requireAdmin(req, "includeDeleted"); err != nil {
+			return err
+		}
+	}
+
 	log.Println("GET all pitchers")
-	players, err := s.db.GetPitchers()
+	page, err := s.db.GetPitchers(list.opts)
 	if err != nil {
 		return err
 	}
 
-	return ToJSON(rw, http.StatusOK, players)
+	res := newPageResponse(page, list.opts)
+	if list.withTotal {
+		total, err := s.db.CountPitchers(list.opts)
+		if err != nil {
+			return err
+		}
+		res.Total = &total
+	}
+
+	setLinkHeader(rw, req, res)
+	return ToJSON(rw, http.StatusOK, res)
 }
 
 func (s *Server) handleGetPitcherByID(rw http.ResponseWriter, req *http.Request) error {
@@ -290,19 +486,42 @@ func (s *Server) handleGetPitcherByID(rw http.ResponseWriter, req *http.Request)
 		return err
 	}
 
-	player, err := s.db.GetPitcherByID(id)
+	player, err := s.getPitcher(req, id)
 	if err != nil {
 		return err
 	}
 
 	log.Println("GET pitcher:", player.Name)
 
-	return ToJSON(rw, http.StatusOK, player)
+	return writeLine(rw, req, player.Version, player)
+}
+
+// handleGetPitcherCareer returns every season line of a pitcher along with their career totals
+func (s *Server) handleGetPitcherCareer(rw http.ResponseWriter, req *http.Request) error {
+	id, err := s.getIDFromPath(req)
+	if err != nil {
+		return err
+	}
+
+	seasons, err := s.db.GetPitcherCareer(id)
+	if err != nil {
+		return err
+	}
+
+	career := models.NewPitcherCareer(seasons)
+
+	log.Println("GET pitcher career:", career.Name)
+
+	return ToJSON(rw, http.StatusOK, career)
 }
 
 func (s *Server) handleAddPitcher(rw http.ResponseWriter, req *http.Request) error {
+	writer, err := s.writer(req)
+	if err != nil {
+		return err
+	}
 	createPitcherReq := models.CreatePitcherRequest{}
-	if err := json.NewDecoder(req.Body).Decode(&createPitcherReq); err != nil {
+	if err := decodeBody(req, &createPitcherReq); err != nil {
 		return err
 	}
 
@@ -311,6 +530,7 @@ func (s *Server) handleAddPitcher(rw http.ResponseWriter, req *http.Request) err
 	player := models.NewPitcher(
 		createPitcherReq.Name,
 		createPitcherReq.Team,
+		createPitcherReq.Season,
 		createPitcherReq.W,
 		createPitcherReq.L,
 		createPitcherReq.SV,
@@ -331,15 +551,23 @@ func (s *Server) handleAddPitcher(rw http.ResponseWriter, req *http.Request) err
 		createPitcherReq.XFIP,
 		createPitcherReq.WAR,
 	)
+	player.PlayerID = createPitcherReq.PlayerID
 
-	if err := s.db.AddPitcher(player); err != nil {
+	created, err := writer.AddPitcher(player)
+	if err != nil {
 		return err
 	}
 
-	return ToJSON(rw, http.StatusOK, createPitcherReq)
+	rw.Header().Set("Location", fmt.Sprintf("/api/pitchers/%d", created.ID))
+	rw.Header().Set("ETag", etag(created.Version))
+	return ToJSON(rw, http.StatusCreated, created)
 }
 
 func (s *Server) handleUpdatePitcher(rw http.ResponseWriter, req *http.Request) error {
+	writer, err := s.writer(req)
+	if err != nil {
+		return err
+	}
 	id, err := s.getIDFromPath(req)
 	if err != nil {
 		return err
@@ -348,14 +576,18 @@ func (s *Server) handleUpdatePitcher(rw http.ResponseWriter, req *http.Request)
 	if err != nil {
 		return err
 	}
+	if player.Version, err = ifMatch(req, player.Version); err != nil {
+		return err
+	}
 
 	updatePitcherReq := models.UpdatePitcherRequest{}
-	if err := json.NewDecoder(req.Body).Decode(&updatePitcherReq); err != nil {
+	if err := decodeBody(req, &updatePitcherReq); err != nil {
 		return err
 	}
 
 	player.Name = updatePitcherReq.Name
 	player.Team = updatePitcherReq.Team
+	player.Season = updatePitcherReq.Season
 	player.W = updatePitcherReq.W
 	player.L = updatePitcherReq.L
 	player.SV = updatePitcherReq.SV
@@ -376,11 +608,12 @@ func (s *Server) handleUpdatePitcher(rw http.ResponseWriter, req *http.Request)
 	player.XFIP = updatePitcherReq.XFIP
 	player.WAR = updatePitcherReq.WAR
 
-	if err := s.db.UpdatePitcher(player); err != nil {
+	if err := writer.UpdatePitcher(player); err != nil {
 		return err
 	}
 
 	log.Println("UPDATE pitcher id:", id)
+	rw.Header().Set("ETag", etag(player.Version))
 
 	resMap := models.UpdatedPitcher{
 		UpdatedMap: map[string]int{
@@ -392,13 +625,28 @@ func (s *Server) handleUpdatePitcher(rw http.ResponseWriter, req *http.Request)
 }
 
 func (s *Server) handleDeletePitcher(rw http.ResponseWriter, req *http.Request) error {
+	writer, err := s.writer(req)
+	if err != nil {
+		return err
+	}
 	id, err := s.getIDFromPath(req)
 	if err != nil {
 		return err
 	}
 
-	err = s.db.DeletePitcher(id)
-	if err != nil {
+	// the line is only read when the delete is conditional
+	version := 0
+	if req.Header.Get("If-Match") != "" {
+		player, err := s.db.GetPitcherByID(id)
+		if err != nil {
+			return err
+		}
+		if version, err = ifMatch(req, player.Version); err != nil {
+			return err
+		}
+	}
+
+	if err := writer.DeletePitcher(id, version); err != nil {
 		return err
 	}
 
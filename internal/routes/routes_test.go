@@ -0,0 +1,873 @@
+package routes
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/e-berman/baseball_api/internal/db"
+	"github.com/e-berman/baseball_api/internal/models"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestServer returns a server backed by a MemoryDB holding the csvs in assets
+func newTestServer(t *testing.T) *Server {
+	memory := db.NewMemoryDB()
+	opts := &db.ImportOptions{Mode: db.ImportLenient, Write: db.WriteInsert, Season: 2022}
+
+	batters, err := os.Open("../../assets/batters_2022.csv")
+	assert.NoError(t, err)
+	defer batters.Close()
+	_, err = memory.ImportPositionPlayers(batters, opts)
+	assert.NoError(t, err)
+
+	pitchers, err := os.Open("../../assets/pitchers_2022.csv")
+	assert.NoError(t, err)
+	defer pitchers.Close()
+	_, err = memory.ImportPitchers(pitchers, opts)
+	assert.NoError(t, err)
+
+	return NewServer(":0", memory, testAdminToken, 0)
+}
+
+// admin token of the test server
+const testAdminToken = "umpire"
+
+// serve sends a request to the server and returns the response
+func serve(s *Server, method, target string, body io.Reader) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, body)
+	rec := httptest.NewRecorder()
+	s.routes().ServeHTTP(rec, req)
+
+	return rec
+}
+
+// decodeJSON decodes the body of a response
+func decodeJSON(t *testing.T, rec *httptest.ResponseRecorder, v any) {
+	assert.NoError(t, json.NewDecoder(rec.Body).Decode(v))
+}
+
+// listResponse is a pageResponse of position players
+type listResponse struct {
+	Data  []*models.PositionPlayer `json:"data"`
+	Next  string                   `json:"next"`
+	Prev  string                   `json:"prev"`
+	Total *int                     `json:"total"`
+}
+
+func TestGetPositionPlayers(t *testing.T) {
+	s := newTestServer(t)
+
+	rec := serve(s, http.MethodGet, "/api/position_players/?team=NYY&sort=-homeRuns&limit=2&count=true", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	res := listResponse{}
+	decodeJSON(t, rec, &res)
+	assert.Equal(t, 2, len(res.Data))
+	assert.Equal(t, "Aaron Judge", res.Data[0].Name)
+	assert.GreaterOrEqual(t, res.Data[0].HR, res.Data[1].HR)
+	assert.NotEmpty(t, res.Next)
+	assert.Empty(t, res.Prev)
+	assert.NotNil(t, res.Total)
+	assert.Contains(t, rec.Header().Get("Link"), `rel="next"`)
+
+	rec = serve(s, http.MethodGet, "/api/position_players/?team=NYY&sort=-homeRuns&limit=2&after="+res.Next, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	next := listResponse{}
+	decodeJSON(t, rec, &next)
+	assert.NotEmpty(t, next.Data)
+	assert.NotEmpty(t, next.Prev)
+	assert.NotContains(t, []string{res.Data[0].Name, res.Data[1].Name}, next.Data[0].Name)
+
+	rec = serve(s, http.MethodGet, "/api/position_players/?team=NYY&sort=-homeRuns&limit=2&before="+next.Prev, nil)
+	prev := listResponse{}
+	decodeJSON(t, rec, &prev)
+	assert.Equal(t, res.Data, prev.Data)
+}
+
+func TestGetPositionPlayersInvalidQuery(t *testing.T) {
+	s := newTestServer(t)
+
+	rec := serve(s, http.MethodGet, "/api/position_players/?homeRuns[gte]=many&sort=height", nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, "application/problem+json", rec.Header().Get("Content-Type"))
+
+	res := problem{}
+	decodeJSON(t, rec, &res)
+	assert.Equal(t, 2, len(res.InvalidParams))
+	assert.Equal(t, "homeRuns[gte]", res.InvalidParams[0].Param)
+	assert.Equal(t, "sort", res.InvalidParams[1].Param)
+}
+
+func TestGetPositionPlayersDerived(t *testing.T) {
+	s := newTestServer(t)
+
+	rec := serve(s, http.MethodGet, "/api/position_players/?ops[gte]=0.9&sort=-ops_plus&limit=2", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	res := listResponse{}
+	decodeJSON(t, rec, &res)
+	assert.Equal(t, "Aaron Judge", res.Data[0].Name)
+	for _, line := range res.Data {
+		assert.GreaterOrEqual(t, line.Derived.OPS, .9)
+	}
+	assert.GreaterOrEqual(t, res.Data[0].Derived.OPSPlus, res.Data[1].Derived.OPSPlus)
+
+	rec = serve(s, http.MethodGet, "/api/position_players/?ops[gte]=0.9&sort=-ops_plus&limit=2&after="+res.Next, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	next := listResponse{}
+	decodeJSON(t, rec, &next)
+	assert.LessOrEqual(t, next.Data[0].Derived.OPSPlus, res.Data[1].Derived.OPSPlus)
+
+	rec = serve(s, http.MethodGet, "/api/position_players/"+strconv.Itoa(res.Data[0].ID), nil)
+	judge := models.PositionPlayer{}
+	decodeJSON(t, rec, &judge)
+	assert.Equal(t, res.Data[0].Derived, judge.Derived)
+
+	rec = serve(s, http.MethodGet, "/api/pitchers/?fip_minus[lt]=abc", nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestPositionPlayerLifecycle(t *testing.T) {
+	s := newTestServer(t)
+
+	body := `{"name": "Jasson Dominguez", "team": "NYY", "season": 2023, "games": 8, "plateAppearances": 33, "homeRuns": 4, "runs": 6, "runsBattedIn": 7}`
+	rec := serve(s, http.MethodPost, "/api/position_players/", strings.NewReader(body))
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	created := models.PositionPlayer{}
+	decodeJSON(t, rec, &created)
+	assert.NotZero(t, created.ID)
+	assert.Equal(t, 1, created.Version)
+	assert.Equal(t, 4, created.HR)
+
+	target := "/api/position_players/" + strconv.Itoa(created.ID)
+	assert.Equal(t, target, rec.Header().Get("Location"))
+	assert.Equal(t, `"1"`, rec.Header().Get("ETag"))
+
+	// a second line for the same name, team and season names the existing one
+	rec = serve(s, http.MethodPost, "/api/position_players/", strings.NewReader(body))
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	conflict := problem{}
+	decodeJSON(t, rec, &conflict)
+	assert.Equal(t, created.ID, conflict.ExistingID)
+
+	rec = serve(s, http.MethodPost, "/api/position_players/", strings.NewReader(`{"name": `))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = serve(s, http.MethodGet, "/api/position_players/?name=Jasson%20Dominguez", nil)
+	res := listResponse{}
+	decodeJSON(t, rec, &res)
+	assert.Equal(t, 1, len(res.Data))
+	assert.Equal(t, created.ID, res.Data[0].ID)
+
+	body = `{"name": "Jasson Dominguez", "team": "NYY", "season": 2023, "games": 8, "plateAppearances": 33, "homeRuns": 5, "runs": 6, "runsBattedIn": 7}`
+	rec = serve(s, http.MethodPut, target, strings.NewReader(body))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = serve(s, http.MethodGet, target, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	player := models.PositionPlayer{}
+	decodeJSON(t, rec, &player)
+	assert.Equal(t, 5, player.HR)
+
+	body = `{"name": "Jasson Dominguez", "team": "NYY", "season": 2023, "homeRuns": -1}`
+	rec = serve(s, http.MethodPut, target, strings.NewReader(body))
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+
+	// the line would share a name, team and season with Aaron Judge's
+	body = `{"name": "Aaron Judge", "team": "NYY", "season": 2022}`
+	rec = serve(s, http.MethodPut, target, strings.NewReader(body))
+	assert.Equal(t, http.StatusConflict, rec.Code)
+
+	rec = serve(s, http.MethodDelete, target, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = serve(s, http.MethodGet, target, nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	missing := problem{}
+	decodeJSON(t, rec, &missing)
+	assert.Equal(t, "position player "+strconv.Itoa(created.ID)+" not found", missing.Detail)
+
+	rec = serve(s, http.MethodDelete, target, nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+// patch sends a PATCH request with the given content type to the server
+func patch(s *Server, target, contentType, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPatch, target, strings.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	rec := httptest.NewRecorder()
+	s.routes().ServeHTTP(rec, req)
+
+	return rec
+}
+
+func TestPatchPositionPlayer(t *testing.T) {
+	s := newTestServer(t)
+
+	rec := serve(s, http.MethodGet, "/api/position_players/?name=Aaron%20Judge", nil)
+	res := listResponse{}
+	decodeJSON(t, rec, &res)
+	assert.Equal(t, 1, len(res.Data))
+	judge := res.Data[0]
+	target := "/api/position_players/" + strconv.Itoa(judge.ID)
+
+	rec = patch(s, target, mergePatchType, `{"stolenBases": 20}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	player := models.PositionPlayer{}
+	decodeJSON(t, rec, &player)
+	assert.Equal(t, 20, player.SB)
+	// fields missing from a merge patch are left as they are
+	assert.Equal(t, judge.HR, player.HR)
+	assert.Equal(t, judge.WAR, player.WAR)
+	// the patched line comes back as a GET returns it, with its derived stats
+	assert.NotNil(t, player.Derived)
+
+	body := `[{"op": "test", "path": "/name", "value": "Aaron Judge"}, {"op": "replace", "path": "/stolenBases", "value": 16}]`
+	rec = patch(s, target, jsonPatchType, body)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	player = models.PositionPlayer{}
+	decodeJSON(t, rec, &player)
+	assert.Equal(t, 16, player.SB)
+	assert.Equal(t, judge.HR, player.HR)
+
+	// nothing is applied when an operation fails
+	body = `[{"op": "replace", "path": "/stolenBases", "value": 30}, {"op": "test", "path": "/team", "value": "BOS"}]`
+	rec = patch(s, target, jsonPatchType, body)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	rec = patch(s, target, jsonPatchType, `[{"op": "remove", "path": "/height"}]`)
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	rec = patch(s, target, jsonPatchType, `[{"op": "jump", "path": "/name"}]`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = patch(s, target, mergePatchType, `{"id": -1, "name": null}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	problemRes := problem{}
+	decodeJSON(t, rec, &problemRes)
+	assert.Equal(t, []models.FieldError{{Field: "id", Reason: "can't be changed"}, {Field: "name", Reason: "can't be removed"}}, problemRes.InvalidFields)
+
+	// derived stats are computed from the others
+	rec = patch(s, target, mergePatchType, `{"derived": {"onBasePlusSlugging": 2}}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+
+	// the patched line is validated as a whole
+	rec = patch(s, target, mergePatchType, `{"homeRuns": 200}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+
+	rec = patch(s, target, "text/plain", `{"stolenBases": 30}`)
+	assert.Equal(t, http.StatusUnsupportedMediaType, rec.Code)
+
+	rec = patch(s, "/api/pitchers/99999", mergePatchType, `{"wins": 1}`)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = serve(s, http.MethodGet, target, nil)
+	player = models.PositionPlayer{}
+	decodeJSON(t, rec, &player)
+	assert.Equal(t, 16, player.SB)
+}
+
+// serveWithHeader sends a request with a header set to the server and returns the response
+func serveWithHeader(s *Server, method, target, body, header, value string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set(header, value)
+	if method == http.MethodPatch {
+		req.Header.Set("Content-Type", mergePatchType)
+	}
+	rec := httptest.NewRecorder()
+	s.routes().ServeHTTP(rec, req)
+
+	return rec
+}
+
+func TestETag(t *testing.T) {
+	s := newTestServer(t)
+
+	rec := serve(s, http.MethodGet, "/api/pitchers/1", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, `"1"`, rec.Header().Get("ETag"))
+
+	rec = serveWithHeader(s, http.MethodGet, "/api/pitchers/1", "", "If-None-Match", `W/"1"`)
+	assert.Equal(t, http.StatusNotModified, rec.Code)
+	assert.Empty(t, rec.Body.String())
+
+	body := `{"name": "Aaron Nola", "team": "PHI", "season": 2022, "wins": 12, "losses": 13, "games": 32, "gamesSaved": 32, "inningsPitched": 205.0}`
+	rec = serveWithHeader(s, http.MethodPut, "/api/pitchers/1", body, "If-Match", `"1"`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, `"2"`, rec.Header().Get("ETag"))
+
+	// a second editor still holding version 1 can't overwrite the first's changes
+	rec = serveWithHeader(s, http.MethodPut, "/api/pitchers/1", body, "If-Match", `"1"`)
+	assert.Equal(t, http.StatusPreconditionFailed, rec.Code)
+	rec = serveWithHeader(s, http.MethodPatch, "/api/pitchers/1", `{"losses": 14}`, "If-Match", `"1"`)
+	assert.Equal(t, http.StatusPreconditionFailed, rec.Code)
+	rec = serveWithHeader(s, http.MethodDelete, "/api/pitchers/1", "", "If-Match", `"1"`)
+	assert.Equal(t, http.StatusPreconditionFailed, rec.Code)
+
+	rec = serveWithHeader(s, http.MethodGet, "/api/pitchers/1", "", "If-None-Match", `"1"`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	pitcher := models.Pitcher{}
+	decodeJSON(t, rec, &pitcher)
+	assert.Equal(t, 12, pitcher.W)
+	assert.Equal(t, 2, pitcher.Version)
+
+	rec = serveWithHeader(s, http.MethodPatch, "/api/pitchers/1", `{"losses": 14}`, "If-Match", `"2"`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, `"3"`, rec.Header().Get("ETag"))
+
+	rec = serveWithHeader(s, http.MethodDelete, "/api/pitchers/1", "", "If-Match", "*")
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestPitcherHistory(t *testing.T) {
+	s := newTestServer(t)
+
+	rec := serveWithHeader(s, http.MethodPatch, "/api/pitchers/1", `{"losses": 14}`, "X-Actor", "scorer")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = serve(s, http.MethodDelete, "/api/pitchers/1", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = serve(s, http.MethodGet, "/api/pitchers/1/history", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	history := []*db.Change[models.Pitcher]{}
+	decodeJSON(t, rec, &history)
+	assert.Equal(t, 3, len(history))
+	assert.Equal(t, db.ChangeCreate, history[0].Action)
+	assert.Equal(t, db.SourceImport, history[0].Source)
+	assert.Equal(t, "scorer", history[1].Actor)
+	assert.Equal(t, 14, history[1].After.L)
+	assert.Equal(t, anonymousActor, history[2].Actor)
+	assert.NotNil(t, history[2].After.DeletedAt)
+
+	rec = serve(s, http.MethodPost, "/api/pitchers/1/history/1/restore", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, `"4"`, rec.Header().Get("ETag"))
+	pitcher := models.Pitcher{}
+	decodeJSON(t, rec, &pitcher)
+	assert.Equal(t, 1, pitcher.ID)
+	assert.Equal(t, 13, pitcher.L)
+	assert.NotNil(t, pitcher.Derived)
+
+	rec = serve(s, http.MethodPost, "/api/position_players/2/history/1/restore", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	player := models.PositionPlayer{}
+	decodeJSON(t, rec, &player)
+	assert.Equal(t, 2, player.ID)
+	assert.NotNil(t, player.Derived)
+
+	rec = serve(s, http.MethodPost, "/api/pitchers/1/history/9/restore", nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec = serve(s, http.MethodPost, "/api/pitchers/1/history/latest/restore", nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = serve(s, http.MethodGet, "/api/pitchers/9999/history", nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = serveWithHeader(s, http.MethodDelete, "/api/pitchers/1", "", "X-Actor", strings.Repeat("a", maxActorLength+1))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = serveWithHeader(s, http.MethodDelete, "/api/pitchers/1", "", "X-Actor", "a\tb")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestPitcherTrash(t *testing.T) {
+	s := newTestServer(t)
+
+	rec := serve(s, http.MethodDelete, "/api/pitchers/1", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = serve(s, http.MethodDelete, "/api/pitchers/1", nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec = serve(s, http.MethodDelete, "/api/pitchers/9999", nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = serve(s, http.MethodGet, "/api/pitchers/1", nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	// only an admin sees and restores the lines in the trash
+	admin := "Bearer " + testAdminToken
+	for _, target := range []string{"/api/pitchers/1?includeDeleted=true", "/api/pitchers/?id=1&includeDeleted=true"} {
+		rec = serve(s, http.MethodGet, target, nil)
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+		rec = serveWithHeader(s, http.MethodGet, target, "", "Authorization", "Bearer bullpen")
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+	}
+	rec = serve(s, http.MethodPost, "/api/pitchers/1/restore", nil)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	rec = serveWithHeader(s, http.MethodPost, "/api/pitchers/1/restore", "", "X-Actor", "admin")
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	// a server without an admin token has no admins
+	noAdmins := NewServer(":0", s.db, "", 0)
+	rec = serveWithHeader(noAdmins, http.MethodGet, "/api/pitchers/1?includeDeleted=true", "", "Authorization", "Bearer ")
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	rec = serveWithHeader(s, http.MethodGet, "/api/pitchers/1?includeDeleted=true", "", "Authorization", admin)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	pitcher := models.Pitcher{}
+	decodeJSON(t, rec, &pitcher)
+	assert.NotNil(t, pitcher.DeletedAt)
+	rec = serve(s, http.MethodGet, "/api/pitchers/1?includeDeleted=maybe", nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = serve(s, http.MethodGet, "/api/pitchers/?id=1", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"data":[]`)
+	rec = serveWithHeader(s, http.MethodGet, "/api/pitchers/?id=1&includeDeleted=true", "", "Authorization", admin)
+	assert.Contains(t, rec.Body.String(), `"deletedAt"`)
+
+	rec = serveWithHeader(s, http.MethodPost, "/api/pitchers/1/restore", "", "Authorization", admin)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, `"3"`, rec.Header().Get("ETag"))
+	assert.NotContains(t, rec.Body.String(), `"deletedAt"`)
+
+	rec = serveWithHeader(s, http.MethodPost, "/api/pitchers/1/restore", "", "Authorization", admin)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec = serve(s, http.MethodGet, "/api/pitchers/1/restore", nil)
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	rec = serve(s, http.MethodGet, "/api/pitchers/1", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestBatchPositionPlayers(t *testing.T) {
+	s := newTestServer(t)
+
+	rec := serve(s, http.MethodGet, "/api/position_players/?name=Aaron%20Judge", nil)
+	list := listResponse{}
+	decodeJSON(t, rec, &list)
+	judge := list.Data[0]
+
+	body := `[
+		{"op": "create", "line": {"name": "Jasson Dominguez", "team": "NYY", "season": 2023, "plateAppearances": 33, "homeRuns": 4, "runs": 6, "runsBattedIn": 7}},
+		{"op": "update", "id": ` + strconv.Itoa(judge.ID) + `, "version": 1, "line": {"name": "Aaron Judge", "team": "NYY", "season": 2022, "plateAppearances": 696, "homeRuns": 63, "runs": 133, "runsBattedIn": 131}},
+		{"op": "delete", "id": -1},
+		{"op": "create", "line": {"name": "Anthony Volpe", "team": "NYY", "season": 2023, "homeRuns": -21}}
+	]`
+
+	// an atomic batch with a failed operation writes nothing
+	rec = serve(s, http.MethodPost, "/api/position_players/batch", strings.NewReader(body))
+	assert.Equal(t, http.StatusMultiStatus, rec.Code)
+	res := batchResponse[models.PositionPlayer]{}
+	decodeJSON(t, rec, &res)
+	assert.Equal(t, db.BatchAtomic, res.Mode)
+	assert.Equal(t, 0, res.Succeeded)
+	assert.Equal(t, 4, res.Failed)
+	statuses := []int{}
+	for _, result := range res.Results {
+		statuses = append(statuses, result.Status)
+	}
+	assert.Equal(t, []int{http.StatusFailedDependency, http.StatusFailedDependency, http.StatusUnprocessableEntity, http.StatusUnprocessableEntity}, statuses)
+	assert.Equal(t, "id", res.Results[2].Error.InvalidFields[0].Field)
+	assert.Equal(t, "line.homeRuns", res.Results[3].Error.InvalidFields[0].Field)
+
+	rec = serve(s, http.MethodGet, "/api/position_players/"+strconv.Itoa(judge.ID), nil)
+	player := models.PositionPlayer{}
+	decodeJSON(t, rec, &player)
+	assert.Equal(t, 62, player.HR)
+
+	// a partial batch keeps the operations that succeeded
+	rec = serve(s, http.MethodPost, "/api/position_players/batch?mode=partial", strings.NewReader(body))
+	assert.Equal(t, http.StatusMultiStatus, rec.Code)
+	res = batchResponse[models.PositionPlayer]{}
+	decodeJSON(t, rec, &res)
+	assert.Equal(t, 2, res.Succeeded)
+	assert.Equal(t, http.StatusCreated, res.Results[0].Status)
+	assert.NotZero(t, res.Results[0].ID)
+	assert.Equal(t, 4, res.Results[0].Line.HR)
+	assert.Equal(t, http.StatusOK, res.Results[1].Status)
+	assert.Equal(t, 63, res.Results[1].Line.HR)
+	assert.Equal(t, 2, res.Results[1].Line.Version)
+
+	// the line is no longer at version 1
+	rec = serve(s, http.MethodPost, "/api/position_players/batch?mode=partial", strings.NewReader(`[{"op": "delete", "id": `+strconv.Itoa(judge.ID)+`, "version": 1}]`))
+	assert.Equal(t, http.StatusMultiStatus, rec.Code)
+	res = batchResponse[models.PositionPlayer]{}
+	decodeJSON(t, rec, &res)
+	assert.Equal(t, http.StatusPreconditionFailed, res.Results[0].Status)
+
+	rec = serve(s, http.MethodPost, "/api/position_players/batch", strings.NewReader(`[{"op": "delete", "id": `+strconv.Itoa(judge.ID)+`}]`))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = serve(s, http.MethodGet, "/api/position_players/"+strconv.Itoa(judge.ID), nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = serve(s, http.MethodPost, "/api/position_players/batch", strings.NewReader(`{"op": "delete", "id": 1}`))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = serve(s, http.MethodPost, "/api/position_players/batch?mode=all", strings.NewReader(`[]`))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = serve(s, http.MethodGet, "/api/pitchers/batch", nil)
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+func TestAddPositionPlayerValidation(t *testing.T) {
+	s := newTestServer(t)
+
+	body := `{"name": "", "team": "NYY", "season": 2023, "battingAvg": 7.0, "onBasePct": 0.3, "sluggingPct": 0.5, "isolatedPower": 0.2, "height": 79}`
+	rec := serve(s, http.MethodPost, "/api/position_players/", strings.NewReader(body))
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+
+	// unknown fields are reported along with the values failing validation
+	res := problem{}
+	decodeJSON(t, rec, &res)
+	assert.Equal(t, models.FieldError{Field: "height", Reason: "unknown field"}, res.InvalidFields[0])
+	assert.Contains(t, res.InvalidFields, models.FieldError{Field: "name", Reason: "is required"})
+	assert.Contains(t, res.InvalidFields, models.FieldError{Field: "battingAvg", Reason: "must be between 0 and 1"})
+
+	// as is every field of the wrong type, rather than the first one
+	body = `{"name": "Aaron Judge", "team": "NYY", "season": 2022, "homeRuns": "sixty-two", "runs": 1.5, "walkRate": 150, "heigth": 79}`
+	rec = serve(s, http.MethodPost, "/api/position_players/", strings.NewReader(body))
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	res = problem{}
+	decodeJSON(t, rec, &res)
+	assert.Equal(t, []models.FieldError{
+		{Field: "heigth", Reason: "unknown field"},
+		{Field: "homeRuns", Reason: "must be an integer"},
+		{Field: "runs", Reason: "must be an integer"},
+		{Field: "walkRate", Reason: "must be between 0 and 100"},
+	}, res.InvalidFields)
+
+	body = `{"name": "", "team": "NYY", "season": 2023, "battingAvg": 0.31, "onBasePct": 0.3, "sluggingPct": 0.5, "isolatedPower": 0.4}`
+	rec = serve(s, http.MethodPost, "/api/position_players/", strings.NewReader(body))
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+
+	res = problem{}
+	decodeJSON(t, rec, &res)
+	fields := []string{}
+	for _, invalid := range res.InvalidFields {
+		fields = append(fields, invalid.Field)
+	}
+	assert.Equal(t, []string{"name", "onBasePct", "isolatedPower"}, fields)
+
+	rec = serve(s, http.MethodPost, "/api/pitchers/", strings.NewReader(`{"name": "Aaron Nola", "team": "PHI", "season": 2022, "wins": "eleven"}`))
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	res = problem{}
+	decodeJSON(t, rec, &res)
+	assert.Equal(t, []models.FieldError{{Field: "wins", Reason: "must be an integer"}}, res.InvalidFields)
+
+	body = `{"name": "Aaron Nola", "team": "PHI", "season": 2022, "wins": 11, "losses": 13, "games": 32, "gamesSaved": 33, "inningsPitched": 205.5}`
+	rec = serve(s, http.MethodPut, "/api/pitchers/1", strings.NewReader(body))
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	res = problem{}
+	decodeJSON(t, rec, &res)
+	assert.Equal(t, 2, len(res.InvalidFields))
+	assert.Equal(t, "gamesSaved", res.InvalidFields[0].Field)
+	assert.Equal(t, "inningsPitched", res.InvalidFields[1].Field)
+}
+
+func TestProblem(t *testing.T) {
+	s := newTestServer(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/pitchers/99999", nil)
+	req.Header.Set("X-Request-ID", "trace-42")
+	rec := httptest.NewRecorder()
+	s.routes().ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Equal(t, "trace-42", rec.Header().Get("X-Request-ID"))
+
+	res := problem{}
+	decodeJSON(t, rec, &res)
+	assert.Equal(t, "about:blank", res.Type)
+	assert.Equal(t, "Not Found", res.Title)
+	assert.Equal(t, http.StatusNotFound, res.Status)
+	assert.Equal(t, "/api/pitchers/99999", res.Instance)
+	assert.Equal(t, "trace-42", res.RequestID)
+
+	// a request id that isn't safe to echo back is replaced
+	req = httptest.NewRequest(http.MethodPost, "/api/pitchers/1", nil)
+	req.Header.Set("X-Request-ID", "bad id\n")
+	rec = httptest.NewRecorder()
+	s.routes().ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	assert.Equal(t, "GET, PUT, PATCH, DELETE", rec.Header().Get("Allow"))
+	assert.NotEqual(t, "bad id\n", rec.Header().Get("X-Request-ID"))
+	assert.NotEmpty(t, rec.Header().Get("X-Request-ID"))
+
+	rec = serve(s, http.MethodPost, "/api/import/teams", strings.NewReader(""))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestGetPitcherCareer(t *testing.T) {
+	s := newTestServer(t)
+
+	rec := serve(s, http.MethodGet, "/api/pitchers/1/career", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	career := models.PitcherCareer{}
+	decodeJSON(t, rec, &career)
+	assert.Equal(t, "Aaron Nola", career.Name)
+	assert.Equal(t, 1, len(career.Seasons))
+}
+
+func TestGetPlayerByExternalID(t *testing.T) {
+	s := newTestServer(t)
+
+	rec := serve(s, http.MethodGet, "/api/players/by-mlbam/605400", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	profile := struct {
+		ID       int               `json:"id"`
+		Name     string            `json:"name"`
+		Pitching []*models.Pitcher `json:"pitching"`
+	}{}
+	decodeJSON(t, rec, &profile)
+	assert.Equal(t, "Aaron Nola", profile.Name)
+	assert.Equal(t, 1, len(profile.Pitching))
+	assert.Equal(t, profile.ID, profile.Pitching[0].PlayerID)
+
+	// any of the player's ids finds the same player
+	rec = serve(s, http.MethodGet, "/api/players/by-fangraphs/16149", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	byFangraphs := models.Player{}
+	decodeJSON(t, rec, &byFangraphs)
+	assert.Equal(t, profile.ID, byFangraphs.ID)
+	assert.Equal(t, "605400", byFangraphs.MLBAMID)
+
+	rec = serve(s, http.MethodGet, "/api/players/by-mlbam/1", nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = serve(s, http.MethodGet, "/api/players/by-espn/1", nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestGetAverages(t *testing.T) {
+	s := newTestServer(t)
+
+	rec := serve(s, http.MethodGet, "/api/league/averages?season=2022", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	league := []*models.LeagueAverages{}
+	decodeJSON(t, rec, &league)
+	assert.Equal(t, 1, len(league))
+	assert.Equal(t, 2022, league[0].Season)
+	assert.Equal(t, 130, league[0].Batting.Lines)
+	assert.NotZero(t, league[0].Pitching.ERA)
+
+	rec = serve(s, http.MethodGet, "/api/teams/PHI/averages", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	team := []*models.LeagueAverages{}
+	decodeJSON(t, rec, &team)
+	assert.Equal(t, "PHI", team[0].Team)
+	assert.Equal(t, 5, team[0].Batting.Lines)
+	assert.Equal(t, 2, team[0].Pitching.Lines)
+
+	rec = serve(s, http.MethodGet, "/api/league/averages?season=twenty", nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = serve(s, http.MethodGet, "/api/league/averages?season=1999", nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = serve(s, http.MethodGet, "/api/teams/PHI/players", nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = serve(s, http.MethodPost, "/api/league/averages", nil)
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+func TestGetPercentiles(t *testing.T) {
+	s := newTestServer(t)
+
+	rec := serve(s, http.MethodGet, "/api/position_players/1/percentiles", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	ranks := models.Percentiles{}
+	decodeJSON(t, rec, &ranks)
+	assert.Equal(t, "Aaron Judge", ranks.Name)
+	assert.Equal(t, "plateAppearances", ranks.QualifyingStat)
+	assert.Equal(t, 100.0, ranks.Minimum)
+	assert.NotZero(t, ranks.Population)
+
+	stats := map[string]*models.StatPercentile{}
+	for _, stat := range ranks.Stats {
+		stats[stat.Stat] = stat
+	}
+	assert.GreaterOrEqual(t, *stats["isolatedPower"].Percentile, 99)
+	assert.Greater(t, *stats["isolatedPower"].ZScore, 0.0)
+	assert.True(t, stats["strikeoutRate"].LowerIsBetter)
+
+	rec = serve(s, http.MethodGet, "/api/position_players/1/percentiles?minPA=650", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	narrowed := models.Percentiles{}
+	decodeJSON(t, rec, &narrowed)
+	assert.Less(t, narrowed.Population, ranks.Population)
+
+	// a minimum no one reaches leaves nothing to rank against
+	rec = serve(s, http.MethodGet, "/api/position_players/1/percentiles?minPA=100000", nil)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.Contains(t, rec.Body.String(), "minPA")
+
+	rec = serve(s, http.MethodGet, "/api/pitchers/1/percentiles?minIP=50.5", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	pitcher := models.Percentiles{}
+	decodeJSON(t, rec, &pitcher)
+	assert.Equal(t, "inningsPitched", pitcher.QualifyingStat)
+	for _, stat := range pitcher.Stats {
+		if stat.Stat == "earnedRunAvg" {
+			assert.True(t, stat.LowerIsBetter)
+		}
+	}
+
+	rec = serve(s, http.MethodGet, "/api/position_players/1/percentiles?minPA=-1", nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = serve(s, http.MethodGet, "/api/pitchers/9999/percentiles", nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestGetLeaderboard(t *testing.T) {
+	s := newTestServer(t)
+
+	rec := serve(s, http.MethodGet, "/api/leaderboards/battingAvg?limit=5", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	board := models.Leaderboard{}
+	decodeJSON(t, rec, &board)
+	assert.Equal(t, "batting", board.Group)
+	assert.Equal(t, 2022, board.Season)
+	assert.Equal(t, "3.1 plateAppearances per team game of a 162 game season", board.Qualification)
+	assert.GreaterOrEqual(t, len(board.Leaders), 5)
+	for i, leader := range board.Leaders {
+		line, err := s.db.GetPositionPlayerByID(leader.ID)
+		assert.NoError(t, err)
+		assert.GreaterOrEqual(t, line.PA, 502)
+		if i > 0 {
+			assert.LessOrEqual(t, leader.Value, board.Leaders[i-1].Value)
+			assert.GreaterOrEqual(t, leader.Rank, board.Leaders[i-1].Rank)
+		}
+	}
+
+	rec = serve(s, http.MethodGet, "/api/leaderboards/battingAvg?minPA=1&limit=500", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	everyone := models.Leaderboard{}
+	decodeJSON(t, rec, &everyone)
+	assert.Equal(t, "at least 1 plateAppearances", everyone.Qualification)
+	assert.Greater(t, len(everyone.Leaders), len(board.Leaders))
+
+	rec = serve(s, http.MethodGet, "/api/leaderboards/era?minIP=50", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	pitchers := models.Leaderboard{}
+	decodeJSON(t, rec, &pitchers)
+	assert.Equal(t, "pitching", pitchers.Group)
+	assert.Equal(t, "earnedRunAvg", pitchers.Stat)
+	assert.True(t, pitchers.LowerIsBetter)
+	for i := 1; i < len(pitchers.Leaders); i++ {
+		assert.GreaterOrEqual(t, pitchers.Leaders[i].Value, pitchers.Leaders[i-1].Value)
+	}
+
+	rec = serve(s, http.MethodGet, "/api/leaderboards/war?group=pitching", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	war := models.Leaderboard{}
+	decodeJSON(t, rec, &war)
+	assert.Equal(t, "pitching", war.Group)
+	assert.Equal(t, "1 inningsPitched per team game of a 162 game season", war.Qualification)
+
+	rec = serve(s, http.MethodGet, "/api/leaderboards/war?group=fielding", nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = serve(s, http.MethodGet, "/api/leaderboards/battingAvg?minPA=lots", nil)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = serve(s, http.MethodGet, "/api/leaderboards/name", nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = serve(s, http.MethodGet, "/api/leaderboards/era?group=batting", nil)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestGetLeaderboardTeamGames(t *testing.T) {
+	memory := db.NewMemoryDB()
+	pitchers, err := os.Open("../../assets/pitchers_2022.csv")
+	assert.NoError(t, err)
+	defer pitchers.Close()
+	_, err = memory.ImportPitchers(pitchers, &db.ImportOptions{Mode: db.ImportLenient, Write: db.WriteInsert, Season: 2022})
+	assert.NoError(t, err)
+
+	// pitchers qualify over a full season without any batting lines
+	s := NewServer(":0", memory, testAdminToken, 0)
+	rec := serve(s, http.MethodGet, "/api/leaderboards/era?limit=500", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	board := models.Leaderboard{}
+	decodeJSON(t, rec, &board)
+	assert.Equal(t, "1 inningsPitched per team game of a 162 game season", board.Qualification)
+	assert.NotEmpty(t, board.Leaders)
+	for _, leader := range board.Leaders {
+		line, err := memory.GetPitcherByID(leader.ID)
+		assert.NoError(t, err)
+		assert.GreaterOrEqual(t, line.IP, 162.0)
+	}
+
+	// a season length set for the server is used instead
+	long := NewServer(":0", memory, testAdminToken, 200)
+	rec = serve(long, http.MethodGet, "/api/leaderboards/era?limit=500", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	longBoard := models.Leaderboard{}
+	decodeJSON(t, rec, &longBoard)
+	assert.Equal(t, "1 inningsPitched per team game of a 200 game season", longBoard.Qualification)
+	assert.NotEmpty(t, longBoard.Leaders)
+	assert.Less(t, len(longBoard.Leaders), len(board.Leaders))
+
+	// hitters of several teams qualify over the same season as everyone else
+	batters := newTestServer(t)
+	rec = serve(batters, http.MethodGet, "/api/leaderboards/battingAvg?limit=500", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	hitters := models.Leaderboard{}
+	decodeJSON(t, rec, &hitters)
+	for _, leader := range hitters.Leaders {
+		line, err := batters.db.GetPositionPlayerByID(leader.ID)
+		assert.NoError(t, err)
+		assert.GreaterOrEqual(t, line.PA, 502, line.Name+" of "+line.Team)
+	}
+}
+
+func TestImportUpload(t *testing.T) {
+	s := newTestServer(t)
+
+	csv := "Name,Team,G,PA,HR,R,RBI,SB,wRC+,BB%,K%,ISO,BABIP,AVG,OBP,SLG,wOBA,xwOBA,BsR,WAR\n" +
+		"Aaron Judge,NYY,157,696,63,133,131,16,207,.159,.251,.375,.340,.311,.425,.686,.458,.458,2.1,11.5\n"
+
+	form := &bytes.Buffer{}
+	writer := multipart.NewWriter(form)
+	part, err := writer.CreateFormFile("file", "batters_2022.csv")
+	assert.NoError(t, err)
+	part.Write([]byte(csv))
+	writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/import/position_players?mode=upsert", form)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	rec := httptest.NewRecorder()
+	s.routes().ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	report := db.ImportReport{}
+	decodeJSON(t, rec, &report)
+	assert.Equal(t, 1, report.Updated)
+	assert.Equal(t, "homeRuns", report.Changes[0].Fields[0].Field)
+
+	// a csv of position players is missing the columns of a pitcher
+	req = httptest.NewRequest(http.MethodPost, "/api/import/pitchers?season=2022", strings.NewReader(csv))
+	req.Header.Set("Content-Type", "text/csv")
+	rec = httptest.NewRecorder()
+	s.routes().ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "missing")
+
+	// a strict import of a csv with an invalid row reports every row
+	invalid := strings.Replace(csv, "157,696,63", "157,696,many", 1)
+	req = httptest.NewRequest(http.MethodPost, "/api/import/position_players?validation=strict", strings.NewReader(invalid))
+	req.Header.Set("Content-Type", "text/csv")
+	rec = httptest.NewRecorder()
+	s.routes().ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+
+	res := problem{}
+	decodeJSON(t, rec, &res)
+	assert.Equal(t, 1, res.Report.Failed)
+
+	req = httptest.NewRequest(http.MethodPost, "/api/import/pitchers", strings.NewReader(csv))
+	req.Header.Set("Content-Type", "application/json")
+	rec = httptest.NewRecorder()
+	s.routes().ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnsupportedMediaType, rec.Code)
+}
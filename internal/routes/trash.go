@@ -0,0 +1,163 @@
+package routes
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/e-berman/baseball_api/internal/db"
+	"github.com/e-berman/baseball_api/internal/models"
+)
+
+// requireAdmin returns a 403 error unless a request sends the server's admin token as a bearer token
+// in its Authorization header, naming the part of the request that needs it
+//
+// the trash is only seen and restored by admins. a server without an admin token has no admins
+func (s *Server) requireAdmin(req *http.Request, action string) error {
+	token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if ok && s.adminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.adminToken)) == 1 {
+		return nil
+	}
+
+	return &statusErr{status: http.StatusForbidden, err: fmt.Errorf("%s requires an admin token", action)}
+}
+
+// parseIncludeDeleted parses the includeDeleted query parameter, which asks for the lines in the trash
+func parseIncludeDeleted(raw string) (bool, error) {
+	include, err := strconv.ParseBool(raw)
+	if err != nil {
+		return false, errors.New("includeDeleted expects true or false")
+	}
+
+	return include, nil
+}
+
+// includeDeleted reports whether a request for a single line asks for it even when it is in the trash
+func includeDeleted(query url.Values) (bool, error) {
+	if !query.Has("includeDeleted") {
+		return false, nil
+	}
+
+	raw := query.Get("includeDeleted")
+	include, err := parseIncludeDeleted(raw)
+	if err != nil {
+		return false, &invalidQueryErr{Params: []queryParamErr{{Param: "includeDeleted", Value: raw, Reason: err.Error()}}}
+	}
+
+	return include, nil
+}
+
+// getIncludingDeleted returns the line with the given id whether or not it is in the trash,
+// listing it by id with list
+func getIncludingDeleted[T any](list func(*db.ListOptions) (*db.Page[*T], error), fields *db.FieldSet, id int) (*T, error) {
+	idField, _ := fields.Lookup("id")
+	page, err := list(&db.ListOptions{
+		Filters:        []db.Filter{{Field: idField, Op: db.OpEq, Value: id}},
+		IncludeDeleted: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(page.Items) == 0 {
+		return nil, &statusErr{status: http.StatusNotFound, err: fmt.Errorf("no line with id %d", id)}
+	}
+
+	return page.Items[0], nil
+}
+
+// getPositionPlayer returns the position player line of a request, including a line in the trash
+// when the request has includeDeleted=true
+func (s *Server) getPositionPlayer(req *http.Request, id int) (*models.PositionPlayer, error) {
+	include, err := includeDeleted(req.URL.Query())
+	if err != nil {
+		return nil, err
+	}
+	if include {
+		if err := s.requireAdmin(req, "includeDeleted"); err != nil {
+			return nil, err
+		}
+		return getIncludingDeleted(s.db.GetPositionPlayers, db.PositionPlayerFields, id)
+	}
+
+	return s.db.GetPositionPlayerByID(id)
+}
+
+// getPitcher returns the pitcher line of a request, including a line in the trash
+// when the request has includeDeleted=true
+func (s *Server) getPitcher(req *http.Request, id int) (*models.Pitcher, error) {
+	include, err := includeDeleted(req.URL.Query())
+	if err != nil {
+		return nil, err
+	}
+	if include {
+		if err := s.requireAdmin(req, "includeDeleted"); err != nil {
+			return nil, err
+		}
+		return getIncludingDeleted(s.db.GetPitchers, db.PitcherFields, id)
+	}
+
+	return s.db.GetPitcherByID(id)
+}
+
+// *******************
+// Handlers
+// *******************
+
+// handleUndeletePositionPlayer takes a position player line out of the trash, for an admin
+//
+// responds with the restored line, which is at a new version
+func (s *Server) handleUndeletePositionPlayer(rw http.ResponseWriter, req *http.Request) error {
+	if err := s.requireAdmin(req, "restoring a line from the trash"); err != nil {
+		return err
+	}
+	writer, err := s.writer(req)
+	if err != nil {
+		return err
+	}
+	id, err := s.getIDFromPath(req)
+	if err != nil {
+		return err
+	}
+
+	restored, err := writer.UndeletePositionPlayer(id)
+	if err != nil {
+		return err
+	}
+
+	log.Println("UNDELETE player id:", id)
+	rw.Header().Set("ETag", etag(restored.Version))
+
+	return ToJSON(rw, http.StatusOK, restored)
+}
+
+// handleUndeletePitcher takes a pitcher line out of the trash, for an admin
+//
+// responds with the restored line, which is at a new version
+func (s *Server) handleUndeletePitcher(rw http.ResponseWriter, req *http.Request) error {
+	if err := s.requireAdmin(req, "restoring a line from the trash"); err != nil {
+		return err
+	}
+	writer, err := s.writer(req)
+	if err != nil {
+		return err
+	}
+	id, err := s.getIDFromPath(req)
+	if err != nil {
+		return err
+	}
+
+	restored, err := writer.UndeletePitcher(id)
+	if err != nil {
+		return err
+	}
+
+	log.Println("UNDELETE pitcher id:", id)
+	rw.Header().Set("ETag", etag(restored.Version))
+
+	return ToJSON(rw, http.StatusOK, restored)
+}